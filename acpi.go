@@ -0,0 +1,275 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// The kernel broadcasts ACPI events, the ones acpid reads from
+// /proc/acpi/event, on this generic netlink family and group.
+const (
+	acpiGenlFamily = "acpi_event"
+	acpiGenlGroup  = "acpi_mc_group"
+	// acpiGenlAttrEvent carries a struct acpi_genl_event.
+	acpiGenlAttrEvent = 1
+	// acpiGenlEventSize is sizeof(struct acpi_genl_event): device_class[20],
+	// bus_id[15], padding, u32 type, u32 data.
+	acpiGenlEventSize = 44
+	// nlaTypeMask clears NLA_F_NESTED and NLA_F_NET_BYTEORDER.
+	nlaTypeMask = 0x3fff
+)
+
+// acpiEvent is one kernel ACPI event, e.g. class "ac_adapter", bus
+// "ACPI0003:00", type 0x80.
+type acpiEvent struct {
+	class string
+	bus   string
+	typ   uint32
+	data  uint32
+}
+
+func (ev acpiEvent) String() string {
+	return fmt.Sprintf("%s %s %08x %08x", ev.class, ev.bus, ev.typ, ev.data)
+}
+
+// kind says why an event matters for GPU switching, or "" when it does not.
+// The WMI core forwards notifications no WMI driver handled as class "wmi"
+// with the (truncated) GUID as bus id, so MSI firmware events msi-wmi does
+// not know arrive that way. Video events come from display outputs,
+// including the dGPU's, being switched or hotplugged.
+func (ev acpiEvent) kind() string {
+	switch {
+	case ev.class == "wmi":
+		return "wmi"
+	case strings.HasPrefix(ev.class, "video"):
+		return "display"
+	case ev.class == "ac_adapter":
+		return "power"
+	case ev.class == "button/lid":
+		return "lid"
+	}
+	return ""
+}
+
+// listenACPI reports the kernel's ACPI events until ctx is done. Events that
+// arrive while the channel is full are dropped.
+func listenACPI(ctx context.Context) (<-chan acpiEvent, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, fmt.Errorf("open generic netlink socket failed: %w", err)
+	}
+	family, group, err := resolveGenlGroup(fd, acpiGenlFamily, acpiGenlGroup)
+	if err == nil {
+		err = unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, int(group))
+	}
+	if err == nil {
+		// Non-blocking, so the runtime poller can interrupt the read on
+		// Close.
+		err = unix.SetNonblock(fd, true)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("subscribe to ACPI events failed: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "acpi-netlink")
+	context.AfterFunc(ctx, func() { f.Close() })
+
+	events := make(chan acpiEvent, 16)
+	go func() {
+		defer f.Close()
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn().Msgf("stopped reading ACPI events: %v", err)
+				}
+				return
+			}
+			for _, ev := range parseACPIEvents(buf[:n], family) {
+				select {
+				case events <- ev:
+				default:
+					log.Debug().Msgf("ACPI event dropped: %s", ev)
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// resolveGenlGroup asks the generic netlink controller for the id of family
+// and of its multicast group.
+func resolveGenlGroup(fd int, family, group string) (uint16, uint32, error) {
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return 0, 0, err
+	}
+	attr := appendNlAttr(nil, unix.CTRL_ATTR_FAMILY_NAME, append([]byte(family), 0))
+	req := appendNlMsg(nil, unix.GENL_ID_CTRL, unix.NLM_F_REQUEST, unix.CTRL_CMD_GETFAMILY, attr)
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return 0, 0, err
+	}
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseGenlFamily(buf[:n], group)
+}
+
+// parseGenlFamily reads the family id and the id of group from a
+// CTRL_CMD_GETFAMILY reply.
+func parseGenlFamily(reply []byte, group string) (uint16, uint32, error) {
+	msgs, err := parseNlMsgs(reply)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, m := range msgs {
+		if m.typ == unix.NLMSG_ERROR {
+			if len(m.payload) >= 4 {
+				if errno := int32(binary.NativeEndian.Uint32(m.payload)); errno != 0 {
+					return 0, 0, unix.Errno(-errno)
+				}
+			}
+			continue
+		}
+		if m.typ != unix.GENL_ID_CTRL || len(m.payload) < unix.GENL_HDRLEN {
+			continue
+		}
+		attrs := parseNlAttrs(m.payload[unix.GENL_HDRLEN:])
+		id := attrs[unix.CTRL_ATTR_FAMILY_ID]
+		if len(id) < 2 {
+			return 0, 0, errors.New("family reply without an id")
+		}
+		for _, g := range parseNlAttrs(attrs[unix.CTRL_ATTR_MCAST_GROUPS]) {
+			ga := parseNlAttrs(g)
+			if name := ga[unix.CTRL_ATTR_MCAST_GRP_NAME]; string(bytes.TrimRight(name, "\x00")) == group && len(ga[unix.CTRL_ATTR_MCAST_GRP_ID]) >= 4 {
+				return binary.NativeEndian.Uint16(id), binary.NativeEndian.Uint32(ga[unix.CTRL_ATTR_MCAST_GRP_ID]), nil
+			}
+		}
+		return 0, 0, fmt.Errorf("family has no multicast group %q", group)
+	}
+	return 0, 0, errors.New("no family in the controller reply")
+}
+
+// parseACPIEvents decodes the events of family in a netlink datagram,
+// skipping anything malformed.
+func parseACPIEvents(datagram []byte, family uint16) []acpiEvent {
+	msgs, _ := parseNlMsgs(datagram)
+	var events []acpiEvent
+	for _, m := range msgs {
+		if m.typ != family || len(m.payload) < unix.GENL_HDRLEN {
+			continue
+		}
+		raw := parseNlAttrs(m.payload[unix.GENL_HDRLEN:])[acpiGenlAttrEvent]
+		if len(raw) < acpiGenlEventSize {
+			continue
+		}
+		cstr := func(b []byte) string {
+			s, _, _ := bytes.Cut(b, []byte{0})
+			return string(s)
+		}
+		events = append(events, acpiEvent{
+			class: cstr(raw[:20]),
+			bus:   cstr(raw[20:35]),
+			typ:   binary.NativeEndian.Uint32(raw[36:]),
+			data:  binary.NativeEndian.Uint32(raw[40:]),
+		})
+	}
+	return events
+}
+
+type nlMsg struct {
+	typ     uint16
+	payload []byte
+}
+
+func parseNlMsgs(b []byte) ([]nlMsg, error) {
+	var msgs []nlMsg
+	for len(b) >= unix.NLMSG_HDRLEN {
+		length := int(binary.NativeEndian.Uint32(b))
+		if length < unix.NLMSG_HDRLEN || length > len(b) {
+			return msgs, errors.New("truncated netlink message")
+		}
+		msgs = append(msgs, nlMsg{typ: binary.NativeEndian.Uint16(b[4:]), payload: b[unix.NLMSG_HDRLEN:length]})
+		b = b[min(nlAlign(length), len(b)):]
+	}
+	return msgs, nil
+}
+
+// parseNlAttrs returns the attributes in b by type; malformed trailing data
+// is ignored.
+func parseNlAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(b))
+		if length < unix.SizeofNlAttr || length > len(b) {
+			break
+		}
+		attrs[binary.NativeEndian.Uint16(b[2:])&nlaTypeMask] = b[unix.SizeofNlAttr:length]
+		b = b[min(nlAlign(length), len(b)):]
+	}
+	return attrs
+}
+
+func nlAlign(n int) int {
+	return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
+
+func appendNlAttr(b []byte, typ uint16, data []byte) []byte {
+	b = binary.NativeEndian.AppendUint16(b, uint16(unix.SizeofNlAttr+len(data)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	return append(b, make([]byte, nlAlign(len(data))-len(data))...)
+}
+
+// appendNlMsg appends a generic netlink message with cmd and attrs.
+func appendNlMsg(b []byte, typ, flags uint16, cmd uint8, attrs []byte) []byte {
+	b = binary.NativeEndian.AppendUint32(b, uint32(unix.NLMSG_HDRLEN+unix.GENL_HDRLEN+len(attrs)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = binary.NativeEndian.AppendUint16(b, flags)
+	b = binary.NativeEndian.AppendUint32(b, 1) // sequence
+	b = binary.NativeEndian.AppendUint32(b, 0) // port id, the kernel's
+	b = append(b, cmd, 1, 0, 0)                // genlmsghdr: cmd, version, reserved
+	return append(b, attrs...)
+}
+
+// handleACPIEvent logs an event that matters for switching and, when the
+// firmware or a display may have moved the MUX, checks the EC against the
+// mode stored in the UEFI variable again.
+func handleACPIEvent(ctx context.Context, ev acpiEvent) {
+	kind := ev.kind()
+	if kind == "" {
+		log.Debug().Msgf("ACPI event: %s", ev)
+		return
+	}
+	log.Info().Msgf("ACPI %s event: %s", kind, ev)
+	if kind != "wmi" && kind != "display" {
+		return
+	}
+	if !exists(uefiVarPath) || !exists(ecIOPath) {
+		return
+	}
+	mode, err := readUefiGpuMode(ctx)
+	if err != nil {
+		log.Debug().Msgf("ACPI event: %v", err)
+		return
+	}
+	ok, err := modeConfigured(ctx, mode)
+	switch {
+	case err != nil:
+		log.Debug().Msgf("ACPI event: %v", err)
+	case !ok:
+		log.Warn().Msgf("after ACPI event %s the EC MUX no longer matches the %s mode in the UEFI variable; the firmware may have switched it", ev, mode)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func acpiGenlEvent(class, bus string, typ, data uint32) []byte {
+	raw := make([]byte, acpiGenlEventSize)
+	copy(raw[:20], class)
+	copy(raw[20:35], bus)
+	binary.NativeEndian.PutUint32(raw[36:], typ)
+	binary.NativeEndian.PutUint32(raw[40:], data)
+	return raw
+}
+
+func TestParseGenlFamily(t *testing.T) {
+	group := func(name string, id uint32) []byte {
+		b := appendNlAttr(nil, unix.CTRL_ATTR_MCAST_GRP_NAME, append([]byte(name), 0))
+		return appendNlAttr(b, unix.CTRL_ATTR_MCAST_GRP_ID, binary.NativeEndian.AppendUint32(nil, id))
+	}
+	groups := appendNlAttr(nil, 1, group("other", 3))
+	groups = appendNlAttr(groups, 2, group(acpiGenlGroup, 7))
+	attrs := appendNlAttr(nil, unix.CTRL_ATTR_FAMILY_ID, binary.NativeEndian.AppendUint16(nil, 0x1a))
+	attrs = appendNlAttr(attrs, unix.CTRL_ATTR_MCAST_GROUPS|unix.NLA_F_NESTED, groups)
+	reply := appendNlMsg(nil, unix.GENL_ID_CTRL, 0, unix.CTRL_CMD_NEWFAMILY, attrs)
+
+	family, id, err := parseGenlFamily(reply, acpiGenlGroup)
+	if err != nil || family != 0x1a || id != 7 {
+		t.Fatalf("parseGenlFamily = %#x %d %v", family, id, err)
+	}
+	if _, _, err := parseGenlFamily(reply, "missing"); err == nil {
+		t.Fatalf("expected a missing group to fail")
+	}
+	if _, _, err := parseGenlFamily(reply[:len(reply)-4], acpiGenlGroup); err == nil {
+		t.Fatalf("expected a truncated reply to fail")
+	}
+
+	errno := -int32(unix.ENOENT)
+	nack := binary.NativeEndian.AppendUint32(nil, uint32(errno))
+	nack = append(nack, make([]byte, unix.NLMSG_HDRLEN)...)
+	msg := binary.NativeEndian.AppendUint32(nil, uint32(unix.NLMSG_HDRLEN+len(nack)))
+	msg = binary.NativeEndian.AppendUint16(msg, unix.NLMSG_ERROR)
+	msg = append(msg, make([]byte, 10)...)
+	if _, _, err := parseGenlFamily(append(msg, nack...), acpiGenlGroup); err != unix.ENOENT {
+		t.Fatalf("expected ENOENT for an unknown family, got %v", err)
+	}
+}
+
+func TestParseACPIEvents(t *testing.T) {
+	const family = 0x1a
+	var datagram []byte
+	for _, ev := range [][]byte{
+		acpiGenlEvent("ac_adapter", "ACPI0003:00", 0x80, 1),
+		acpiGenlEvent("wmi", "ABBC0F6D-8EA1-11D1-00A0-C90629100000", 0xd0, 0),
+		acpiGenlEvent("battery", "PNP0C0A:00", 0x80, 1),
+		acpiGenlEvent("short", "", 0, 0)[:20],
+	} {
+		datagram = appendNlMsg(datagram, family, 0, 1, appendNlAttr(nil, acpiGenlAttrEvent, ev))
+	}
+	datagram = appendNlMsg(datagram, family+1, 0, 1, appendNlAttr(nil, acpiGenlAttrEvent, acpiGenlEvent("video", "LNXVIDEO:00", 0x80, 0)))
+
+	events := parseACPIEvents(datagram, family)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v", events)
+	}
+	want := []struct{ str, kind string }{
+		{"ac_adapter ACPI0003:00 00000080 00000001", "power"},
+		{"wmi ABBC0F6D-8EA1-1 000000d0 00000000", "wmi"},
+		{"battery PNP0C0A:00 00000080 00000001", ""},
+	}
+	for i, w := range want {
+		if events[i].String() != w.str || events[i].kind() != w.kind {
+			t.Fatalf("event %d: %q (%q), want %q (%q)", i, events[i], events[i].kind(), w.str, w.kind)
+		}
+	}
+	if got := parseACPIEvents(datagram[:7], family); len(got) != 0 {
+		t.Fatalf("expected nothing from a truncated datagram, got %+v", got)
+	}
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type apiStatus struct {
+	Active  string   `json:"active"`
+	Pending string   `json:"pending"`
+	Modes   []string `json:"modes"`
+}
+
+type apiSwitchRequest struct {
+	Mode string `json:"mode"`
+}
+
+// apiHandler serves GET /status, GET /metrics and POST /switch. A non-empty token must be
+// sent as "Authorization: Bearer <token>".
+func apiHandler(ctx context.Context, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		s := readBarState(r.Context())
+		writeJSON(w, http.StatusOK, apiStatus{Active: s.active, Pending: s.pending, Modes: modeNames()})
+	})
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("POST /switch", func(w http.ResponseWriter, r *http.Request) {
+		var req apiSwitchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("parse request: %w", err))
+			return
+		}
+		mode, err := parseMode(req.Mode)
+		if err == nil {
+			err = checkModeSupported(mode)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Info().Msgf("switch to %s requested over HTTP", mode)
+		// The switch must not be cut short by a client disconnecting.
+		err = switchGPU(ctx, mode)
+		recordAudit(auditActor{via: "api", uid: -1}, []string{"switch", mode.String()}, err)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if k, ok := errorKind(err); ok {
+				status = k.status
+			}
+			writeJSONError(w, status, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "ok", "mode": mode.String()})
+	})
+	if token == "" {
+		return mux
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError adds the error's kind as "code" and what to do about it as
+// "hint" when known.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if k, ok := errorKind(err); ok {
+		body["code"], body["hint"] = k.name, k.hint
+	}
+	writeJSON(w, status, body)
+}
+
+// listenAPI listens on "unix:<path>" or a loopback host:port. TCP needs a
+// token: any local user can connect to it.
+func listenAPI(addr, token string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0o660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("refusing to listen on non-loopback address %s", addr)
+	}
+	if token == "" {
+		return nil, errors.New("a TCP listener needs --api-token-file")
+	}
+	return net.Listen("tcp", addr)
+}
+
+func readTokenFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// serveAPI serves the HTTP API on addr until the returned func shuts it down.
+func serveAPI(ctx context.Context, addr, tokenFile string) (func(), error) {
+	token, err := readTokenFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listenAPI(addr, token)
+	if err != nil {
+		return nil, fmt.Errorf("api listen failed: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           apiHandler(ctx, token),
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Msgf("api: %v", err)
+		}
+	}()
+	log.Info().Msgf("serving HTTP API on %s", addr)
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}, nil
+}
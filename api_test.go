@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIRequiresToken(t *testing.T) {
+	srv := httptest.NewServer(apiHandler(context.Background(), "secret"))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/status")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var status apiStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(status.Modes) == 0 {
+		t.Fatalf("unexpected status %d %+v", resp.StatusCode, status)
+	}
+}
+
+func TestAPISwitchRejectsBadMode(t *testing.T) {
+	srv := httptest.NewServer(apiHandler(context.Background(), ""))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/switch", "application/json", strings.NewReader(`{"mode": "bogus"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestListenAPIRefusesUnsafeAddresses(t *testing.T) {
+	if _, err := listenAPI("0.0.0.0:0", "secret"); err == nil {
+		t.Fatalf("expected non-loopback address to be refused")
+	}
+	if _, err := listenAPI("127.0.0.1:0", ""); err == nil {
+		t.Fatalf("expected TCP without a token to be refused")
+	}
+	ln, err := listenAPI("unix:"+t.TempDir()+"/api.sock", "")
+	if err != nil {
+		t.Fatalf("unix listener: %v", err)
+	}
+	ln.Close()
+}
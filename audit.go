@@ -0,0 +1,253 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// auditPath is the append-only log of privileged actions. Each entry holds
+// the hash of the one before, so editing or dropping a line breaks the chain
+// from there on.
+var auditPath = "/var/log/msi-gpu-switcher/audit.jsonl"
+
+// fsAppendFl is FS_APPEND_FL from linux/fs.h.
+const fsAppendFl = 0x00000020
+
+// auditEntry is one privileged action. UID and User are who asked for it:
+// the user behind sudo, doas or pkexec, or the peer of a helper or D-Bus
+// request.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Via    string    `json:"via"`
+	UID    int       `json:"uid"`
+	User   string    `json:"user,omitempty"`
+	PID    int       `json:"pid,omitempty"`
+	TTY    string    `json:"tty,omitempty"`
+	Args   []string  `json:"args"`
+	Result string    `json:"result"`
+	Prev   string    `json:"prev"`
+	Hash   string    `json:"hash"`
+}
+
+// hash covers every field but Hash itself, Prev included.
+func (e auditEntry) hash() string {
+	e.Hash = ""
+	raw, _ := json.Marshal(e)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditActor describes who asked for an action.
+type auditActor struct {
+	via string
+	uid int
+	pid int
+	tty string
+}
+
+// cliActor is the user running this process, looking through elevation.
+func cliActor() auditActor {
+	a := auditActor{via: "cli", uid: os.Getuid(), tty: controllingTTY()}
+	for _, env := range []string{"SUDO_UID", "PKEXEC_UID"} {
+		if uid, err := strconv.Atoi(os.Getenv(env)); err == nil {
+			a.uid = uid
+			return a
+		}
+	}
+	if name := os.Getenv("DOAS_USER"); name != "" {
+		if u, err := user.Lookup(name); err == nil {
+			if uid, err := strconv.Atoi(u.Uid); err == nil {
+				a.uid = uid
+			}
+		}
+	}
+	return a
+}
+
+func controllingTTY() string {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", strconv.Itoa(int(f.Fd())))); err == nil && strings.HasPrefix(target, "/dev/") && target != "/dev/null" {
+			return target
+		}
+	}
+	return ""
+}
+
+// recordAudit appends an action to the audit log. Failing to do so is only
+// logged: the action has already happened.
+func recordAudit(a auditActor, args []string, actionErr error) {
+	result := "ok"
+	if actionErr != nil {
+		result = actionErr.Error()
+	}
+	e := auditEntry{Time: time.Now().UTC(), Via: a.via, UID: a.uid, PID: a.pid, TTY: a.tty, Args: args, Result: result}
+	if u, err := user.LookupId(strconv.Itoa(a.uid)); err == nil {
+		e.User = u.Username
+	}
+	if err := appendAudit(e); err != nil {
+		log.Warn().Msgf("write audit log failed: %v", err)
+	}
+}
+
+// appendAudit chains e to the last entry and appends it. An flock on the log
+// keeps concurrent writers from chaining to the same entry.
+func appendAudit(e auditEntry) error {
+	if err := os.MkdirAll(filepath.Dir(auditPath), 0o700); err != nil {
+		return err
+	}
+	f, err := openFile(auditPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		// With the append-only flag even root has to clear it before
+		// rewriting the log; tmpfs and friends do not support it.
+		if flags, err := getInodeFlags(int(f.Fd())); err == nil {
+			if err := setInodeFlags(int(f.Fd()), flags|fsAppendFl); err != nil {
+				log.Debug().Msgf("set append-only on %s failed: %v", auditPath, err)
+			}
+		}
+	}
+
+	last, err := lastAuditHash(f)
+	if err != nil {
+		return err
+	}
+	e.Prev = last
+	e.Hash = e.hash()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func lastAuditHash(r io.Reader) (string, error) {
+	var last []byte
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := sc.Err(); err != nil || last == nil {
+		return "", err
+	}
+	var e auditEntry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return "", fmt.Errorf("parse last audit entry: %w", err)
+	}
+	return e.Hash, nil
+}
+
+func readAudit() ([]auditEntry, error) {
+	raw, err := os.ReadFile(auditPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []auditEntry
+	for i, line := range bytes.Split(raw, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return entries, fmt.Errorf("audit log line %d: %w", i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// verifyAudit checks the hash chain. Lines cut off the end leave no trace
+// in the chain itself; the append-only flag is what guards against that.
+func verifyAudit(entries []auditEntry) error {
+	prev := ""
+	for i, e := range entries {
+		if e.Prev != prev {
+			return fmt.Errorf("entry %d does not follow the previous one", i+1)
+		}
+		if e.hash() != e.Hash {
+			return fmt.Errorf("entry %d was modified", i+1)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+func auditCmd() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the log of privileged actions",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			entries, err := readAudit()
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				log.Info().Msg("no privileged actions recorded")
+				return nil
+			}
+			if limit > 0 && len(entries) > limit {
+				entries = entries[len(entries)-limit:]
+			}
+			for _, e := range entries {
+				who := e.User
+				if who == "" {
+					who = strconv.Itoa(e.UID)
+				}
+				outf("%s  %-6s  %-10s  %-12s  %s  %s",
+					e.Time.Local().Format(time.RFC3339), e.Via, who, e.TTY, strings.Join(e.Args, " "), e.Result)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "show only the last N entries (0 = all)")
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify",
+		Short: "Check that no audit entry was changed or removed",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			entries, err := readAudit()
+			if err != nil {
+				return err
+			}
+			if err := verifyAudit(entries); err != nil {
+				return err
+			}
+			log.Info().Msgf("audit log intact (%d entries)", len(entries))
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tempAuditLog points the audit log at a temp dir and records the inode
+// flags set on it instead of setting them, since an append-only file could
+// not be cleaned up.
+func tempAuditLog(t *testing.T) *int {
+	t.Helper()
+	originalPath, originalGet, originalSet := auditPath, getInodeFlags, setInodeFlags
+	t.Cleanup(func() { auditPath, getInodeFlags, setInodeFlags = originalPath, originalGet, originalSet })
+	auditPath = filepath.Join(t.TempDir(), "log", "audit.jsonl")
+	flags := new(int)
+	getInodeFlags = func(int) (int, error) { return *flags, nil }
+	setInodeFlags = func(_ int, v int) error {
+		*flags = v
+		return nil
+	}
+	return flags
+}
+
+func TestAuditChain(t *testing.T) {
+	flags := tempAuditLog(t)
+
+	recordAudit(auditActor{via: "cli", uid: 1000, tty: "/dev/pts/1"}, []string{"switch", "discrete"}, nil)
+	recordAudit(auditActor{via: "dbus", uid: 1000, pid: 42}, []string{"switch", "hybrid"}, errors.New("EC MUX is not available"))
+	recordAudit(auditActor{via: "daemon"}, []string{"enforce", "discrete"}, nil)
+
+	if *flags&fsAppendFl == 0 {
+		t.Fatal("expected the new log to be made append-only")
+	}
+	entries, err := readAudit()
+	if err != nil {
+		t.Fatalf("readAudit: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Prev != "" || entries[1].Prev != entries[0].Hash || entries[2].Prev != entries[1].Hash {
+		t.Fatalf("entries are not chained: %+v", entries)
+	}
+	if entries[1].Result != "EC MUX is not available" || entries[0].TTY != "/dev/pts/1" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if err := verifyAudit(entries); err != nil {
+		t.Fatalf("verifyAudit: %v", err)
+	}
+
+	raw, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	tampered := strings.Replace(string(raw), `"uid":1000`, `"uid":1001`, 1)
+	if err := os.WriteFile(auditPath, []byte(tampered), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	entries, _ = readAudit()
+	if err := verifyAudit(entries); err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Fatalf("expected the edited entry to be reported, got %v", err)
+	}
+
+	lines := strings.SplitAfter(string(raw), "\n")
+	if err := os.WriteFile(auditPath, []byte(lines[0]+lines[2]), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	entries, _ = readAudit()
+	if err := verifyAudit(entries); err == nil || !strings.Contains(err.Error(), "entry 2") {
+		t.Fatalf("expected the dropped entry to be noticed, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rs/zerolog/log"
+)
+
+// efiGlobalGuid is EFI_GLOBAL_VARIABLE, the vendor GUID of Boot#### entries.
+const efiGlobalGuid = "8BE4DF61-93CA-11D2-AA0D-00E098032B8C"
+
+type bootEntry struct {
+	name        string
+	description string
+}
+
+// listBootEntries reads every Boot#### variable and decodes its description.
+func listBootEntries(ctx context.Context) ([]bootEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(efivarsDir, "Boot[0-9A-F][0-9A-F][0-9A-F][0-9A-F]-"+efiGlobalGuid))
+	if err != nil {
+		return nil, err
+	}
+	var entries []bootEntry
+	for _, p := range paths {
+		_, data, err := readEfiVar(ctx, p)
+		if err != nil {
+			continue
+		}
+		name, _, _ := splitEfiVarName(filepath.Base(p))
+		entries = append(entries, bootEntry{name: name, description: loadOptionDescription(data)})
+	}
+	return entries, nil
+}
+
+// loadOptionDescription extracts the description of an EFI_LOAD_OPTION:
+// UINT32 Attributes, UINT16 FilePathListLength, then a NUL-terminated
+// UTF-16LE description.
+func loadOptionDescription(data []byte) string {
+	const header = 6
+	if len(data) < header {
+		return ""
+	}
+	var units []uint16
+	for i := header; i+1 < len(data); i += 2 {
+		u := binary.LittleEndian.Uint16(data[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+// findWindowsBootManager returns the first boot entry for Windows, if any.
+func findWindowsBootManager(ctx context.Context) (bootEntry, bool) {
+	entries, err := listBootEntries(ctx)
+	if err != nil {
+		return bootEntry{}, false
+	}
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.description), "windows boot manager") {
+			return e, true
+		}
+	}
+	return bootEntry{}, false
+}
+
+// warnDualBoot points out that MSI Center on a Windows install booting from
+// the same firmware may overwrite the GPU mode.
+func warnDualBoot(ctx context.Context) {
+	entry, ok := findWindowsBootManager(ctx)
+	if !ok {
+		return
+	}
+	log.Warn().Msgf("Windows Boot Manager found (%s): MSI Center on Windows may overwrite the GPU mode", entry.name)
+	log.Warn().Msg("run 'msi-gpu-switcher daemon --enforce' to re-apply the last requested mode when that happens")
+}
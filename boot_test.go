@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+func loadOption(description string) []byte {
+	data := make([]byte, 6)
+	binary.LittleEndian.PutUint32(data, 1)
+	for _, u := range utf16.Encode([]rune(description)) {
+		data = binary.LittleEndian.AppendUint16(data, u)
+	}
+	return append(data, 0, 0, 0x04, 0x01)
+}
+
+func TestFindWindowsBootManager(t *testing.T) {
+	originalDir := efivarsDir
+	efivarsDir = t.TempDir()
+	t.Cleanup(func() { efivarsDir = originalDir })
+
+	write := func(name, description string) {
+		payload := append([]byte{0x07, 0, 0, 0}, loadOption(description)...)
+		if err := os.WriteFile(filepath.Join(efivarsDir, name+"-"+efiGlobalGuid), payload, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("Boot0000", "Linux Boot Manager")
+
+	ctx := context.Background()
+	if _, ok := findWindowsBootManager(ctx); ok {
+		t.Fatalf("expected no Windows entry")
+	}
+
+	write("Boot0003", "Windows Boot Manager")
+	entry, ok := findWindowsBootManager(ctx)
+	if !ok || entry.name != "Boot0003" {
+		t.Fatalf("expected Boot0003, got %+v %v", entry, ok)
+	}
+}
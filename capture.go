@@ -0,0 +1,391 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// A capture is a gzipped tar of the host files the switcher reads, stored
+// under their absolute paths without the leading slash, plus captureManifest.
+// --replay roots hostfs in an extracted capture.
+const (
+	captureSchemaVersion = 1
+	captureManifest      = "capture.json"
+
+	// captureMaxFile skips anything larger; sysfs attributes and the MSI
+	// UEFI variables are a few bytes.
+	captureMaxFile = 1 << 20
+
+	// captureReadTimeout bounds reading one file; sysfs reads can block on
+	// a wedged driver.
+	captureReadTimeout = 5 * time.Second
+)
+
+type captureInfo struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Version       string    `json:"version"`
+	Captured      time.Time `json:"captured"`
+	Board         string    `json:"board"`
+	BIOS          string    `json:"bios"`
+	// Errors lists what could not be captured.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// captureGpuAttrs are the attributes read from a GPU's PCI device.
+var captureGpuAttrs = []string{
+	"class", "vendor", "device", "subsystem_vendor", "subsystem_device",
+	"boot_vga", "driver", "removable", "d3cold_allowed", "power_state",
+	"power/runtime_status", "power/control", "pp_dpm_pcie", "mem_info_vram_total",
+	"current_link_speed", "current_link_width", "max_link_speed", "max_link_width",
+	"hwmon/hwmon*/name", "hwmon/hwmon*/temp1_input", "hwmon/hwmon*/power1_input", "hwmon/hwmon*/power1_average",
+}
+
+func captureCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "capture [file]",
+		Short: "Save the GPU, EC and UEFI state to a tarball --replay can run against",
+		Long: "Capture the sysfs, EC and UEFI variable state the switcher reads into a gzipped tarball\n" +
+			"(default msi-gpu-switcher-capture.tar.gz, - for stdout). Attach it to a bug report; running\n" +
+			"any command with --replay <file> then behaves as on the captured machine.\n" +
+			"Serial numbers and UEFI variables of other vendors are not included.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			requireRoot()
+			path := "msi-gpu-switcher-capture.tar.gz"
+			if len(args) > 0 {
+				path = args[0]
+			}
+			var w io.Writer = stdout
+			if path != "-" {
+				f, err := os.Create(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			info, err := writeCapture(cmd.Context(), w)
+			if err != nil {
+				return err
+			}
+			for _, e := range info.Errors {
+				log.Warn().Msgf("not captured: %s", e)
+			}
+			if path != "-" {
+				log.Info().Msgf("captured %s %s to %s", info.Board, info.BIOS, path)
+			}
+			return nil
+		},
+	}
+}
+
+// captureWriter adds host files to a capture tarball.
+type captureWriter struct {
+	ctx    context.Context
+	tw     *tar.Writer
+	seen   map[string]bool
+	errors []string
+}
+
+// writeCapture writes a capture of this host, as seen through hostfs, to w.
+func writeCapture(ctx context.Context, w io.Writer) (captureInfo, error) {
+	info := captureInfo{
+		SchemaVersion: captureSchemaVersion,
+		Version:       version,
+		Captured:      time.Now().UTC(),
+		Board:         readDMI("board_name"),
+		BIOS:          readDMI("bios_version"),
+	}
+	gz := gzip.NewWriter(w)
+	c := &captureWriter{ctx: ctx, tw: tar.NewWriter(gz), seen: map[string]bool{}}
+
+	for _, field := range []string{"board_name", "board_vendor", "sys_vendor", "product_name", "bios_vendor", "bios_version", "bios_date"} {
+		c.add(filepath.Join(dmiRoot, field))
+	}
+	gpus, err := listGPUs()
+	if err != nil {
+		c.failed("gpus", err)
+	}
+	for _, g := range gpus {
+		dir := filepath.Join(pciRoot, g.addr)
+		for _, attr := range captureGpuAttrs {
+			c.glob(filepath.Join(dir, attr))
+		}
+		// isExternalGPU looks at every bridge above the device.
+		if path, err := hostfs.EvalSymlinks(dir); err == nil {
+			for path = filepath.Dir(path); strings.Contains(filepath.Base(path), ":"); path = filepath.Dir(path) {
+				c.add(filepath.Join(path, "removable"))
+			}
+		}
+		c.add(filepath.Join(sysModuleRoot, g.driver, "version"))
+		c.glob(filepath.Join(nvidiaProcDir(), "gpus", g.addr, "*"))
+	}
+	c.add(filepath.Join(nvidiaProcDir(), "version"))
+	c.add(filepath.Join(sysModuleRoot, "amdgpu", "parameters", "runpm"))
+	for _, pattern := range []string{"card*-*/status", "card*-*/enabled", "card*/device"} {
+		c.glob(filepath.Join(drmRoot, pattern))
+	}
+	for _, attr := range []string{"type", "online", "scope", "capacity"} {
+		c.glob(filepath.Join(powerSupplyRoot, "*", attr))
+	}
+	c.glob(filepath.Join(lidRoot, "*", "state"))
+	c.add(filepath.Join(ecSysParamsDir, "write_support"))
+
+	if exists(ecIOPath) {
+		if snap, err := readECSnapshot(ctx); err != nil {
+			c.failed("ec", err)
+		} else {
+			c.write(ecIOPath, snap, 0o644)
+		}
+	}
+	if vars, err := listEfiVars(ctx, []string{msiVendorGuid, uefiVarGuid}); err != nil {
+		c.failed("uefi", err)
+	} else {
+		for _, v := range vars {
+			c.add(filepath.Join(efivarsDir, v.name+"-"+v.guid))
+		}
+	}
+
+	info.Errors = c.errors
+	manifest, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return info, err
+	}
+	c.write("/"+captureManifest, append(manifest, '\n'), 0o644)
+	if err := c.tw.Close(); err != nil {
+		return info, err
+	}
+	return info, gz.Close()
+}
+
+func (c *captureWriter) failed(what string, err error) {
+	c.errors = append(c.errors, fmt.Sprintf("%s: %v", what, err))
+}
+
+func (c *captureWriter) glob(pattern string) {
+	matches, err := hostfs.Glob(pattern)
+	if err != nil {
+		c.failed(pattern, err)
+		return
+	}
+	for _, m := range matches {
+		c.add(m)
+	}
+}
+
+// add captures the file at name together with every symlink on the way to
+// it, so the replayed tree resolves name the same way. Missing files are
+// skipped: most attributes only exist for some drivers.
+func (c *captureWriter) add(name string) {
+	c.resolve(name, 0)
+}
+
+func (c *captureWriter) resolve(name string, depth int) {
+	if depth > 40 {
+		c.failed(name, errors.New("too many levels of symbolic links"))
+		return
+	}
+	parts := strings.Split(strings.Trim(filepath.Clean(name), "/"), "/")
+	dir := "/"
+	for i, part := range parts {
+		path := filepath.Join(dir, part)
+		info, err := hostfs.Lstat(path)
+		if err != nil {
+			return
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := hostfs.Readlink(path)
+			if err != nil {
+				c.failed(path, err)
+				return
+			}
+			if filepath.IsAbs(target) || filepath.Clean(target) != target {
+				c.failed(path, fmt.Errorf("symlink to %s cannot be replayed", target))
+				return
+			}
+			c.link(path, target)
+			c.resolve(filepath.Join(append([]string{dir, target}, parts[i+1:]...)...), depth+1)
+			return
+		}
+		dir = path
+	}
+	info, err := hostfs.Lstat(dir)
+	if err != nil || !info.Mode().IsRegular() || c.seen[dir] {
+		return
+	}
+	var data []byte
+	err = runWithTimeout(c.ctx, captureReadTimeout, func() error {
+		var err error
+		data, err = hostfs.ReadFile(dir)
+		return err
+	})
+	switch {
+	case err != nil:
+		// Write-only attributes and ones the driver refuses to read.
+		log.Debug().Msgf("capture %s: %v", dir, err)
+	case len(data) > captureMaxFile:
+		c.failed(dir, fmt.Errorf("%d bytes is too large", len(data)))
+	default:
+		c.write(dir, data, 0o644)
+	}
+}
+
+func (c *captureWriter) write(name string, data []byte, mode int64) {
+	if c.seen[name] {
+		return
+	}
+	c.seen[name] = true
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: strings.TrimPrefix(name, "/"), Mode: mode, Size: int64(len(data))}
+	if err := c.tw.WriteHeader(hdr); err != nil {
+		c.failed(name, err)
+		return
+	}
+	if _, err := c.tw.Write(data); err != nil {
+		c.failed(name, err)
+	}
+}
+
+func (c *captureWriter) link(name, target string) {
+	if c.seen[name] {
+		return
+	}
+	c.seen[name] = true
+	hdr := &tar.Header{Typeflag: tar.TypeSymlink, Name: strings.TrimPrefix(name, "/"), Linkname: target, Mode: 0o777}
+	if err := c.tw.WriteHeader(hdr); err != nil {
+		c.failed(name, err)
+	}
+}
+
+// extractCapture unpacks the capture at path into dir. Captures come from
+// other people's machines, so it accepts only regular files and clean
+// relative symlinks that stay inside dir, and nothing below a symlink: with
+// every link's parent a real directory, checking targets lexically is exact.
+func extractCapture(path, dir string) (captureInfo, error) {
+	var info captureInfo
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return info, fmt.Errorf("read capture %s: %w", path, err)
+	}
+	tr := tar.NewReader(gz)
+	links := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return info, fmt.Errorf("read capture %s: %w", path, err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return info, fmt.Errorf("capture %s: entry %q is outside the capture", path, hdr.Name)
+		}
+		for parent := filepath.Dir(hdr.Name); parent != "."; parent = filepath.Dir(parent) {
+			if links[parent] {
+				return info, fmt.Errorf("capture %s: entry %q is below the link %q", path, hdr.Name, parent)
+			}
+		}
+		dst := filepath.Join(dir, hdr.Name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return info, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			data, err := io.ReadAll(io.LimitReader(tr, captureMaxFile+1))
+			if err != nil {
+				return info, fmt.Errorf("read capture %s: %w", path, err)
+			}
+			if hdr.Name == captureManifest {
+				if err := json.Unmarshal(data, &info); err != nil {
+					return info, fmt.Errorf("capture %s: parse %s: %w", path, captureManifest, err)
+				}
+				if info.SchemaVersion > captureSchemaVersion {
+					return info, fmt.Errorf("capture %s has schema version %d; this version reads up to %d", path, info.SchemaVersion, captureSchemaVersion)
+				}
+			}
+			if err := os.WriteFile(dst, data, 0o644); err != nil {
+				return info, err
+			}
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if filepath.IsAbs(target) || filepath.Clean(target) != target || !filepath.IsLocal(filepath.Join(filepath.Dir(hdr.Name), target)) {
+				return info, fmt.Errorf("capture %s: link %q points outside the capture", path, hdr.Name)
+			}
+			if err := os.Symlink(target, dst); err != nil {
+				return info, err
+			}
+			links[filepath.Clean(hdr.Name)] = true
+		case tar.TypeDir:
+		default:
+			return info, fmt.Errorf("capture %s: unsupported entry %q", path, hdr.Name)
+		}
+	}
+	if info.SchemaVersion == 0 {
+		return info, fmt.Errorf("capture %s: no %s", path, captureManifest)
+	}
+	return info, nil
+}
+
+// replayRoot is the extracted capture given with --replay, or "".
+var replayRoot string
+
+// startReplay points the switcher at the capture at path instead of this
+// machine: hostfs, and the state, lock, audit, hook, modprobe.d and udev
+// rules paths, all move into a scratch copy, and checkNotReplaying refuses
+// the rest, so nothing the commands write reaches the real system. A
+// directory is used as an already extracted capture and written to in place.
+// The returned func removes the scratch copy.
+func startReplay(path string, keepConfig bool) (func(), error) {
+	root, done := path, func() {}
+	if st, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !st.IsDir() {
+		if root, err = os.MkdirTemp("", "msi-gpu-switcher-replay-"); err != nil {
+			return nil, err
+		}
+		done = func() { os.RemoveAll(root) }
+		info, err := extractCapture(path, root)
+		if err != nil {
+			done()
+			return nil, err
+		}
+		log.Info().Msgf("replaying %s: %s %s captured %s by %s", path, info.Board, info.BIOS, info.Captured.Format(time.RFC3339), info.Version)
+	}
+	replayRoot = root
+	hostfs = osFS{root: root}
+	for _, p := range []*string{&stateDir, &lockPath, &auditPath, &hooksDir, &helperSocket, &modprobeDir, &udevRulesDir} {
+		*p = filepath.Join(root, *p)
+	}
+	if !keepConfig {
+		configPath = filepath.Join(root, configPath)
+	}
+	return done, nil
+}
+
+// checkNotReplaying refuses, during a replay, what cannot be kept inside the
+// scratch copy: running other programs such as systemctl, systemd-run or
+// udevadm, installing to the system and replacing this binary.
+func checkNotReplaying(what string) error {
+	if replayRoot != "" {
+		return fmt.Errorf("%s would change this machine; not available with --replay", what)
+	}
+	return nil
+}
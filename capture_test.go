@@ -0,0 +1,156 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureReplays(t *testing.T) {
+	laptopFixture(t)
+	ctx := context.Background()
+	want, err := listGPUs()
+	if err != nil {
+		t.Fatalf("listGPUs: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	info, err := writeCapture(ctx, f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("writeCapture: %v", err)
+	}
+	if info.Board != "MS-17KK" || len(info.Errors) != 0 {
+		t.Fatalf("unexpected capture info: %+v", info)
+	}
+
+	root := t.TempDir()
+	if _, err := extractCapture(path, root); err != nil {
+		t.Fatalf("extractCapture: %v", err)
+	}
+	hostfs = osFS{root: root}
+	got, err := listGPUs()
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("replayed listGPUs: %+v %v", got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("replayed GPU %d: got %+v want %+v", i, got[i], want[i])
+		}
+	}
+	if board := readDMI("board_name"); board != "MS-17KK" {
+		t.Fatalf("replayed board %q", board)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("replayed readUefiGpuMode = %v %v", mode, err)
+	}
+	if snap, err := readECSnapshot(ctx); err != nil || len(snap) != ecSize {
+		t.Fatalf("replayed EC: %d bytes, %v", len(snap), err)
+	}
+	if r := collectStatus(ctx); len(r.Errors) != 0 || r.Pending != modeHybrid.String() {
+		t.Fatalf("replayed status: pending %q, errors %v", r.Pending, r.Errors)
+	}
+}
+
+func TestExtractCaptureRejectsEscapes(t *testing.T) {
+	for name, entries := range map[string][]tar.Header{
+		"dotdot":        {{Typeflag: tar.TypeReg, Name: "../evil"}},
+		"absolute link": {{Typeflag: tar.TypeSymlink, Name: "sys/x", Linkname: "/etc"}},
+		"escaping link": {{Typeflag: tar.TypeSymlink, Name: "sys/x", Linkname: "../../etc"}},
+		"unclean link":  {{Typeflag: tar.TypeSymlink, Name: "x", Linkname: "y/../../etc"}},
+		"below link": {
+			{Typeflag: tar.TypeSymlink, Name: "sys/x", Linkname: ".."},
+			{Typeflag: tar.TypeReg, Name: "sys/x/evil"},
+		},
+	} {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, hdr := range entries {
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		tw.Close()
+		gz.Close()
+		path := filepath.Join(t.TempDir(), "capture.tar.gz")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		// Refused before the missing manifest is noticed.
+		if _, err := extractCapture(path, t.TempDir()); err == nil || strings.Contains(err.Error(), captureManifest) {
+			t.Fatalf("%s: expected the entry to be refused, got %v", name, err)
+		}
+	}
+}
+
+// TestReplayCaptures replays the captures in testdata/captures, taken from
+// reporters' machines, and checks the status reads cleanly on each.
+func TestReplayCaptures(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "captures", "*.tar.gz"))
+	if len(paths) == 0 {
+		t.Skip("no captures in testdata/captures")
+	}
+	original := hostfs
+	t.Cleanup(func() { hostfs = original })
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			root := t.TempDir()
+			if _, err := extractCapture(path, root); err != nil {
+				t.Fatalf("extractCapture: %v", err)
+			}
+			hostfs = osFS{root: root}
+			r := collectStatus(context.Background())
+			if len(r.Errors) != 0 || r.Pending == "" {
+				t.Fatalf("status: pending %q, errors %v", r.Pending, r.Errors)
+			}
+		})
+	}
+}
+
+func TestStartReplayKeepsWritesInTheCopy(t *testing.T) {
+	originals := []*string{&stateDir, &lockPath, &auditPath, &hooksDir, &helperSocket, &configPath, &modprobeDir, &udevRulesDir}
+	saved := make([]string, len(originals))
+	for i, p := range originals {
+		saved[i] = *p
+	}
+	originalFS := hostfs
+	t.Cleanup(func() {
+		for i, p := range originals {
+			*p = saved[i]
+		}
+		hostfs, replayRoot = originalFS, ""
+	})
+
+	root := t.TempDir()
+	done, err := startReplay(root, false)
+	if err != nil {
+		t.Fatalf("startReplay: %v", err)
+	}
+	defer done()
+	for _, p := range originals {
+		if !strings.HasPrefix(*p, root+"/") {
+			t.Errorf("%s is outside the replay copy", *p)
+		}
+	}
+	if _, err := runCommand(context.Background(), "true"); err == nil {
+		t.Fatal("expected running a program to be refused while replaying")
+	}
+	if err := writeProfileModprobe([]string{"options nvidia NVreg_DynamicPowerManagement=0x02"}); err != nil {
+		t.Fatalf("writeProfileModprobe: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "modprobe.d", profileModprobeFile)); err != nil {
+		t.Fatalf("expected the modprobe snippet in the copy: %v", err)
+	}
+}
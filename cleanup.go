@@ -0,0 +1,74 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	cleanupMu   sync.Mutex
+	cleanups    = map[int]func(){}
+	nextCleanup int
+)
+
+// registerCleanup arranges for fn to run if the process is interrupted by a
+// signal. The returned func runs fn immediately and unregisters it; fn runs
+// at most once either way.
+func registerCleanup(fn func()) func() {
+	var once sync.Once
+	run := func() { once.Do(fn) }
+
+	cleanupMu.Lock()
+	id := nextCleanup
+	nextCleanup++
+	cleanups[id] = run
+	cleanupMu.Unlock()
+
+	return func() {
+		cleanupMu.Lock()
+		delete(cleanups, id)
+		cleanupMu.Unlock()
+		run()
+	}
+}
+
+// runCleanups runs every registered cleanup, newest first.
+func runCleanups() {
+	cleanupMu.Lock()
+	ids := make([]int, 0, len(cleanups))
+	for id := range cleanups {
+		ids = append(ids, id)
+	}
+	fns := cleanups
+	cleanups = map[int]func(){}
+	cleanupMu.Unlock()
+
+	slices.Sort(ids)
+	for i := len(ids) - 1; i >= 0; i-- {
+		fns[ids[i]]()
+	}
+}
+
+// handleSignals runs pending cleanups before exiting on SIGINT/SIGTERM, so an
+// interrupted switch still restores the efivar immutable flag.
+func handleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		log.Warn().Msgf("received %s, cleaning up", sig)
+		runCleanups()
+		code := 130
+		if sig == syscall.SIGTERM {
+			code = 143
+		}
+		os.Exit(code)
+	}()
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestRunCleanupsRunsNewestFirstOnce(t *testing.T) {
+	var order []int
+	registerCleanup(func() { order = append(order, 1) })
+	done := registerCleanup(func() { order = append(order, 2) })
+	registerCleanup(func() { order = append(order, 3) })
+
+	runCleanups()
+	done()
+	runCleanups()
+
+	if len(order) != 3 || order[0] != 3 || order[1] != 2 || order[2] != 1 {
+		t.Fatalf("unexpected cleanup order: %v", order)
+	}
+}
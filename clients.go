@@ -0,0 +1,303 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	procRoot = "/proc"
+	drmRoot  = "/sys/class/drm"
+)
+
+// gpuClient is a process holding GPU device nodes open.
+type gpuClient struct {
+	pid   int
+	comm  string
+	nodes []string
+}
+
+// gpuClients scans /proc/*/fd for open DRM and NVIDIA device nodes and
+// groups the processes by the PCI address of the GPU they belong to.
+// Without root only the caller's own processes are visible.
+func gpuClients() (map[string][]gpuClient, error) {
+	entries, err := hostfs.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	nvidiaAddr := "nvidia"
+	if gpus, err := listGPUs(); err == nil {
+		for _, g := range gpus {
+			if g.vendor == nvidiaVendor {
+				nvidiaAddr = g.addr
+				break
+			}
+		}
+	}
+
+	clients := map[string][]gpuClient{}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, e.Name(), "fd")
+		fds, err := hostfs.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		byGPU := map[string][]string{}
+		for _, fd := range fds {
+			target, err := hostfs.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			addr, ok := gpuNodeOwner(target, nvidiaAddr)
+			if !ok || slices.Contains(byGPU[addr], target) {
+				continue
+			}
+			byGPU[addr] = append(byGPU[addr], target)
+		}
+		comm := readFirstLine(filepath.Join(procRoot, e.Name(), "comm"))
+		for addr, nodes := range byGPU {
+			sort.Strings(nodes)
+			clients[addr] = append(clients[addr], gpuClient{pid: pid, comm: comm, nodes: nodes})
+		}
+	}
+	for addr := range clients {
+		sort.Slice(clients[addr], func(i, j int) bool { return clients[addr][i].pid < clients[addr][j].pid })
+	}
+	return clients, nil
+}
+
+// gpuNodeOwner maps a device node to the PCI address of its GPU. NVIDIA's
+// control nodes are not per device and go to nvidiaAddr.
+func gpuNodeOwner(node, nvidiaAddr string) (string, bool) {
+	switch {
+	case strings.HasPrefix(node, "/dev/dri/"):
+		target, err := hostfs.EvalSymlinks(filepath.Join(drmRoot, filepath.Base(node), "device"))
+		if err != nil {
+			return "", false
+		}
+		return filepath.Base(target), true
+	case strings.HasPrefix(node, "/dev/nvidia"):
+		return nvidiaAddr, true
+	}
+	return "", false
+}
+
+func whoUsesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "who-uses",
+		Short: "List processes using each GPU",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			clients, err := gpuClients()
+			if err != nil {
+				return err
+			}
+			if os.Geteuid() != 0 {
+				log.Warn().Msg("not root: only your own processes are shown")
+			}
+			gpus, err := listGPUs()
+			if gpuSelector != "" {
+				gpus, err = selectedDGPUs()
+			}
+			if err != nil {
+				return err
+			}
+			for _, g := range gpus {
+				outf("%s %s:", g.addr, pciName(g.vendor, g.device))
+				printGpuClients(clients[g.addr])
+				delete(clients, g.addr)
+			}
+			if gpuSelector != "" {
+				return nil
+			}
+			// Nodes whose GPU is not in the PCI list, e.g. nvidia without one.
+			for addr, list := range clients {
+				outf("%s:", addr)
+				printGpuClients(list)
+			}
+			return nil
+		},
+	}
+}
+
+func printGpuClients(list []gpuClient) {
+	if len(list) == 0 {
+		outln("  (no processes)")
+		return
+	}
+	for _, c := range list {
+		outf("  %7d  %-16s %s", c.pid, c.comm, strings.Join(c.nodes, " "))
+	}
+}
+
+// dgpuClients returns the processes holding the discrete GPU open, or the
+// one selected with --gpu.
+func dgpuClients() ([]gpuClient, error) {
+	clients, err := gpuClients()
+	if err != nil {
+		return nil, err
+	}
+	dgpus, err := selectedDGPUs()
+	if err != nil {
+		return nil, err
+	}
+	var list []gpuClient
+	for _, g := range dgpus {
+		list = append(list, clients[g.addr]...)
+	}
+	// nvidiactl and nvidia-modeset are shared by every NVIDIA GPU.
+	if gpuSelector == "" {
+		list = append(list, clients["nvidia"]...)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].pid < list[j].pid })
+	return list, nil
+}
+
+type killOptions struct {
+	enabled bool
+	grace   time.Duration
+}
+
+func (o *killOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.enabled, "kill", false, "terminate processes using the dGPU before switching away from discrete")
+	cmd.Flags().DurationVar(&o.grace, "kill-timeout", 5*time.Second, "time to wait after SIGTERM before sending SIGKILL")
+}
+
+// run lists the dGPU clients, asks for confirmation and terminates them when
+// --kill was given and mode moves off the dGPU.
+func (o *killOptions) run(ctx context.Context, mode gpuMode) error {
+	if !o.enabled {
+		return nil
+	}
+	if mode == modeDiscrete {
+		log.Warn().Msg("--kill has no effect when switching to discrete")
+		return nil
+	}
+	clients, err := dgpuClients()
+	if err != nil {
+		return err
+	}
+	if len(clients) == 0 {
+		log.Info().Msg("no processes are using the dGPU")
+		return nil
+	}
+	log.Info().Msg("processes using the dGPU:")
+	printGpuClients(clients)
+	ok, err := confirm(fmt.Sprintf("Terminate %d process(es)?", len(clients)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not terminating dGPU clients; aborting switch")
+	}
+	pids := make([]int, 0, len(clients))
+	for _, c := range clients {
+		pids = append(pids, c.pid)
+	}
+	return terminate(ctx, pids, o.grace)
+}
+
+// terminate sends SIGTERM to pids, then SIGKILL to those still alive after
+// grace.
+func terminate(ctx context.Context, pids []int, grace time.Duration) error {
+	for _, pid := range pids {
+		if err := unix.Kill(pid, unix.SIGTERM); err != nil && !errors.Is(err, unix.ESRCH) {
+			return fmt.Errorf("SIGTERM %d failed: %w", pid, err)
+		}
+	}
+	deadline := time.Now().Add(grace)
+	for {
+		alive := pids[:0:0]
+		for _, pid := range pids {
+			if unix.Kill(pid, 0) == nil {
+				alive = append(alive, pid)
+			}
+		}
+		if len(alive) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			for _, pid := range alive {
+				log.Warn().Msgf("process %d ignored SIGTERM; sending SIGKILL", pid)
+				if err := unix.Kill(pid, unix.SIGKILL); err != nil && !errors.Is(err, unix.ESRCH) {
+					return fmt.Errorf("SIGKILL %d failed: %w", pid, err)
+				}
+			}
+			return nil
+		}
+		pids = alive
+		if err := sleepContext(ctx, 100*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// renderingNode reports whether node is used for rendering rather than just
+// control: NVIDIA's per-GPU nodes and DRM render nodes.
+func renderingNode(node string) bool {
+	if strings.HasPrefix(node, "/dev/dri/renderD") {
+		return true
+	}
+	rest, ok := strings.CutPrefix(node, "/dev/nvidia")
+	if !ok || rest == "" {
+		return false
+	}
+	_, err := strconv.Atoi(rest)
+	return err == nil
+}
+
+// busyClients keeps the clients that render on the GPU, leaving out daemons
+// such as nvidia-persistenced that only keep the driver initialised.
+func busyClients(clients []gpuClient) []gpuClient {
+	var busy []gpuClient
+	for _, c := range clients {
+		if c.comm == "nvidia-persistenced" {
+			continue
+		}
+		if slices.ContainsFunc(c.nodes, renderingNode) {
+			busy = append(busy, c)
+		}
+	}
+	return busy
+}
+
+// checkDgpuIdle refuses, unless forced, to move off the dGPU while processes
+// render on it: they tend to crash at the next suspend or device removal.
+func checkDgpuIdle(mode gpuMode) error {
+	if mode == modeDiscrete {
+		return nil
+	}
+	clients, err := dgpuClients()
+	if err != nil {
+		log.Debug().Msgf("list dGPU clients failed: %v", err)
+		return nil
+	}
+	busy := busyClients(clients)
+	if len(busy) == 0 {
+		return nil
+	}
+	log.Warn().Msg("processes are rendering on the dGPU:")
+	printGpuClients(busy)
+	if forceWrites {
+		return nil
+	}
+	return fmt.Errorf("%d process(es) are using the dGPU; close them, pass --kill, or re-run with --force", len(busy))
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGpuClients(t *testing.T) {
+	originalProc, originalDRM, originalPCI := procRoot, drmRoot, pciRoot
+	procRoot, drmRoot, pciRoot = t.TempDir(), t.TempDir(), t.TempDir()
+	t.Cleanup(func() { procRoot, drmRoot, pciRoot = originalProc, originalDRM, originalPCI })
+
+	mkdir := func(path string) {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	symlink := func(target, link string) {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+
+	dgpu := filepath.Join(pciRoot, "0000:01:00.0")
+	igpu := filepath.Join(pciRoot, "0000:06:00.0")
+	mkdir(dgpu)
+	mkdir(igpu)
+	for dir, vendor := range map[string]string{dgpu: nvidiaVendor, igpu: "0x1002"} {
+		_ = os.WriteFile(filepath.Join(dir, "class"), []byte("0x030000\n"), 0o644)
+		_ = os.WriteFile(filepath.Join(dir, "vendor"), []byte(vendor+"\n"), 0o644)
+	}
+	mkdir(filepath.Join(drmRoot, "renderD129"))
+	symlink(igpu, filepath.Join(drmRoot, "renderD129", "device"))
+
+	proc := func(pid, comm string, targets ...string) {
+		fd := filepath.Join(procRoot, pid, "fd")
+		mkdir(fd)
+		_ = os.WriteFile(filepath.Join(procRoot, pid, "comm"), []byte(comm+"\n"), 0o644)
+		for i, target := range targets {
+			symlink(target, filepath.Join(fd, string(rune('3'+i))))
+		}
+	}
+	proc("100", "firefox", "/dev/dri/renderD129", "/dev/null")
+	proc("200", "steam", "/dev/nvidiactl", "/dev/nvidia0", "/dev/nvidia0")
+	proc("self", "ignored", "/dev/nvidia0")
+
+	clients, err := gpuClients()
+	if err != nil {
+		t.Fatalf("gpuClients: %v", err)
+	}
+	if got := clients["0000:06:00.0"]; len(got) != 1 || got[0].pid != 100 || got[0].comm != "firefox" {
+		t.Fatalf("unexpected iGPU clients: %+v", got)
+	}
+	got := clients["0000:01:00.0"]
+	if len(got) != 1 || got[0].pid != 200 || len(got[0].nodes) != 2 {
+		t.Fatalf("unexpected dGPU clients: %+v", got)
+	}
+}
+
+func TestTerminateEscalatesToSigkill(t *testing.T) {
+	start := func(args ...string) (*exec.Cmd, chan error) {
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Start(); err != nil {
+			t.Skipf("start %v: %v", args, err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		return cmd, done
+	}
+	polite, politeDone := start("sleep", "60")
+	stubborn, stubbornDone := start("sh", "-c", `trap "" TERM; while :; do sleep 0.05; done`)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := terminate(context.Background(), []int{polite.Process.Pid, stubborn.Process.Pid}, 200*time.Millisecond); err != nil {
+		t.Fatalf("terminate: %v", err)
+	}
+	for name, done := range map[string]chan error{"polite": politeDone, "stubborn": stubbornDone} {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s process still running", name)
+		}
+	}
+}
+
+func TestBusyClients(t *testing.T) {
+	clients := []gpuClient{
+		{pid: 1, comm: "nvidia-persistenced", nodes: []string{"/dev/nvidia0", "/dev/nvidiactl"}},
+		{pid: 2, comm: "Xorg", nodes: []string{"/dev/dri/card1", "/dev/nvidiactl", "/dev/nvidia-modeset"}},
+		{pid: 3, comm: "game", nodes: []string{"/dev/nvidia0", "/dev/nvidia-uvm"}},
+		{pid: 4, comm: "firefox", nodes: []string{"/dev/dri/renderD128"}},
+	}
+	busy := busyClients(clients)
+	if len(busy) != 2 || busy[0].pid != 3 || busy[1].pid != 4 {
+		t.Fatalf("unexpected busy clients: %+v", busy)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func completionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion <bash|zsh|fish>",
+		Short: "Generate a shell completion script",
+		Long: "Generate a shell completion script.\n\n" +
+			"  bash: source <(msi-gpu-switcher completion bash)\n" +
+			"  zsh:  msi-gpu-switcher completion zsh > \"${fpath[1]}/_msi-gpu-switcher\"\n" +
+			"  fish: msi-gpu-switcher completion fish > ~/.config/fish/completions/msi-gpu-switcher.fish",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			default:
+				return root.GenFishCompletion(os.Stdout, true)
+			}
+		},
+	}
+}
+
+// completeModes completes the first argument with the supported mode names.
+func completeModes(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return modeNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeMsiVars completes the first argument with the MSI variables present
+// in efivarfs, using bare names for the MSI vendor GUID like efiVarPath does.
+func completeMsiVars(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	vars, err := listEfiVars(cmd.Context(), msiVendorGuids)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var names []string
+	for _, v := range vars {
+		if strings.EqualFold(v.guid, msiVendorGuid) {
+			names = append(names, v.name)
+		} else {
+			names = append(names, v.name+"-"+v.guid)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeLayouts(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, l := range uefiLayouts {
+		names = append(names, l.name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompleteModes(t *testing.T) {
+	original := triStateModes
+	t.Cleanup(func() { triStateModes = original })
+
+	triStateModes = false
+	got, _ := completeModes(nil, nil, "")
+	if want := []string{"hybrid", "discrete"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, _ := completeModes(nil, []string{"hybrid"}, ""); got != nil {
+		t.Fatalf("expected no completion after the mode, got %v", got)
+	}
+}
+
+func TestCompleteMsiVars(t *testing.T) {
+	original := efivarsDir
+	efivarsDir = t.TempDir()
+	t.Cleanup(func() { efivarsDir = original })
+
+	for _, name := range []string{"MsiDCVarData-" + msiVendorGuid, "BootOrder-8be4df61-93ca-11d2-aa0d-00e098032b8c"} {
+		if err := os.WriteFile(filepath.Join(efivarsDir, name), []byte{7, 0, 0, 0, 1}, 0o644); err != nil {
+			t.Fatalf("write var: %v", err)
+		}
+	}
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	got, _ := completeMsiVars(cmd, nil, "")
+	if want := []string{"MsiDCVarData"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var configPath = "/etc/msi-gpu-switcher/config.json"
+
+// loadedConfig is the config read at startup, for commands whose own flags
+// can also be set there.
+var loadedConfig config
+
+// config mirrors the global flags and the flags of the commands run by
+// services and hooks (daemon, handle-event, resume); flags given on the
+// command line win.
+type config struct {
+	UefiVarName        string   `json:"uefi_var_name,omitempty"`
+	UefiVarGuid        string   `json:"uefi_var_guid,omitempty"`
+	UefiModeByte       *int     `json:"uefi_mode_byte,omitempty"`
+	CreateUefiVar      bool     `json:"create_uefi_var,omitempty"`
+	TriState           bool     `json:"tri_state,omitempty"`
+	UefiLayout         string   `json:"uefi_layout,omitempty"`
+	KeepUnlocked       bool     `json:"keep_unlocked,omitempty"`
+	Sandbox            bool     `json:"sandbox,omitempty"`
+	DropPrivileges     string   `json:"drop_privileges,omitempty"`
+	Backends           []string `json:"backends,omitempty"`
+	ReadOnly           bool     `json:"read_only,omitempty"`
+	EcTimeout          string   `json:"ec_timeout,omitempty"`
+	EfivarTimeout      string   `json:"efivar_timeout,omitempty"`
+	CommandTimeout     string   `json:"command_timeout,omitempty"`
+	Journal            bool     `json:"journal,omitempty"`
+	LogLevel           string   `json:"log_level,omitempty"`
+	LogFile            string   `json:"log_file,omitempty"`
+	LogFormat          string   `json:"log_format,omitempty"`
+	NoColor            bool     `json:"no_color,omitempty"`
+	ManagePersistenced bool     `json:"manage_persistenced,omitempty"`
+	DockPolicy         bool     `json:"dock_policy,omitempty"`
+	DisplayPolicy      bool     `json:"display_policy,omitempty"`
+	BatteryPolicy      bool     `json:"battery_policy,omitempty"`
+	BatteryThreshold   *int     `json:"battery_threshold,omitempty"`
+	BatteryHysteresis  *int     `json:"battery_hysteresis,omitempty"`
+	PolicyDebounce     string   `json:"policy_debounce,omitempty"`
+	PolicyMinInterval  string   `json:"policy_min_interval,omitempty"`
+	ReconcileOnResume  bool     `json:"reconcile_on_resume,omitempty"`
+
+	Profiles map[string]profile `json:"profiles,omitempty"`
+}
+
+// loadConfig reads path; a missing file yields the zero config.
+func loadConfig(path string) (config, error) {
+	var cfg config
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"uefi_var_name": "MsiGpuMode", "uefi_mode_byte": 3}`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.UefiVarName != "MsiGpuMode" || cfg.UefiModeByte == nil || *cfg.UefiModeByte != 3 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if cfg.UefiVarGuid != "" {
+		t.Fatalf("expected empty guid, got %q", cfg.UefiVarGuid)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.UefiVarName != "" || cfg.UefiModeByte != nil {
+		t.Fatalf("expected zero config, got %+v", cfg)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := loadConfig(path); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
@@ -0,0 +1,301 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func daemonCmd() *cobra.Command {
+	var opts daemonOptions
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run in the background and watch GPU mode state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			requireRoot()
+			if err := opts.policy.applyConfig(cmd, loadedConfig); err != nil {
+				return err
+			}
+			opts.reload = func(ctx context.Context) (policyOptions, error) {
+				if err := reloadConfig(ctx, cmd); err != nil {
+					return policyOptions{}, err
+				}
+				err := opts.policy.applyConfig(cmd, loadedConfig)
+				return opts.policy, err
+			}
+			return runDaemon(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().DurationVar(&opts.interval, "interval", 5*time.Second, "state polling interval")
+	cmd.Flags().BoolVar(&opts.enforce, "enforce", false, "re-apply the last requested mode when something else changes it")
+	cmd.Flags().BoolVar(&opts.dbus, "dbus", false, "serve "+dbusName+" on the system bus (used by tray)")
+	cmd.Flags().StringVar(&opts.listen, "listen", "", "serve the HTTP API on unix:<path> or a loopback host:port")
+	cmd.Flags().StringVar(&opts.tokenFile, "api-token-file", "", "file holding the bearer token the HTTP API requires")
+	cmd.Flags().StringVar(&opts.mqtt.broker, "mqtt-broker", "", "publish to this MQTT broker, e.g. tcp://homeassistant.local:1883")
+	cmd.Flags().StringVar(&opts.mqtt.topic, "mqtt-topic", "", "MQTT topic prefix (default msi-gpu-switcher/<hostname>)")
+	cmd.Flags().StringVar(&opts.mqtt.username, "mqtt-username", "", "MQTT username")
+	cmd.Flags().StringVar(&opts.mqtt.passwordFile, "mqtt-password-file", "", "file holding the MQTT password")
+	cmd.Flags().BoolVar(&opts.acpiEvents, "acpi-events", true, "react to kernel ACPI events (WMI, display, power, lid) right away")
+	cmd.Flags().BoolVar(&opts.hotkey, "hotkey", false, "switch to the next mode when the Fn GPU key is pressed")
+	cmd.Flags().IntVar(&opts.hotkeyCode, "hotkey-code", defaultHotkeyCode, "input key code of the GPU key (see evtest)")
+	cmd.Flags().StringVar(&opts.mqtt.discoveryPrefix, "mqtt-discovery-prefix", "homeassistant", "Home Assistant discovery prefix (empty disables discovery)")
+	opts.policy.addFlags(cmd)
+	return cmd
+}
+
+type daemonOptions struct {
+	interval   time.Duration
+	enforce    bool
+	dbus       bool
+	listen     string
+	tokenFile  string
+	mqtt       mqttOptions
+	policy     policyOptions
+	acpiEvents bool
+	// hotkey listens for hotkeyCode on the input devices.
+	hotkey     bool
+	hotkeyCode int
+	// reload re-reads the config file and returns the new policy settings.
+	reload func(context.Context) (policyOptions, error)
+}
+
+// reloadConfig re-reads the config file for the running cmd. Flags not given
+// on the command line go back to their defaults first, so keys removed from
+// the file take effect too; the root's PersistentPreRunE then applies the
+// file and the model quirk again. The lock keeps a switch in progress from
+// seeing half of the new settings.
+func reloadConfig(ctx context.Context, cmd *cobra.Command) error {
+	if _, err := loadConfig(configPath); err != nil {
+		return err
+	}
+	unlock, err := acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var reset error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			reset = errors.Join(reset, f.Value.Set(f.DefValue))
+		}
+	})
+	if reset != nil {
+		return reset
+	}
+	return cmd.Root().PersistentPreRunE(cmd, nil)
+}
+
+func runDaemon(ctx context.Context, opts daemonOptions) error {
+	interval := opts.interval
+	log.Info().Msgf("daemon started (poll interval %s, enforce %v)", interval, opts.enforce)
+	var svc *dbusService
+	if opts.dbus {
+		var (
+			stop func()
+			err  error
+		)
+		if svc, stop, err = serveDBus(ctx); err != nil {
+			return err
+		}
+		defer stop()
+	}
+	if opts.listen != "" {
+		stop, err := serveAPI(ctx, opts.listen, opts.tokenFile)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	var bridge *mqttBridge
+	if opts.mqtt.broker != "" {
+		var (
+			stop func()
+			err  error
+		)
+		if bridge, stop, err = startMQTT(ctx, opts.mqtt); err != nil {
+			return err
+		}
+		defer stop()
+	}
+	var hotkeys <-chan struct{}
+	if opts.hotkey {
+		var err error
+		if hotkeys, err = listenHotkey(ctx, opts.hotkeyCode); err != nil {
+			return err
+		}
+	}
+	var acpiEvents <-chan acpiEvent
+	if opts.acpiEvents {
+		var err error
+		if acpiEvents, err = listenACPI(ctx); err != nil {
+			log.Warn().Msgf("not watching ACPI events: %v", err)
+		}
+	}
+	// Everything the daemon listens on is open by now.
+	enterSandbox()
+	w := &uefiWatcher{enforce: opts.enforce}
+	policies := newPolicyRunner(opts.policy)
+	// The servers above keep running across a reload; only the config file,
+	// the model quirk and the policies are read again.
+	reload := func() error {
+		policy, err := opts.reload(ctx)
+		if err != nil {
+			return err
+		}
+		policies = policies.reconfigured(policy)
+		log.Info().Msgf("reloaded %s", configPath)
+		return nil
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var reloads chan chan error
+	if svc != nil {
+		reloads = svc.reloads
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.check(ctx, interval)
+		if policies != nil {
+			policies.evaluate(ctx)
+		}
+		if svc != nil {
+			svc.refresh()
+		}
+		if bridge != nil {
+			bridge.refresh()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-hup:
+			if err := reload(); err != nil {
+				log.Error().Msgf("reload failed, keeping the previous config: %v", err)
+			}
+		case done := <-reloads:
+			done <- reload()
+		case ev := <-acpiEvents:
+			// The next round of the loop re-reads the state at once.
+			handleACPIEvent(ctx, ev)
+		case <-hotkeys:
+			mode, switched, err := hotkeyToggle(ctx)
+			if err != nil {
+				log.Error().Msgf("hotkey switch failed: %v", err)
+			}
+			if svc != nil {
+				name := ""
+				if switched {
+					name = mode.String()
+				}
+				svc.refresh()
+				svc.hotkeySwitched(name, err)
+			}
+		}
+	}
+}
+
+// uefiWatcher notices changes of the UEFI mode byte between polls and tells
+// apart our own switches from ones made by other tools or firmware updates.
+type uefiWatcher struct {
+	last    byte
+	known   bool
+	enforce bool
+}
+
+func (w *uefiWatcher) check(ctx context.Context, interval time.Duration) {
+	if !exists(uefiVarPath) {
+		if w.known {
+			log.Warn().Msgf("UEFI var %s disappeared", uefiVarName)
+		}
+		w.known = false
+		return
+	}
+	value, err := readUefiModeByte(ctx)
+	if err != nil {
+		log.Debug().Msgf("uefi watch: %v", err)
+		return
+	}
+	before, known := w.last, w.known
+	w.last, w.known = value, true
+	mode, _ := modeFromByte(value)
+	if !known {
+		// The first poll has no value to compare with, but the variable may
+		// have been rewritten while the daemon was not running, typically by
+		// MSI Center while Windows was booted.
+		if w.enforce {
+			if want, ok := lastRequestedMode(); ok && want != mode {
+				log.Warn().Msgf("UEFI GPU mode is %s, not the last requested %s; it was changed while the daemon was not running", mode, want)
+				w.enforceMode(ctx, want)
+			}
+		}
+		return
+	}
+	if before == value {
+		return
+	}
+
+	prev, _ := modeFromByte(before)
+	if switchedBySelf(mode, time.Now(), 2*interval) {
+		log.Info().Msgf("UEFI GPU mode changed %s -> %s by msi-gpu-switcher", prev, mode)
+		return
+	}
+	log.Warn().Msgf("UEFI GPU mode changed %s -> %s externally (another tool or a firmware update)", prev, mode)
+	if !w.enforce {
+		return
+	}
+	if want, ok := lastRequestedMode(); ok && want != mode {
+		w.enforceMode(ctx, want)
+	}
+}
+
+// enforceMode switches back to want, the last requested mode.
+func (w *uefiWatcher) enforceMode(ctx context.Context, want gpuMode) {
+	log.Info().Msgf("enforcing requested mode %s", want)
+	err := switchGPUWith(ctx, want, switchOptions{enforce: true})
+	recordAudit(auditActor{via: "daemon"}, []string{"enforce", want.String()}, err)
+	if err != nil {
+		log.Error().Msgf("enforce %s failed: %v", want, err)
+	}
+}
+
+// lastRequestedMode returns the mode of the most recent successful switch.
+func lastRequestedMode() (gpuMode, bool) {
+	entries, err := readHistory()
+	if err != nil {
+		return 0, false
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Result != "ok" {
+			continue
+		}
+		mode, err := parseMode(entries[i].Mode)
+		return mode, err == nil
+	}
+	return 0, false
+}
+
+// switchedBySelf reports whether the history holds a successful switch to
+// mode within window before now.
+func switchedBySelf(mode gpuMode, now time.Time, window time.Duration) bool {
+	entries, err := readHistory()
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+	last := entries[len(entries)-1]
+	return last.Mode == mode.String() && last.Result == "ok" && now.Sub(last.Time) <= window
+}
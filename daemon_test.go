@@ -0,0 +1,148 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSwitchedBySelf(t *testing.T) {
+	originalDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = originalDir })
+
+	now := time.Now().UTC()
+	if switchedBySelf(modeDiscrete, now, time.Minute) {
+		t.Fatalf("expected false without history")
+	}
+	if err := appendHistory(historyEntry{Time: now.Add(-10 * time.Second), Mode: "discrete", Backend: "uefi+ec", Result: "ok"}); err != nil {
+		t.Fatalf("appendHistory: %v", err)
+	}
+	if !switchedBySelf(modeDiscrete, now, time.Minute) {
+		t.Fatalf("expected recent switch to be ours")
+	}
+	if switchedBySelf(modeHybrid, now, time.Minute) {
+		t.Fatalf("expected different mode to be external")
+	}
+	if switchedBySelf(modeDiscrete, now, 5*time.Second) {
+		t.Fatalf("expected switch outside window to be external")
+	}
+}
+
+func TestUefiWatcherEnforcesOnFirstPoll(t *testing.T) {
+	switchFixture(t)
+	ctx := context.Background()
+	// dgpu was requested, and the variable went back to hybrid before the
+	// daemon started, as MSI Center does on Windows.
+	if err := appendHistory(historyEntry{Time: time.Now().Add(-time.Hour), Mode: "discrete", Result: "ok"}); err != nil {
+		t.Fatalf("history: %v", err)
+	}
+
+	(&uefiWatcher{}).check(ctx, time.Second)
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("without --enforce: readUefiGpuMode = %v %v, want hybrid", mode, err)
+	}
+	w := &uefiWatcher{enforce: true}
+	w.check(ctx, time.Second)
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v, want the requested discrete", mode, err)
+	}
+	if !w.known {
+		t.Fatal("expected the first poll to record a baseline")
+	}
+}
+
+func TestLastRequestedModeSkipsFailures(t *testing.T) {
+	originalDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = originalDir })
+
+	if _, ok := lastRequestedMode(); ok {
+		t.Fatalf("expected no mode without history")
+	}
+	now := time.Now().UTC()
+	_ = appendHistory(historyEntry{Time: now, Mode: "discrete", Backend: "uefi+ec", Result: "ok"})
+	_ = appendHistory(historyEntry{Time: now, Mode: "hybrid", Backend: "ec", Result: "EC MUX write failed"})
+
+	mode, ok := lastRequestedMode()
+	if !ok || mode != modeDiscrete {
+		t.Fatalf("expected discrete, got %s %v", mode, ok)
+	}
+}
+
+func TestReloadConfigResetsUnchangedFlags(t *testing.T) {
+	dir := t.TempDir()
+	originalConfig, originalLock := configPath, lockPath
+	configPath, lockPath = filepath.Join(dir, "config.json"), filepath.Join(dir, "test.lock")
+	t.Cleanup(func() { configPath, lockPath = originalConfig, originalLock })
+
+	var fromFile, fromFlag bool
+	var applied int
+	root := &cobra.Command{
+		Use: "root",
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applied++
+			return nil
+		},
+	}
+	sub := &cobra.Command{Use: "sub"}
+	sub.Flags().BoolVar(&fromFile, "from-file", false, "")
+	sub.Flags().BoolVar(&fromFlag, "from-flag", false, "")
+	root.AddCommand(sub)
+	if err := sub.Flags().Parse([]string{"--from-flag"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// As if set from the config file at startup.
+	fromFile = true
+
+	if err := os.WriteFile(configPath, []byte("{"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := reloadConfig(context.Background(), sub); err == nil {
+		t.Fatal("expected a broken config to fail the reload")
+	}
+	if !fromFile || applied != 0 {
+		t.Fatalf("failed reload touched the settings: fromFile=%v applied=%d", fromFile, applied)
+	}
+
+	if err := os.WriteFile(configPath, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := reloadConfig(context.Background(), sub); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if fromFile {
+		t.Fatal("expected a flag set only by the old config to go back to its default")
+	}
+	if !fromFlag {
+		t.Fatal("expected a command line flag to survive the reload")
+	}
+	if applied != 1 {
+		t.Fatalf("PersistentPreRunE ran %d times, want 1", applied)
+	}
+}
+
+func TestDaemonRejectsBadDurations(t *testing.T) {
+	cmd := daemonCmd()
+	cmd.SetArgs([]string{"--interval", "0"})
+	cmd.SilenceErrors, cmd.SilenceUsage = true, true
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected --interval 0 to be rejected")
+	}
+
+	var opts policyOptions
+	cmd = &cobra.Command{Use: "daemon"}
+	opts.addFlags(cmd)
+	if err := opts.applyConfig(cmd, config{PolicyDebounce: "-1s"}); err == nil {
+		t.Fatal("expected a negative policy_debounce to be rejected")
+	}
+	if err := opts.applyConfig(cmd, config{PolicyDebounce: "1s"}); err != nil || opts.debounce != time.Second {
+		t.Fatalf("applyConfig = %v, debounce %s", err, opts.debounce)
+	}
+}
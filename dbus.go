@@ -0,0 +1,311 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dbusName  = "io.github.ElXreno.MsiGpuSwitcher"
+	dbusPath  = dbus.ObjectPath("/io/github/ElXreno/MsiGpuSwitcher")
+	dbusIface = dbusName
+)
+
+// dbusService is what the daemon exports on the system bus. Who may call
+// Switch and Reload is decided by the bus policy in dist/, not here.
+type dbusService struct {
+	ctx   context.Context
+	conn  *dbus.Conn
+	props *prop.Properties
+	// reloads hands Reload calls to the daemon loop, which answers on the
+	// channel it receives.
+	reloads chan chan error
+}
+
+// Mode returns the mode stored in the UEFI variable.
+func (s *dbusService) Mode() (string, *dbus.Error) {
+	mode, err := readUefiGpuMode(s.ctx)
+	if err != nil {
+		return "", dbusError(err)
+	}
+	return mode.String(), nil
+}
+
+// Modes returns the modes this firmware supports.
+func (s *dbusService) Modes() ([]string, *dbus.Error) {
+	return modeNames(), nil
+}
+
+// Switch performs a switch exactly like the switch command.
+func (s *dbusService) Switch(sender dbus.Sender, name string) *dbus.Error {
+	mode, err := parseMode(name)
+	if err == nil {
+		err = checkModeSupported(mode)
+	}
+	if err == nil {
+		log.Info().Msgf("switch to %s requested over D-Bus", mode)
+		err = switchGPU(s.ctx, mode)
+		recordAudit(s.caller(sender), []string{"switch", mode.String()}, err)
+	}
+	s.refresh()
+	if err != nil {
+		return dbusError(err)
+	}
+	return nil
+}
+
+// Reload makes the daemon re-read its config file, like SIGHUP.
+func (s *dbusService) Reload(sender dbus.Sender) *dbus.Error {
+	done := make(chan error, 1)
+	select {
+	case s.reloads <- done:
+	case <-s.ctx.Done():
+		return dbusError(s.ctx.Err())
+	}
+	err := <-done
+	recordAudit(s.caller(sender), []string{"reload"}, err)
+	if err != nil {
+		return dbusError(err)
+	}
+	return nil
+}
+
+// dbusError names errors of a known kind, e.g.
+// io.github.ElXreno.MsiGpuSwitcher.Error.NeedsRoot, so clients need not
+// match messages.
+func dbusError(err error) *dbus.Error {
+	if k, ok := errorKind(err); ok {
+		return dbus.NewError(dbusIface+".Error."+k.name, []any{err.Error()})
+	}
+	return dbus.MakeFailedError(err)
+}
+
+// caller asks the bus for the uid and pid behind sender.
+func (s *dbusService) caller(sender dbus.Sender) auditActor {
+	a := auditActor{via: "dbus", uid: -1}
+	if s.conn == nil {
+		return a
+	}
+	bus := s.conn.BusObject()
+	var uid, pid uint32
+	if err := bus.Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender)).Store(&uid); err == nil {
+		a.uid = int(uid)
+	}
+	if err := bus.Call("org.freedesktop.DBus.GetConnectionUnixProcessID", 0, string(sender)).Store(&pid); err == nil {
+		a.pid = int(pid)
+	}
+	return a
+}
+
+// modeProperties returns CurrentMode, the mode the system booted with, and
+// PendingMode, the mode stored for the next boot. Unknown values are "".
+func (s *dbusService) modeProperties() (current, pending string) {
+	if mode, err := activeMode(); err == nil {
+		current = mode.String()
+	}
+	if mode, err := readUefiGpuMode(s.ctx); err == nil {
+		pending = mode.String()
+	}
+	return current, pending
+}
+
+// refresh updates the properties, emitting PropertiesChanged for the ones
+// whose value changed.
+func (s *dbusService) refresh() {
+	if s.props == nil {
+		return
+	}
+	current, pending := s.modeProperties()
+	for name, value := range map[string]string{"CurrentMode": current, "PendingMode": pending} {
+		if s.props.GetMust(dbusIface, name) != value {
+			s.props.SetMust(dbusIface, name, value)
+		}
+	}
+}
+
+// hotkeySwitched emits HotkeySwitched(mode, error) after a switch from the
+// GPU key, for the tray to show a notification; error is "" on success, and
+// mode is "" when no switch was tried.
+func (s *dbusService) hotkeySwitched(mode string, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if err := s.conn.Emit(dbusPath, dbusIface+".HotkeySwitched", mode, msg); err != nil {
+		log.Warn().Msgf("emit HotkeySwitched failed: %v", err)
+	}
+}
+
+// serveDBus claims dbusName on the system bus and serves dbusService until
+// ctx is done or the returned func closes the connection.
+func serveDBus(ctx context.Context) (*dbusService, func(), error) {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect system bus failed: %w", err)
+	}
+	svc := &dbusService{ctx: ctx, conn: conn, reloads: make(chan chan error)}
+	if err := svc.export(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("request bus name failed: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, nil, fmt.Errorf("bus name %s is already taken", dbusName)
+	}
+	log.Info().Msgf("serving %s on the system bus", dbusName)
+	return svc, func() { conn.Close() }, nil
+}
+
+func (s *dbusService) export(conn *dbus.Conn) error {
+	current, pending := s.modeProperties()
+	props, err := prop.Export(conn, dbusPath, prop.Map{
+		dbusIface: {
+			"CurrentMode": {Value: current, Emit: prop.EmitTrue},
+			"PendingMode": {Value: pending, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.props = props
+	if err := conn.Export(s, dbusPath, dbusIface); err != nil {
+		return err
+	}
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{
+				Name:       dbusIface,
+				Methods:    introspect.Methods(s),
+				Properties: props.Introspection(dbusIface),
+				Signals: []introspect.Signal{{Name: "HotkeySwitched", Args: []introspect.Arg{
+					{Name: "mode", Type: "s"},
+					{Name: "error", Type: "s"},
+				}}},
+			},
+		},
+	}
+	return conn.Export(introspect.NewIntrospectable(node), dbusPath, "org.freedesktop.DBus.Introspectable")
+}
+
+// dbusSwitch asks the daemon to switch to mode.
+func dbusSwitch(ctx context.Context, mode gpuMode) error {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("connect system bus failed: %w", err)
+	}
+	defer conn.Close()
+	call := conn.Object(dbusName, dbusPath).CallWithContext(ctx, dbusIface+".Switch", 0, mode.String())
+	if call.Err != nil {
+		return fmt.Errorf("daemon switch failed: %w", call.Err)
+	}
+	return nil
+}
+
+// dbusChanges reports the daemon's PropertiesChanged signals on the
+// returned channel until ctx is done. It fails when no daemon serves
+// dbusName.
+func dbusChanges(ctx context.Context) (<-chan struct{}, error) {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connect system bus failed: %w", err)
+	}
+	var owned bool
+	if err := conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, dbusName).Store(&owned); err != nil || !owned {
+		conn.Close()
+		return nil, fmt.Errorf("%s is not on the bus", dbusName)
+	}
+	err = conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(dbusPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe to %s failed: %w", dbusName, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-signals:
+				if !ok {
+					return
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// hotkeySwitch is a HotkeySwitched signal; err is "" on success and mode is
+// "" when the daemon could not tell which mode to switch to.
+type hotkeySwitch struct {
+	mode, err string
+}
+
+// dbusHotkeySwitches reports the daemon's HotkeySwitched signals on the
+// returned channel until ctx is done.
+func dbusHotkeySwitches(ctx context.Context) (<-chan hotkeySwitch, error) {
+	conn, err := dbus.ConnectSystemBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connect system bus failed: %w", err)
+	}
+	err = conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(dbusPath),
+		dbus.WithMatchInterface(dbusIface),
+		dbus.WithMatchMember("HotkeySwitched"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe to %s failed: %w", dbusName, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	switches := make(chan hotkeySwitch, 1)
+	go func() {
+		defer conn.Close()
+		defer close(switches)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-signals:
+				if !ok {
+					return
+				}
+				var sw hotkeySwitch
+				if dbus.Store(sig.Body, &sw.mode, &sw.err) != nil {
+					continue
+				}
+				select {
+				case switches <- sw:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return switches, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDBusServiceRejectsUnsupportedModes(t *testing.T) {
+	original := triStateModes
+	triStateModes = false
+	t.Cleanup(func() { triStateModes = original })
+
+	svc := &dbusService{ctx: context.Background()}
+	if err := svc.Switch("", "bogus"); err == nil {
+		t.Fatalf("expected unknown mode to be rejected")
+	}
+	if err := svc.Switch("", "integrated"); err == nil {
+		t.Fatalf("expected integrated to be rejected without --tri-state")
+	}
+	modes, err := svc.Modes()
+	if err != nil || len(modes) != 2 {
+		t.Fatalf("unexpected modes %v, %v", modes, err)
+	}
+}
@@ -0,0 +1,196 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var ecSysParamsDir = "/sys/module/ec_sys/parameters"
+
+// doctorCheck is one prerequisite for switching. A failing optional check is
+// reported as a warning and does not fail the doctor run.
+type doctorCheck struct {
+	name     string
+	optional bool
+	run      func(ctx context.Context) (string, error)
+}
+
+func doctorChecks() []doctorCheck {
+	return []doctorCheck{
+		{name: "root", optional: true, run: func(context.Context) (string, error) {
+			if os.Geteuid() != 0 {
+				return "", errors.New("not running as root; switching needs root")
+			}
+			return "running as root", nil
+		}},
+		{name: "model", optional: true, run: func(context.Context) (string, error) {
+			q, known := detectQuirk()
+			if !known {
+				return "", fmt.Errorf("board %q is not in the quirk table; using %s defaults", readDMI("board_name"), q.name)
+			}
+			return q.name, nil
+		}},
+		{name: "bios", optional: true, run: func(context.Context) (string, error) {
+			q, known := detectQuirk()
+			version := readDMI("bios_version")
+			if known && len(q.testedBios) > 0 && !q.biosTested(version) {
+				return "", fmt.Errorf("BIOS %s is outside the versions tested on %s", version, q.name)
+			}
+			return version, nil
+		}},
+		{name: "dgpu runtime pm", optional: true, run: func(context.Context) (string, error) {
+			return checkRuntimePM()
+		}},
+		{name: "nvidia dynamic pm", optional: true, run: func(context.Context) (string, error) {
+			switch pm := nvidiaDynamicPM(); pm {
+			case "":
+				return "nvidia driver not loaded", nil
+			case "0":
+				return "", errors.New("NVreg_DynamicPowerManagement=0 keeps the dGPU powered; see msi-gpu-switcher nvidia-pm")
+			default:
+				return "DynamicPowerManagement " + pm, nil
+			}
+		}},
+		{name: "efivarfs", run: func(context.Context) (string, error) {
+			if !exists(efivarsDir) {
+				return "", fmt.Errorf("%s not found; is efivarfs mounted?", efivarsDir)
+			}
+			return efivarsDir, nil
+		}},
+		{name: "uefi var", run: func(ctx context.Context) (string, error) {
+			_, data, err := readUefiVar(ctx)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) && createUefiVar {
+					return "missing, will be created (--create-uefi-var)", nil
+				}
+				return "", err
+			}
+			v, err := parseMsiDC(data)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, layout %s (v%d), mode byte %d", uefiVarName, v.layout.name, v.layout.version, v.offset), nil
+		}},
+		{name: "ec_sys", run: func(context.Context) (string, error) {
+			if !exists(ecIOPath) {
+				return "", fmt.Errorf("%s not found; load ec_sys with write_support=1", ecIOPath)
+			}
+			if support := readFirstLine(filepath.Join(ecSysParamsDir, "write_support")); support != "Y" {
+				return "", errors.New("ec_sys loaded without write_support=1")
+			}
+			return ecIOPath, nil
+		}},
+		{name: "mac policy", optional: true, run: func(context.Context) (string, error) {
+			return checkMACDenials()
+		}},
+	}
+}
+
+// hintError is a failed check with lines telling the user what to do about
+// it, such as policy rules to add.
+type hintError struct {
+	err   error
+	hints []string
+}
+
+func (e *hintError) Error() string { return e.err.Error() }
+
+func (e *hintError) Unwrap() error { return e.err }
+
+// checkRuntimePM verifies the dGPU is allowed to runtime suspend, without
+// which hybrid mode keeps it powered. amdgpu additionally disables runtime PM
+// entirely with runpm=0.
+func checkRuntimePM() (string, error) {
+	gpus, err := listGPUs()
+	if err != nil {
+		return "", err
+	}
+	for _, g := range gpus {
+		if !g.discrete {
+			continue
+		}
+		dir := filepath.Join(pciRoot, g.addr)
+		if control := readFirstLine(filepath.Join(dir, "power", "control")); control != "" && control != "auto" {
+			return "", fmt.Errorf("%s power/control is %q; the dGPU cannot sleep in hybrid mode (set it to auto)", g.addr, control)
+		}
+		if g.driver == "amdgpu" && readFirstLine(filepath.Join(sysModuleRoot, "amdgpu", "parameters", "runpm")) == "0" {
+			return "", errors.New("amdgpu.runpm=0 disables runtime PM for the dGPU")
+		}
+		return fmt.Sprintf("%s can runtime suspend", g.addr), nil
+	}
+	return "no discrete GPU present", nil
+}
+
+// runDoctorChecks runs every check.
+func runDoctorChecks(ctx context.Context) doctorReport {
+	r := doctorReport{SchemaVersion: schemaVersion}
+	for _, c := range doctorChecks() {
+		detail, err := c.run(ctx)
+		res := doctorResult{Name: c.name, Status: "ok", Detail: detail}
+		if he := (*hintError)(nil); errors.As(err, &he) {
+			res.Hints = he.hints
+		}
+		switch {
+		case err == nil:
+		case c.optional:
+			res.Status, res.Detail = "warn", err.Error()
+		default:
+			r.Failed++
+			res.Status, res.Detail = "fail", err.Error()
+		}
+		r.Checks = append(r.Checks, res)
+	}
+	return r
+}
+
+// runDoctor reports the checks and returns an error if a required one failed.
+func runDoctor(ctx context.Context, asJSON bool) error {
+	r := runDoctorChecks(ctx)
+	if asJSON {
+		if err := printJSON(r); err != nil {
+			return err
+		}
+	} else {
+		width := 0
+		for _, res := range r.Checks {
+			width = max(width, len(res.Name))
+		}
+		for _, res := range r.Checks {
+			switch res.Status {
+			case "ok":
+				log.Info().Msgf("ok    %-*s %s", width, res.Name, res.Detail)
+			case "warn":
+				log.Warn().Msgf("warn  %-*s %s", width, res.Name, res.Detail)
+			default:
+				log.Error().Msgf("fail  %-*s %s", width, res.Name, res.Detail)
+			}
+			for _, hint := range res.Hints {
+				log.Info().Msgf("      %s", hint)
+			}
+		}
+	}
+	if r.Failed > 0 {
+		return fmt.Errorf("%d check(s) failed", r.Failed)
+	}
+	return nil
+}
+
+func doctorCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that everything needed for switching is in place",
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, _ []string) error { return runDoctor(cmd.Context(), asJSON) },
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON to stdout (see the schema command)")
+	return cmd
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoctorFailsWithoutEcSys(t *testing.T) {
+	dir := t.TempDir()
+	originalEfivars, originalVar, originalEC, originalParams := efivarsDir, uefiVarPath, ecIOPath, ecSysParamsDir
+	efivarsDir = dir
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	ecIOPath = filepath.Join(dir, "missing-io")
+	ecSysParamsDir = dir
+	t.Cleanup(func() {
+		efivarsDir, uefiVarPath, ecIOPath, ecSysParamsDir = originalEfivars, originalVar, originalEC, originalParams
+	})
+
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 1, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	if err := runDoctor(context.Background(), false); err == nil {
+		t.Fatalf("expected missing ec_sys to fail")
+	}
+
+	ecIOPath = filepath.Join(dir, "io")
+	for name, content := range map[string]string{"io": "", "write_support": "Y\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := runDoctor(context.Background(), false); err != nil {
+		t.Fatalf("runDoctor: %v", err)
+	}
+}
+
+func TestCheckRuntimePM(t *testing.T) {
+	originalPCI, originalModules := pciRoot, sysModuleRoot
+	pciRoot, sysModuleRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { pciRoot, sysModuleRoot = originalPCI, originalModules })
+
+	dir := filepath.Join(pciRoot, "0000:01:00.0")
+	if err := os.MkdirAll(filepath.Join(dir, "power"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	write := func(path, value string) {
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	write(filepath.Join(dir, "class"), "0x030000")
+	write(filepath.Join(dir, "vendor"), nvidiaVendor)
+	write(filepath.Join(dir, "power", "control"), "on")
+	if _, err := checkRuntimePM(); err == nil {
+		t.Fatal("expected power/control=on to fail")
+	}
+	write(filepath.Join(dir, "power", "control"), "auto")
+	if _, err := checkRuntimePM(); err != nil {
+		t.Fatalf("checkRuntimePM: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// drmConnector is a display output such as eDP-1 or HDMI-A-1 and the GPU it
+// is wired to.
+type drmConnector struct {
+	Name    string `json:"name"`
+	Card    string `json:"card"`
+	GPU     string `json:"gpu"`
+	Status  string `json:"status"`
+	Enabled string `json:"enabled,omitempty"`
+}
+
+func (c drmConnector) connected() bool {
+	return c.Status == "connected"
+}
+
+// drmConnectors lists the connectors in /sys/class/drm, which are named
+// card<N>-<connector>. The NVIDIA driver only registers connectors with
+// nvidia-drm.modeset=1.
+func drmConnectors() ([]drmConnector, error) {
+	entries, err := hostfs.Glob(filepath.Join(drmRoot, "card*-*"))
+	if err != nil {
+		return nil, err
+	}
+	var connectors []drmConnector
+	for _, entry := range entries {
+		card, name, ok := strings.Cut(filepath.Base(entry), "-")
+		if !ok {
+			continue
+		}
+		gpu := ""
+		if target, err := hostfs.EvalSymlinks(filepath.Join(drmRoot, card, "device")); err == nil {
+			gpu = filepath.Base(target)
+		}
+		connectors = append(connectors, drmConnector{
+			Name:    name,
+			Card:    card,
+			GPU:     gpu,
+			Status:  readFirstLine(filepath.Join(entry, "status")),
+			Enabled: readFirstLine(filepath.Join(entry, "enabled")),
+		})
+	}
+	sort.Slice(connectors, func(i, j int) bool {
+		if connectors[i].GPU != connectors[j].GPU {
+			return connectors[i].GPU < connectors[j].GPU
+		}
+		return connectors[i].Name < connectors[j].Name
+	})
+	return connectors, nil
+}
+
+// connectorsByGPU groups connectors by PCI address.
+func connectorsByGPU(connectors []drmConnector) map[string][]drmConnector {
+	byGPU := map[string][]drmConnector{}
+	for _, c := range connectors {
+		byGPU[c.GPU] = append(byGPU[c.GPU], c)
+	}
+	return byGPU
+}
+
+func formatConnectors(list []drmConnector) string {
+	if len(list) == 0 {
+		return "(no connectors)"
+	}
+	parts := make([]string, 0, len(list))
+	for _, c := range list {
+		parts = append(parts, fmt.Sprintf("%s %s", c.Name, orUnknown(c.Status)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printDisplays shows which outputs each GPU drives. Outputs wired to the
+// dGPU go dark in integrated mode, and on MUX models the internal panel
+// moves between GPUs.
+func printDisplays() {
+	outln("")
+	outln("Displays:")
+	connectors, err := drmConnectors()
+	if err != nil {
+		log.Error().Msgf("  error: %v", err)
+		return
+	}
+	if len(connectors) == 0 {
+		outln("  (no DRM connectors found)")
+		return
+	}
+	byGPU := connectorsByGPU(connectors)
+	gpus, _ := listGPUs()
+	for _, g := range gpus {
+		if list, ok := byGPU[g.addr]; ok {
+			outf("  %s (%s): %s", g.addr, gpuRole(g.discrete, g.external), formatConnectors(list))
+			delete(byGPU, g.addr)
+		}
+	}
+	for addr, list := range byGPU {
+		outf("  %s: %s", orUnknown(addr), formatConnectors(list))
+	}
+}
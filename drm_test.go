@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDrmConnectors(t *testing.T) {
+	originalDRM := drmRoot
+	drmRoot = t.TempDir()
+	t.Cleanup(func() { drmRoot = originalDRM })
+
+	pci := t.TempDir()
+	for card, addr := range map[string]string{"card0": "0000:01:00.0", "card1": "0000:06:00.0"} {
+		if err := os.MkdirAll(filepath.Join(pci, addr), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Join(drmRoot, card), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.Symlink(filepath.Join(pci, addr), filepath.Join(drmRoot, card, "device")); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+	}
+	for name, status := range map[string]string{
+		"card0-HDMI-A-1": "connected",
+		"card0-DP-1":     "disconnected",
+		"card1-eDP-1":    "connected",
+	} {
+		dir := filepath.Join(drmRoot, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	connectors, err := drmConnectors()
+	if err != nil {
+		t.Fatalf("drmConnectors: %v", err)
+	}
+	byGPU := connectorsByGPU(connectors)
+	if got := formatConnectors(byGPU["0000:01:00.0"]); got != "DP-1 disconnected, HDMI-A-1 connected" {
+		t.Fatalf("dGPU connectors = %q", got)
+	}
+	if list := byGPU["0000:06:00.0"]; len(list) != 1 || list[0].Name != "eDP-1" || !list[0].connected() {
+		t.Fatalf("iGPU connectors = %+v", list)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// EC
+const (
+	ecSize = 256
+
+	ecRetries      = 5
+	ecRetryBackoff = 10 * time.Millisecond
+)
+
+// ecTimeout bounds one EC access (--ec-timeout); slow ECs need more.
+var ecTimeout = 2 * time.Second
+
+var (
+	ecRoot   = "/sys/kernel/debug/ec"
+	ecIOPath = ecPath(0)
+)
+
+func ecPath(index int) string {
+	return filepath.Join(ecRoot, fmt.Sprintf("ec%d", index), "io")
+}
+
+// listECs returns the indexes of all EC devices exposed by ec_sys.
+func listECs() ([]int, error) {
+	paths, err := hostfs.Glob(filepath.Join(ecRoot, "ec*", "io"))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []int
+	for _, p := range paths {
+		var index int
+		if _, err := fmt.Sscanf(filepath.Base(filepath.Dir(p)), "ec%d", &index); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+	return indexes, nil
+}
+
+// ecSession keeps the EC io node open so a batch of reads and writes goes
+// through a single fd. Without ec_sys write_support the node is read-only
+// and the session falls back to O_RDONLY; writes then fail.
+type ecSession struct {
+	f *os.File
+}
+
+func openEC() (*ecSession, error) {
+	if readOnly {
+		return openECReadOnly()
+	}
+	s, err := openECFlag(os.O_RDWR)
+	if errors.Is(err, os.ErrPermission) {
+		return openECReadOnly()
+	}
+	return s, err
+}
+
+// openECReadOnly opens the EC for callers that only read it.
+func openECReadOnly() (*ecSession, error) {
+	return openECFlag(os.O_RDONLY)
+}
+
+func openECFlag(flag int) (*ecSession, error) {
+	f, err := hostfs.OpenFile(ecIOPath, flag, 0)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("%w: %w", ErrECUnavailable, err)
+	case errors.Is(err, os.ErrPermission):
+		return nil, fmt.Errorf("%w: %w", ErrNeedsRoot, err)
+	case err != nil:
+		return nil, err
+	}
+	return &ecSession{f: f}, nil
+}
+
+func (s *ecSession) Close() error {
+	return s.f.Close()
+}
+
+func (s *ecSession) readByte(ctx context.Context, offset int) (byte, error) {
+	if s == nil {
+		return 0, fmt.Errorf("ec session not open: %s", ecIOPath)
+	}
+	buf := []byte{0}
+	err := withEcRetry(ctx, func() error {
+		_, err := s.f.ReadAt(buf, int64(offset))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Trace().Int("offset", offset).Uint8("value", buf[0]).Msgf("ec read [0x%02x]=0x%02x", offset, buf[0])
+	return buf[0], nil
+}
+
+func (s *ecSession) writeByte(ctx context.Context, offset int, value byte) error {
+	if s == nil {
+		return fmt.Errorf("ec session not open: %s", ecIOPath)
+	}
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	log.Trace().Int("offset", offset).Uint8("value", value).Msgf("ec write [0x%02x]=0x%02x", offset, value)
+	err := withEcRetry(ctx, func() error {
+		_, err := s.f.WriteAt([]byte{value}, int64(offset))
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w: ec [0x%02x]: %w", ErrWriteRejected, offset, err)
+	}
+	return err
+}
+
+// readRange reads len(buf) registers starting at offset in one access.
+func (s *ecSession) readRange(ctx context.Context, offset int, buf []byte) error {
+	if s == nil {
+		return fmt.Errorf("ec session not open: %s", ecIOPath)
+	}
+	if offset < 0 || offset+len(buf) > ecSize {
+		return fmt.Errorf("ec range 0x%02x+%d outside register space", offset, len(buf))
+	}
+	return withEcRetry(ctx, func() error {
+		_, err := s.f.ReadAt(buf, int64(offset))
+		return err
+	})
+}
+
+func (s *ecSession) readMuxState(ctx context.Context) (bool, error) {
+	value, err := s.readByte(ctx, ecMuxOffset)
+	if err != nil {
+		return false, err
+	}
+	return (value & ecMuxMask) != 0, nil
+}
+
+func (s *ecSession) setMux(ctx context.Context, discrete bool) error {
+	value, err := s.readByte(ctx, ecMuxOffset)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("ec mux before: 0x%02x", value)
+	value = muxValue(value, discrete)
+	log.Debug().Msgf("ec mux after: 0x%02x", value)
+	return s.writeByte(ctx, ecMuxOffset, value)
+}
+
+func (s *ecSession) triggerSwitch(ctx context.Context) error {
+	value, err := s.readByte(ctx, ecSwitchOffset)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("ec switch before: 0x%02x", value)
+	value = switchValue(value)
+	log.Debug().Msgf("ec switch after: 0x%02x", value)
+	return s.writeByte(ctx, ecSwitchOffset, value)
+}
+
+// withEcRetry retries fn with exponential backoff while the EC reports
+// itself busy; it NAKs debugfs accesses during fan or battery transactions.
+// Each attempt is bounded by ecTimeout.
+func withEcRetry(ctx context.Context, fn func() error) error {
+	delay := ecRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := runWithTimeout(ctx, ecTimeout, fn)
+		if err == nil || attempt >= ecRetries || !isEcBusy(err) {
+			return err
+		}
+		log.Debug().Msgf("ec busy (%v), retrying in %s", err, delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func isEcBusy(err error) bool {
+	return errors.Is(err, unix.EIO) || errors.Is(err, unix.EAGAIN)
+}
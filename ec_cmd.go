@@ -0,0 +1,407 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+func ecCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ec",
+		Short: "Low-level EC register access",
+	}
+	cmd.AddCommand(ecReadCmd(), ecWriteCmd(), ecDumpCmd(), ecSnapshotCmd(), ecDiffCmd(), ecWatchCmd())
+	return cmd
+}
+
+func ecReadCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "read <offset>",
+		Short: "Read one EC register",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			requireRoot()
+			offset, err := parseByteArg("offset", args[0])
+			if err != nil {
+				return err
+			}
+			ec, err := openEC()
+			if err != nil {
+				return err
+			}
+			defer ec.Close()
+			value, err := ec.readByte(cmd.Context(), int(offset))
+			if err != nil {
+				return err
+			}
+			outf("[0x%02x] = 0x%02x (%d, 0b%08b)", offset, value, value, value)
+			return nil
+		},
+	}
+}
+
+func ecWriteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "write <offset> <value>",
+		Short: "Write one EC register (dangerous)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWritable(); err != nil {
+				return err
+			}
+			requireRoot()
+			offset, err := parseByteArg("offset", args[0])
+			if err != nil {
+				return err
+			}
+			value, err := parseByteArg("value", args[1])
+			if err != nil {
+				return err
+			}
+			// Held across the prompt, so the value shown is the one replaced.
+			release, err := acquireLock(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer release()
+			ec, err := openEC()
+			if err != nil {
+				return err
+			}
+			defer ec.Close()
+			before, err := ec.readByte(cmd.Context(), int(offset))
+			if err != nil {
+				return err
+			}
+			ok, err := confirm(fmt.Sprintf("Write 0x%02x to EC [0x%02x] (currently 0x%02x)?", value, offset, before))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Info().Msg("aborted")
+				return nil
+			}
+			if err := ec.writeByte(cmd.Context(), int(offset), value); err != nil {
+				return err
+			}
+			log.Info().Msgf("[0x%02x] 0x%02x -> 0x%02x", offset, before, value)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func ecDumpCmd() *cobra.Command {
+	var span string
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Hexdump the EC register space",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			start, end, err := parseRange(span)
+			if err != nil {
+				return err
+			}
+			ec, err := openEC()
+			if err != nil {
+				return err
+			}
+			defer ec.Close()
+			buf := make([]byte, end-start+1)
+			if err := ec.readRange(cmd.Context(), start, buf); err != nil {
+				return err
+			}
+			for _, line := range hexdump(start, buf) {
+				outln(line)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&span, "range", "0x00:0xff", "inclusive register range start:end")
+	return cmd
+}
+
+func ecSnapshotCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "snapshot <file>",
+		Short: "Save the raw EC register space to a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			requireRoot()
+			snap, err := readECSnapshot(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(args[0], snap, 0o644); err != nil {
+				return err
+			}
+			log.Info().Msgf("saved %d bytes to %s", len(snap), args[0])
+			return nil
+		},
+	}
+}
+
+func ecDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <before> [after]",
+		Short: "Show EC registers that differ between two snapshots",
+		Long: "Compare two files written by 'ec snapshot'. If after is omitted the\n" +
+			"live EC is used, e.g. snapshot, toggle the mode in the BIOS, then diff.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			before, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var after []byte
+			if len(args) == 2 {
+				after, err = os.ReadFile(args[1])
+			} else {
+				requireRoot()
+				after, err = readECSnapshot(cmd.Context())
+			}
+			if err != nil {
+				return err
+			}
+			changes := diffSnapshots(before, after)
+			if len(changes) == 0 {
+				log.Info().Msg("no differences")
+				return nil
+			}
+			for _, c := range changes {
+				outf("[0x%02x] 0x%02x -> 0x%02x (changed bits 0b%08b)", c.offset, c.before, c.after, c.before^c.after)
+			}
+			return nil
+		},
+	}
+}
+
+func ecWatchCmd() *cobra.Command {
+	var (
+		span     string
+		interval time.Duration
+		output   string
+	)
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Poll the EC and print register changes as they happen",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			requireRoot()
+			start, end, err := parseRange(span)
+			if err != nil {
+				return err
+			}
+			emit := func(line string) error {
+				outln(line)
+				return nil
+			}
+			if output != "" {
+				f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+				if err != nil {
+					return err
+				}
+				defer registerCleanup(func() { _ = f.Close() })()
+				emit = func(line string) error {
+					_, err := fmt.Fprintln(f, line)
+					return err
+				}
+				log.Info().Msgf("recording EC changes to %s, press Ctrl-C to stop", output)
+			}
+			ec, err := openEC()
+			if err != nil {
+				return err
+			}
+			defer ec.Close()
+			return watchEC(cmd.Context(), ec, start, end-start+1, interval, emit)
+		},
+	}
+	cmd.Flags().StringVar(&span, "range", "0x00:0xff", "inclusive register range start:end")
+	cmd.Flags().DurationVar(&interval, "interval", 500*time.Millisecond, "polling interval")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "append events to this file instead of the terminal")
+	return cmd
+}
+
+// watchEC polls size registers from start every interval and emits one
+// timestamped line per changed byte until ctx is done.
+func watchEC(ctx context.Context, ec *ecSession, start, size int, interval time.Duration, emit func(string) error) error {
+	prev := make([]byte, size)
+	if err := ec.readRange(ctx, start, prev); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		cur := make([]byte, size)
+		if err := ec.readRange(ctx, start, cur); err != nil {
+			return err
+		}
+		now := time.Now().Format("15:04:05.000")
+		for _, c := range diffSnapshots(prev, cur) {
+			line := fmt.Sprintf("%s [0x%02x] 0x%02x -> 0x%02x", now, start+c.offset, c.before, c.after)
+			if err := emit(line); err != nil {
+				return err
+			}
+		}
+		prev = cur
+	}
+}
+
+func readECSnapshot(ctx context.Context) ([]byte, error) {
+	ec, err := openEC()
+	if err != nil {
+		return nil, err
+	}
+	defer ec.Close()
+	snap := make([]byte, ecSize)
+	if err := ec.readRange(ctx, 0, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+type ecChange struct {
+	offset        int
+	before, after byte
+}
+
+// diffSnapshots lists the offsets whose values differ. Bytes present in only
+// one snapshot are compared against zero.
+func diffSnapshots(before, after []byte) []ecChange {
+	var changes []ecChange
+	for i := 0; i < max(len(before), len(after)); i++ {
+		var b, a byte
+		if i < len(before) {
+			b = before[i]
+		}
+		if i < len(after) {
+			a = after[i]
+		}
+		if a != b {
+			changes = append(changes, ecChange{offset: i, before: b, after: a})
+		}
+	}
+	return changes
+}
+
+// parseRange parses an inclusive "start:end" EC register range.
+func parseRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q: expected start:end", s)
+	}
+	start, err := parseByteArg("range start", lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseByteArg("range end", hi)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range %q: end before start", s)
+	}
+	return int(start), int(end), nil
+}
+
+// hexdump formats data as 16-byte rows aligned to absolute offsets, with a
+// column header and an ASCII gutter. Offsets widen to four digits for data
+// extending past 0xff.
+func hexdump(base int, data []byte) []string {
+	width := 2
+	if base+len(data) > 0x100 {
+		width = 4
+	}
+	var header strings.Builder
+	header.WriteString(strings.Repeat(" ", width+1))
+	for i := 0; i < 16; i++ {
+		fmt.Fprintf(&header, " %02x", i)
+	}
+	lines := []string{header.String()}
+
+	for row := base &^ 0xf; row < base+len(data); row += 16 {
+		var hex, ascii strings.Builder
+		for col := 0; col < 16; col++ {
+			addr := row + col
+			if addr < base || addr >= base+len(data) {
+				hex.WriteString("   ")
+				ascii.WriteByte(' ')
+				continue
+			}
+			b := data[addr-base]
+			fmt.Fprintf(&hex, " %02x", b)
+			if b >= 0x20 && b < 0x7f {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%0*x: %s  |%s|", width, row, hex.String()[1:], ascii.String()))
+	}
+	return lines
+}
+
+func parseByteArg(name, s string) (byte, error) {
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be 0-255 (0x00-0xff)", name, s)
+	}
+	return byte(v), nil
+}
+
+// nonInteractive makes confirm answer no without prompting, for callers such
+// as configuration management that must never block on stdin.
+var nonInteractive bool
+
+// assumeYes is --yes: confirm answers yes without prompting.
+var assumeYes bool
+
+// stdinIsTerminal is replaced in tests.
+var stdinIsTerminal = func() bool {
+	_, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// confirm asks prompt on the terminal. Without one it answers no, so a
+// script has to pass --yes rather than have something destructive happen
+// unasked.
+func confirm(prompt string) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	if nonInteractive {
+		return false, nil
+	}
+	if !stdinIsTerminal() {
+		log.Warn().Msgf("%s Not asking without a terminal; pass --yes to confirm.", prompt)
+		return false, nil
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,182 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteArg(t *testing.T) {
+	cases := map[string]byte{"0x2e": 0x2e, "46": 46, "0xff": 0xff, "0": 0}
+	for in, want := range cases {
+		got, err := parseByteArg("offset", in)
+		if err != nil {
+			t.Fatalf("parseByteArg(%q): %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parseByteArg(%q) = 0x%02x, want 0x%02x", in, got, want)
+		}
+	}
+	for _, in := range []string{"0x100", "-1", "abc", ""} {
+		if _, err := parseByteArg("offset", in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	start, end, err := parseRange("0x20:0x2f")
+	if err != nil {
+		t.Fatalf("parseRange: %v", err)
+	}
+	if start != 0x20 || end != 0x2f {
+		t.Fatalf("unexpected range %d:%d", start, end)
+	}
+	for _, in := range []string{"0x20", "0x2f:0x20", "0x00:0x100"} {
+		if _, _, err := parseRange(in); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func TestHexdumpAlignsPartialRows(t *testing.T) {
+	lines := hexdump(0x2e, []byte{0x40, 0x41, 0x00})
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %d: %q", len(lines), lines)
+	}
+	want := "20: " + strings.Repeat("   ", 14) + "40 41  |" + strings.Repeat(" ", 14) + "@A|"
+	if lines[1] != want {
+		t.Fatalf("unexpected first row:\n got %q\nwant %q", lines[1], want)
+	}
+	if lines[2][:7] != "30: 00 " {
+		t.Fatalf("unexpected second row: %q", lines[2])
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	before := []byte{0x00, 0x10, 0x20}
+	after := []byte{0x00, 0x50, 0x20, 0x01}
+	changes := diffSnapshots(before, after)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if changes[0] != (ecChange{offset: 1, before: 0x10, after: 0x50}) {
+		t.Fatalf("unexpected change: %+v", changes[0])
+	}
+	if changes[1] != (ecChange{offset: 3, before: 0x00, after: 0x01}) {
+		t.Fatalf("unexpected change: %+v", changes[1])
+	}
+}
+
+func TestEcWatchRejectsBadInterval(t *testing.T) {
+	for _, interval := range []string{"0", "-1s"} {
+		cmd := ecWatchCmd()
+		cmd.SetArgs([]string{"--interval", interval})
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+		if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--interval") {
+			t.Fatalf("--interval %s: expected an error, got %v", interval, err)
+		}
+	}
+}
+
+func TestEcWriteTakesLock(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ec write needs root")
+	}
+	root := switchFixture(t)
+	originalYes := assumeYes
+	t.Cleanup(func() { assumeYes = originalYes })
+	assumeYes = true
+	release, err := acquireLock(context.Background())
+	if err != nil {
+		t.Fatalf("acquireLock: %v", err)
+	}
+
+	write := func(ctx context.Context) error {
+		cmd := ecWriteCmd()
+		cmd.SetArgs([]string{"0x10", "0x5a"})
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+		return cmd.ExecuteContext(ctx)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := write(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the write to wait for the lock, got %v", err)
+	}
+	ecFile := filepath.Join(root, ecIOPath)
+	if data, _ := os.ReadFile(ecFile); data[0x10] != 0 {
+		t.Fatalf("EC written while the lock was held: 0x%02x", data[0x10])
+	}
+	release()
+	if err := write(context.Background()); err != nil {
+		t.Fatalf("ec write: %v", err)
+	}
+	if data, _ := os.ReadFile(ecFile); data[0x10] != 0x5a {
+		t.Fatalf("EC [0x10] = 0x%02x, want 0x5a", data[0x10])
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	originalYes, originalTerminal, originalStdin := assumeYes, stdinIsTerminal, os.Stdin
+	t.Cleanup(func() { assumeYes, stdinIsTerminal, os.Stdin = originalYes, originalTerminal, originalStdin })
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	os.Stdin = r
+	if _, err := w.WriteString("y\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	stdinIsTerminal = func() bool { return false }
+	if ok, _ := confirm("Go?"); ok {
+		t.Fatal("expected no without a terminal")
+	}
+	assumeYes = true
+	if ok, _ := confirm("Go?"); !ok {
+		t.Fatal("expected --yes to confirm")
+	}
+	assumeYes = false
+	stdinIsTerminal = func() bool { return true }
+	if ok, _ := confirm("Go?"); !ok {
+		t.Fatal("expected y on the terminal to confirm")
+	}
+}
+
+func TestConfirmUnknownModel(t *testing.T) {
+	originalDMI, originalYes, originalForce, originalTerminal := dmiRoot, assumeYes, forceWrites, stdinIsTerminal
+	t.Cleanup(func() {
+		dmiRoot, assumeYes, forceWrites, stdinIsTerminal = originalDMI, originalYes, originalForce, originalTerminal
+		unknownModelConfirmed = false
+	})
+	dmiRoot = t.TempDir()
+	if err := os.WriteFile(dmiRoot+"/board_name", []byte("MS-0000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdinIsTerminal = func() bool { return false }
+	assumeYes, forceWrites = false, false
+
+	if err := confirmUnknownModel(); !errors.Is(err, ErrUnsupportedModel) {
+		t.Fatalf("expected an unknown board to need confirming, got %v", err)
+	}
+	assumeYes = true
+	if err := confirmUnknownModel(); err != nil {
+		t.Fatalf("expected --yes to confirm: %v", err)
+	}
+	if err := checkKnownModel(); err != nil {
+		t.Fatalf("expected the switch to be let through once confirmed: %v", err)
+	}
+	unknownModelConfirmed = false
+	assumeYes, forceWrites = false, true
+	if err := confirmUnknownModel(); err != nil {
+		t.Fatalf("expected --force to skip the question: %v", err)
+	}
+}
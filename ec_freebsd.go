@@ -0,0 +1,125 @@
+//go:build freebsd && amd64
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// acpi_ec(4) has no userland interface, so the EC is driven the way acpi_ec
+// drives it: the ACPI embedded controller protocol on ports 0x62 and 0x66.
+// Opening /dev/io grants the calling thread port access until it is closed,
+// so each transaction runs on one locked OS thread. Nothing coordinates these
+// transactions with acpi_ec's, and one of ours landing in the middle of its
+// own corrupts both, so the ports are only used while acpi_ec is not attached
+// (hint.acpi_ec.0.disabled="1" in loader.conf).
+var ecDevIOPath = "/dev/io"
+
+const (
+	ecDataPort = 0x62
+	ecCmdPort  = 0x66
+
+	ecStatusOBF = 0x01
+	ecStatusIBF = 0x02
+
+	ecCmdRead  = 0x80
+	ecCmdWrite = 0x81
+
+	ecPortTimeout = time.Second
+)
+
+// inb and outb are in ec_freebsd_amd64.s.
+func inb(port uint16) byte
+func outb(port uint16, value byte)
+
+// acpiECSysctl exists while acpi_ec is attached to the EC.
+const acpiECSysctl = "dev.acpi_ec.0.%driver"
+
+// withPorts runs fn with port access on a locked OS thread.
+func withPorts(fn func() error) error {
+	if _, err := unix.Sysctl(acpiECSysctl); err == nil {
+		return fmt.Errorf("%w: acpi_ec is attached and its transactions would race ours", ErrECUnavailable)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	f, err := os.OpenFile(ecDevIOPath, os.O_RDWR, 0)
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %w", ErrNeedsRoot, err)
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrECUnavailable, err)
+	}
+	defer f.Close()
+	return fn()
+}
+
+// ecWait polls the status port until mask reads as set.
+func ecWait(ctx context.Context, mask byte, set bool) error {
+	deadline := time.Now().Add(ecPortTimeout)
+	for {
+		if (inb(ecCmdPort)&mask != 0) == set {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ec status 0x%02x timed out", inb(ecCmdPort))
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+// ecSend waits for the EC to take its input, then writes value to port.
+func ecSend(ctx context.Context, port uint16, value byte) error {
+	if err := ecWait(ctx, ecStatusIBF, false); err != nil {
+		return err
+	}
+	outb(port, value)
+	return nil
+}
+
+func readEC(ctx context.Context, offset byte) (byte, error) {
+	var value byte
+	err := withPorts(func() error {
+		if err := ecSend(ctx, ecCmdPort, ecCmdRead); err != nil {
+			return err
+		}
+		if err := ecSend(ctx, ecDataPort, offset); err != nil {
+			return err
+		}
+		if err := ecWait(ctx, ecStatusOBF, true); err != nil {
+			return err
+		}
+		value = inb(ecDataPort)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ec read [0x%02x]: %w", offset, err)
+	}
+	log.Trace().Msgf("ec read [0x%02x]=0x%02x", offset, value)
+	return value, nil
+}
+
+func writeEC(ctx context.Context, offset, value byte) error {
+	log.Trace().Msgf("ec write [0x%02x]=0x%02x", offset, value)
+	return withPorts(func() error {
+		for _, step := range []struct {
+			port  uint16
+			value byte
+		}{{ecCmdPort, ecCmdWrite}, {ecDataPort, offset}, {ecDataPort, value}} {
+			if err := ecSend(ctx, step.port, step.value); err != nil {
+				return err
+			}
+		}
+		return ecWait(ctx, ecStatusIBF, false)
+	})
+}
@@ -0,0 +1,20 @@
+//go:build freebsd && !amd64
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// The EC ports are only reachable through /dev/io on amd64; elsewhere the
+// FreeBSD build switches through the UEFI variable alone (--no-ec).
+
+func readEC(context.Context, byte) (byte, error) {
+	return 0, fmt.Errorf("%w: no I/O port access on %s", ErrECUnavailable, runtime.GOARCH)
+}
+
+func writeEC(context.Context, byte, byte) error {
+	return fmt.Errorf("%w: no I/O port access on %s", ErrECUnavailable, runtime.GOARCH)
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestECSessionReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "io")
+	originalPath := ecIOPath
+	ecIOPath = path
+	t.Cleanup(func() { ecIOPath = originalPath })
+
+	if err := os.WriteFile(path, make([]byte, 256), 0o600); err != nil {
+		t.Fatalf("write test ec: %v", err)
+	}
+
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+
+	ctx := context.Background()
+	if err := ec.setMux(ctx, true); err != nil {
+		t.Fatalf("setMux: %v", err)
+	}
+	state, err := ec.readMuxState(ctx)
+	if err != nil {
+		t.Fatalf("readMuxState: %v", err)
+	}
+	if !state {
+		t.Fatalf("expected discrete mux state")
+	}
+	if err := ec.triggerSwitch(ctx); err != nil {
+		t.Fatalf("triggerSwitch: %v", err)
+	}
+	value, err := ec.readByte(ctx, ecSwitchOffset)
+	if err != nil {
+		t.Fatalf("readByte: %v", err)
+	}
+	if value != ecSwitchMask0 {
+		t.Fatalf("expected switch byte 0x%02x, got 0x%02x", ecSwitchMask0, value)
+	}
+}
+
+func TestWithEcRetryRetriesBusyErrors(t *testing.T) {
+	calls := 0
+	err := withEcRetry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &os.PathError{Op: "write", Path: ecIOPath, Err: unix.EIO}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withEcRetry: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestWithEcRetryStopsOnOtherErrors(t *testing.T) {
+	calls := 0
+	err := withEcRetry(context.Background(), func() error {
+		calls++
+		return os.ErrPermission
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single call, got %d", calls)
+	}
+}
@@ -0,0 +1,128 @@
+//go:build freebsd
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// FreeBSD reaches UEFI variables through the efidev(4) ioctls on /dev/efi,
+// which is what efivar(8) and libefivar use.
+var efiDevPath = "/dev/efi"
+
+var platformHints = map[error]string{
+	ErrNeedsRoot: "run it as root, e.g. with doas or sudo",
+	ErrECUnavailable: "the EC is reached through /dev/io on amd64 while acpi_ec is not attached " +
+		"(hint.acpi_ec.0.disabled=\"1\"); otherwise pass --no-ec to switch through the UEFI variable",
+	ErrUefiVarMissing: "check --uefi-var-name and --uefi-var-guid; efivar -l lists the variables, " +
+		"and /dev/efi needs the efirt(4) driver (kldload efirt)",
+}
+
+// efiVarIoc is struct efi_var_ioc from <sys/efiio.h>; size_t is uint.
+type efiVarIoc struct {
+	name     *uint16
+	namesize uint
+	vendor   [16]byte
+	attrib   uint32
+	data     unsafe.Pointer
+	datasize uint
+}
+
+// EFIIOC_VAR_GET and EFIIOC_VAR_SET: _IOWR('E', 4 and 6, struct efi_var_ioc).
+const (
+	efiIocVarGet = 0xc0000000 | uintptr(unsafe.Sizeof(efiVarIoc{}))<<16 | 'E'<<8 | 4
+	efiIocVarSet = 0xc0000000 | uintptr(unsafe.Sizeof(efiVarIoc{}))<<16 | 'E'<<8 | 6
+)
+
+const efivarMaxSize = 64 << 10
+
+func requirePrivilege() error {
+	if unix.Geteuid() != 0 {
+		return ErrNeedsRoot
+	}
+	return nil
+}
+
+// guidBytes lays out a GUID as struct uuid does: the first three fields in
+// host (little-endian) order, the rest as written.
+func guidBytes(guid string) ([16]byte, error) {
+	var b [16]byte
+	raw, err := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	if err != nil || len(raw) != 16 || !isGuid(guid) {
+		return b, fmt.Errorf("invalid uefi var guid %q", guid)
+	}
+	binary.LittleEndian.PutUint32(b[0:], binary.BigEndian.Uint32(raw[0:]))
+	binary.LittleEndian.PutUint16(b[4:], binary.BigEndian.Uint16(raw[4:]))
+	binary.LittleEndian.PutUint16(b[6:], binary.BigEndian.Uint16(raw[6:]))
+	copy(b[8:], raw[8:])
+	return b, nil
+}
+
+// efiVarCall runs the ioctl req for the GPU mode variable with buf as data.
+func efiVarCall(req uintptr, attrs uint32, buf []byte) (efiVarIoc, error) {
+	name := utf16.Encode([]rune(uefiVarName + "\x00"))
+	vendor, err := guidBytes(uefiVarGuid)
+	if err != nil {
+		return efiVarIoc{}, err
+	}
+	ioc := efiVarIoc{
+		name:     &name[0],
+		namesize: uint(len(name) * 2),
+		vendor:   vendor,
+		attrib:   attrs,
+		data:     unsafe.Pointer(&buf[0]),
+		datasize: uint(len(buf)),
+	}
+	f, err := os.OpenFile(efiDevPath, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return ioc, fmt.Errorf("%w: %w", ErrNeedsRoot, err)
+		}
+		return ioc, fmt.Errorf("%w: %w", ErrUefiVarMissing, err)
+	}
+	defer f.Close()
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&ioc)))
+	if errno != 0 {
+		return ioc, errno
+	}
+	return ioc, nil
+}
+
+func readUefiVar() (uint32, []byte, error) {
+	if err := requirePrivilege(); err != nil {
+		return 0, nil, err
+	}
+	for size := 1024; ; size *= 2 {
+		buf := make([]byte, size)
+		ioc, err := efiVarCall(efiIocVarGet, 0, buf)
+		switch {
+		case err == nil:
+			log.Debug().Msgf("uefi %s attrs=0x%08x len=%d", uefiVarName, ioc.attrib, ioc.datasize)
+			return ioc.attrib, buf[:ioc.datasize], nil
+		case errors.Is(err, unix.ENOENT):
+			return 0, nil, fmt.Errorf("%w: %s-%s", ErrUefiVarMissing, uefiVarName, uefiVarGuid)
+		case !errors.Is(err, unix.EOVERFLOW) || size >= efivarMaxSize:
+			return 0, nil, fmt.Errorf("read uefi var failed: %w", err)
+		}
+	}
+}
+
+func writeUefiVar(attrs uint32, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("refusing to write an empty uefi var, which would delete it")
+	}
+	if _, err := efiVarCall(efiIocVarSet, attrs, data); err != nil {
+		return fmt.Errorf("write uefi var failed: %w", err)
+	}
+	return nil
+}
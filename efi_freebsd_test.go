@@ -0,0 +1,28 @@
+//go:build freebsd
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGuidBytes(t *testing.T) {
+	got, err := guidBytes(msiVendorGuid)
+	if err != nil {
+		t.Fatalf("guidBytes: %v", err)
+	}
+	want := []byte{0xaf, 0xba, 0x96, 0xdd, 0x5e, 0x14, 0x56, 0x4f, 0xb1, 0xcf, 0x19, 0x32, 0x56, 0x29, 0x8e, 0x99}
+	if !bytes.Equal(got[:], want) {
+		t.Fatalf("guidBytes = %x, want %x", got, want)
+	}
+	if _, err := guidBytes("not-a-guid"); err == nil {
+		t.Fatal("expected an error for a malformed guid")
+	}
+}
+
+func TestEfiIoctlNumbers(t *testing.T) {
+	if efiIocVarGet != 0xc0384504 || efiIocVarSet != 0xc0384506 {
+		t.Fatalf("ioctls 0x%x 0x%x do not match <sys/efiio.h>", efiIocVarGet, efiIocVarSet)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// noElevate makes commands that need root fail instead of re-running
+// themselves through sudo, doas or pkexec.
+var noElevate bool
+
+// elevator picks the tool to re-run through. pkexec comes first when there
+// is no terminal to type a sudo password into but a graphical session that
+// can show a polkit prompt.
+func elevator(lookPath func(string) (string, error), terminal, graphical bool) (string, error) {
+	order := []string{"sudo", "doas", "pkexec"}
+	if !terminal && graphical {
+		order = []string{"pkexec", "sudo", "doas"}
+	}
+	for _, name := range order {
+		if path, err := lookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no sudo, doas or pkexec found")
+}
+
+// reexecAsRoot replaces the process with the same command line run through
+// an elevator. It only returns on failure.
+func reexecAsRoot() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	graphical := os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+	path, err := elevator(exec.LookPath, stdinIsTerminal(), graphical)
+	if err != nil {
+		return err
+	}
+	// pkexec needs an absolute path, which also matches the exec.path
+	// annotation of the polkit action.
+	argv := append([]string{path, self}, os.Args[1:]...)
+	return syscall.Exec(path, argv, os.Environ())
+}
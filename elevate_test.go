@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestElevatorOrder(t *testing.T) {
+	have := func(names ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, n := range names {
+				if n == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", errors.New("not found")
+		}
+	}
+
+	for _, tc := range []struct {
+		installed           []string
+		terminal, graphical bool
+		want                string
+	}{
+		{[]string{"sudo", "pkexec"}, true, true, "/usr/bin/sudo"},
+		{[]string{"sudo", "pkexec"}, false, true, "/usr/bin/pkexec"},
+		{[]string{"doas", "pkexec"}, true, false, "/usr/bin/doas"},
+		{[]string{"sudo"}, false, true, "/usr/bin/sudo"},
+	} {
+		got, err := elevator(have(tc.installed...), tc.terminal, tc.graphical)
+		if err != nil || got != tc.want {
+			t.Fatalf("%+v: got %q %v, want %q", tc, got, err, tc.want)
+		}
+	}
+	if _, err := elevator(have(), true, false); err == nil {
+		t.Fatalf("expected an error with no elevator installed")
+	}
+}
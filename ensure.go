@@ -0,0 +1,100 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// exitChanged is ensure's exit code after it switched, like puppet's
+// --detailed-exitcodes; 0 means nothing had to change and 1 an error.
+const exitChanged = 2
+
+func ensureCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ensure <mode>",
+		Short: "Switch only if needed; exit 0 if unchanged, 2 if changed",
+		Long: "Make sure the given mode is configured, for configuration management.\n\n" +
+			"Exits 0 when the UEFI variable and EC MUX already select the mode, 2 after\n" +
+			"switching, and 1 on errors. Never prompts: checks that would ask for\n" +
+			"confirmation fail instead unless --force is given.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeModes,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := parseMode(args[0])
+			if err != nil {
+				return err
+			}
+			if err := checkModeSupported(mode); err != nil {
+				return err
+			}
+			nonInteractive = true
+			requireRoot()
+			changed, err := ensureMode(cmd.Context(), mode)
+			if err != nil {
+				return err
+			}
+			if changed {
+				os.Exit(exitChanged)
+			}
+			return nil
+		},
+	}
+}
+
+// ensureMode switches to mode unless it is already configured and reports
+// whether it switched. An unknown board is refused unless forced, since the
+// default offsets are all it would be checked against.
+func ensureMode(ctx context.Context, mode gpuMode) (bool, error) {
+	if err := confirmUnknownModel(); err != nil {
+		return false, err
+	}
+	ok, err := modeConfigured(ctx, mode)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		log.Info().Msgf("already in %s mode", mode)
+		return false, nil
+	}
+	if err := switchGPU(ctx, mode); err != nil {
+		return false, err
+	}
+	log.Info().Msgf("switched to %s mode", mode)
+	return true, nil
+}
+
+// modeConfigured reports whether both the UEFI variable (when present) and
+// the EC MUX already select mode.
+func modeConfigured(ctx context.Context, mode gpuMode) (bool, error) {
+	if !exists(ecIOPath) {
+		return false, fmt.Errorf("%w; cannot switch without ec_sys/debugfs", ErrECUnavailable)
+	}
+	switch {
+	case exists(uefiVarPath):
+		current, err := readUefiGpuMode(ctx)
+		if err != nil {
+			return false, err
+		}
+		if current != mode {
+			return false, nil
+		}
+	case createUefiVar:
+		return false, nil
+	}
+	ec, err := openEC()
+	if err != nil {
+		return false, err
+	}
+	defer ec.Close()
+	discrete, err := ec.readMuxState(ctx)
+	if err != nil {
+		return false, err
+	}
+	return discrete == mode.muxDiscrete(), nil
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModeConfigured(t *testing.T) {
+	dir := t.TempDir()
+	originalEC, originalVar := ecIOPath, uefiVarPath
+	ecIOPath = filepath.Join(dir, "io")
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	t.Cleanup(func() { ecIOPath, uefiVarPath = originalEC, originalVar })
+
+	ctx := context.Background()
+	if _, err := modeConfigured(ctx, modeHybrid); err == nil {
+		t.Fatalf("expected an error without the EC")
+	}
+
+	if err := os.WriteFile(ecIOPath, make([]byte, ecSize), 0o600); err != nil {
+		t.Fatalf("write ec: %v", err)
+	}
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	if ok, err := modeConfigured(ctx, modeHybrid); err != nil || !ok {
+		t.Fatalf("expected hybrid to be configured, got %v %v", ok, err)
+	}
+	if ok, err := modeConfigured(ctx, modeDiscrete); err != nil || ok {
+		t.Fatalf("expected discrete to need a switch, got %v %v", ok, err)
+	}
+
+	// UEFI already says discrete but the MUX still says hybrid.
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 1, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	if ok, err := modeConfigured(ctx, modeDiscrete); err != nil || ok {
+		t.Fatalf("expected the MUX mismatch to need a switch, got %v %v", ok, err)
+	}
+}
+
+func TestEnsureModeUnknownBoard(t *testing.T) {
+	root := switchFixture(t)
+	originalNonInteractive, originalForce := nonInteractive, forceWrites
+	t.Cleanup(func() { nonInteractive, forceWrites = originalNonInteractive, originalForce })
+	nonInteractive = true
+	if err := os.WriteFile(filepath.Join(root, dmiRoot, "board_name"), []byte("MS-0000\n"), 0o644); err != nil {
+		t.Fatalf("write board: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := ensureMode(ctx, modeDiscrete); !errors.Is(err, ErrUnsupportedModel) {
+		t.Fatalf("expected an unknown board to be refused, got %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("readUefiGpuMode = %v %v, want the fixture's hybrid", mode, err)
+	}
+	forceWrites = true
+	if changed, err := ensureMode(ctx, modeDiscrete); err != nil || !changed {
+		t.Fatalf("ensureMode with --force = %v %v", changed, err)
+	}
+}
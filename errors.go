@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// kindError is a class of failure that callers tell apart with errors.Is
+// instead of matching messages. Each one carries what to do about it, which
+// the CLI prints under the error and the API and D-Bus pass on.
+type kindError struct {
+	name   string
+	msg    string
+	hint   string
+	status int
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+var (
+	// ErrECUnavailable means the EC register file is missing.
+	ErrECUnavailable error = &kindError{
+		name:   "ECUnavailable",
+		msg:    "EC MUX is not available",
+		hint:   "load ec_sys with write_support=1 (modprobe ec_sys write_support=1), or mount debugfs",
+		status: http.StatusServiceUnavailable,
+	}
+	// ErrUefiVarMissing means the UEFI variable does not exist.
+	ErrUefiVarMissing error = &kindError{
+		name:   "UefiVarMissing",
+		msg:    "UEFI variable not found",
+		hint:   "check --uefi-var-name and --uefi-var-guid, or use --create-uefi-var to create it",
+		status: http.StatusServiceUnavailable,
+	}
+	// ErrUnsupportedModel means the firmware lacks a mode or was never
+	// tested with this tool.
+	ErrUnsupportedModel error = &kindError{
+		name:   "UnsupportedModel",
+		msg:    "unsupported model",
+		hint:   "run msi-gpu-switcher doctor and see Tested Hardware in the README",
+		status: http.StatusUnprocessableEntity,
+	}
+	// ErrBiosChanged means the BIOS is not the one recorded at the last
+	// switch and nobody accepted the new one.
+	ErrBiosChanged error = &kindError{
+		name:   "BiosChanged",
+		msg:    "BIOS changed since the last switch",
+		hint:   "switch once as root from a terminal to review the EC checks and accept the new BIOS, or pass --force",
+		status: http.StatusConflict,
+	}
+	// ErrNeedsRoot means the action needs root.
+	ErrNeedsRoot error = &kindError{
+		name:   "NeedsRoot",
+		msg:    "this command requires root",
+		hint:   "run it with sudo, or use the D-Bus service",
+		status: http.StatusForbidden,
+	}
+	// ErrWriteRejected means the kernel or firmware refused a write, or the
+	// value read back was not the one written.
+	ErrWriteRejected error = &kindError{
+		name:   "WriteRejected",
+		msg:    "write rejected",
+		hint:   "ec_sys needs write_support=1 and the UEFI variable must not be locked by the firmware",
+		status: http.StatusInternalServerError,
+	}
+	// ErrReadOnly means writes are disabled with --read-only.
+	ErrReadOnly error = &kindError{
+		name:   "ReadOnly",
+		msg:    "read-only mode: firmware writes are disabled",
+		hint:   "drop --read-only, or read_only from the config, to allow writes",
+		status: http.StatusForbidden,
+	}
+)
+
+// errorKind returns the kindError err wraps, if any.
+func errorKind(err error) (*kindError, bool) {
+	var k *kindError
+	return k, errors.As(err, &k)
+}
+
+// errorHint returns what to do about err, or "" if nothing specific is known.
+func errorHint(err error) string {
+	if k, ok := errorKind(err); ok {
+		return k.hint
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	originalEC, originalVar, originalTriState, originalRetries := ecIOPath, uefiVarPath, triStateModes, verifyRetries
+	t.Cleanup(func() {
+		ecIOPath, uefiVarPath, triStateModes, verifyRetries = originalEC, originalVar, originalTriState, originalRetries
+	})
+	dir := t.TempDir()
+	ecIOPath = filepath.Join(dir, "io")
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	triStateModes = false
+	verifyRetries = 0
+	ctx := context.Background()
+
+	_, err := openEC()
+	if !errors.Is(err, ErrECUnavailable) {
+		t.Fatalf("openEC on a missing node = %v, want ErrECUnavailable", err)
+	}
+	if _, err := readUefiGpuMode(ctx); !errors.Is(err, ErrUefiVarMissing) {
+		t.Fatalf("readUefiGpuMode on a missing variable = %v, want ErrUefiVarMissing", err)
+	}
+	if err := checkModeSupported(modeIntegrated); !errors.Is(err, ErrUnsupportedModel) {
+		t.Fatalf("checkModeSupported(integrated) = %v, want ErrUnsupportedModel", err)
+	}
+	err = writeVerified(ctx, "bit", func() error { return nil }, func() (bool, error) { return false, nil })
+	if !errors.Is(err, ErrWriteRejected) {
+		t.Fatalf("writeVerified that never sticks = %v, want ErrWriteRejected", err)
+	}
+	if errorHint(err) == "" || errorHint(errors.New("other")) != "" {
+		t.Fatal("expected a hint only for known kinds")
+	}
+	if name := dbusError(ErrNeedsRoot).Name; name != dbusIface+".Error.NeedsRoot" {
+		t.Fatalf("dbusError name = %s", name)
+	}
+}
+
+func TestWriteJSONErrorCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSONError(rec, 403, ErrNeedsRoot)
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != "NeedsRoot" || body["hint"] == "" || body["error"] != ErrNeedsRoot.Error() {
+		t.Fatalf("unexpected body %v", body)
+	}
+}
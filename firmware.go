@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+// forceWrites skips the safety checks that otherwise stop a switch, such as
+// an unvalidated BIOS update.
+var forceWrites bool
+
+// readOnly refuses every EC and UEFI write, for monitoring scripts and
+// wrappers that must never change firmware state. --force does not lift it.
+var readOnly bool
+
+// checkWritable fails when writes are disabled with --read-only.
+func checkWritable() error {
+	if readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// sanityEC opens the EC read-only for the sanity checks confirmFirmware
+// runs; without it they report it unreadable.
+func sanityEC() (ecReader, func()) {
+	ec, err := openECReadOnly()
+	if err != nil {
+		return (*ecSession)(nil), func() {}
+	}
+	return ec, func() { ec.Close() }
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFirmwareDetectsBiosChange(t *testing.T) {
+	originalState, originalDMI, originalForce, originalYes, originalTerminal := stateDir, dmiRoot, forceWrites, assumeYes, stdinIsTerminal
+	stateDir, dmiRoot = t.TempDir(), t.TempDir()
+	stdinIsTerminal = func() bool { return false }
+	t.Cleanup(func() {
+		stateDir, dmiRoot, forceWrites, assumeYes, stdinIsTerminal = originalState, originalDMI, originalForce, originalYes, originalTerminal
+	})
+
+	ecPath := filepath.Join(t.TempDir(), "io")
+	if err := os.WriteFile(ecPath, make([]byte, ecSize), 0o600); err != nil {
+		t.Fatalf("write ec: %v", err)
+	}
+	originalEC := ecIOPath
+	ecIOPath = ecPath
+	t.Cleanup(func() { ecIOPath = originalEC })
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+
+	setBios := func(version string) {
+		if err := os.WriteFile(filepath.Join(dmiRoot, "bios_version"), []byte(version+"\n"), 0o644); err != nil {
+			t.Fatalf("write dmi: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	setBios("E17KKIMS.114")
+	if err := checkFirmware(ctx, ec); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if err := checkFirmware(ctx, ec); err != nil {
+		t.Fatalf("unchanged: %v", err)
+	}
+
+	setBios("E17KKIMS.115")
+	if err := checkFirmware(ctx, ec); !errors.Is(err, ErrBiosChanged) {
+		t.Fatalf("expected a switch to refuse a new BIOS without asking, got %v", err)
+	}
+	if err := confirmFirmware(ctx); !errors.Is(err, ErrBiosChanged) {
+		t.Fatalf("expected the CLI to refuse without a terminal, got %v", err)
+	}
+	forceWrites = true
+	if err := checkFirmware(ctx, ec); err != nil {
+		t.Fatalf("forced: %v", err)
+	}
+	saved, found, err := loadFirmware()
+	if err != nil || !found || saved.BiosVersion != "E17KKIMS.115" {
+		t.Fatalf("expected new BIOS saved, got %+v %v %v", saved, found, err)
+	}
+
+	setBios("E17KKIMS.116")
+	forceWrites, assumeYes = false, true
+	if err := confirmFirmware(ctx); err != nil {
+		t.Fatalf("confirmed: %v", err)
+	}
+	if err := checkFirmware(ctx, ec); err != nil {
+		t.Fatalf("expected the confirmed BIOS to be recorded, got %v", err)
+	}
+}
+
+func TestEcSanityCheckFlagsUnusedRegisters(t *testing.T) {
+	ecPath := filepath.Join(t.TempDir(), "io")
+	data := make([]byte, ecSize)
+	data[ecMuxOffset] = 0xff
+	if err := os.WriteFile(ecPath, data, 0o600); err != nil {
+		t.Fatalf("write ec: %v", err)
+	}
+	originalEC := ecIOPath
+	ecIOPath = ecPath
+	t.Cleanup(func() { ecIOPath = originalEC })
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+
+	if problems := ecSanityCheck(context.Background(), ec); len(problems) != 1 {
+		t.Fatalf("expected one problem, got %v", problems)
+	}
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	originalEC, originalVar, originalReadOnly := ecIOPath, uefiVarPath, readOnly
+	t.Cleanup(func() { ecIOPath, uefiVarPath, readOnly = originalEC, originalVar, originalReadOnly })
+	dir := t.TempDir()
+	ecIOPath = filepath.Join(dir, "io")
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	if err := os.WriteFile(ecIOPath, make([]byte, ecSize), 0o600); err != nil {
+		t.Fatalf("write ec: %v", err)
+	}
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1}, 0o600); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	readOnly = true
+	ctx := context.Background()
+
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+	if _, err := ec.readByte(ctx, ecMuxOffset); err != nil {
+		t.Fatalf("read under --read-only: %v", err)
+	}
+	if err := ec.writeByte(ctx, ecMuxOffset, 1); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("writeByte = %v, want ErrReadOnly", err)
+	}
+	if err := writeEfiVar(ctx, uefiVarPath, 7, []byte{0}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("writeEfiVar = %v, want ErrReadOnly", err)
+	}
+	if err := setImmutable(ctx, uefiVarPath, true); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("setImmutable = %v, want ErrReadOnly", err)
+	}
+	if err := switchGPU(ctx, modeDiscrete); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("switchGPU = %v, want ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+const firmwareFile = "firmware.json"
+
+// firmwareInfo identifies the installed BIOS. A change means EC offsets and
+// MsiDCVarData may no longer be where the quirk expects them.
+type firmwareInfo struct {
+	BoardName   string `json:"board_name"`
+	BiosVendor  string `json:"bios_vendor"`
+	BiosVersion string `json:"bios_version"`
+	BiosDate    string `json:"bios_date"`
+}
+
+func currentFirmware() firmwareInfo {
+	return firmwareInfo{
+		BoardName:   readDMI("board_name"),
+		BiosVendor:  readDMI("bios_vendor"),
+		BiosVersion: readDMI("bios_version"),
+		BiosDate:    readDMI("bios_date"),
+	}
+}
+
+func firmwarePath() string {
+	return filepath.Join(stateDir, firmwareFile)
+}
+
+func loadFirmware() (firmwareInfo, bool, error) {
+	var info firmwareInfo
+	raw, err := readFile(firmwarePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return info, false, nil
+		}
+		return info, false, err
+	}
+	// Held before dropping privileges, the file exists but is empty until
+	// the first BIOS is recorded.
+	if len(raw) == 0 {
+		return info, false, nil
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return info, false, fmt.Errorf("parse %s: %w", firmwareFile, err)
+	}
+	return info, true, nil
+}
+
+func saveFirmware(info firmwareInfo) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(firmwarePath(), append(raw, '\n'), 0o644)
+}
+
+// ecReader reads EC registers for the sanity checks: an ecSession on Linux,
+// while elsewhere readEC is wrapped in hostEC.
+type ecReader interface {
+	readByte(ctx context.Context, offset int) (byte, error)
+}
+
+// ecSanityCheck looks for signs that the EC registers we write are not the
+// ones the quirk expects.
+func ecSanityCheck(ctx context.Context, ec ecReader) []string {
+	var problems []string
+	for _, reg := range []struct {
+		name   string
+		offset int
+	}{
+		{"MUX", ecMuxOffset},
+		{"switch", ecSwitchOffset},
+	} {
+		value, err := ec.readByte(ctx, reg.offset)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("EC %s register [0x%02x] unreadable: %v", reg.name, reg.offset, err))
+			continue
+		}
+		if value == 0xff {
+			problems = append(problems, fmt.Sprintf("EC %s register [0x%02x] reads 0xff, which usually means it is unused", reg.name, reg.offset))
+		}
+	}
+	return problems
+}
+
+// checkFirmware records the BIOS on first use. It never asks: a switch
+// after a BIOS change fails with ErrBiosChanged unless forced, so that the
+// daemon and the other unattended callers report it, and confirmFirmware
+// lets the CLI accept the new BIOS first.
+func checkFirmware(ctx context.Context, ec ecReader) error {
+	cur := currentFirmware()
+	saved, found, err := loadFirmware()
+	if err != nil {
+		return err
+	}
+	if found && saved == cur {
+		return nil
+	}
+	if !found {
+		log.Debug().Msgf("recording BIOS %s (%s)", cur.BiosVersion, cur.BiosDate)
+		if err := saveFirmware(cur); err != nil {
+			log.Warn().Msgf("record BIOS version failed: %v", err)
+		}
+		return nil
+	}
+	if !forceWrites {
+		return biosChangedError(saved, cur)
+	}
+	reportBiosChange(ctx, ec, saved, cur)
+	return saveFirmware(cur)
+}
+
+// confirmFirmware asks before a switch from the CLI writes with a BIOS other
+// than the one recorded, after re-running the EC sanity checks, and records
+// the new BIOS once accepted. With --force checkFirmware accepts it instead.
+func confirmFirmware(ctx context.Context) error {
+	cur := currentFirmware()
+	saved, found, err := loadFirmware()
+	if err != nil || !found || saved == cur || forceWrites {
+		return err
+	}
+	ec, closeEC := sanityEC()
+	defer closeEC()
+	reportBiosChange(ctx, ec, saved, cur)
+	ok, err := confirm("Continue writing with the new BIOS?")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w; re-run with --force to accept it", biosChangedError(saved, cur))
+	}
+	return saveFirmware(cur)
+}
+
+func biosChangedError(saved, cur firmwareInfo) error {
+	return fmt.Errorf("%w: %s (%s) -> %s (%s)", ErrBiosChanged, saved.BiosVersion, saved.BiosDate, cur.BiosVersion, cur.BiosDate)
+}
+
+// reportBiosChange warns about a BIOS change and what the EC sanity checks
+// make of it.
+func reportBiosChange(ctx context.Context, ec ecReader, saved, cur firmwareInfo) {
+	log.Warn().Msgf("BIOS changed since last switch: %s (%s) -> %s (%s)",
+		saved.BiosVersion, saved.BiosDate, cur.BiosVersion, cur.BiosDate)
+	log.Warn().Msg("firmware updates have moved EC offsets and reset MsiDCVarData before")
+	problems := ecSanityCheck(ctx, ec)
+	for _, p := range problems {
+		log.Warn().Msgf("  %s", p)
+	}
+	if len(problems) == 0 {
+		log.Info().Msg("  EC sanity checks passed")
+	}
+}
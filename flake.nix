@@ -21,8 +21,36 @@
 
             src = self;
             subPackages = [ "." ];
+            # Landlock can only restrict every thread without cgo.
+            env.CGO_ENABLED = 0;
 
-            vendorHash = "sha256-loaEr1mX4T1MwfuNiQYByxeSa7qEmaH7EZ2nCdD0AY8=";
+            vendorHash = "sha256-WCZkCEZVSsauedgT7bxIM4bQWCWtQP8qnbBTIPo1k4A=";
+
+            ldflags = [
+              "-s"
+              "-w"
+              "-X main.version=${version}"
+              "-X main.commit=${self.rev or self.dirtyRev or "unknown"}"
+              "-X main.buildDate=${self.lastModifiedDate or "unknown"}"
+            ];
+
+            nativeBuildInputs = [ pkgs.installShellFiles ];
+
+            postInstall = ''
+              $out/bin/msi-gpu-switcher gen-man -o $out/share/man/man8
+              install -Dm644 dist/io.github.ElXreno.MsiGpuSwitcher.conf -t $out/share/dbus-1/system.d
+              mkdir -p $out/lib/udev/rules.d
+              substitute dist/90-msi-gpu-switcher.rules $out/lib/udev/rules.d/90-msi-gpu-switcher.rules \
+                --replace-fail '{{.Bin}}' $out/bin/msi-gpu-switcher
+              mkdir -p $out/lib/systemd/system-sleep
+              substitute dist/msi-gpu-switcher.sleep $out/lib/systemd/system-sleep/msi-gpu-switcher \
+                --replace-fail '{{.Bin}}' $out/bin/msi-gpu-switcher
+              chmod +x $out/lib/systemd/system-sleep/msi-gpu-switcher
+              installShellCompletion --cmd msi-gpu-switcher \
+                --bash <($out/bin/msi-gpu-switcher completion bash) \
+                --zsh <($out/bin/msi-gpu-switcher completion zsh) \
+                --fish <($out/bin/msi-gpu-switcher completion fish)
+            '';
           };
         });
 
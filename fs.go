@@ -0,0 +1,106 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostFS is the view of sysfs, procfs, debugfs and efivarfs the hardware
+// code reads and writes through: GPU discovery, the EC io node and the UEFI
+// variables among others. State and config files do not go through it.
+// Tests swap hostfs for a fixture tree; paths stay the real absolute ones.
+type hostFS interface {
+	// OpenFile returns an *os.File because the EC is accessed with ReadAt
+	// and WriteAt and efivarfs needs the fd for its inode flag ioctls.
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Glob(pattern string) ([]string, error)
+	EvalSymlinks(path string) (string, error)
+}
+
+var hostfs hostFS = osFS{}
+
+// osFS is the real filesystem, seen from root when it is set. Symlinks in a
+// fixture tree under root have to be relative, as they are in sysfs.
+type osFS struct {
+	root string
+}
+
+// hostPath is the path name stands for in the real filesystem: under the
+// root of an osFS hostfs, unchanged otherwise.
+func hostPath(name string) string {
+	if f, ok := hostfs.(osFS); ok {
+		return f.path(name)
+	}
+	return name
+}
+
+func (f osFS) path(name string) string {
+	if f.root == "" {
+		return name
+	}
+	return filepath.Join(f.root, name)
+}
+
+// unroot turns a path under root back into the absolute path it stands for.
+func (f osFS) unroot(path string) string {
+	if f.root == "" {
+		return path
+	}
+	if rel, ok := strings.CutPrefix(path, f.root); ok && (rel == "" || rel[0] == '/') {
+		return "/" + strings.TrimPrefix(rel, "/")
+	}
+	return path
+}
+
+func (f osFS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return openFile(f.path(name), flag, perm)
+}
+
+func (f osFS) ReadFile(name string) ([]byte, error) {
+	return readFile(f.path(name))
+}
+
+func (f osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFile(f.path(name), data, perm)
+}
+
+func (f osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(f.path(name))
+}
+
+func (f osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(f.path(name))
+}
+
+func (f osFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(f.path(name))
+}
+
+func (f osFS) Readlink(name string) (string, error) {
+	return os.Readlink(f.path(name))
+}
+
+func (f osFS) Glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(f.path(pattern))
+	for i, m := range matches {
+		matches[i] = f.unroot(m)
+	}
+	return matches, err
+}
+
+func (f osFS) EvalSymlinks(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(f.path(path))
+	if err != nil {
+		return "", err
+	}
+	return f.unroot(resolved), nil
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureTree builds a host filesystem under a temp dir from files and
+// relative symlinks keyed by their absolute path, and points hostfs at it.
+func fixtureTree(t *testing.T, files, links map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	original := hostfs
+	hostfs = osFS{root: root}
+	t.Cleanup(func() { hostfs = original })
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	for name, target := range links {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatalf("symlink %s: %v", name, err)
+		}
+	}
+	return root
+}
+
+// laptopFixture points hostfs at a hybrid-mode laptop with an Intel iGPU,
+// an NVIDIA dGPU behind a root port, an EC and MsiDCVarData.
+func laptopFixture(t *testing.T) string {
+	t.Helper()
+	originalPCI, originalEC, originalVar := pciRoot, ecIOPath, uefiVarPath
+	pciRoot, ecIOPath = "/sys/bus/pci/devices", "/sys/kernel/debug/ec/ec0/io"
+	uefiVarPath = filepath.Join("/sys/firmware/efi/efivars", uefiVarName+"-"+uefiVarGuid)
+	t.Cleanup(func() { pciRoot, ecIOPath, uefiVarPath = originalPCI, originalEC, originalVar })
+
+	const igpu, dgpu = "/sys/devices/pci0000:00/0000:00:02.0", "/sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0"
+	efivar := binary.LittleEndian.AppendUint32(nil, uefiDefaultAttrs)
+	efivar = append(efivar, 0x00, byte(modeHybrid), 0x00, 0x00)
+	return fixtureTree(t, map[string]string{
+		igpu + "/class":           "0x030000\n",
+		igpu + "/vendor":          "0x8086\n",
+		igpu + "/device":          "0xa788\n",
+		igpu + "/boot_vga":        "1\n",
+		dgpu + "/class":           "0x030000\n",
+		dgpu + "/vendor":          nvidiaVendor + "\n",
+		dgpu + "/device":          "0x2860\n",
+		dgpu + "/boot_vga":        "0\n",
+		dmiRoot + "/board_name":   "MS-17KK\n",
+		dmiRoot + "/bios_version": "E17KKIMS.114\n",
+		ecIOPath:                  strings.Repeat("\x00", ecSize),
+		uefiVarPath:               string(efivar),
+	}, map[string]string{
+		pciRoot + "/0000:00:02.0": "../../../devices/pci0000:00/0000:00:02.0",
+		pciRoot + "/0000:01:00.0": "../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0",
+		dgpu + "/driver":          "../../../../bus/pci/drivers/nvidia",
+	})
+}
+
+// switchFixture is laptopFixture with the state, lock, hooks and audit log in
+// temp dirs, so that a whole switch can run against it. It returns the root of
+// the fixture tree.
+func switchFixture(t *testing.T) string {
+	t.Helper()
+	root := laptopFixture(t)
+	tempAuditLog(t)
+	dir := t.TempDir()
+	originalState, originalLock, originalHooks := stateDir, lockPath, hooksDir
+	stateDir, lockPath, hooksDir = filepath.Join(dir, "state"), filepath.Join(dir, "test.lock"), filepath.Join(dir, "hooks")
+	t.Cleanup(func() { stateDir, lockPath, hooksDir = originalState, originalLock, originalHooks })
+	return root
+}
+
+// busyDgpu makes a process in the fixture at root render on the dGPU.
+func busyDgpu(t *testing.T, root string) {
+	t.Helper()
+	proc := filepath.Join(root, procRoot, "4242")
+	if err := os.MkdirAll(filepath.Join(proc, "fd"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(proc, "comm"), []byte("game\n"), 0o644); err != nil {
+		t.Fatalf("write comm: %v", err)
+	}
+	if err := os.Symlink("/dev/nvidia0", filepath.Join(proc, "fd", "3")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+}
+
+func TestHostFSFixture(t *testing.T) {
+	laptopFixture(t)
+
+	gpus, err := listGPUs()
+	if err != nil || len(gpus) != 2 {
+		t.Fatalf("listGPUs: %+v %v", gpus, err)
+	}
+	if g := gpus[1]; g.addr != "0000:01:00.0" || !g.discrete || g.driver != "nvidia" || gpus[0].discrete {
+		t.Fatalf("unexpected GPUs: %+v", gpus)
+	}
+
+	ctx := context.Background()
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+	if err := ec.setMux(ctx, true); err != nil {
+		t.Fatalf("setMux: %v", err)
+	}
+	if discrete, err := ec.readMuxState(ctx); err != nil || !discrete {
+		t.Fatalf("readMuxState = %v %v", discrete, err)
+	}
+
+	if err := setUefiGpuMode(ctx, modeDiscrete); err != nil {
+		t.Fatalf("setUefiGpuMode: %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v", mode, err)
+	}
+	if vars, err := listEfiVars(ctx, nil); err != nil || len(vars) != 1 || vars[0].name != uefiVarName {
+		t.Fatalf("listEfiVars = %+v %v", vars, err)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+func genManCmd() *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:    "gen-man",
+		Short:  "Generate man pages for all commands",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			root := cmd.Root()
+			root.DisableAutoGenTag = true
+			if err := doc.GenManTree(root, manHeader(), dir); err != nil {
+				return fmt.Errorf("generate man pages failed: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&dir, "output", "o", "man", "directory to write the man pages to")
+	return cmd
+}
+
+func manHeader() *doc.GenManHeader {
+	return &doc.GenManHeader{
+		Title:   "MSI-GPU-SWITCHER",
+		Section: "8",
+		Source:  "msi-gpu-switcher " + version,
+		Manual:  "System Administration",
+	}
+}
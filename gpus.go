@@ -0,0 +1,287 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+var sysModuleRoot = "/sys/module"
+
+// gpuSelector is the --gpu PCI address. Empty means every discrete GPU.
+var gpuSelector string
+
+// normalizePCIAddr adds the default PCI domain to addresses like "01:00.0".
+func normalizePCIAddr(addr string) string {
+	if strings.Count(addr, ":") == 1 {
+		return "0000:" + addr
+	}
+	return addr
+}
+
+// selectedDGPUs returns the GPU chosen with --gpu, or all discrete GPUs.
+// A selected GPU does not have to be discrete, so an eGPU can be picked
+// explicitly.
+func selectedDGPUs() ([]gpuInfo, error) {
+	gpus, err := listGPUs()
+	if err != nil {
+		return nil, err
+	}
+	var selected []gpuInfo
+	if gpuSelector != "" {
+		addr := normalizePCIAddr(gpuSelector)
+		for _, g := range gpus {
+			if g.addr == addr {
+				return []gpuInfo{g}, nil
+			}
+		}
+		return nil, fmt.Errorf("no GPU at %s", addr)
+	}
+	for _, g := range gpus {
+		if g.discrete {
+			selected = append(selected, g)
+		}
+	}
+	return selected, nil
+}
+
+// selectDGPU returns the one discrete GPU to act on, asking for --gpu when
+// there are several.
+func selectDGPU() (gpuInfo, error) {
+	gpus, err := selectedDGPUs()
+	if err != nil {
+		return gpuInfo{}, err
+	}
+	switch len(gpus) {
+	case 0:
+		return gpuInfo{}, errors.New("no discrete GPU found")
+	case 1:
+		return gpus[0], nil
+	}
+	addrs := make([]string, 0, len(gpus))
+	for _, g := range gpus {
+		addrs = append(addrs, g.addr)
+	}
+	return gpuInfo{}, fmt.Errorf("several discrete GPUs (%s); pick one with --gpu", strings.Join(addrs, ", "))
+}
+
+func completeGPUs(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	gpus, err := listGPUs()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var addrs []string
+	for _, g := range gpus {
+		addrs = append(addrs, g.addr+"\t"+pciName(g.vendor, g.device))
+	}
+	return addrs, cobra.ShellCompDirectiveNoFileComp
+}
+
+// gpuDetails is the per-GPU hardware overview shown by the gpus command.
+type gpuDetails struct {
+	Address         string         `json:"address"`
+	Name            string         `json:"name"`
+	Vendor          string         `json:"vendor"`
+	Device          string         `json:"device"`
+	SubsystemVendor string         `json:"subsystem_vendor"`
+	SubsystemDevice string         `json:"subsystem_device"`
+	Class           string         `json:"class"`
+	Driver          string         `json:"driver"`
+	DriverVersion   string         `json:"driver_version"`
+	BootVGA         bool           `json:"boot_vga"`
+	Discrete        bool           `json:"discrete"`
+	External        bool           `json:"external"`
+	PowerState      string         `json:"power_state,omitempty"`
+	RuntimeStatus   string         `json:"runtime_status,omitempty"`
+	VRAMBytes       uint64         `json:"vram_bytes,omitempty"`
+	Link            *pcieLink      `json:"link,omitempty"`
+	Sensors         *gpuSensors    `json:"sensors,omitempty"`
+	NVIDIA          *nvidiaDetails `json:"nvidia,omitempty"`
+	Connectors      []drmConnector `json:"connectors,omitempty"`
+}
+
+// pcieLink is the negotiated and maximum PCIe link, e.g. "16.0 GT/s PCIe"
+// and "8".
+type pcieLink struct {
+	Speed    string `json:"speed"`
+	Width    string `json:"width"`
+	MaxSpeed string `json:"max_speed"`
+	MaxWidth string `json:"max_width"`
+}
+
+func readPCIeLink(dir string) *pcieLink {
+	l := pcieLink{
+		Speed:    readFirstLine(filepath.Join(dir, "current_link_speed")),
+		Width:    readFirstLine(filepath.Join(dir, "current_link_width")),
+		MaxSpeed: readFirstLine(filepath.Join(dir, "max_link_speed")),
+		MaxWidth: readFirstLine(filepath.Join(dir, "max_link_width")),
+	}
+	if l.Speed == "" && l.Width == "" {
+		return nil
+	}
+	return &l
+}
+
+// degraded reports a link running below its maximum. GPUs lower the link
+// speed when idle, so this alone is not a fault.
+func (l *pcieLink) degraded() bool {
+	return l.Speed != l.MaxSpeed || l.Width != l.MaxWidth
+}
+
+func (l *pcieLink) String() string {
+	s := fmt.Sprintf("%s x%s", l.Speed, l.Width)
+	if l.degraded() {
+		s += fmt.Sprintf(" (max %s x%s)", l.MaxSpeed, l.MaxWidth)
+	}
+	return s
+}
+
+func readGpuDetails(g gpuInfo) gpuDetails {
+	dir := filepath.Join(pciRoot, g.addr)
+	d := gpuDetails{
+		Address:         g.addr,
+		Name:            pciName(g.vendor, g.device),
+		Vendor:          g.vendor,
+		Device:          g.device,
+		SubsystemVendor: readFirstLine(filepath.Join(dir, "subsystem_vendor")),
+		SubsystemDevice: readFirstLine(filepath.Join(dir, "subsystem_device")),
+		Class:           g.class,
+		Driver:          g.driver,
+		DriverVersion:   driverVersion(g.driver),
+		BootVGA:         g.bootVGA,
+		Discrete:        g.discrete,
+		External:        g.external,
+		PowerState:      readFirstLine(filepath.Join(dir, "power_state")),
+		RuntimeStatus:   readFirstLine(filepath.Join(dir, "power", "runtime_status")),
+		Link:            readPCIeLink(dir),
+		Sensors:         readGpuSensors(dir),
+		NVIDIA:          readNvidiaDetails(g.addr),
+	}
+	// amdgpu reports VRAM directly; other drivers need their own tools.
+	if vram, err := strconv.ParseUint(readFirstLine(filepath.Join(dir, "mem_info_vram_total")), 10, 64); err == nil {
+		d.VRAMBytes = vram
+	}
+	return d
+}
+
+// driverVersion returns the module's version, or the kernel release for
+// in-tree drivers that have none.
+func driverVersion(driver string) string {
+	if driver == "" || driver == "unknown" {
+		return ""
+	}
+	if v := readFirstLine(filepath.Join(sysModuleRoot, driver, "version")); v != "" {
+		return v
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return ""
+	}
+	return "in-tree (" + unix.ByteSliceToString(uts.Release[:]) + ")"
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func printGpuDetails(d gpuDetails) {
+	outf("%s %s (%s)", d.Address, d.Name, gpuRole(d.Discrete, d.External))
+	outf("  ids:        %s:%s (subsystem %s:%s), class %s", d.Vendor, d.Device, d.SubsystemVendor, d.SubsystemDevice, d.Class)
+	driver := d.Driver
+	if d.DriverVersion != "" {
+		driver += " " + d.DriverVersion
+	}
+	outf("  driver:     %s", driver)
+	outf("  boot_vga:   %v", d.BootVGA)
+	power := orUnknown(d.PowerState)
+	if d.RuntimeStatus != "" {
+		power += ", runtime " + d.RuntimeStatus
+	}
+	outf("  power:      %s", power)
+	if d.VRAMBytes > 0 {
+		outf("  vram:       %s", formatBytes(d.VRAMBytes))
+	}
+	if d.Link != nil {
+		outf("  pcie link:  %s", d.Link)
+		if d.Link.degraded() && d.RuntimeStatus == "active" && d.PowerState == "D0" {
+			log.Warn().Msg("  link is below its maximum; normal while idle, suspicious under load")
+		}
+	}
+	if d.Sensors != nil {
+		outf("  sensors:    %s (%s)", d.Sensors, d.Sensors.Hwmon)
+	}
+	if len(d.Connectors) > 0 {
+		outf("  outputs:    %s", formatConnectors(d.Connectors))
+	}
+	if n := d.NVIDIA; n != nil {
+		outf("  nvidia:     %s, driver %s, vbios %s, persistence %v", orUnknown(n.Model), orUnknown(n.DriverVersion), orUnknown(n.VideoBIOS), n.Persistence)
+		outf("  runtime d3: %s, video memory %s, d3cold allowed %v", orUnknown(n.RuntimeD3), orUnknown(n.VideoMemory), n.D3ColdAllowed)
+	}
+}
+
+// gpuDetailsList reads every GPU with the display connectors it drives.
+func gpuDetailsList() ([]gpuDetails, error) {
+	gpus, err := listGPUs()
+	if err != nil {
+		return nil, err
+	}
+	connectors, err := drmConnectors()
+	if err != nil {
+		return nil, err
+	}
+	byGPU := connectorsByGPU(connectors)
+	details := make([]gpuDetails, 0, len(gpus))
+	for _, g := range gpus {
+		d := readGpuDetails(g)
+		d.Connectors = byGPU[g.addr]
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+func gpusCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "gpus",
+		Short: "Show detailed information about each GPU",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			details, err := gpuDetailsList()
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return printJSON(gpusReport{SchemaVersion: schemaVersion, GPUs: details})
+			}
+			if len(details) == 0 {
+				log.Info().Msg("no GPUs found")
+			}
+			for i, d := range details {
+				if i > 0 {
+					outln("")
+				}
+				printGpuDetails(d)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON to stdout (see the schema command)")
+	return cmd
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadGpuDetails(t *testing.T) {
+	originalPCI, originalModules := pciRoot, sysModuleRoot
+	pciRoot, sysModuleRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { pciRoot, sysModuleRoot = originalPCI, originalModules })
+
+	dir := filepath.Join(pciRoot, "0000:06:00.0")
+	if err := os.MkdirAll(filepath.Join(dir, "power"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, value := range map[string]string{
+		"subsystem_vendor":     "0x1462",
+		"subsystem_device":     "0x13a1",
+		"power_state":          "D0",
+		"power/runtime_status": "active",
+		"mem_info_vram_total":  "536870912",
+		"current_link_speed":   "2.5 GT/s PCIe",
+		"current_link_width":   "1",
+		"max_link_speed":       "16.0 GT/s PCIe",
+		"max_link_width":       "8",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(sysModuleRoot, "nvidia"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sysModuleRoot, "nvidia", "version"), []byte("570.124.04\n"), 0o644); err != nil {
+		t.Fatalf("write version: %v", err)
+	}
+
+	d := readGpuDetails(gpuInfo{addr: "0000:06:00.0", class: "0x030000", vendor: "0x1002", device: "0x15bf", driver: "amdgpu", bootVGA: true})
+	if d.SubsystemVendor != "0x1462" || d.PowerState != "D0" || d.RuntimeStatus != "active" || d.VRAMBytes != 512<<20 || !d.BootVGA {
+		t.Fatalf("unexpected details: %+v", d)
+	}
+	if d.Link == nil || d.Link.String() != "2.5 GT/s PCIe x1 (max 16.0 GT/s PCIe x8)" {
+		t.Fatalf("unexpected link: %v", d.Link)
+	}
+	if got := formatBytes(d.VRAMBytes); got != "512.0 MiB" {
+		t.Fatalf("formatBytes = %q", got)
+	}
+	if got := driverVersion("nvidia"); got != "570.124.04" {
+		t.Fatalf("driverVersion = %q", got)
+	}
+}
+
+func TestSelectDGPU(t *testing.T) {
+	originalRoot, originalSelector := pciRoot, gpuSelector
+	pciRoot = t.TempDir()
+	t.Cleanup(func() { pciRoot, gpuSelector = originalRoot, originalSelector })
+
+	for _, addr := range []string{"0000:01:00.0", "0000:02:00.0"} {
+		dir := filepath.Join(pciRoot, addr)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for name, value := range map[string]string{"class": "0x030200", "vendor": nvidiaVendor} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644); err != nil {
+				t.Fatalf("write %s: %v", name, err)
+			}
+		}
+	}
+
+	gpuSelector = ""
+	if _, err := selectDGPU(); err == nil {
+		t.Fatal("expected an error with two discrete GPUs and no --gpu")
+	}
+	gpuSelector = "02:00.0"
+	if g, err := selectDGPU(); err != nil || g.addr != "0000:02:00.0" {
+		t.Fatalf("selectDGPU = %+v, %v", g, err)
+	}
+	gpuSelector = "0000:03:00.0"
+	if _, err := selectDGPU(); err == nil {
+		t.Fatal("expected an error for a missing GPU")
+	}
+}
@@ -0,0 +1,258 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// helperSocket is where the root helper listens. Unprivileged commands use
+// it instead of requiring root when it exists.
+var helperSocket = "/run/msi-gpu-switcher/helper.sock"
+
+const (
+	helperMaxLine = 64
+	helperTimeout = 30 * time.Second
+)
+
+// The helper protocol is one request line per connection, answered by one
+// line starting with "ok" or "error":
+//
+//	mux            -> ok discrete|hybrid
+//	switch <mode>  -> ok <mode>
+func handleHelperRequest(ctx context.Context, line string) string {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && fields[0] == "mux":
+		ec, err := openEC()
+		if err != nil {
+			return helperError(err)
+		}
+		defer ec.Close()
+		discrete, err := ec.readMuxState(ctx)
+		if err != nil {
+			return helperError(err)
+		}
+		if discrete {
+			return "ok discrete"
+		}
+		return "ok hybrid"
+	case len(fields) == 2 && fields[0] == "switch":
+		mode, err := parseMode(fields[1])
+		if err == nil {
+			err = checkModeSupported(mode)
+		}
+		if err == nil {
+			err = switchGPU(ctx, mode)
+		}
+		if err != nil {
+			return helperError(err)
+		}
+		return "ok " + mode.String()
+	}
+	return "error unknown request"
+}
+
+func helperError(err error) string {
+	return "error " + strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+func helperCmd() *cobra.Command {
+	var group string
+	cmd := &cobra.Command{
+		Use:   "helper",
+		Short: "Run the root helper that performs switches for unprivileged users",
+		Long: "Run the root helper that performs switches for unprivileged users.\n\n" +
+			"It listens on " + helperSocket + ", which only root and the\n" +
+			"given group can connect to, and accepts nothing but reading the MUX\n" +
+			"state and switching modes.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			return runHelper(cmd.Context(), group)
+		},
+	}
+	cmd.Flags().StringVar(&group, "group", "wheel", "group allowed to use the helper")
+	return cmd
+}
+
+func runHelper(ctx context.Context, group string) error {
+	ln, err := listenHelper(group)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	log.Info().Msgf("helper listening on %s (group %s)", helperSocket, group)
+	enterSandbox()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveHelperConn(ctx, conn.(*net.UnixConn))
+	}
+}
+
+func listenHelper(group string) (net.Listener, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(helperSocket), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(helperSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", helperSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chown(helperSocket, 0, gid); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Chmod(helperSocket, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func serveHelperConn(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(helperTimeout))
+	peer := "unknown"
+	actor := auditActor{via: "helper", uid: -1}
+	if raw, err := conn.SyscallConn(); err == nil {
+		_ = raw.Control(func(fd uintptr) {
+			if cred, err := unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED); err == nil {
+				peer = fmt.Sprintf("uid %d pid %d", cred.Uid, cred.Pid)
+				actor.uid, actor.pid = int(cred.Uid), int(cred.Pid)
+			}
+		})
+	}
+	line, err := bufio.NewReader(io.LimitReader(conn, helperMaxLine+1)).ReadString('\n')
+	if err != nil || len(line) > helperMaxLine {
+		fmt.Fprintln(conn, "error malformed request")
+		return
+	}
+	line = strings.TrimSpace(line)
+	log.Info().Msgf("helper: %q from %s", line, peer)
+	reply := handleHelperRequest(ctx, line)
+	if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "switch" {
+		var err error
+		if rest, failed := strings.CutPrefix(reply, "error "); failed {
+			err = errors.New(rest)
+		}
+		recordAudit(actor, fields, err)
+	}
+	fmt.Fprintln(conn, reply)
+}
+
+// helperCall sends one request to the helper and returns the text after "ok".
+func helperCall(ctx context.Context, request string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, helperTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", helperSocket)
+	if err != nil {
+		return "", fmt.Errorf("connect helper failed: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintln(conn, request); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read helper reply failed: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+	if rest, ok := strings.CutPrefix(reply, "ok"); ok {
+		return strings.TrimSpace(rest), nil
+	}
+	return "", fmt.Errorf("helper: %s", strings.TrimPrefix(reply, "error "))
+}
+
+// useHelper reports whether an unprivileged command should go through the
+// helper instead of requiring root.
+func useHelper() bool {
+	if os.Geteuid() == 0 {
+		return false
+	}
+	_, err := os.Stat(helperSocket)
+	return err == nil
+}
+
+// switchMode switches directly as root, or through the helper otherwise.
+func switchMode(ctx context.Context, mode gpuMode) error {
+	// The helper runs without --read-only, so it is refused here.
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	// The helper reads the same config but none of our flags, so switching
+	// through other backends than it would needs root here.
+	configured := backendNames
+	if len(loadedConfig.Backends) > 0 {
+		configured = loadedConfig.Backends
+	}
+	if useHelper() && slices.Equal(activeBackends(), configured) {
+		// The helper cannot be told a board was confirmed here; like a BIOS
+		// change, an unknown board needs a switch as root or --force on it.
+		_, err := helperCall(ctx, "switch "+mode.String())
+		return err
+	}
+	// Asked after elevating, so the re-executed process does not ask again.
+	requireRoot()
+	if err := confirmUnknownModel(); err != nil {
+		return err
+	}
+	if err := confirmFirmware(ctx); err != nil {
+		return err
+	}
+	// Other users' /proc/<pid>/fd cannot be read once privileges are
+	// dropped, so the dGPU clients are looked for while still root, before
+	// the pre hooks.
+	var opts switchOptions
+	if dropUser != "" && os.Geteuid() == 0 {
+		if err := checkDgpuIdle(mode); err != nil {
+			return err
+		}
+		opts.allowBusy = true
+	}
+	enterSandbox()
+	if err := dropPrivileges(ctx); err != nil {
+		return err
+	}
+	return switchGPUWith(ctx, mode, opts)
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHelperRejectsInvalidRequests(t *testing.T) {
+	g, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skipf("lookup own group: %v", err)
+	}
+	original := helperSocket
+	helperSocket = filepath.Join(t.TempDir(), "helper.sock")
+	t.Cleanup(func() { helperSocket = original })
+	tempAuditLog(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = runHelper(ctx, g.Name) }()
+	for i := 0; i < 100 && !exists(helperSocket); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for request, want := range map[string]string{
+		"frobnicate":                          "unknown request",
+		"switch":                              "unknown request",
+		"switch bogus":                        "bogus",
+		strings.Repeat("x", helperMaxLine+10): "malformed request",
+	} {
+		_, err := helperCall(ctx, request)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%q: expected error containing %q, got %v", request, want, err)
+		}
+	}
+	entries, err := readAudit()
+	if err != nil || len(entries) != 1 || entries[0].Via != "helper" || strings.Join(entries[0].Args, " ") != "switch bogus" {
+		t.Fatalf("expected the rejected switch in the audit log, got %+v (%v)", entries, err)
+	}
+	info, err := os.Stat(helperSocket)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o660 {
+		t.Fatalf("expected socket mode 0660, got %o", perm)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const historyFile = "history.jsonl"
+
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Mode    string    `json:"mode"`
+	Backend string    `json:"backend"`
+	Result  string    `json:"result"`
+}
+
+func historyPath() string {
+	return filepath.Join(stateDir, historyFile)
+}
+
+func recordSwitch(mode gpuMode, backends []string, switchErr error) {
+	backend := strings.Join(backends, "+")
+	if backend == "" {
+		backend = "none"
+	}
+	result := "ok"
+	if switchErr != nil {
+		result = switchErr.Error()
+	}
+	entry := historyEntry{
+		Time:    time.Now().UTC(),
+		Mode:    mode.String(),
+		Backend: backend,
+		Result:  result,
+	}
+	if err := appendHistory(entry); err != nil {
+		log.Warn().Msgf("record history failed: %v", err)
+	}
+}
+
+func appendHistory(entry historyEntry) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := openFile(historyPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func readHistory() ([]historyEntry, error) {
+	f, err := os.Open(historyPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("parse %s line %d: %w", historyFile, n, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func showHistory(limit int) error {
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		log.Info().Msg("no switches recorded")
+		return nil
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for _, e := range entries {
+		outf("%s  %-8s  %-6s  %s",
+			e.Time.Local().Format(time.RFC3339), e.Mode, e.Backend, e.Result)
+	}
+	return nil
+}
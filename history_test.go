@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordSwitchAppendsHistory(t *testing.T) {
+	originalDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = originalDir })
+
+	recordSwitch(modeDiscrete, []string{"uefi", "ec"}, nil)
+	recordSwitch(modeHybrid, nil, errors.New("boom"))
+
+	entries, err := readHistory()
+	if err != nil {
+		t.Fatalf("readHistory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Mode != "discrete" || entries[0].Backend != "uefi+ec" || entries[0].Result != "ok" {
+		t.Fatalf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Mode != "hybrid" || entries[1].Backend != "none" || entries[1].Result != "boom" {
+		t.Fatalf("unexpected second entry: %+v", entries[1])
+	}
+}
+
+func TestReadHistoryMissingFile(t *testing.T) {
+	originalDir := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = originalDir })
+
+	entries, err := readHistory()
+	if err != nil {
+		t.Fatalf("readHistory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no entries, got %d", len(entries))
+	}
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// hooksDir holds pre.d and post.d, whose executables run before and after
+// every switch in name order, like run-parts.
+var hooksDir = "/etc/msi-gpu-switcher/hooks"
+
+// hookEvent describes a switch to the hooks through the environment.
+type hookEvent struct {
+	oldMode  string
+	newMode  gpuMode
+	backends []string
+	err      error
+}
+
+func (e hookEvent) env(stage string) []string {
+	env := []string{
+		"MSI_GPU_SWITCHER_STAGE=" + stage,
+		"MSI_GPU_SWITCHER_OLD_MODE=" + e.oldMode,
+		"MSI_GPU_SWITCHER_NEW_MODE=" + e.newMode.String(),
+	}
+	if stage == "post" {
+		result := "ok"
+		if e.err != nil {
+			result = "failed"
+			env = append(env, "MSI_GPU_SWITCHER_ERROR="+e.err.Error())
+		}
+		env = append(env,
+			"MSI_GPU_SWITCHER_RESULT="+result,
+			"MSI_GPU_SWITCHER_BACKENDS="+strings.Join(e.backends, "+"),
+		)
+	}
+	return env
+}
+
+// hookScripts lists the executables in dir. Hidden files, editor backups
+// and files without an execute bit are skipped.
+func hookScripts(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scripts []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		scripts = append(scripts, filepath.Join(dir, name))
+	}
+	sort.Strings(scripts)
+	return scripts, nil
+}
+
+// runHooks runs the hooks of stage from hooksDir/<stage>.d. With stop a
+// failing hook ends the run and is returned; otherwise it is logged and the
+// next hook runs.
+func runHooks(ctx context.Context, stage string, ev hookEvent, stop bool) error {
+	return runHookDir(ctx, stage, filepath.Join(hooksDir, stage+".d"), ev.env(stage), stop)
+}
+
+func runHookDir(ctx context.Context, stage, dir string, extraEnv []string, stop bool) error {
+	scripts, err := hookScripts(dir)
+	if err != nil {
+		return fmt.Errorf("list %s hooks failed: %w", stage, err)
+	}
+	env := append(os.Environ(), extraEnv...)
+	for _, script := range scripts {
+		log.Debug().Msgf("running %s hook %s", stage, script)
+		hookCtx, cancel := context.WithTimeout(ctx, commandTimeout)
+		cmd := exec.CommandContext(hookCtx, script)
+		cmd.Env = env
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		err := cmd.Run()
+		cancel()
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s hook %s failed: %w", stage, filepath.Base(script), err)
+		if stop {
+			return err
+		}
+		log.Warn().Msgf("%v", err)
+	}
+	return nil
+}
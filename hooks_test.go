@@ -0,0 +1,77 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeHook(t *testing.T, stage, name, body string, mode os.FileMode) {
+	t.Helper()
+	dir := filepath.Join(hooksDir, stage+".d")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), mode); err != nil {
+		t.Fatalf("write hook: %v", err)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	originalDir := hooksDir
+	hooksDir = t.TempDir()
+	t.Cleanup(func() { hooksDir = originalDir })
+
+	out := filepath.Join(t.TempDir(), "out")
+	script := `echo "$0 $MSI_GPU_SWITCHER_STAGE $MSI_GPU_SWITCHER_OLD_MODE $MSI_GPU_SWITCHER_NEW_MODE $MSI_GPU_SWITCHER_RESULT $MSI_GPU_SWITCHER_BACKENDS" >>` + out
+	writeHook(t, "post", "20-second", script, 0o755)
+	writeHook(t, "post", "10-first", script, 0o755)
+	writeHook(t, "post", "30-disabled", script, 0o644)
+	writeHook(t, "post", "40-backup~", script, 0o755)
+
+	ev := hookEvent{oldMode: "hybrid", newMode: modeDiscrete, backends: []string{"uefi", "ec"}}
+	if err := runHooks(context.Background(), "post", ev, false); err != nil {
+		t.Fatalf("runHooks: %v", err)
+	}
+	raw, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	post := filepath.Join(hooksDir, "post.d")
+	want := post + "/10-first post hybrid discrete ok uefi+ec\n" + post + "/20-second post hybrid discrete ok uefi+ec\n"
+	if string(raw) != want {
+		t.Fatalf("hook output = %q, want %q", raw, want)
+	}
+
+	ev.err = errors.New("ec write failed")
+	if env := strings.Join(ev.env("post"), "\n"); !strings.Contains(env, "MSI_GPU_SWITCHER_RESULT=failed") || !strings.Contains(env, "MSI_GPU_SWITCHER_ERROR=ec write failed") {
+		t.Fatalf("failed switch env = %q", env)
+	}
+}
+
+func TestPreHookFailureStops(t *testing.T) {
+	originalDir := hooksDir
+	hooksDir = t.TempDir()
+	t.Cleanup(func() { hooksDir = originalDir })
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	writeHook(t, "pre", "10-refuse", "exit 1", 0o755)
+	writeHook(t, "pre", "20-after", "touch "+marker, 0o755)
+
+	ev := hookEvent{oldMode: "discrete", newMode: modeHybrid}
+	err := runHooks(context.Background(), "pre", ev, true)
+	if err == nil || !strings.Contains(err.Error(), "10-refuse") {
+		t.Fatalf("expected the failing hook to be reported, got %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("expected hooks after a failing pre hook not to run")
+	}
+	if err := runHooks(context.Background(), "missing", ev, true); err != nil {
+		t.Fatalf("expected a missing hook dir to be fine, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build freebsd
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var lockPath = "/var/run/msi-gpu-switcher.lock"
+
+// stateDir holds the switch history and the recorded BIOS, as
+// /var/lib/msi-gpu-switcher does on Linux.
+var stateDir = "/var/db/msi-gpu-switcher"
+
+// kenvValues maps the Linux DMI fields to the kernel environment variables
+// the loader sets from SMBIOS.
+var kenvValues = map[string]string{
+	"board_name":   "smbios.planar.product",
+	"bios_vendor":  "smbios.bios.vendor",
+	"bios_version": "smbios.bios.version",
+	"bios_date":    "smbios.bios.reldate",
+}
+
+// kenvGet is KENV_GET from <sys/kenv.h>, and kenvMvallen KENV_MVALLEN.
+const (
+	kenvGet     = 0
+	kenvMvallen = 128
+)
+
+// readDMI returns an SMBIOS field, or "" when it cannot be read.
+func readDMI(field string) string {
+	name, err := unix.BytePtrFromString(kenvValues[field])
+	if err != nil {
+		return ""
+	}
+	buf := make([]byte, kenvMvallen+1)
+	n, _, errno := unix.Syscall6(unix.SYS_KENV, kenvGet, uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return ""
+	}
+	value, _, _ := bytes.Cut(buf[:min(int(n), len(buf))], []byte{0})
+	return string(value)
+}
+
+func stdinIsTerminal() bool {
+	_, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TIOCGETA)
+	return err == nil
+}
+
+// tryLock takes an exclusive flock on f without waiting and reports whether
+// it got it.
+func tryLock(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// lockPath is shared by every user, like /run on Linux.
+var lockPath = filepath.Join(programData(), "msi-gpu-switcher.lock")
+
+// stateDir holds the switch history and the recorded BIOS, as
+// /var/lib/msi-gpu-switcher does on Linux.
+var stateDir = filepath.Join(programData(), "msi-gpu-switcher")
+
+func programData() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return dir
+	}
+	return `C:\ProgramData`
+}
+
+// dmiValues maps the Linux DMI fields to the values Windows copies from
+// SMBIOS into the registry at boot.
+var dmiValues = map[string]string{
+	"board_name":   "BaseBoardProduct",
+	"bios_vendor":  "BIOSVendor",
+	"bios_version": "BIOSVersion",
+	"bios_date":    "BIOSReleaseDate",
+}
+
+// readDMI returns an SMBIOS field, or "" when it cannot be read.
+func readDMI(field string) string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\BIOS`, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer k.Close()
+	value, _, err := k.GetStringValue(dmiValues[field])
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+func stdinIsTerminal() bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(os.Stdin.Fd()), &mode) == nil
+}
+
+// tryLock takes an exclusive lock on f's first byte without waiting and
+// reports whether it got it.
+func tryLock(f *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,179 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// inputRoot lists the input devices; their nodes are /dev/input/<name>.
+var inputRoot = "/sys/class/input"
+
+const (
+	// defaultHotkeyCode is KEY_PROG1. The code the Fn GPU key produces
+	// depends on the model and kernel driver; evtest shows it.
+	defaultHotkeyCode = 148
+	// hotkeyDebounce drops presses this soon after the previous one, so a
+	// bouncing key does not switch back right away.
+	hotkeyDebounce = time.Second
+	evKey          = 0x01
+)
+
+// inputEvent is struct input_event.
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// hasKey reports whether a capabilities/key bitmap, hex words of
+// unsigned long with the most significant first, has the bit for code.
+func hasKey(bitmap string, code int) bool {
+	words := strings.Fields(bitmap)
+	i := len(words) - 1 - code/strconv.IntSize
+	if i < 0 || i >= len(words) {
+		return false
+	}
+	word, err := strconv.ParseUint(words[i], 16, strconv.IntSize)
+	return err == nil && word&(1<<(code%strconv.IntSize)) != 0
+}
+
+// hotkeyDevices returns the event device nodes that can report code.
+func hotkeyDevices(code int) ([]string, error) {
+	dirs, err := hostfs.Glob(filepath.Join(inputRoot, "event*"))
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, dir := range dirs {
+		if hasKey(readFirstLine(filepath.Join(dir, "device", "capabilities", "key")), code) {
+			devices = append(devices, filepath.Join("/dev/input", filepath.Base(dir)))
+		}
+	}
+	return devices, nil
+}
+
+// listenHotkey reports presses of code on every input device that has the
+// key until ctx is done. Presses arriving while the previous one is still
+// being handled are dropped.
+func listenHotkey(ctx context.Context, code int) (<-chan struct{}, error) {
+	devices, err := hotkeyDevices(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no input device reports key code %d; find the GPU key's code with evtest", code)
+	}
+	presses := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var last time.Time
+	for _, device := range devices {
+		f, err := hostfs.OpenFile(device, os.O_RDONLY, 0)
+		if err != nil {
+			log.Warn().Msgf("hotkey: %v", err)
+			continue
+		}
+		log.Info().Msgf("listening for key code %d on %s", code, device)
+		context.AfterFunc(ctx, func() { f.Close() })
+		go func() {
+			defer f.Close()
+			err := readKeyPresses(f, code, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if now := time.Now(); now.Sub(last) >= hotkeyDebounce {
+					last = now
+					select {
+					case presses <- struct{}{}:
+					default:
+					}
+				}
+			})
+			if ctx.Err() == nil {
+				log.Warn().Msgf("hotkey: stopped reading %s: %v", device, err)
+			}
+		}()
+	}
+	return presses, nil
+}
+
+// readKeyPresses calls press for every key-down event of code read from r,
+// until r fails.
+func readKeyPresses(r io.Reader, code int, press func()) error {
+	for {
+		var ev inputEvent
+		if err := binary.Read(r, binary.NativeEndian, &ev); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			return err
+		}
+		if ev.Type == evKey && int(ev.Code) == code && ev.Value == 1 {
+			press()
+		}
+	}
+}
+
+// nextMode is the mode the GPU key selects after mode, cycling through the
+// supported modes like MSI Center does.
+func nextMode(mode gpuMode) gpuMode {
+	modes := supportedModes()
+	i := slices.Index(modes, mode)
+	return modes[(i+1)%len(modes)]
+}
+
+// hotkeyPending is the mode the next boot uses, which the GPU key moves on
+// from: the UEFI variable's, else the EC MUX's when the variable is missing,
+// else the mode in use.
+func hotkeyPending(ctx context.Context) (gpuMode, error) {
+	mode, err := readUefiGpuMode(ctx)
+	if !errors.Is(err, ErrUefiVarMissing) {
+		return mode, err
+	}
+	if exists(ecIOPath) {
+		ec, err := openECReadOnly()
+		if err != nil {
+			return 0, err
+		}
+		defer ec.Close()
+		discrete, err := ec.readMuxState(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if discrete {
+			return modeDiscrete, nil
+		}
+		return modeHybrid, nil
+	}
+	return activeMode()
+}
+
+// hotkeyToggle switches to the mode after the pending one. switched is false
+// when the pending mode could not be read and nothing was tried. Nobody at
+// the key can pass --force, and the switch only applies at the next boot, so
+// processes on the dGPU do not stop it.
+func hotkeyToggle(ctx context.Context) (mode gpuMode, switched bool, err error) {
+	pending, err := hotkeyPending(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("read the pending mode: %w", err)
+	}
+	mode = nextMode(pending)
+	log.Info().Msgf("GPU key pressed, switching %s -> %s", pending, mode)
+	err = switchGPUWith(ctx, mode, switchOptions{allowBusy: true})
+	recordAudit(auditActor{via: "hotkey"}, []string{"switch", mode.String()}, err)
+	return mode, true, err
+}
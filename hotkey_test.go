@@ -0,0 +1,141 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyBitmap formats a capabilities/key bitmap with the given codes set.
+func keyBitmap(codes ...int) string {
+	words := make([]uint64, 1)
+	for _, code := range codes {
+		for len(words) <= code/strconv.IntSize {
+			words = append(words, 0)
+		}
+		words[code/strconv.IntSize] |= 1 << (code % strconv.IntSize)
+	}
+	var fields []string
+	for i := len(words) - 1; i >= 0; i-- {
+		fields = append(fields, fmt.Sprintf("%x", words[i]))
+	}
+	return strings.Join(fields, " ")
+}
+
+func keyEvents(events ...inputEvent) string {
+	var buf bytes.Buffer
+	for _, ev := range events {
+		binary.Write(&buf, binary.NativeEndian, ev)
+	}
+	return buf.String()
+}
+
+func TestHasKey(t *testing.T) {
+	bitmap := keyBitmap(1, 30, defaultHotkeyCode)
+	for code, want := range map[int]bool{1: true, 30: true, defaultHotkeyCode: true, 2: false, 500: false} {
+		if got := hasKey(bitmap, code); got != want {
+			t.Fatalf("hasKey(%q, %d) = %v", bitmap, code, got)
+		}
+	}
+	if hasKey("", 1) || hasKey("zz", 1) {
+		t.Fatalf("expected malformed bitmaps to have no keys")
+	}
+}
+
+func TestListenHotkey(t *testing.T) {
+	press := inputEvent{Type: evKey, Code: defaultHotkeyCode, Value: 1}
+	release := inputEvent{Type: evKey, Code: defaultHotkeyCode, Value: 0}
+	other := inputEvent{Type: evKey, Code: 30, Value: 1}
+	fixtureTree(t, map[string]string{
+		inputRoot + "/event0/device/capabilities/key": keyBitmap(30) + "\n",
+		inputRoot + "/event3/device/capabilities/key": keyBitmap(defaultHotkeyCode) + "\n",
+		"/dev/input/event0":                           keyEvents(other),
+		"/dev/input/event3":                           keyEvents(other, press, release, press),
+	}, nil)
+
+	devices, err := hotkeyDevices(defaultHotkeyCode)
+	if err != nil || len(devices) != 1 || devices[0] != "/dev/input/event3" {
+		t.Fatalf("hotkeyDevices = %v %v", devices, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	presses, err := listenHotkey(ctx, defaultHotkeyCode)
+	if err != nil {
+		t.Fatalf("listenHotkey: %v", err)
+	}
+	select {
+	case <-presses:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no press reported")
+	}
+	// The second press came within hotkeyDebounce of the first.
+	select {
+	case <-presses:
+		t.Fatalf("bounced press reported")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := listenHotkey(ctx, 500); err == nil {
+		t.Fatalf("expected an error for a key no device has")
+	}
+}
+
+func TestNextMode(t *testing.T) {
+	original := triStateModes
+	t.Cleanup(func() { triStateModes = original })
+
+	triStateModes = false
+	for mode, want := range map[gpuMode]gpuMode{modeHybrid: modeDiscrete, modeDiscrete: modeHybrid, modeIntegrated: modeHybrid} {
+		if got := nextMode(mode); got != want {
+			t.Fatalf("nextMode(%s) = %s, want %s", mode, got, want)
+		}
+	}
+	triStateModes = true
+	if nextMode(modeDiscrete) != modeIntegrated || nextMode(modeIntegrated) != modeHybrid {
+		t.Fatalf("tri-state modes do not cycle through integrated")
+	}
+}
+
+func TestHotkeyToggle(t *testing.T) {
+	root := switchFixture(t)
+	busyDgpu(t, root)
+	ctx := context.Background()
+	if err := writeUefiVar(ctx, uefiDefaultAttrs, []byte{0, byte(modeDiscrete), 0, 0}); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+
+	// Nobody at the key can pass --force, so a busy dGPU does not stop it.
+	if mode, switched, err := hotkeyToggle(ctx); err != nil || !switched || mode != modeHybrid {
+		t.Fatalf("hotkeyToggle off a busy dGPU = %v %v %v", mode, switched, err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("readUefiGpuMode = %v %v, want hybrid", mode, err)
+	}
+
+	// A variable that cannot be parsed is not guessed around.
+	varFile := filepath.Join(root, uefiVarPath)
+	if err := os.WriteFile(varFile, []byte{0x07, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("truncate var: %v", err)
+	}
+	if _, switched, err := hotkeyToggle(ctx); err == nil || switched {
+		t.Fatalf("expected an unreadable variable to fail without a switch, got switched=%v err=%v", switched, err)
+	}
+
+	// Without the variable the EC MUX, hybrid in the fixture, is moved on
+	// from.
+	if err := os.Remove(varFile); err != nil {
+		t.Fatalf("remove var: %v", err)
+	}
+	if mode, switched, _ := hotkeyToggle(ctx); !switched || mode != modeDiscrete {
+		t.Fatalf("hotkeyToggle without the variable = %v %v, want a switch to discrete", mode, switched)
+	}
+}
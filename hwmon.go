@@ -0,0 +1,87 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gpuSensors holds the readings of a GPU's hwmon node. Zero values mean the
+// driver does not report that sensor.
+type gpuSensors struct {
+	Hwmon       string  `json:"hwmon"`
+	TempCelsius float64 `json:"temp_celsius,omitempty"`
+	PowerWatts  float64 `json:"power_watts,omitempty"`
+	Asleep      bool    `json:"asleep,omitempty"`
+}
+
+// findHwmon returns the first hwmon directory under a PCI device, or "".
+func findHwmon(dir string) string {
+	entries, err := hostfs.ReadDir(filepath.Join(dir, "hwmon"))
+	if err != nil {
+		return ""
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "hwmon") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return filepath.Join(dir, "hwmon", names[0])
+}
+
+// readHwmonScaled parses the first readable file among names, divided by scale.
+func readHwmonScaled(dir string, scale float64, names ...string) float64 {
+	for _, name := range names {
+		v, err := strconv.ParseFloat(readFirstLine(filepath.Join(dir, name)), 64)
+		if err == nil {
+			return v / scale
+		}
+	}
+	return 0
+}
+
+// readGpuSensors reads temperature and power draw for the GPU at dir. A
+// runtime-suspended GPU is not touched: reading its sensors would wake it up,
+// and being asleep is the answer the user is looking for.
+func readGpuSensors(dir string) *gpuSensors {
+	hwmon := findHwmon(dir)
+	if hwmon == "" {
+		return nil
+	}
+	s := gpuSensors{Hwmon: readFirstLine(filepath.Join(hwmon, "name"))}
+	if readFirstLine(filepath.Join(dir, "power", "runtime_status")) == "suspended" {
+		s.Asleep = true
+		return &s
+	}
+	s.TempCelsius = readHwmonScaled(hwmon, 1000, "temp1_input")
+	// amdgpu exposes power1_average on older kernels and power1_input on
+	// newer ones; both are in microwatts.
+	s.PowerWatts = readHwmonScaled(hwmon, 1e6, "power1_input", "power1_average")
+	return &s
+}
+
+func (s *gpuSensors) String() string {
+	if s.Asleep {
+		return "asleep"
+	}
+	var parts []string
+	if s.TempCelsius != 0 {
+		parts = append(parts, fmt.Sprintf("%.0f°C", s.TempCelsius))
+	}
+	if s.PowerWatts != 0 {
+		parts = append(parts, fmt.Sprintf("%.1f W", s.PowerWatts))
+	}
+	if len(parts) == 0 {
+		return "no readings"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadGpuSensors(t *testing.T) {
+	dir := t.TempDir()
+	hwmon := filepath.Join(dir, "hwmon", "hwmon4")
+	if err := os.MkdirAll(hwmon, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "power"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, value := range map[string]string{
+		"hwmon/hwmon4/name":           "amdgpu",
+		"hwmon/hwmon4/temp1_input":    "47000",
+		"hwmon/hwmon4/power1_average": "12345000",
+		"power/runtime_status":        "active",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	s := readGpuSensors(dir)
+	if s == nil || s.Hwmon != "amdgpu" || s.TempCelsius != 47 || s.PowerWatts != 12.345 {
+		t.Fatalf("unexpected sensors: %+v", s)
+	}
+	if got := s.String(); got != "47°C, 12.3 W" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "power", "runtime_status"), []byte("suspended\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s = readGpuSensors(dir)
+	if s == nil || !s.Asleep || s.TempCelsius != 0 || s.String() != "asleep" {
+		t.Fatalf("suspended GPU should not be read: %+v", s)
+	}
+
+	if readGpuSensors(t.TempDir()) != nil {
+		t.Fatal("expected nil without hwmon")
+	}
+}
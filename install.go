@@ -0,0 +1,276 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+//go:embed dist
+var distFS embed.FS
+
+const manifestFile = "installed.txt"
+
+// installFile is one support asset and where it goes on the system.
+type installFile struct {
+	path string
+	data []byte
+	mode os.FileMode
+}
+
+// installFiles renders every asset for prefix. D-Bus only reads policies from
+// /usr/share and /etc, polkit only from /usr/share, and udev only from
+// /usr/lib and /etc, so those ignore prefix where needed.
+func installFiles(root *cobra.Command, prefix, bin string) ([]installFile, error) {
+	dbusDir, udevDir := "/etc/dbus-1/system.d", udevRulesDir
+	if prefix == "/usr" {
+		dbusDir, udevDir = "/usr/share/dbus-1/system.d", "/usr/lib/udev/rules.d"
+	}
+	var files []installFile
+	for _, a := range []struct {
+		name, dir, target string
+		mode              os.FileMode
+	}{
+		{"io.github.ElXreno.MsiGpuSwitcher.conf", dbusDir, "", 0o644},
+		{"io.github.ElXreno.MsiGpuSwitcher.policy", "/usr/share/polkit-1/actions", "", 0o644},
+		{"msi-gpu-switcher.service", filepath.Join(prefix, "lib/systemd/system"), "", 0o644},
+		{"msi-gpu-switcher-helper.service", filepath.Join(prefix, "lib/systemd/system"), "", 0o644},
+		{"msi-gpu-switcher-boot.service", filepath.Join(prefix, "lib/systemd/system"), "", 0o644},
+		{udevRulesFile, udevDir, "", 0o644},
+		// systemd-sleep only looks in /usr/lib.
+		{"msi-gpu-switcher.sleep", "/usr/lib/systemd/system-sleep", "msi-gpu-switcher", 0o755},
+	} {
+		data, err := renderAsset(a.name, bin)
+		if err != nil {
+			return nil, err
+		}
+		target := a.target
+		if target == "" {
+			target = a.name
+		}
+		files = append(files, installFile{filepath.Join(a.dir, target), data, a.mode})
+	}
+
+	for _, c := range []struct {
+		path string
+		gen  func(*bytes.Buffer) error
+	}{
+		{"share/bash-completion/completions/msi-gpu-switcher", func(b *bytes.Buffer) error { return root.GenBashCompletionV2(b, true) }},
+		{"share/zsh/site-functions/_msi-gpu-switcher", func(b *bytes.Buffer) error { return root.GenZshCompletion(b) }},
+		{"share/fish/vendor_completions.d/msi-gpu-switcher.fish", func(b *bytes.Buffer) error { return root.GenFishCompletion(b, true) }},
+	} {
+		var buf bytes.Buffer
+		if err := c.gen(&buf); err != nil {
+			return nil, err
+		}
+		files = append(files, installFile{filepath.Join(prefix, c.path), buf.Bytes(), 0o644})
+	}
+
+	man, err := manPages(root)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(man))
+	for name := range man {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files = append(files, installFile{filepath.Join(prefix, "share/man/man8", name), man[name], 0o644})
+	}
+	return files, nil
+}
+
+func renderAsset(name, bin string) ([]byte, error) {
+	raw, err := distFS.ReadFile("dist/" + name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Bin string }{bin}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func manPages(root *cobra.Command) (map[string][]byte, error) {
+	dir, err := os.MkdirTemp("", "msi-gpu-switcher-man")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	root.DisableAutoGenTag = true
+	if err := doc.GenManTree(root, manHeader(), dir); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pages[e.Name()] = data
+	}
+	return pages, nil
+}
+
+func manifestPath() string {
+	return filepath.Join(stateDir, manifestFile)
+}
+
+func readManifest() ([]string, error) {
+	f, err := os.Open(manifestPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+func installBinary() string {
+	bin, err := os.Executable()
+	if err != nil {
+		return "/usr/local/bin/msi-gpu-switcher"
+	}
+	if resolved, err := filepath.EvalSymlinks(bin); err == nil {
+		bin = resolved
+	}
+	return bin
+}
+
+func installCmd() *cobra.Command {
+	var prefix, destdir, bin string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the D-Bus and polkit policies, systemd units and sleep hook, udev rules, completions and man pages",
+		Long: "Install the support files for a manual installation.\n\n" +
+			"The installed paths are recorded in /var/lib/msi-gpu-switcher/" + manifestFile + "\n" +
+			"so that uninstall removes exactly those files. Packagers can use --destdir.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if destdir == "" && !dryRun {
+				if err := checkNotReplaying("install"); err != nil {
+					return err
+				}
+				requireRoot()
+			}
+			if bin == "" {
+				bin = installBinary()
+			}
+			files, err := installFiles(cmd.Root(), prefix, bin)
+			if err != nil {
+				return err
+			}
+			var paths []string
+			for _, f := range files {
+				target := filepath.Join(destdir, f.path)
+				log.Info().Msgf("install %s", target)
+				paths = append(paths, f.path)
+				if dryRun {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(target, f.data, f.mode); err != nil {
+					return fmt.Errorf("install %s failed: %w", target, err)
+				}
+			}
+			if dryRun || destdir != "" {
+				return nil
+			}
+			if err := os.MkdirAll(stateDir, 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(manifestPath(), []byte(strings.Join(paths, "\n")+"\n"), 0o644); err != nil {
+				return fmt.Errorf("write manifest failed: %w", err)
+			}
+			log.Info().Msg("run `systemctl daemon-reload && systemctl enable --now msi-gpu-switcher` to start the daemon")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "/usr/local", "installation prefix")
+	cmd.Flags().StringVar(&destdir, "destdir", "", "stage files under this directory (packaging)")
+	cmd.Flags().StringVar(&bin, "bin", "", "binary path used in the unit and policy (default: this executable)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print what would be installed")
+	return cmd
+}
+
+func uninstallCmd() *cobra.Command {
+	var prefix string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the files installed by install",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !dryRun {
+				if err := checkNotReplaying("uninstall"); err != nil {
+					return err
+				}
+				requireRoot()
+			}
+			paths, err := readManifest()
+			if errors.Is(err, os.ErrNotExist) {
+				// No manifest: remove whatever install would put under prefix.
+				files, ferr := installFiles(cmd.Root(), prefix, installBinary())
+				if ferr != nil {
+					return ferr
+				}
+				for _, f := range files {
+					paths = append(paths, f.path)
+				}
+			} else if err != nil {
+				return err
+			}
+			for _, p := range paths {
+				log.Info().Msgf("remove %s", p)
+				if dryRun {
+					continue
+				}
+				if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("remove %s failed: %w", p, err)
+				}
+			}
+			if dryRun {
+				return nil
+			}
+			if err := os.Remove(manifestPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "/usr/local", "installation prefix, used when no manifest was recorded")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print what would be removed")
+	return cmd
+}
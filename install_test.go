@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestInstallFiles(t *testing.T) {
+	root := &cobra.Command{Use: "msi-gpu-switcher"}
+	root.AddCommand(&cobra.Command{Use: "status", Run: func(*cobra.Command, []string) {}})
+
+	files, err := installFiles(root, "/usr/local", "/opt/bin/msi-gpu-switcher")
+	if err != nil {
+		t.Fatalf("installFiles: %v", err)
+	}
+	byPath := map[string]string{}
+	for _, f := range files {
+		byPath[f.path] = string(f.data)
+		if f.path == "/usr/lib/systemd/system-sleep/msi-gpu-switcher" && f.mode != 0o755 {
+			t.Fatalf("sleep hook mode = %v", f.mode)
+		}
+	}
+	for _, want := range []string{
+		"/etc/dbus-1/system.d/io.github.ElXreno.MsiGpuSwitcher.conf",
+		"/usr/share/polkit-1/actions/io.github.ElXreno.MsiGpuSwitcher.policy",
+		"/usr/local/lib/systemd/system/msi-gpu-switcher.service",
+		"/usr/lib/systemd/system-sleep/msi-gpu-switcher",
+		"/usr/local/share/bash-completion/completions/msi-gpu-switcher",
+		"/usr/local/share/man/man8/msi-gpu-switcher-status.8",
+	} {
+		if _, ok := byPath[want]; !ok {
+			t.Fatalf("missing %s", want)
+		}
+	}
+	unit := byPath["/usr/local/lib/systemd/system/msi-gpu-switcher.service"]
+	if !strings.Contains(unit, "ExecStart=/opt/bin/msi-gpu-switcher daemon") {
+		t.Fatalf("unit does not use the binary path:\n%s", unit)
+	}
+	if policy := byPath["/usr/share/polkit-1/actions/io.github.ElXreno.MsiGpuSwitcher.policy"]; !strings.Contains(policy, ">/opt/bin/msi-gpu-switcher<") {
+		t.Fatalf("policy does not use the binary path:\n%s", policy)
+	}
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog"
+)
+
+// journalSocket is where journald takes native protocol datagrams.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journalWriter sends zerolog events to journald with their fields as
+// journal fields, so `journalctl MODE=discrete` or `BACKEND=ec` finds them.
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+func newJournalWriter() (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect journald failed: %w", err)
+	}
+	return &journalWriter{conn: conn}, nil
+}
+
+func (w *journalWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *journalWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg, err := journalMessage(level, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.conn.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journalPriority maps zerolog levels to syslog priorities.
+func journalPriority(level zerolog.Level) int {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel, zerolog.PanicLevel:
+		return 2
+	}
+	return 6
+}
+
+// journalMessage turns a zerolog JSON event into a native protocol datagram.
+func journalMessage(level zerolog.Level, event []byte) ([]byte, error) {
+	fields := map[string]any{}
+	d := json.NewDecoder(bytes.NewReader(event))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("decode log event: %w", err)
+	}
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(journalPriority(level)))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", "msi-gpu-switcher")
+	if msg, ok := fields[zerolog.MessageFieldName].(string); ok {
+		writeJournalField(&buf, "MESSAGE", msg)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		switch key {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := fields[key].(string)
+		if !ok {
+			raw, _ := json.Marshal(fields[key])
+			value = string(raw)
+		}
+		writeJournalField(&buf, journalFieldName(key), value)
+	}
+	return buf.Bytes(), nil
+}
+
+// journalFieldName upper-cases key and replaces what journald does not allow
+// in field names; a leading underscore would mark a trusted field.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	return strings.TrimLeft(name, "_0123456789")
+}
+
+// writeJournalField writes KEY=value, or the length-prefixed form journald
+// needs for values spanning several lines.
+func writeJournalField(buf *bytes.Buffer, key, value string) {
+	if key == "" {
+		return
+	}
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", key, value)
+		return
+	}
+	buf.WriteString(key + "\n")
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// stderrIsJournal reports whether systemd connected stderr to the journal,
+// in which case console output would show up there a second time.
+func stderrIsJournal() bool {
+	dev, ino, ok := strings.Cut(os.Getenv("JOURNAL_STREAM"), ":")
+	if !ok {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && strconv.FormatUint(uint64(st.Dev), 10) == dev && strconv.FormatUint(st.Ino, 10) == ino
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestJournalMessage(t *testing.T) {
+	event := []byte(`{"level":"info","mode":"discrete","backend":"ec","offset":46,"time":"2025-01-01T00:00:00Z","message":"Requested primary GPU: discrete"}`)
+	msg, err := journalMessage(zerolog.InfoLevel, event)
+	if err != nil {
+		t.Fatalf("journalMessage: %v", err)
+	}
+	want := "PRIORITY=6\nSYSLOG_IDENTIFIER=msi-gpu-switcher\nMESSAGE=Requested primary GPU: discrete\n" +
+		"BACKEND=ec\nMODE=discrete\nOFFSET=46\n"
+	if string(msg) != want {
+		t.Fatalf("journalMessage = %q, want %q", msg, want)
+	}
+
+	msg, err = journalMessage(zerolog.WarnLevel, []byte(`{"message":"line one\nline two","_secret":"x"}`))
+	if err != nil {
+		t.Fatalf("journalMessage: %v", err)
+	}
+	var multi bytes.Buffer
+	multi.WriteString("PRIORITY=4\nSYSLOG_IDENTIFIER=msi-gpu-switcher\nMESSAGE\n")
+	_ = binary.Write(&multi, binary.LittleEndian, uint64(len("line one\nline two")))
+	multi.WriteString("line one\nline two\nSECRET=x\n")
+	if !bytes.Equal(msg, multi.Bytes()) {
+		t.Fatalf("journalMessage = %q, want %q", msg, multi.Bytes())
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, want := range map[string]string{"mode": "MODE", "ec-offset": "EC_OFFSET", "_trusted": "TRUSTED", "9lives": "LIVES"} {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+var lockPath = "/run/msi-gpu-switcher.lock"
+
+// stateDir holds the switch history, the recorded BIOS and the other state
+// kept across runs.
+var stateDir = "/var/lib/msi-gpu-switcher"
+
+const lockPollInterval = 100 * time.Millisecond
+
+// acquireLock takes an exclusive flock on lockPath, waiting until ctx is done
+// for any other instance that currently holds it. The returned func releases
+// the lock.
+func acquireLock(ctx context.Context) (func(), error) {
+	f, err := openFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file failed: %w", err)
+	}
+	fd := int(f.Fd())
+	for waiting := false; ; waiting = true {
+		err := unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", lockPath, err)
+		}
+		if !waiting {
+			log.Info().Msg("waiting for another msi-gpu-switcher instance to finish...")
+		}
+		if err := sleepContext(ctx, lockPollInterval); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", lockPath, err)
+		}
+	}
+	log.Debug().Msgf("acquired lock %s", lockPath)
+	return registerCleanup(func() {
+		_ = unix.Flock(fd, unix.LOCK_UN)
+		_ = f.Close()
+	}), nil
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAcquireLockIsExclusive(t *testing.T) {
+	originalPath := lockPath
+	lockPath = filepath.Join(t.TempDir(), "test.lock")
+	t.Cleanup(func() { lockPath = originalPath })
+
+	release, err := acquireLock(context.Background())
+	if err != nil {
+		t.Fatalf("acquireLock: %v", err)
+	}
+
+	f, err := os.Open(lockPath)
+	if err != nil {
+		t.Fatalf("open lock: %v", err)
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err == nil {
+		t.Fatalf("expected lock to be held")
+	}
+
+	release()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		t.Fatalf("expected lock to be free after release: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// journalFields are the structured fields given to journald and JSON output;
+// the console messages already spell them out.
+var journalFields = []string{"mode", "backend", "offset", "value"}
+
+func consoleWriter(w io.Writer, color bool) zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{Out: w, NoColor: !color, FieldsExclude: journalFields}
+}
+
+// useColor reports whether console output to f gets colors: not with
+// --no-color, a non-empty NO_COLOR (https://no-color.org) or when f is not a
+// terminal, so logs captured in files or CI stay free of escape codes.
+func useColor(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+func init() {
+	log.Logger = log.Output(consoleWriter(os.Stderr, useColor(os.Stderr, false)))
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+}
+
+const (
+	logMaxSize  = 10 << 20
+	logMaxFiles = 3
+)
+
+type logOptions struct {
+	level   string
+	debug   bool
+	quiet   bool
+	verbose int
+	journal bool
+	file    string
+	format  string
+	noColor bool
+}
+
+func (o *logOptions) addFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&o.level, "log-level", "info", "log level ("+strings.Join(logLevels, ", ")+"); trace shows every EC access")
+	cmd.PersistentFlags().BoolVar(&o.debug, "debug", false, "same as --log-level debug")
+	_ = cmd.PersistentFlags().MarkDeprecated("debug", "use --log-level debug")
+	cmd.PersistentFlags().BoolVarP(&o.quiet, "quiet", "q", false, "log errors only, e.g. for cron jobs")
+	cmd.PersistentFlags().CountVarP(&o.verbose, "verbose", "v", "log at debug level; -vv logs at trace")
+	cmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	_ = cmd.RegisterFlagCompletionFunc("log-level", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return logLevels, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.PersistentFlags().BoolVar(&o.journal, "journal", false, "also log to journald, with mode, backend and offsets as journal fields")
+	cmd.PersistentFlags().StringVar(&o.file, "log-file", "", "also log to this file, rotated at 10 MiB with 3 old files kept")
+	cmd.PersistentFlags().StringVar(&o.format, "log-format", "console", "log format for stderr and --log-file (console, json)")
+	cmd.PersistentFlags().BoolVar(&o.noColor, "no-color", false, "disable colors in console log output (also NO_COLOR)")
+}
+
+// setLevel applies --log-level, or -q, -v or --debug when that was not
+// given.
+func (o *logOptions) setLevel(changed func(string) bool) error {
+	name := o.level
+	switch {
+	case changed("log-level"):
+	case o.quiet:
+		name = "error"
+	case o.verbose > 1:
+		name = "trace"
+	case o.verbose == 1, o.debug:
+		name = "debug"
+	}
+	return setLogLevel(name)
+}
+
+func (o *logOptions) applyConfig(changed func(string) bool, cfg config) {
+	if cfg.LogLevel != "" && !changed("log-level") {
+		o.level = cfg.LogLevel
+	}
+	if cfg.Journal && !changed("journal") {
+		o.journal = true
+	}
+	if cfg.LogFile != "" && !changed("log-file") {
+		o.file = cfg.LogFile
+	}
+	if cfg.LogFormat != "" && !changed("log-format") {
+		o.format = cfg.LogFormat
+	}
+	if cfg.NoColor && !changed("no-color") {
+		o.noColor = true
+	}
+}
+
+// journal and logFile are kept across config reloads.
+var (
+	journal *journalWriter
+	logFile *rotatingFile
+)
+
+// setup points the logger at stderr, the journal and the log file. Under
+// systemd, where stderr already goes to the journal, the journal replaces
+// stderr instead of repeating it.
+func (o logOptions) setup() error {
+	var jsonFormat bool
+	switch o.format {
+	case "console":
+	case "json":
+		jsonFormat = true
+	default:
+		return fmt.Errorf("unknown log format %q (expected console or json)", o.format)
+	}
+	format := func(w io.Writer) io.Writer {
+		if jsonFormat {
+			return w
+		}
+		return consoleWriter(w, w == os.Stderr && useColor(os.Stderr, o.noColor))
+	}
+
+	var writers []io.Writer
+	if o.journal && journal == nil {
+		w, err := newJournalWriter()
+		if err != nil {
+			log.Warn().Msgf("%v; not logging to the journal", err)
+		}
+		journal = w
+	}
+	if o.journal && journal != nil {
+		writers = append(writers, journal)
+	}
+	if len(writers) == 0 || !stderrIsJournal() {
+		writers = append(writers, format(os.Stderr))
+	}
+	if o.file != "" {
+		if logFile == nil || logFile.path != o.file {
+			f, err := openRotatingFile(o.file, logMaxSize, logMaxFiles)
+			if err != nil {
+				return fmt.Errorf("open log file failed: %w", err)
+			}
+			if logFile != nil {
+				logFile.Close()
+			}
+			logFile = f
+		}
+		writers = append(writers, format(logFile))
+	} else if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	log.Logger = log.Output(zerolog.MultiLevelWriter(writers...))
+	return nil
+}
+
+// rotatingFile is an append-only log file that is renamed to path.1 (and
+// path.1 to path.2 and so on) once it would grow past maxSize.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64
+	maxSize int64
+	keep    int
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	for i := r.keep - 1; i > 0; i-- {
+		_ = os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	if r.keep > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
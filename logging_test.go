@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "msi-gpu-switcher.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer r.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for name, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		raw, err := os.ReadFile(path + name)
+		if err != nil || string(raw) != want {
+			t.Fatalf("%s%s = %q (%v), want %q", filepath.Base(path), name, raw, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 old files, stat .3: %v", err)
+	}
+}
+
+func TestLogSetupJSONFile(t *testing.T) {
+	original := log.Logger
+	t.Cleanup(func() {
+		log.Logger = original
+		if logFile != nil {
+			logFile.Close()
+			logFile = nil
+		}
+	})
+
+	path := filepath.Join(t.TempDir(), "switcher.log")
+	if err := (logOptions{format: "yaml"}).setup(); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+	if err := (logOptions{format: "json", file: path}).setup(); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	log.Info().Str("mode", "discrete").Msg("switched")
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(raw))), &event); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", raw, err)
+	}
+	if event["message"] != "switched" || event["mode"] != "discrete" || event["level"] != "info" {
+		t.Fatalf("unexpected event %v", event)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	original := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(original) })
+	none := func(string) bool { return false }
+
+	for _, tc := range []struct {
+		opts logOptions
+		want zerolog.Level
+	}{
+		{logOptions{level: "trace"}, zerolog.TraceLevel},
+		{logOptions{level: "warn"}, zerolog.WarnLevel},
+		{logOptions{level: "info", debug: true}, zerolog.DebugLevel},
+		{logOptions{level: "info", quiet: true}, zerolog.ErrorLevel},
+		{logOptions{level: "info", verbose: 1}, zerolog.DebugLevel},
+		{logOptions{level: "warn", verbose: 2}, zerolog.TraceLevel},
+	} {
+		if err := tc.opts.setLevel(none); err != nil {
+			t.Fatalf("setLevel(%+v): %v", tc.opts, err)
+		}
+		if got := zerolog.GlobalLevel(); got != tc.want {
+			t.Fatalf("setLevel(%+v) = %s, want %s", tc.opts, got, tc.want)
+		}
+	}
+	// An explicit --log-level beats -v and --debug.
+	o := logOptions{level: "error", debug: true, verbose: 1}
+	if err := o.setLevel(func(name string) bool { return name == "log-level" }); err != nil || zerolog.GlobalLevel() != zerolog.ErrorLevel {
+		t.Fatalf("expected --log-level to win over -v and --debug, got %s (%v)", zerolog.GlobalLevel(), err)
+	}
+	if err := (&logOptions{level: "panic"}).setLevel(none); err == nil {
+		t.Fatal("expected levels outside the list to be rejected")
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	// A pipe is never a terminal.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	t.Setenv("NO_COLOR", "")
+	if useColor(w, false) {
+		t.Fatal("expected no colors on a pipe")
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("no terminal: %v", err)
+	}
+	defer tty.Close()
+	if !useColor(tty, false) {
+		t.Fatal("expected colors on a terminal")
+	}
+	if useColor(tty, true) {
+		t.Fatal("expected --no-color to disable colors")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if useColor(tty, false) {
+		t.Fatal("expected NO_COLOR to disable colors")
+	}
+}
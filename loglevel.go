@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// logLevels are the names --log-level takes; trace adds every EC byte
+// access to debug.
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+// setLogLevel sets the global log level from one of logLevels.
+func setLogLevel(name string) error {
+	if !slices.Contains(logLevels, name) {
+		return fmt.Errorf("unknown log level %q (expected one of %s)", name, strings.Join(logLevels, ", "))
+	}
+	level, err := zerolog.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
+}
@@ -0,0 +1,232 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// MAC denials end up in auditd's log or, without auditd, in the kernel log.
+var (
+	auditLogPath = "/var/log/audit/audit.log"
+	kmsgPath     = "/dev/kmsg"
+)
+
+const (
+	// macDenialWindow is how far back doctor looks for denials.
+	macDenialWindow = 7 * 24 * time.Hour
+	// auditLogTail is how much of the end of audit.log is read.
+	auditLogTail = 4 << 20
+	// macComm is our comm as the audit records log it, cut to
+	// TASK_COMM_LEN-1 bytes.
+	macComm = "msi-gpu-switche"
+)
+
+var (
+	auditField   = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+	auditTime    = regexp.MustCompile(`audit\((\d+)\.\d+:\d+\)`)
+	selinuxPerms = regexp.MustCompile(`avc:\s+denied\s+\{ ([^}]*) \}`)
+)
+
+// macDenial is an SELinux AVC or AppArmor denial of our access to debugfs,
+// where the EC node is, or efivarfs.
+type macDenial struct {
+	lsm   string // "selinux" or "apparmor"
+	fs    string // "debugfs" or "efivarfs"
+	name  string
+	perms []string
+	// SELinux only: the source and target types and the object class.
+	source, target, class string
+	// AppArmor only.
+	profile string
+}
+
+// parseMACDenial decodes one audit record, as auditd writes it or the
+// kernel logs it, and reports whether it is one of our denials on debugfs or
+// efivarfs since since.
+func parseMACDenial(line string, since time.Time) (macDenial, bool) {
+	if m := auditTime.FindStringSubmatch(line); m != nil {
+		if sec, err := strconv.ParseInt(m[1], 10, 64); err == nil && time.Unix(sec, 0).Before(since) {
+			return macDenial{}, false
+		}
+	}
+	fields := map[string]string{}
+	for _, m := range auditField.FindAllStringSubmatch(line, -1) {
+		fields[m[1]] = strings.Trim(m[2], `"`)
+	}
+	if fields["comm"] != macComm {
+		return macDenial{}, false
+	}
+	d := macDenial{name: fields["name"]}
+	switch {
+	case fields["apparmor"] == "DENIED":
+		d.lsm, d.profile = "apparmor", fields["profile"]
+		switch {
+		case strings.HasPrefix(d.name, "/sys/kernel/debug/"):
+			d.fs = "debugfs"
+		case strings.HasPrefix(d.name, efivarsDir+"/"):
+			d.fs = "efivarfs"
+		}
+		for _, c := range fields["requested_mask"] {
+			perm := string(c)
+			switch c {
+			case 'a', 'c', 'd':
+				perm = "w"
+			case 'r', 'w', 'k':
+			default:
+				continue
+			}
+			if !slices.Contains(d.perms, perm) {
+				d.perms = append(d.perms, perm)
+			}
+		}
+		slices.SortFunc(d.perms, func(a, b string) int { return strings.Index("rwk", a) - strings.Index("rwk", b) })
+	case selinuxPerms.MatchString(line):
+		d.lsm = "selinux"
+		d.perms = strings.Fields(selinuxPerms.FindStringSubmatch(line)[1])
+		d.source, d.target, d.class = contextType(fields["scontext"]), contextType(fields["tcontext"]), fields["tclass"]
+		switch {
+		case fields["dev"] == "debugfs" || d.target == "debugfs_t":
+			d.fs = "debugfs"
+		case fields["dev"] == "efivarfs" || d.target == "efivarfs_t":
+			d.fs = "efivarfs"
+		}
+	}
+	return d, d.lsm != "" && d.fs != ""
+}
+
+// contextType is the type of an SELinux context, user:role:type:level.
+func contextType(context string) string {
+	parts := strings.Split(context, ":")
+	if len(parts) < 3 {
+		return context
+	}
+	return parts[2]
+}
+
+// policy is the rule that would allow the denied access.
+func (d macDenial) policy() string {
+	if d.lsm == "selinux" {
+		return fmt.Sprintf("allow %s %s:%s { %s };", d.source, d.target, d.class, strings.Join(d.perms, " "))
+	}
+	return fmt.Sprintf("%s %s,  # in profile %s", d.name, strings.Join(d.perms, ""), d.profile)
+}
+
+func (d macDenial) String() string {
+	return fmt.Sprintf("%s denied %s on %s %s", d.lsm, strings.Join(d.perms, ","), d.fs, d.name)
+}
+
+// readMACDenials collects our denials since since from whichever logs are
+// readable, one per policy rule. It fails only when no log could be read.
+func readMACDenials(since time.Time) ([]macDenial, error) {
+	var lines []string
+	var errs []error
+	for _, read := range []func() ([]string, error){readAuditLogTail, readKmsg} {
+		l, err := read()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lines = append(lines, l...)
+	}
+	if len(errs) == 2 {
+		return nil, errors.Join(errs...)
+	}
+	var denials []macDenial
+	for _, line := range lines {
+		d, ok := parseMACDenial(line, since)
+		if ok && !slices.ContainsFunc(denials, func(o macDenial) bool { return o.policy() == d.policy() }) {
+			denials = append(denials, d)
+		}
+	}
+	return denials, nil
+}
+
+func readAuditLogTail() ([]string, error) {
+	f, err := os.Open(auditLogPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if st, err := f.Stat(); err == nil && st.Size() > auditLogTail {
+		if _, err := f.Seek(-auditLogTail, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(raw), "\n"), nil
+}
+
+// readKmsg reads the kernel log records still in the ring buffer. The fd is
+// used directly, since the runtime poller would wait for new records at the
+// end.
+func readKmsg() ([]string, error) {
+	fd, err := unix.Open(kmsgPath, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: kmsgPath, Err: err}
+	}
+	defer unix.Close(fd)
+	var raw []byte
+	buf := make([]byte, 8192)
+	for {
+		n, err := unix.Read(fd, buf)
+		if errors.Is(err, unix.EPIPE) {
+			// The record was overwritten while reading; go on with the next.
+			continue
+		}
+		if errors.Is(err, unix.EAGAIN) || err == nil && n == 0 {
+			break
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "read", Path: kmsgPath, Err: err}
+		}
+		raw = append(raw, buf[:n]...)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		// Records are "prio,seq,time,flags;message"; continuation lines,
+		// which start with a space, only carry device properties.
+		if _, msg, ok := strings.Cut(line, ";"); ok && !strings.HasPrefix(line, " ") {
+			lines = append(lines, msg)
+		}
+	}
+	return lines, nil
+}
+
+// checkMACDenials is doctor's check for SELinux and AppArmor blocking the
+// EC node or the UEFI variable, a common cause of writes failing for root.
+func checkMACDenials() (string, error) {
+	denials, err := readMACDenials(time.Now().Add(-macDenialWindow))
+	if err != nil {
+		return "audit and kernel logs not readable (run doctor as root)", nil
+	}
+	if len(denials) == 0 {
+		return "no SELinux or AppArmor denials in the last week", nil
+	}
+	var summary []string
+	hints := []string{"allow the access, e.g. with:"}
+	for _, d := range denials {
+		summary = append(summary, d.String())
+		hints = append(hints, "  "+d.policy())
+	}
+	if slices.ContainsFunc(denials, func(d macDenial) bool { return d.lsm == "selinux" }) {
+		hints = append(hints, "or build a module from the log: ausearch -m AVC -c "+macComm+" | audit2allow -M msi-gpu-switcher")
+	}
+	if slices.ContainsFunc(denials, func(d macDenial) bool { return d.lsm == "apparmor" }) {
+		hints = append(hints, "AppArmor rules go in the profile's file under /etc/apparmor.d/local; reload it with apparmor_parser -r")
+	}
+	return "", &hintError{err: errors.New(strings.Join(summary, "; ")), hints: hints}
+}
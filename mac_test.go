@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func selinuxDenial(ts int64, comm string) string {
+	return fmt.Sprintf(`type=AVC msg=audit(%d.120:311): avc:  denied  { write open } for  pid=2211 comm="%s" name="io" dev="debugfs" ino=3047 scontext=system_u:system_r:unconfined_service_t:s0 tcontext=system_u:object_r:debugfs_t:s0 tclass=file permissive=0`, ts, comm)
+}
+
+func apparmorDenial(ts int64) string {
+	return fmt.Sprintf(`audit: type=1400 audit(%d.551:87): apparmor="DENIED" operation="open" class="file" profile="/usr/bin/msi-gpu-switcher" name="/sys/firmware/efi/efivars/MsiDCVarData-DD96BAAF-145E-4F56-B1CF-193256298E99" pid=2230 comm="msi-gpu-switche" requested_mask="wc" denied_mask="wc" fsuid=0 ouid=0`, ts)
+}
+
+func TestParseMACDenial(t *testing.T) {
+	now := time.Now()
+	since := now.Add(-time.Hour)
+
+	d, ok := parseMACDenial(selinuxDenial(now.Unix(), macComm), since)
+	if !ok || d.lsm != "selinux" || d.fs != "debugfs" {
+		t.Fatalf("SELinux denial = %+v %v", d, ok)
+	}
+	if want := "allow unconfined_service_t debugfs_t:file { write open };"; d.policy() != want {
+		t.Fatalf("policy = %q, want %q", d.policy(), want)
+	}
+	d, ok = parseMACDenial(apparmorDenial(now.Unix()), since)
+	if !ok || d.lsm != "apparmor" || d.fs != "efivarfs" {
+		t.Fatalf("AppArmor denial = %+v %v", d, ok)
+	}
+	if !strings.HasPrefix(d.policy(), "/sys/firmware/efi/efivars/MsiDCVarData-DD96BAAF-145E-4F56-B1CF-193256298E99 w,") {
+		t.Fatalf("policy = %q", d.policy())
+	}
+
+	for name, line := range map[string]string{
+		"other process": selinuxDenial(now.Unix(), "Xorg"),
+		"too old":       selinuxDenial(since.Add(-time.Minute).Unix(), macComm),
+		"other fs":      strings.ReplaceAll(strings.ReplaceAll(selinuxDenial(now.Unix(), macComm), "debugfs_t", "sysfs_t"), `dev="debugfs"`, `dev="sysfs"`),
+		"granted":       `type=AVC msg=audit(1.0:1): avc:  granted  { setenforce } for comm="msi-gpu-switche"`,
+	} {
+		if d, ok := parseMACDenial(line, since); ok {
+			t.Fatalf("%s: unexpected denial %+v", name, d)
+		}
+	}
+}
+
+func TestCheckMACDenials(t *testing.T) {
+	dir := t.TempDir()
+	originalAudit, originalKmsg := auditLogPath, kmsgPath
+	auditLogPath, kmsgPath = filepath.Join(dir, "audit.log"), filepath.Join(dir, "kmsg")
+	t.Cleanup(func() { auditLogPath, kmsgPath = originalAudit, originalKmsg })
+
+	if detail, err := checkMACDenials(); err != nil || !strings.Contains(detail, "not readable") {
+		t.Fatalf("without logs: %q %v", detail, err)
+	}
+
+	now := time.Now().Unix()
+	audit := selinuxDenial(now, macComm) + "\n" + selinuxDenial(now, macComm) + "\n"
+	kmsg := "6,1,100,-;" + apparmorDenial(now) + "\n SUBSYSTEM=audit\n4,2,200,-;unrelated\n"
+	for path, content := range map[string]string{auditLogPath: audit, kmsgPath: kmsg} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := checkMACDenials()
+	var he *hintError
+	if !errors.As(err, &he) {
+		t.Fatalf("expected denials to be reported, got %v", err)
+	}
+	// One rule per denial, plus the heading and a line for each LSM.
+	if len(he.hints) != 5 || !strings.Contains(err.Error(), "selinux denied write,open on debugfs io") {
+		t.Fatalf("unexpected report %q %q", err, he.hints)
+	}
+
+	if err := os.WriteFile(auditLogPath, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(kmsgPath)
+	if detail, err := checkMACDenials(); err != nil || !strings.Contains(detail, "no SELinux or AppArmor denials") {
+		t.Fatalf("without denials: %q %v", detail, err)
+	}
+}
@@ -1,92 +1,260 @@
+//go:build linux
+
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
-	"golang.org/x/sys/unix"
-)
-
-// EC
-const (
-	ecIOPath       = "/sys/kernel/debug/ec/ec0/io"
-	ecMuxOffset    = 0x2e
-	ecMuxMask      = 0x40
-	ecSwitchOffset = 0xd1
-	ecSwitchMask0  = 0x01
-	ecSwitchMask1  = 0x02
-)
-
-// UEFI
-const (
-	uefiVarName  = "MsiDCVarData"
-	uefiVarGuid  = "DD96BAAF-145E-4F56-B1CF-193256298E99"
-	uefiDataBase = 4
-	uefiModeByte = 1
 )
 
-// UEFI too
-var uefiVarPath = "/sys/firmware/efi/efivars/" + uefiVarName + "-" + uefiVarGuid
-
 type gpuInfo struct {
 	addr, class, vendor, device, driver string
+	// bootVGA marks the GPU the firmware handed the display to at boot.
+	bootVGA bool
+	// discrete separates a dGPU from the iGPU, see isDiscreteGPU.
+	discrete bool
+	// external marks an eGPU behind Thunderbolt/USB4. It is never treated
+	// as the dGPU the MUX switches to.
+	external bool
 }
 
+var pciRoot = "/sys/bus/pci/devices"
+
+const (
+	nvidiaVendor = "0x10de"
+	amdVendor    = "0x1002"
+)
+
 func main() {
-	if err := rootCmd().Execute(); err != nil {
+	handleSignals()
+	err := rootCmd().ExecuteContext(context.Background())
+	// Whatever is still registered, such as the --replay scratch copy.
+	runCleanups()
+	if ranAsRoot {
+		recordAudit(cliActor(), os.Args[1:], err)
+	}
+	if err != nil {
 		fatal(err)
 	}
 }
 
-func gpuLabel(discrete bool) string {
-	if discrete {
-		return "dGPU (discrete)"
+func statusCmd() *cobra.Command {
+	var (
+		asJSON   bool
+		watch    bool
+		interval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show current GPU/MUX/UEFI status",
+		Long: "Show the current GPU, MUX and UEFI state. With --verbose (-v) status also\n" +
+			"prints the raw EC bytes and a hexdump of the UEFI variable, which is what\n" +
+			"bug reports need.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if asJSON {
+				return printJSON(collectStatus(cmd.Context()))
+			}
+			verbose, _ := cmd.Flags().GetCount("verbose")
+			if watch {
+				if interval <= 0 {
+					return errors.New("--interval must be positive")
+				}
+				return watchStatus(cmd.Context(), interval, verbose > 0)
+			}
+			return showStatus(cmd.Context(), verbose > 0)
+		},
 	}
-	return "iGPU (hybrid)"
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON to stdout (see the schema command)")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "redraw every --interval, and on mode changes when the daemon serves D-Bus")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "refresh interval with --watch")
+	cmd.MarkFlagsMutuallyExclusive("json", "watch")
+	return cmd
 }
 
-func showStatus() error {
+// watchStatus redraws the status until ctx is done: every interval, and
+// right away when the daemon signals that the mode changed.
+func watchStatus(ctx context.Context, interval time.Duration, verbose bool) error {
+	changes, err := dbusChanges(ctx)
+	if err != nil {
+		log.Debug().Msgf("not watching D-Bus: %v", err)
+	}
+	for {
+		fmt.Fprint(stdout, clearScreen)
+		outf("msi-gpu-switcher status  %s  every %s", time.Now().Format("15:04:05"), interval)
+		outln("")
+		if err := showStatus(ctx, verbose); err != nil {
+			return err
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-changes:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// showStatus prints the status report; verbose adds the raw firmware data
+// behind it.
+func showStatus(ctx context.Context, verbose bool) error {
+	var ec *ecSession
+	if exists(ecIOPath) {
+		var err error
+		if ec, err = openEC(); err != nil {
+			log.Debug().Msgf("open ec failed: %v", err)
+		} else {
+			defer ec.Close()
+		}
+	}
+	s := readBarState(ctx)
+	if s.pending == "" && ec != nil && !exists(uefiVarPath) {
+		// Without the UEFI variable the EC MUX alone picks the next boot.
+		if discrete, err := ec.readMuxState(ctx); err == nil {
+			s.pending = muxLabel(discrete)
+		}
+	}
+	outln(s.summary())
+	outln("")
+
+	printModel()
 	printGpuDevices()
-	printEcMux()
-	printEcSwitch()
-	printUefiVar()
+	printDisplays()
+	printActiveMode()
+	printPanelOwner()
+	printRenderer(ctx)
+
+	printEcDevices()
+	printEcMux(ctx, ec, verbose)
+	printEcSwitch(ctx, ec, verbose)
+	printUefiVar(ctx, verbose)
+	warnDualBoot(ctx)
+	printOnce()
+	printRecommendation()
 	return nil
 }
 
+// modelInfo returns the model, its BIOS version and, for models with tested
+// BIOS versions, whether this one is among them.
+func modelInfo() (string, string, *bool) {
+	q, known := detectQuirk()
+	name := q.name
+	if !known {
+		name = fmt.Sprintf("unknown (board %q)", readDMI("board_name"))
+	}
+	version := readDMI("bios_version")
+	if !known || len(q.testedBios) == 0 {
+		return name, version, nil
+	}
+	tested := q.biosTested(version)
+	return name, version, &tested
+}
+
+func printModel() {
+	outln("Model:")
+	name, version, tested := modelInfo()
+	suffix := ""
+	switch {
+	case tested == nil:
+	case *tested:
+		suffix = ", tested"
+	default:
+		suffix = ", untested"
+	}
+	outf("  %s, BIOS %s%s", name, version, suffix)
+}
+
 func printGpuDevices() {
-	log.Info().Msg("GPU devices:")
+	outln("")
+	outln("GPU devices:")
 	gpus, err := listGPUs()
 	if err != nil {
 		log.Error().Msgf("  error: %v", err)
 		return
 	}
 	if len(gpus) == 0 {
-		log.Info().Msg("  (none found)")
+		outln("  (none found)")
 		return
 	}
 	for _, g := range gpus {
-		log.Info().Msgf("  %s class=%s vendor=%s device=%s driver=%s",
-			g.addr, g.class, g.vendor, g.device, g.driver)
+		line := fmt.Sprintf("  %s %s (%s, driver %s)", g.addr, pciName(g.vendor, g.device), gpuRole(g.discrete, g.external), g.driver)
+		dir := filepath.Join(pciRoot, g.addr)
+		if link := readPCIeLink(dir); link != nil {
+			line += ", link " + link.String()
+		}
+		if sensors := readGpuSensors(dir); sensors != nil {
+			line += ", " + sensors.String()
+		}
+		outln(line)
+		if n := readNvidiaDetails(g.addr); n != nil {
+			outf("    nvidia %s, runtime D3 %s, persistence %v", orUnknown(n.DriverVersion), orUnknown(n.RuntimeD3), n.Persistence)
+		}
+	}
+}
+
+// printActiveMode shows the mode in effect this boot, as opposed to what the
+// EC and UEFI sections below request for the next one.
+func printActiveMode() {
+	outln("")
+	outln("Active this boot:")
+	mode, source, err := activeModeSource()
+	if err != nil {
+		outf("  unknown (%v)", err)
+		return
 	}
+	outf("  %s (%s, from %s)", mode, mode.primary(), source)
 }
 
-func printEcMux() {
-	log.Info().Msg("")
-	log.Info().Msg("EC MUX:")
+func printEcDevices() {
+	outln("")
+	outln("EC devices:")
+	indexes, err := listECs()
+	if err != nil {
+		log.Error().Msgf("  error: %v", err)
+		return
+	}
+	if len(indexes) == 0 {
+		outln("  (none found)")
+		return
+	}
+	for _, i := range indexes {
+		suffix := ""
+		if ecPath(i) == ecIOPath {
+			suffix = " (selected)"
+		}
+		outf("  ec%d%s", i, suffix)
+	}
+}
+
+func printEcMux(ctx context.Context, ec *ecSession, verbose bool) {
+	outln("")
+	outln("EC MUX (requested for the next boot):")
+	if ec == nil && useHelper() {
+		state, err := helperCall(ctx, "mux")
+		if err != nil {
+			log.Error().Msgf("  error: %v", err)
+			return
+		}
+		outf("  %s (via helper)", state)
+		return
+	}
 	if !exists(ecIOPath) {
-		log.Info().Msg("  not available (ec_sys/debugfs)")
+		outln("  not available (ec_sys/debugfs)")
 		return
 	}
-	state, err := readEcMuxState()
+	state, err := ec.readMuxState(ctx)
 	if err != nil {
 		log.Error().Msgf("  error: %v", err)
 		return
@@ -95,77 +263,78 @@ func printEcMux() {
 	if state {
 		label = "discrete (PXCT=1)"
 	}
-	log.Info().Msgf("  %s", label)
+	outf("  %s", label)
+	if verbose {
+		if value, err := ec.readByte(ctx, ecMuxOffset); err == nil {
+			outf("  raw [0x%02x]=0x%02x (mask 0x%02x)", ecMuxOffset, value, ecMuxMask)
+		}
+	}
+	warnPanelMismatch(state)
 }
 
-func printEcSwitch() {
-	log.Info().Msg("")
-	log.Info().Msg("EC switch trigger:")
+func printEcSwitch(ctx context.Context, ec *ecSession, verbose bool) {
+	outln("")
+	outln("EC switch trigger:")
 	if !exists(ecIOPath) {
-		log.Info().Msg("  not available (ec_sys/debugfs)")
+		outln("  not available (ec_sys/debugfs)")
 		return
 	}
-	value, err := readEcByte(ecSwitchOffset)
+	value, err := ec.readByte(ctx, ecSwitchOffset)
 	if err != nil {
 		log.Error().Msgf("  error: %v", err)
 		return
 	}
-	log.Info().Msgf("  0x%02x (bits0/1=%d%d)", value, (value&ecSwitchMask1)>>1, value&ecSwitchMask0)
+	outf("  0x%02x (bits0/1=%d%d)", value, (value&ecSwitchMask1)>>1, value&ecSwitchMask0)
+	if verbose {
+		outf("  raw [0x%02x]=0x%02x (masks 0x%02x, 0x%02x)", ecSwitchOffset, value, ecSwitchMask0, ecSwitchMask1)
+	}
 }
 
-func printUefiVar() {
-	log.Info().Msg("")
-	log.Info().Msg("UEFI var:")
+func printUefiVar(ctx context.Context, verbose bool) {
+	outln("")
+	outln("UEFI var (requested for the next boot):")
 	if !exists(uefiVarPath) {
-		log.Info().Msg("  not available (efivarfs)")
+		outln("  not available (efivarfs)")
 		return
 	}
-	state, err := readUefiGpuMode()
+	attrs, data, err := readUefiVar(ctx)
 	if err != nil {
 		log.Error().Msgf("  error: %v", err)
 		return
 	}
-	label := "hybrid (byte[1]=0)"
-	if state {
-		label = "discrete (byte[1]=1)"
-	}
-	log.Info().Msgf("  %s", label)
-}
-
-func switchGPU(discrete bool) error {
-	label := gpuLabel(discrete)
-	uefiSet := false
-
-	if exists(uefiVarPath) {
-		if err := setUefiGpuMode(discrete); err != nil {
-			return err
+	v, parseErr := parseMsiDC(data)
+	if parseErr == nil {
+		mode, ok := modeFromByte(v.modeByte())
+		name := mode.String()
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", v.modeByte())
 		}
-		log.Info().Msgf("UEFI target set: %s", label)
-		uefiSet = true
+		outf("  %s (%s byte[%d]=%d)", name, uefiVarName, v.offset, v.modeByte())
 	}
-
-	if exists(ecIOPath) {
-		if uefiSet {
-			if err := triggerEcSwitch(); err != nil {
-				log.Warn().Msgf("EC switch trigger failed: %v (is ec_sys write_support=1?)", err)
-			}
-		}
-		if err := setEcMux(discrete); err != nil {
-			if uefiSet {
-				log.Warn().Msgf("EC MUX write failed: %v (is ec_sys write_support=1?)", err)
-				return nil
-			}
-			return err
+	outf("  %d bytes, attrs 0x%08x (%s)", len(data), attrs, efiAttrString(attrs))
+	if immutable, err := isImmutable(uefiVarPath); err == nil {
+		outf("  immutable: %v", immutable)
+	}
+	if parseErr != nil {
+		log.Warn().Msgf("  %v", parseErr)
+	} else {
+		outf("  layout: %s (v%d)", v.layout.name, v.layout.version)
+	}
+	_, warnings := decodeMsiDC(attrs, data)
+	for _, w := range warnings {
+		log.Warn().Msgf("  %s", w)
+	}
+	if verbose {
+		outf("  path: %s", uefiVarPath)
+		outf("  attrs: %s", strings.Join(efiAttrNames(attrs), ", "))
+		for _, line := range hexdump(0, data) {
+			outln("  " + line)
 		}
-		log.Info().Msgf("Requested primary GPU: %s (EC MUX)", label)
-		return nil
 	}
-
-	return errors.New("EC MUX is not available; cannot switch without ec_sys/debugfs")
 }
 
 func listGPUs() ([]gpuInfo, error) {
-	entries, err := filepath.Glob("/sys/bus/pci/devices/*")
+	entries, err := hostfs.Glob(filepath.Join(pciRoot, "*"))
 	if err != nil {
 		return nil, err
 	}
@@ -179,263 +348,462 @@ func listGPUs() ([]gpuInfo, error) {
 			continue
 		}
 		gpus = append(gpus, gpuInfo{
-			addr:   filepath.Base(entry),
-			class:  class,
-			vendor: strings.TrimSpace(readFirstLine(filepath.Join(entry, "vendor"))),
-			device: strings.TrimSpace(readFirstLine(filepath.Join(entry, "device"))),
-			driver: readDriver(entry),
+			addr:     filepath.Base(entry),
+			class:    class,
+			vendor:   strings.TrimSpace(readFirstLine(filepath.Join(entry, "vendor"))),
+			device:   strings.TrimSpace(readFirstLine(filepath.Join(entry, "device"))),
+			driver:   readDriver(entry),
+			bootVGA:  readFirstLine(filepath.Join(entry, "boot_vga")) == "1",
+			discrete: isDiscreteGPU(entry),
+			external: isExternalGPU(entry),
 		})
 	}
 	return gpus, nil
 }
 
-func readDriver(devPath string) string {
-	target, err := os.Readlink(filepath.Join(devPath, "driver"))
-	if err != nil {
-		return "unknown"
+// isDiscreteGPU reports whether the PCI device at dir is a discrete GPU.
+// NVIDIA GPUs in MSI laptops always are. All-AMD models such as the Delta
+// have two amdgpu devices; amdgpu only exposes pp_dpm_pcie on dGPUs, since an
+// APU has no PCIe link to manage. An AMD GPU without amdgpu bound is taken to
+// be the dGPU unless the firmware booted on it. eGPUs are never the dGPU.
+func isDiscreteGPU(dir string) bool {
+	if isExternalGPU(dir) {
+		return false
+	}
+	switch strings.TrimSpace(readFirstLine(filepath.Join(dir, "vendor"))) {
+	case nvidiaVendor:
+		return true
+	case amdVendor:
+		if readDriver(dir) == "amdgpu" {
+			return exists(filepath.Join(dir, "pp_dpm_pcie"))
+		}
+		return readFirstLine(filepath.Join(dir, "boot_vga")) != "1"
 	}
-	return filepath.Base(target)
+	return false
 }
 
-func readFirstLine(path string) string {
-	f, err := os.Open(path)
+// isExternalGPU reports whether the device at dir, or a bridge above it,
+// sits behind an external-facing port. The kernel marks everything below
+// Thunderbolt and USB4 ports as removable.
+func isExternalGPU(dir string) bool {
+	path, err := hostfs.EvalSymlinks(dir)
 	if err != nil {
-		return ""
+		path = dir
 	}
-	defer f.Close()
-	line, _ := bufio.NewReader(f).ReadString('\n')
-	return strings.TrimSpace(line)
-}
-
-func exists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	for ; strings.Contains(filepath.Base(path), ":"); path = filepath.Dir(path) {
+		if readFirstLine(filepath.Join(path, "removable")) == "removable" {
+			return true
+		}
+	}
+	return false
 }
 
-func requireRoot() {
-	if os.Geteuid() != 0 {
-		fatal(errors.New("this command requires root"))
+func gpuRole(discrete, external bool) string {
+	switch {
+	case external:
+		return "eGPU"
+	case discrete:
+		return "discrete"
 	}
+	return "integrated"
 }
 
-func fatal(err error) {
-	log.Error().Msgf("error: %v", err)
-	os.Exit(1)
+// activeMode infers the mode the firmware booted with. Unlike the UEFI
+// variable and the EC MUX, which request the mode for the next boot, it does
+// not change until reboot.
+func activeMode() (gpuMode, error) {
+	mode, _, err := activeModeSource()
+	return mode, err
 }
 
-func readEcMuxState() (bool, error) {
-	value, err := readEcByte(ecMuxOffset)
+// activeModeSource is activeMode together with what it was read from:
+// "drm" when a GPU's internal panel connector is connected, else "boot_vga"
+// for the GPU the firmware initialized. A hidden discrete GPU means
+// integrated-only ("pci").
+func activeModeSource() (gpuMode, string, error) {
+	gpus, err := listGPUs()
 	if err != nil {
-		return false, err
+		return 0, "", err
 	}
-	return (value & ecMuxMask) != 0, nil
-}
-
-func setEcMux(discrete bool) error {
-	value, err := readEcByte(ecMuxOffset)
-	if err != nil {
-		return err
+	if len(gpus) == 0 {
+		return 0, "", errors.New("no GPUs found")
 	}
-	log.Debug().Msgf("ec mux before: 0x%02x", value)
-	if discrete {
-		value |= ecMuxMask
-	} else {
-		value &^= ecMuxMask
+	discrete := false
+	for _, g := range gpus {
+		discrete = discrete || g.discrete
+	}
+	if !discrete {
+		if !triStateModes {
+			return 0, "", errors.New("no discrete GPU found")
+		}
+		return modeIntegrated, "pci", nil
+	}
+	if owner, err := detectPanelOwner(); err == nil {
+		for _, g := range gpus {
+			if g.addr == owner.GPU {
+				if owner.Discrete {
+					return modeDiscrete, owner.Source, nil
+				}
+				return modeHybrid, owner.Source, nil
+			}
+		}
 	}
-	log.Debug().Msgf("ec mux after: 0x%02x", value)
-	return writeEcByte(ecMuxOffset, value)
+	return modeHybrid, "boot_vga", nil
 }
 
-func readEcByte(offset int) (byte, error) {
-	f, err := os.Open(ecIOPath)
+func readDriver(devPath string) string {
+	target, err := hostfs.Readlink(filepath.Join(devPath, "driver"))
 	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-	buf := []byte{0}
-	if _, err := f.ReadAt(buf, int64(offset)); err != nil {
-		return 0, err
+		return "unknown"
 	}
-	log.Debug().Msgf("ec read [0x%02x]=0x%02x", offset, buf[0])
-	return buf[0], nil
+	return filepath.Base(target)
 }
 
-func writeEcByte(offset int, value byte) error {
-	f, err := os.OpenFile(ecIOPath, os.O_RDWR, 0)
+func readFirstLine(path string) string {
+	f, err := hostfs.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
-		return err
+		return ""
 	}
 	defer f.Close()
-	log.Debug().Msgf("ec write [0x%02x]=0x%02x", offset, value)
-	_, err = f.WriteAt([]byte{value}, int64(offset))
-	return err
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.TrimSpace(line)
 }
 
-func readUefiGpuMode() (bool, error) {
-	_, data, err := readUefiVar()
-	if err != nil {
-		return false, err
-	}
-	if len(data) <= uefiModeByte {
-		return false, fmt.Errorf("uefi var too small: %d bytes", len(data))
-	}
-	return data[uefiModeByte] == 1, nil
+func exists(path string) bool {
+	_, err := hostfs.Stat(path)
+	return err == nil
 }
 
-func setUefiGpuMode(discrete bool) error {
-	attrs, data, err := readUefiVar()
-	if err != nil {
+// runWithTimeout runs fn and gives up after timeout or when ctx is done.
+// Blocking sysfs/debugfs I/O cannot be interrupted, so on timeout fn is left
+// running in the background and its result is discarded.
+func runWithTimeout(ctx context.Context, timeout time.Duration, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
 		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if len(data) <= uefiModeByte {
-		return fmt.Errorf("uefi var too small: %d bytes", len(data))
+}
+
+// commandTimeout bounds each hook and external tool, such as systemctl
+// (--command-timeout).
+var commandTimeout = time.Minute
+
+// runCommand runs name under commandTimeout and returns its combined output.
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := checkNotReplaying("running " + name); err != nil {
+		return nil, err
 	}
-	before := data[uefiModeByte]
-	if discrete {
-		data[uefiModeByte] = 1
-	} else {
-		data[uefiModeByte] = 0
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("%s timed out after %s", name, commandTimeout)
 	}
-	log.Debug().Msgf("uefi %s[%d] before=0x%02x after=0x%02x", uefiVarName, uefiModeByte, before, data[uefiModeByte])
-	return writeUefiVar(attrs, data)
+	return out, err
 }
 
-func readUefiVar() (uint32, []byte, error) {
-	raw, err := os.ReadFile(uefiVarPath)
-	if err != nil {
-		return 0, nil, err
-	}
-	if len(raw) < uefiDataBase {
-		return 0, nil, fmt.Errorf("uefi var too small: %d bytes", len(raw))
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	attrs := binary.LittleEndian.Uint32(raw[:uefiDataBase])
-	data := make([]byte, len(raw)-uefiDataBase)
-	copy(data, raw[uefiDataBase:])
-	log.Debug().Msgf("uefi %s attrs=0x%08x len=%d", uefiVarName, attrs, len(data))
-	return attrs, data, nil
 }
 
-func writeUefiVar(attrs uint32, data []byte) error {
-	payload := make([]byte, uefiDataBase+len(data))
-	binary.LittleEndian.PutUint32(payload[:uefiDataBase], attrs)
-	copy(payload[uefiDataBase:], data)
+// ranAsRoot is set once a command passed requireRoot, so main records it in
+// the audit log.
+var ranAsRoot bool
 
-	restore, err := makeUefiVarMutable()
-	if err != nil {
-		return fmt.Errorf("prepare uefi var failed: %w", err)
+func requireRoot() {
+	if replayRoot != "" {
+		// A replay only writes to its scratch copy and refuses what would
+		// reach further (checkNotReplaying).
+		return
 	}
-	if restore != nil {
-		defer restore()
+	if os.Geteuid() == 0 {
+		ranAsRoot = true
+		return
 	}
-
-	if err := os.WriteFile(uefiVarPath, payload, 0o644); err != nil {
-		return fmt.Errorf("write uefi var failed: %w", err)
+	if privilegesDropped {
+		// An earlier switch in this process, e.g. in the TUI, opened
+		// everything the next one needs as root.
+		return
 	}
-	return nil
-}
-
-func triggerEcSwitch() error {
-	value, err := readEcByte(ecSwitchOffset)
-	if err != nil {
-		return err
+	if !noElevate && !nonInteractive {
+		err := reexecAsRoot()
+		log.Debug().Msgf("elevate failed: %v", err)
 	}
-	log.Debug().Msgf("ec switch before: 0x%02x", value)
-	value &^= ecSwitchMask0 | ecSwitchMask1
-	value |= ecSwitchMask0
-	log.Debug().Msgf("ec switch after: 0x%02x", value)
-	return writeEcByte(ecSwitchOffset, value)
+	fatal(ErrNeedsRoot)
 }
 
-func init() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+func fatal(err error) {
+	log.Error().Msgf("error: %v", err)
+	if hint := errorHint(err); hint != "" {
+		log.Info().Msgf("hint: %s", hint)
+	}
+	os.Exit(1)
 }
 
 func rootCmd() *cobra.Command {
-	var debug bool
+	var (
+		logging  logOptions
+		ecIndex  int
+		varName  = uefiVarName
+		varGuid  = uefiVarGuid
+		modeByte = uefiModeByte
+		backends = strings.Join(backendNames, ",")
+		replay   string
+	)
 
 	cmd := &cobra.Command{
 		Use:   "msi-gpu-switcher",
 		Short: "GPU MUX switcher for MSI laptops",
 		Long:  "Switch primary GPU output using UEFI vars and EC trigger.",
-		PersistentPreRun: func(_ *cobra.Command, _ []string) {
-			if debug {
-				zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			// Set before the config is read so its loading can be debugged.
+			if err := logging.setLevel(cmd.Flags().Changed); err != nil {
+				return err
+			}
+			if replay != "" {
+				done, err := startReplay(replay, cmd.Flags().Changed("config"))
+				if err != nil {
+					return err
+				}
+				registerCleanup(done)
+			}
+			cfg, err := loadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			loadedConfig = cfg
+			changed := cmd.Flags().Changed
+			logging.applyConfig(changed, cfg)
+			if err := logging.setLevel(changed); err != nil {
+				return err
+			}
+			if err := logging.setup(); err != nil {
+				return err
+			}
+			if cfg.UefiVarName != "" && !changed("uefi-var-name") {
+				varName = cfg.UefiVarName
+			}
+			if cfg.UefiVarGuid != "" && !changed("uefi-var-guid") {
+				varGuid = cfg.UefiVarGuid
+			}
+			if cfg.UefiModeByte != nil && !changed("uefi-mode-byte") {
+				modeByte = *cfg.UefiModeByte
+			}
+			if cfg.CreateUefiVar && !changed("create-uefi-var") {
+				createUefiVar = true
+			}
+			if cfg.TriState && !changed("tri-state") {
+				triStateModes = true
+			}
+			if cfg.UefiLayout != "" && !changed("uefi-layout") {
+				uefiLayoutName = cfg.UefiLayout
+			}
+			if cfg.KeepUnlocked && !changed("keep-unlocked") {
+				keepUnlocked = true
 			}
+			if cfg.Sandbox && !changed("sandbox") {
+				useSandbox = true
+			}
+			if cfg.DropPrivileges != "" && !changed("drop-privileges") {
+				dropUser = cfg.DropPrivileges
+			}
+			if len(cfg.Backends) > 0 && !changed("backends") {
+				backends = strings.Join(cfg.Backends, ",")
+			}
+			if switchBackends, err = parseBackends(backends); err != nil {
+				return err
+			}
+			for _, d := range []struct {
+				flag, value string
+				dst         *time.Duration
+			}{
+				{"ec-timeout", cfg.EcTimeout, &ecTimeout},
+				{"efivar-timeout", cfg.EfivarTimeout, &efivarTimeout},
+				{"command-timeout", cfg.CommandTimeout, &commandTimeout},
+			} {
+				if d.value != "" && !changed(d.flag) {
+					v, err := time.ParseDuration(d.value)
+					if err != nil {
+						return fmt.Errorf("parse %s: %w", d.flag, err)
+					}
+					*d.dst = v
+				}
+				if *d.dst <= 0 {
+					return fmt.Errorf("--%s must be positive", d.flag)
+				}
+			}
+			if cfg.ReadOnly && !changed("read-only") {
+				readOnly = true
+			}
+			if cfg.ManagePersistenced && !changed("manage-persistenced") {
+				managePersistenced = true
+			}
+			if err := setUefiTarget(varName, varGuid, modeByte); err != nil {
+				return err
+			}
+			applyQuirk(ecIndex)
+			return nil
 		},
 	}
-	cmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug logging")
+	logging.addFlags(cmd)
+	cmd.PersistentFlags().StringVar(&configPath, "config", configPath, "config file path")
+	cmd.PersistentFlags().StringVar(&varName, "uefi-var-name", varName, "UEFI variable holding the GPU mode")
+	cmd.PersistentFlags().StringVar(&varGuid, "uefi-var-guid", varGuid, "vendor GUID of the GPU mode variable")
+	cmd.PersistentFlags().IntVar(&modeByte, "uefi-mode-byte", modeByte, "offset of the GPU mode byte within the variable data; -1 uses the detected layout's")
+	cmd.PersistentFlags().StringVar(&uefiLayoutName, "uefi-layout", "", "force the GPU mode variable layout instead of detecting it ("+layoutNames()+")")
+	cmd.PersistentFlags().IntVar(&ecIndex, "ec", -1, "EC device index to use (default: from model quirk)")
+	cmd.PersistentFlags().BoolVar(&triStateModes, "tri-state", false, "firmware mode byte also encodes integrated (iGPU-only) mode")
+	cmd.PersistentFlags().BoolVar(&createUefiVar, "create-uefi-var", false, "create the GPU mode variable if it is missing")
+	cmd.PersistentFlags().BoolVar(&keepUnlocked, "keep-unlocked", false, "do not restore the immutable flag after writing the UEFI var")
+	cmd.PersistentFlags().BoolVar(&useSandbox, "sandbox", false, "restrict root commands that write firmware state, and their hooks, with Landlock and seccomp")
+	cmd.PersistentFlags().StringVar(&dropUser, "drop-privileges", "", "switch as this user once the EC and UEFI variable are open (bare: nobody)")
+	cmd.PersistentFlags().Lookup("drop-privileges").NoOptDefVal = "nobody"
+	cmd.PersistentFlags().BoolVar(&noElevate, "no-elevate", false, "fail instead of re-running through sudo, doas or pkexec when root is needed")
+	cmd.PersistentFlags().BoolVar(&forceWrites, "force", false, "write even when safety checks (e.g. a changed BIOS) would stop it")
+	cmd.PersistentFlags().DurationVar(&ecTimeout, "ec-timeout", ecTimeout, "give up on one EC access after this long")
+	cmd.PersistentFlags().DurationVar(&efivarTimeout, "efivar-timeout", efivarTimeout, "give up on one UEFI variable read or write after this long")
+	cmd.PersistentFlags().DurationVar(&commandTimeout, "command-timeout", commandTimeout, "give up on a hook or external tool (systemctl, udevadm, ...) after this long")
+	cmd.PersistentFlags().StringVar(&backends, "backends", backends, "comma-separated backends a switch writes through, in order ("+strings.Join(backendNames, ", ")+")")
+	cmd.PersistentFlags().BoolVar(&noEC, "no-ec", false, "switch through the UEFI variable only, leaving the EC alone")
+	cmd.PersistentFlags().BoolVar(&noUefi, "no-uefi", false, "switch through the EC MUX only, leaving the UEFI variable alone")
+	cmd.MarkFlagsMutuallyExclusive("no-ec", "no-uefi")
+	cmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "refuse every EC and UEFI write, for monitoring")
+	cmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "answer yes to confirmation prompts, for scripts")
+	cmd.PersistentFlags().BoolVar(&managePersistenced, "manage-persistenced", false, "enable nvidia-persistenced for discrete mode and disable it otherwise")
+	cmd.PersistentFlags().StringVar(&gpuSelector, "gpu", "", "PCI address of the discrete GPU to act on when there are several")
+	cmd.PersistentFlags().StringVar(&replay, "replay", "", "run against a capture made with the capture command instead of this machine")
+	cmd.PersistentFlags().IntVar(&verifyRetries, "verify-retries", verifyRetries, "times to retry a write whose read-back does not match")
+	_ = cmd.RegisterFlagCompletionFunc("uefi-layout", completeLayouts)
+	_ = cmd.RegisterFlagCompletionFunc("gpu", completeGPUs)
+	cmd.CompletionOptions.DisableDefaultCmd = true
 
 	cmd.AddCommand(
-		&cobra.Command{
-			Use:   "status",
-			Short: "Show current GPU/MUX/UEFI status",
-			RunE:  func(_ *cobra.Command, _ []string) error { return showStatus() },
-		},
+		statusCmd(),
 		&cobra.Command{
 			Use:   "igpu",
 			Short: "Switch to iGPU (hybrid)",
-			RunE: func(_ *cobra.Command, _ []string) error {
-				requireRoot()
-				return switchGPU(false)
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return switchMode(cmd.Context(), modeHybrid)
 			},
 		},
 		&cobra.Command{
 			Use:   "dgpu",
 			Short: "Switch to dGPU (discrete)",
-			RunE: func(_ *cobra.Command, _ []string) error {
-				requireRoot()
-				return switchGPU(true)
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return switchMode(cmd.Context(), modeDiscrete)
 			},
 		},
+		integratedCmd(),
+		switchCmd(),
+		ensureCmd(),
+		historyCmd(),
+		ecCmd(),
+		uefiCmd(),
+		shellCmd(),
+		lockCmd(true),
+		lockCmd(false),
+		daemonCmd(),
+		helperCmd(),
+		doctorCmd(),
+		tuiCmd(),
+		trayCmd(),
+		statusbarCmd(),
+		gpusCmd(),
+		whoUsesCmd(),
+		runCmd(),
+		nvidiaPMCmd(),
+		profileCmd(),
+		scheduleCmd(),
+		policyCmd(),
+		handleEventCmd(),
+		udevCmd(),
+		resumeCmd(),
+		auditCmd(),
+		metricsCmd(),
+		schemaCmd(),
+		captureCmd(),
+		selfUpdateCmd(),
+		quirksCmd(),
+		versionCmd(),
+		genManCmd(),
+		completionCmd(),
+		installCmd(),
+		uninstallCmd(),
 	)
 	return cmd
 }
 
-func restoreImmutable(path string) {
-	f, err := os.Open(path)
-	if err == nil {
-		flags, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
-		if err == nil {
-			if err := unix.IoctlSetInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, flags|int(unix.STATX_ATTR_IMMUTABLE)); err == nil {
-				log.Debug().Msg("restored immutable flag via ioctl")
-				_ = f.Close()
-				return
+func integratedCmd() *cobra.Command {
+	var kill killOptions
+	cmd := &cobra.Command{
+		Use:   "integrated",
+		Short: "Switch to iGPU only, dGPU disabled (tri-state firmwares)",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := checkModeSupported(modeIntegrated); err != nil {
+				return err
 			}
-		}
-		_ = f.Close()
-	}
-	log.Debug().Msg("ioctl restore failed, falling back to chattr +i")
-	if out, err := exec.Command("chattr", "+i", path).CombinedOutput(); err != nil {
-		log.Warn().Msgf("chattr +i failed: %v (%s)", err, strings.TrimSpace(string(out)))
+			if err := kill.run(cmd.Context(), modeIntegrated); err != nil {
+				return err
+			}
+			return switchMode(cmd.Context(), modeIntegrated)
+		},
 	}
+	kill.addFlags(cmd)
+	return cmd
 }
 
-func makeUefiVarMutable() (func(), error) {
-	fd, err := os.Open(uefiVarPath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	defer fd.Close()
-
-	flags, err := unix.IoctlGetInt(int(fd.Fd()), unix.FS_IOC_GETFLAGS)
-	if err == nil {
-		if flags&int(unix.STATX_ATTR_IMMUTABLE) == 0 {
-			return nil, nil
-		}
-		newFlags := flags &^ int(unix.STATX_ATTR_IMMUTABLE)
-		if err := unix.IoctlSetInt(int(fd.Fd()), unix.FS_IOC_SETFLAGS, newFlags); err == nil {
-			log.Debug().Msg("cleared immutable flag via ioctl")
-			return func() { restoreImmutable(uefiVarPath) }, nil
-		}
+func switchCmd() *cobra.Command {
+	var (
+		kill killOptions
+		once bool
+	)
+	cmd := &cobra.Command{
+		Use:               "switch <mode>",
+		Short:             "Switch to the given mode (hybrid, discrete, integrated)",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeModes,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := parseMode(args[0])
+			if err != nil {
+				return err
+			}
+			if once {
+				requireRoot()
+			}
+			if err := kill.run(cmd.Context(), mode); err != nil {
+				return err
+			}
+			if once {
+				return switchOnce(cmd.Context(), mode)
+			}
+			return switchMode(cmd.Context(), mode)
+		},
 	}
+	kill.addFlags(cmd)
+	cmd.Flags().BoolVar(&once, "once", false, "use the mode for the next boot only; the boot service switches back at the boot after")
+	return cmd
+}
 
-	log.Debug().Msg("ioctl failed, falling back to chattr -i")
-	if out, err := exec.Command("chattr", "-i", uefiVarPath).CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("chattr -i failed: %v (%s)", err, strings.TrimSpace(string(out)))
+func historyCmd() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show previously performed switches",
+		RunE:  func(_ *cobra.Command, _ []string) error { return showHistory(limit) },
 	}
-	return func() { restoreImmutable(uefiVarPath) }, nil
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "show only the last N entries (0 = all)")
+	return cmd
 }
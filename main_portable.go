@@ -0,0 +1,420 @@
+//go:build windows || freebsd
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// The Windows and FreeBSD builds cover reading the mode and switching it,
+// with the same modes, variable and EC registers as on Linux. Each OS
+// provides the primitives:
+//
+//	requirePrivilege() error
+//	readUefiVar() (attrs uint32, data []byte, err error)
+//	writeUefiVar(attrs uint32, data []byte) error
+//	readEC(ctx, offset) (byte, error)
+//	writeEC(ctx, offset, value) error
+//	readDMI(field) string
+//	stdinIsTerminal() bool
+//	tryLock(f) (bool, error)
+//
+// and platformHints, the hints of errors whose remedy differs there,
+// lockPath and stateDir.
+
+// noEC and noUefi restrict a switch to one mechanism, as on Linux.
+var noEC, noUefi bool
+
+// assumeYes, forceWrites and readOnly are --yes, --force and --read-only, as
+// on Linux.
+var assumeYes, forceWrites, readOnly bool
+
+const lockPollInterval = 100 * time.Millisecond
+
+func main() {
+	log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"}).With().Timestamp().Logger()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if err := rootCmd().ExecuteContext(context.Background()); err != nil {
+		log.Error().Msgf("error: %v", err)
+		if hint := platformHint(err); hint != "" {
+			log.Info().Msgf("hint: %s", hint)
+		}
+		os.Exit(1)
+	}
+}
+
+func platformHint(err error) string {
+	for kind, hint := range platformHints {
+		if errors.Is(err, kind) {
+			return hint
+		}
+	}
+	return errorHint(err)
+}
+
+func rootCmd() *cobra.Command {
+	var (
+		logLevel string
+		debug    bool
+		varName  = uefiVarName
+		varGuid  = uefiVarGuid
+		modeByte = uefiModeByte
+	)
+	cmd := &cobra.Command{
+		Use:   "msi-gpu-switcher",
+		Short: "GPU MUX switcher for MSI laptops",
+		Long:  "Switch primary GPU output using UEFI vars and EC trigger.",
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if debug && !cmd.Flags().Changed("log-level") {
+				logLevel = "debug"
+			}
+			if err := setLogLevel(logLevel); err != nil {
+				return err
+			}
+			if varName == "" || !isGuid(varGuid) || modeByte < -1 {
+				return fmt.Errorf("invalid uefi var %q-%q byte %d", varName, varGuid, modeByte)
+			}
+			uefiVarName, uefiVarGuid, uefiModeByte = varName, strings.ToUpper(varGuid), modeByte
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level ("+strings.Join(logLevels, ", ")+"); trace shows every EC access")
+	cmd.PersistentFlags().BoolVar(&debug, "debug", false, "same as --log-level debug")
+	_ = cmd.PersistentFlags().MarkDeprecated("debug", "use --log-level debug")
+	_ = cmd.RegisterFlagCompletionFunc("log-level", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return logLevels, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.PersistentFlags().StringVar(&varName, "uefi-var-name", varName, "UEFI variable holding the GPU mode")
+	cmd.PersistentFlags().StringVar(&varGuid, "uefi-var-guid", varGuid, "vendor GUID of the GPU mode variable")
+	cmd.PersistentFlags().IntVar(&modeByte, "uefi-mode-byte", modeByte, "offset of the GPU mode byte within the variable data; -1 uses the detected layout's")
+	cmd.PersistentFlags().BoolVar(&triStateModes, "tri-state", false, "firmware mode byte also encodes integrated (iGPU-only) mode")
+	cmd.PersistentFlags().BoolVar(&noEC, "no-ec", false, "switch through the UEFI variable only, leaving the EC alone")
+	cmd.PersistentFlags().BoolVar(&noUefi, "no-uefi", false, "switch through the EC MUX only, leaving the UEFI variable alone")
+	cmd.MarkFlagsMutuallyExclusive("no-ec", "no-uefi")
+	cmd.PersistentFlags().BoolVar(&forceWrites, "force", false, "write even when safety checks (e.g. an untested BIOS) would stop it")
+	cmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "refuse every EC and UEFI write, for monitoring")
+	cmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "answer yes to confirmation prompts, for scripts")
+	cmd.CompletionOptions.DisableDefaultCmd = true
+
+	switchTo := func(mode gpuMode) func(*cobra.Command, []string) error {
+		return func(cmd *cobra.Command, _ []string) error {
+			return switchGPU(cmd.Context(), mode)
+		}
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "status",
+			Short: "Show current GPU/MUX/UEFI status",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				return showStatus(cmd.Context())
+			},
+		},
+		&cobra.Command{Use: "igpu", Short: "Switch to iGPU (hybrid)", Args: cobra.NoArgs, RunE: switchTo(modeHybrid)},
+		&cobra.Command{Use: "dgpu", Short: "Switch to dGPU (discrete)", Args: cobra.NoArgs, RunE: switchTo(modeDiscrete)},
+		&cobra.Command{
+			Use:   "switch <mode>",
+			Short: "Switch to the given mode (hybrid, discrete, integrated)",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				mode, err := parseMode(args[0])
+				if err != nil {
+					return err
+				}
+				return switchGPU(cmd.Context(), mode)
+			},
+		},
+		historyCmd(),
+		versionCmd(),
+	)
+	return cmd
+}
+
+func historyCmd() *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show previously performed switches",
+		Args:  cobra.NoArgs,
+		RunE:  func(_ *cobra.Command, _ []string) error { return showHistory(limit) },
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "show only the last N entries (0 = all)")
+	return cmd
+}
+
+func showStatus(ctx context.Context) error {
+	outln("UEFI var (requested for the next boot):")
+	if mode, err := readUefiGpuMode(); err != nil {
+		outf("  unavailable: %v", err)
+	} else {
+		outf("  %s: %s", uefiVarName, mode.label())
+	}
+	outln("EC MUX (requested for the next boot):")
+	if discrete, err := readMuxState(ctx); err != nil {
+		outf("  unavailable: %v", err)
+	} else if discrete {
+		outln("  dGPU (discrete)")
+	} else {
+		outln("  iGPU (hybrid)")
+	}
+	return nil
+}
+
+// switchGPU writes the UEFI variable, makes the EC pick it up and sets the
+// MUX, in the Linux order, after the same board and BIOS checks and under the
+// same kind of lock. When the EC refuses, the variable is put back. The
+// outcome goes to the history, as on Linux.
+func switchGPU(ctx context.Context, mode gpuMode) error {
+	if err := checkModeSupported(mode); err != nil {
+		return err
+	}
+	if readOnly {
+		return ErrReadOnly
+	}
+	if err := requirePrivilege(); err != nil {
+		return err
+	}
+	if err := confirmFirmware(ctx); err != nil {
+		return err
+	}
+	if err := confirmUnknownModel(); err != nil {
+		return err
+	}
+	if err := checkTestedBios(); err != nil {
+		return err
+	}
+	unlock, err := acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkFirmware(ctx, hostEC{}); err != nil {
+		return err
+	}
+	backends, err := applySwitch(ctx, mode)
+	recordSwitch(mode, backends, err)
+	return err
+}
+
+// applySwitch does the writes of switchGPU and returns the backends that
+// took them.
+func applySwitch(ctx context.Context, mode gpuMode) ([]string, error) {
+	var backends []string
+	var undo func() error
+	if !noUefi {
+		before, err := writeUefiModeByte(byte(mode))
+		if err != nil {
+			return nil, fmt.Errorf("UEFI var write failed: %w", err)
+		}
+		backends = append(backends, "uefi")
+		undo = func() error {
+			_, err := writeUefiModeByte(before)
+			return err
+		}
+		log.Info().Msgf("UEFI target set: %s", mode.label())
+	}
+	if !noEC {
+		if err := switchEC(ctx, mode, !noUefi); err != nil {
+			if undo != nil {
+				log.Warn().Msg("rolling back UEFI var write")
+				if rbErr := undo(); rbErr != nil {
+					return backends, errors.Join(err, fmt.Errorf("rollback UEFI var write failed: %w", rbErr))
+				}
+			}
+			return backends, err
+		}
+		backends = append(backends, "ec")
+		log.Info().Msgf("Requested primary GPU: %s (EC MUX)", mode.label())
+	}
+	return backends, nil
+}
+
+func switchEC(ctx context.Context, mode gpuMode, trigger bool) error {
+	if trigger {
+		value, err := readEC(ctx, ecSwitchOffset)
+		if err == nil {
+			err = setEC(ctx, ecSwitchOffset, switchValue(value))
+		}
+		// As on Linux the trigger is optional; the MUX write decides.
+		if err != nil {
+			log.Warn().Msgf("EC switch trigger failed: %v", err)
+		}
+	}
+	value, err := readEC(ctx, ecMuxOffset)
+	if err != nil {
+		return fmt.Errorf("EC MUX write failed: %w", err)
+	}
+	if err := setEC(ctx, ecMuxOffset, muxValue(value, mode.muxDiscrete())); err != nil {
+		return fmt.Errorf("EC MUX write failed: %w", err)
+	}
+	return nil
+}
+
+// setEC writes an EC register and reads it back.
+func setEC(ctx context.Context, offset, value byte) error {
+	if err := writeEC(ctx, offset, value); err != nil {
+		return fmt.Errorf("%w: ec [0x%02x]: %w", ErrWriteRejected, offset, err)
+	}
+	got, err := readEC(ctx, offset)
+	if err != nil {
+		return fmt.Errorf("read back ec [0x%02x] failed: %w", offset, err)
+	}
+	if got != value {
+		return fmt.Errorf("%w: ec [0x%02x] reads 0x%02x after writing 0x%02x", ErrWriteRejected, offset, got, value)
+	}
+	return nil
+}
+
+// hostEC reads the EC through readEC, for the BIOS sanity checks.
+type hostEC struct{}
+
+func (hostEC) readByte(ctx context.Context, offset int) (byte, error) {
+	return readEC(ctx, byte(offset))
+}
+
+// sanityEC returns the EC for the sanity checks confirmFirmware runs.
+func sanityEC() (ecReader, func()) {
+	return hostEC{}, func() {}
+}
+
+func readMuxState(ctx context.Context) (bool, error) {
+	value, err := readEC(ctx, ecMuxOffset)
+	if err != nil {
+		return false, err
+	}
+	return value&ecMuxMask != 0, nil
+}
+
+// readUefiGpuMode returns the mode the variable requests for the next boot.
+func readUefiGpuMode() (gpuMode, error) {
+	_, data, err := readUefiVar()
+	if err != nil {
+		return 0, err
+	}
+	v, err := parseMsiDC(data)
+	if err != nil {
+		return 0, err
+	}
+	return v.mode()
+}
+
+// writeUefiModeByte sets the mode byte, keeping the attributes and every
+// other byte, and returns the value it replaced.
+func writeUefiModeByte(value byte) (byte, error) {
+	attrs, data, err := readUefiVar()
+	if err != nil {
+		return 0, err
+	}
+	v, err := parseMsiDC(data)
+	if err != nil {
+		return 0, err
+	}
+	if attrs&efiAttrAuthWrites != 0 {
+		return 0, fmt.Errorf("%w: refusing to write authenticated variable %s (attrs=0x%08x)", ErrWriteRejected, uefiVarName, attrs)
+	}
+	before := v.modeByte()
+	v.setModeByte(value)
+	if err := writeUefiVar(attrs, data); err != nil {
+		return before, err
+	}
+	_, got, err := readUefiVar()
+	if err != nil {
+		return before, fmt.Errorf("read back uefi var failed: %w", err)
+	}
+	if len(got) != len(data) || got[v.offset] != value {
+		return before, fmt.Errorf("%w: uefi mode byte did not stick", ErrWriteRejected)
+	}
+	return before, nil
+}
+
+// efiAttrAuthWrites are the authenticated write attributes (UEFI spec 8.2);
+// such variables need a signed payload, which this tool never has.
+const efiAttrAuthWrites = 0x10 | 0x20
+
+// detectQuirk matches the SMBIOS board name against quirkTable; there is no
+// installed quirk database outside Linux.
+func detectQuirk() (quirk, bool) {
+	board := readDMI("board_name")
+	for _, q := range quirkTable {
+		if q.boardName != "" && q.boardName == board {
+			return q, true
+		}
+	}
+	return defaultQuirk, false
+}
+
+// confirm asks prompt on the terminal and answers no without one, as on
+// Linux.
+func confirm(prompt string) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	if !stdinIsTerminal() {
+		log.Warn().Msgf("%s Not asking without a terminal; pass --yes to confirm.", prompt)
+		return false, nil
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
+
+// acquireLock takes an exclusive lock on lockPath, waiting until ctx is done
+// for another instance holding it. The returned func releases the lock.
+func acquireLock(ctx context.Context) (func(), error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file failed: %w", err)
+	}
+	for waiting := false; ; waiting = true {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", lockPath, err)
+		}
+		if ok {
+			break
+		}
+		if !waiting {
+			log.Info().Msg("waiting for another msi-gpu-switcher instance to finish...")
+		}
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", lockPath, ctx.Err())
+		}
+	}
+	log.Debug().Msgf("acquired lock %s", lockPath)
+	// Closing the file releases the lock.
+	return func() { f.Close() }, nil
+}
+
+// There is no privilege drop outside Linux, so the state files are opened
+// directly.
+
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func readFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func replaceFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
@@ -1,10 +1,16 @@
+//go:build linux
+
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSetUefiGpuModeUpdatesByte(t *testing.T) {
@@ -23,18 +29,18 @@ func TestSetUefiGpuModeUpdatesByte(t *testing.T) {
 		t.Fatalf("write test var: %v", err)
 	}
 
-	if err := setUefiGpuMode(false); err != nil {
+	if err := setUefiGpuMode(context.Background(), modeHybrid); err != nil {
 		t.Fatalf("setUefiGpuMode: %v", err)
 	}
 
-	_, updated, err := readUefiVar()
+	_, updated, err := readUefiVar(context.Background())
 	if err != nil {
 		t.Fatalf("readUefiVar: %v", err)
 	}
-	if len(updated) <= uefiModeByte {
+	if len(updated) != len(data) {
 		t.Fatalf("updated data too small: %d", len(updated))
 	}
-	if got := updated[uefiModeByte]; got != 0 {
+	if got := updated[1]; got != 0 {
 		t.Fatalf("expected mode byte 0, got 0x%02x", got)
 	}
 }
@@ -50,7 +56,7 @@ func TestReadUefiVarTooSmall(t *testing.T) {
 		t.Fatalf("write test var: %v", err)
 	}
 
-	if _, _, err := readUefiVar(); err == nil {
+	if _, _, err := readUefiVar(context.Background()); err == nil {
 		t.Fatalf("expected error on short uefi var")
 	}
 }
@@ -64,11 +70,11 @@ func TestWriteUefiVarPreservesAttrsAndData(t *testing.T) {
 
 	attrs := uint32(0x07)
 	data := []byte{0x01, 0x02, 0x03, 0x04}
-	if err := writeUefiVar(attrs, data); err != nil {
+	if err := writeUefiVar(context.Background(), attrs, data); err != nil {
 		t.Fatalf("writeUefiVar: %v", err)
 	}
 
-	readAttrs, readData, err := readUefiVar()
+	readAttrs, readData, err := readUefiVar(context.Background())
 	if err != nil {
 		t.Fatalf("readUefiVar: %v", err)
 	}
@@ -93,7 +99,7 @@ func TestReadUefiGpuMode(t *testing.T) {
 	t.Cleanup(func() { uefiVarPath = originalPath })
 
 	attrs := uint32(0x07)
-	data := []byte{0x00, 0x01, 0x00}
+	data := []byte{0x00, 0x01, 0x00, 0x00}
 	payload := make([]byte, uefiDataBase+len(data))
 	binary.LittleEndian.PutUint32(payload[:uefiDataBase], attrs)
 	copy(payload[uefiDataBase:], data)
@@ -101,11 +107,198 @@ func TestReadUefiGpuMode(t *testing.T) {
 		t.Fatalf("write test var: %v", err)
 	}
 
-	mode, err := readUefiGpuMode()
+	mode, err := readUefiGpuMode(context.Background())
 	if err != nil {
 		t.Fatalf("readUefiGpuMode: %v", err)
 	}
-	if !mode {
-		t.Fatalf("expected discrete mode true")
+	if mode != modeDiscrete {
+		t.Fatalf("expected discrete mode, got %s", mode)
+	}
+}
+
+func TestRunWithTimeoutGivesUp(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	err := runWithTimeout(context.Background(), 10*time.Millisecond, func() error {
+		<-block
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRunCommandTimesOut(t *testing.T) {
+	original := commandTimeout
+	t.Cleanup(func() { commandTimeout = original })
+	commandTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := runCommand(context.Background(), "sleep", "5")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("runCommand took %s", time.Since(start))
+	}
+}
+
+func TestActiveMode(t *testing.T) {
+	originalRoot, originalDRM, originalTriState := pciRoot, drmRoot, triStateModes
+	pciRoot, drmRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { pciRoot, drmRoot, triStateModes = originalRoot, originalDRM, originalTriState })
+
+	addGPU := func(addr, vendor, bootVGA string) {
+		dir := filepath.Join(pciRoot, addr)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for name, value := range map[string]string{"class": "0x030000", "vendor": vendor, "device": "0x0001", "boot_vga": bootVGA} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644); err != nil {
+				t.Fatalf("write %s: %v", name, err)
+			}
+		}
+	}
+
+	addGPU("0000:06:00.0", "0x1002", "1")
+	triStateModes = true
+	if mode, err := activeMode(); err != nil || mode != modeIntegrated {
+		t.Fatalf("expected integrated without a discrete GPU, got %s %v", mode, err)
+	}
+	addGPU("0000:01:00.0", nvidiaVendor, "0")
+	if mode, err := activeMode(); err != nil || mode != modeHybrid {
+		t.Fatalf("expected hybrid, got %s %v", mode, err)
+	}
+	addGPU("0000:01:00.0", nvidiaVendor, "1")
+	if mode, source, err := activeModeSource(); err != nil || mode != modeDiscrete || source != "boot_vga" {
+		t.Fatalf("expected discrete from boot_vga, got %s %s %v", mode, source, err)
+	}
+
+	// A connected internal panel on the iGPU outweighs boot_vga.
+	card := filepath.Join(drmRoot, "card1")
+	if err := os.MkdirAll(card, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(pciRoot, "0000:06:00.0"), filepath.Join(card, "device")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(drmRoot, "card1-eDP-1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(drmRoot, "card1-eDP-1", "status"), []byte("connected\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if mode, source, err := activeModeSource(); err != nil || mode != modeHybrid || source != "drm" {
+		t.Fatalf("expected hybrid from drm, got %s %s %v", mode, source, err)
+	}
+}
+
+func TestIsDiscreteGPUAllAMD(t *testing.T) {
+	originalRoot := pciRoot
+	pciRoot = t.TempDir()
+	t.Cleanup(func() { pciRoot = originalRoot })
+
+	drivers := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(drivers, "amdgpu"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	addGPU := func(addr string, files map[string]string) string {
+		dir := filepath.Join(pciRoot, addr)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for name, value := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o644); err != nil {
+				t.Fatalf("write %s: %v", name, err)
+			}
+		}
+		if err := os.Symlink(filepath.Join(drivers, "amdgpu"), filepath.Join(dir, "driver")); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+		return dir
+	}
+	igpu := addGPU("0000:07:00.0", map[string]string{"class": "0x030000", "vendor": amdVendor, "boot_vga": "1"})
+	dgpu := addGPU("0000:03:00.0", map[string]string{"class": "0x030000", "vendor": amdVendor, "boot_vga": "0", "pp_dpm_pcie": "0: 2.5GT/s, x1 *"})
+
+	if isDiscreteGPU(igpu) {
+		t.Fatal("APU without pp_dpm_pcie reported as discrete")
+	}
+	if !isDiscreteGPU(dgpu) {
+		t.Fatal("amdgpu dGPU not reported as discrete")
+	}
+	if mode, err := activeMode(); err != nil || mode != modeHybrid {
+		t.Fatalf("expected hybrid, got %s %v", mode, err)
+	}
+}
+
+func TestExternalGPUIsNotDiscrete(t *testing.T) {
+	originalRoot := pciRoot
+	pciRoot = t.TempDir()
+	t.Cleanup(func() { pciRoot = originalRoot })
+
+	// An eGPU below a Thunderbolt bridge, with pciRoot linking into the tree
+	// the way /sys/bus/pci/devices links into /sys/devices.
+	devices := t.TempDir()
+	bridge := filepath.Join(devices, "0000:00:07.0")
+	egpu := filepath.Join(bridge, "0000:3a:00.0")
+	if err := os.MkdirAll(egpu, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for path, value := range map[string]string{
+		filepath.Join(bridge, "removable"): "removable",
+		filepath.Join(egpu, "class"):       "0x030000",
+		filepath.Join(egpu, "vendor"):      nvidiaVendor,
+	} {
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	if err := os.Symlink(egpu, filepath.Join(pciRoot, "0000:3a:00.0")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	gpus, err := listGPUs()
+	if err != nil || len(gpus) != 1 {
+		t.Fatalf("listGPUs = %+v, %v", gpus, err)
+	}
+	if !gpus[0].external || gpus[0].discrete {
+		t.Fatalf("eGPU flags: %+v", gpus[0])
+	}
+	if isDiscreteGPU(filepath.Join(pciRoot, "0000:3a:00.0")) {
+		t.Fatal("eGPU must not count for the busy-GPU guard")
+	}
+}
+
+func TestPrintUefiVarVerbose(t *testing.T) {
+	originalPath := uefiVarPath
+	uefiVarPath = filepath.Join(t.TempDir(), "MsiDCVarData-test")
+	t.Cleanup(func() { uefiVarPath = originalPath })
+	fakeInodeFlags(t, 0, 0)
+	if err := os.WriteFile(uefiVarPath, []byte{0x07, 0, 0, 0, 0x00, 0x01, 0x00, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := captureOutput(t)
+
+	printUefiVar(context.Background(), false)
+	if strings.Contains(out.String(), "NON_VOLATILE") {
+		t.Fatalf("expected no raw details without verbose, got %q", out)
+	}
+	out.Reset()
+	printUefiVar(context.Background(), true)
+	for _, want := range []string{"NON_VOLATILE (0x1), BOOTSERVICE_ACCESS (0x2), RUNTIME_ACCESS (0x4)", "00 01 00 00", uefiVarPath} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("verbose output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWatchStatusStopsWithContext(t *testing.T) {
+	out, _ := captureOutput(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := watchStatus(ctx, time.Hour, false); err != nil {
+		t.Fatalf("watchStatus: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), clearScreen+"msi-gpu-switcher status") || !strings.Contains(out.String(), "GPU devices:") {
+		t.Fatalf("expected one redraw, got %q", out)
 	}
 }
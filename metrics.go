@@ -0,0 +1,129 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// writeMetrics writes the Prometheus text exposition format. The switch
+// counters are derived from the history file so they survive restarts.
+func writeMetrics(ctx context.Context, w io.Writer) error {
+	var buf bytes.Buffer
+	s := readBarState(ctx)
+
+	gauge := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	bool01 := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	gauge("msi_gpu_switcher_mode", "Mode the system booted with (1 for the active mode).")
+	for _, m := range supportedModes() {
+		fmt.Fprintf(&buf, "msi_gpu_switcher_mode{mode=%q} %d\n", m.String(), bool01(s.active == m.String()))
+	}
+	gauge("msi_gpu_switcher_pending_mode", "Mode stored in the UEFI variable for the next boot.")
+	for _, m := range supportedModes() {
+		fmt.Fprintf(&buf, "msi_gpu_switcher_pending_mode{mode=%q} %d\n", m.String(), bool01(s.pending == m.String()))
+	}
+	gauge("msi_gpu_switcher_pending_reboot", "Whether a switch waits for a reboot.")
+	fmt.Fprintf(&buf, "msi_gpu_switcher_pending_reboot %d\n", bool01(s.class() == "pending"))
+
+	if state, ok := dgpuPowerState(); ok {
+		gauge("msi_gpu_switcher_dgpu_powered", "Whether the discrete GPU is in D0, labelled with its PCI power state.")
+		fmt.Fprintf(&buf, "msi_gpu_switcher_dgpu_powered{state=%q} %d\n", state, bool01(state == "D0"))
+	}
+
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+	switches := map[string]int{}
+	failures := map[string]int{}
+	for _, e := range entries {
+		if e.Result == "ok" {
+			switches[e.Mode]++
+		} else {
+			failures[e.Mode]++
+		}
+	}
+	for _, c := range []struct {
+		name, help string
+		counts     map[string]int
+	}{
+		{"msi_gpu_switcher_switches_total", "Successful switches by target mode.", switches},
+		{"msi_gpu_switcher_switch_failures_total", "Failed switches by target mode.", failures},
+	} {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, m := range allModes {
+			fmt.Fprintf(&buf, "%s{mode=%q} %d\n", c.name, m.String(), c.counts[m.String()])
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// dgpuPowerState returns the PCI power state (D0, D3hot, D3cold) of the
+// discrete GPU, if one is present.
+func dgpuPowerState() (string, bool) {
+	g, err := selectDGPU()
+	if err != nil {
+		return "", false
+	}
+	state := readFirstLine(filepath.Join(pciRoot, g.addr, "power_state"))
+	return state, state != ""
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := writeMetrics(r.Context(), &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeTextfile replaces path atomically, as node_exporter's textfile
+// collector may read it at any time.
+func writeTextfile(ctx context.Context, path string) error {
+	var buf bytes.Buffer
+	if err := writeMetrics(ctx, &buf); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func metricsCmd() *cobra.Command {
+	var textfile string
+	cmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Print Prometheus metrics (or write them for the textfile collector)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if textfile != "" {
+				return writeTextfile(cmd.Context(), textfile)
+			}
+			return writeMetrics(cmd.Context(), os.Stdout)
+		},
+	}
+	cmd.Flags().StringVar(&textfile, "textfile", "", "write to this .prom file instead of stdout")
+	return cmd
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	originalDir, originalRoot, originalVar := stateDir, pciRoot, uefiVarPath
+	stateDir, pciRoot = t.TempDir(), t.TempDir()
+	uefiVarPath = filepath.Join(stateDir, "missing")
+	t.Cleanup(func() { stateDir, pciRoot, uefiVarPath = originalDir, originalRoot, originalVar })
+
+	dgpu := filepath.Join(pciRoot, "0000:01:00.0")
+	if err := os.MkdirAll(dgpu, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, value := range map[string]string{"class": "0x030000", "vendor": nvidiaVendor, "boot_vga": "1", "power_state": "D3cold"} {
+		if err := os.WriteFile(filepath.Join(dgpu, name), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	now := time.Now().UTC()
+	_ = appendHistory(historyEntry{Time: now, Mode: "discrete", Backend: "uefi+ec", Result: "ok"})
+	_ = appendHistory(historyEntry{Time: now, Mode: "discrete", Backend: "uefi+ec", Result: "ok"})
+	_ = appendHistory(historyEntry{Time: now, Mode: "hybrid", Backend: "ec", Result: "EC MUX write failed"})
+
+	var buf bytes.Buffer
+	if err := writeMetrics(context.Background(), &buf); err != nil {
+		t.Fatalf("writeMetrics: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`msi_gpu_switcher_mode{mode="discrete"} 1`,
+		`msi_gpu_switcher_mode{mode="hybrid"} 0`,
+		`msi_gpu_switcher_pending_reboot 0`,
+		`msi_gpu_switcher_dgpu_powered{state="D3cold"} 0`,
+		`msi_gpu_switcher_switches_total{mode="discrete"} 2`,
+		`msi_gpu_switcher_switch_failures_total{mode="hybrid"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gpuMode is the GPU configuration requested from the firmware. Its value
+// is what the firmware stores in the UEFI mode byte.
+type gpuMode byte
+
+const (
+	modeHybrid gpuMode = iota
+	modeDiscrete
+	// modeIntegrated disables the dGPU entirely. Only some firmwares know
+	// this third value; see triStateModes.
+	modeIntegrated
+)
+
+// triStateModes allows modeIntegrated on firmwares whose mode byte encodes
+// three values.
+var triStateModes bool
+
+var allModes = []gpuMode{modeHybrid, modeDiscrete, modeIntegrated}
+
+func (m gpuMode) String() string {
+	switch m {
+	case modeHybrid:
+		return "hybrid"
+	case modeDiscrete:
+		return "discrete"
+	case modeIntegrated:
+		return "integrated"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(m))
+}
+
+func (m gpuMode) label() string {
+	switch m {
+	case modeHybrid:
+		return "iGPU (hybrid)"
+	case modeDiscrete:
+		return "dGPU (discrete)"
+	case modeIntegrated:
+		return "iGPU only (integrated)"
+	}
+	return m.String()
+}
+
+// primary says which GPU drives the display in m.
+func (m gpuMode) primary() string {
+	switch m {
+	case modeDiscrete:
+		return "dGPU primary"
+	case modeHybrid:
+		return "iGPU primary"
+	case modeIntegrated:
+		return "dGPU off"
+	}
+	return "unknown"
+}
+
+// modeNamed looks up a mode by its canonical name, whether or not this
+// firmware supports it.
+func modeNamed(name string) (gpuMode, bool) {
+	for _, m := range allModes {
+		if m.String() == name {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// muxDiscrete reports whether the EC MUX routes the panel to the dGPU.
+func (m gpuMode) muxDiscrete() bool {
+	return m == modeDiscrete
+}
+
+// supportedModes returns the modes selectable on this machine.
+func supportedModes() []gpuMode {
+	if triStateModes {
+		return allModes
+	}
+	return allModes[:2]
+}
+
+func modeNames() []string {
+	var names []string
+	for _, m := range supportedModes() {
+		names = append(names, m.String())
+	}
+	return names
+}
+
+// parseMode accepts a mode name or its igpu/dgpu/igpu-only alias.
+func parseMode(s string) (gpuMode, error) {
+	var m gpuMode
+	switch strings.ToLower(s) {
+	case "hybrid", "igpu":
+		m = modeHybrid
+	case "discrete", "dgpu":
+		m = modeDiscrete
+	case "integrated", "igpu-only":
+		m = modeIntegrated
+	default:
+		return 0, fmt.Errorf("unknown mode %q (expected one of %s)", s, strings.Join(modeNames(), ", "))
+	}
+	return m, checkModeSupported(m)
+}
+
+func checkModeSupported(m gpuMode) error {
+	for _, sm := range supportedModes() {
+		if sm == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s mode is not supported on this firmware (use --tri-state if it encodes three modes)", ErrUnsupportedModel, m)
+}
+
+// modeFromByte maps a UEFI mode byte to a mode. Integrated is only known to
+// tri-state firmware; elsewhere 2 is as unknown as any other value.
+func modeFromByte(b byte) (gpuMode, bool) {
+	m := gpuMode(b)
+	return m, checkModeSupported(m) == nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	originalTriState := triStateModes
+	t.Cleanup(func() { triStateModes = originalTriState })
+
+	triStateModes = false
+	for in, want := range map[string]gpuMode{"hybrid": modeHybrid, "igpu": modeHybrid, "DGPU": modeDiscrete, "discrete": modeDiscrete} {
+		got, err := parseMode(in)
+		if err != nil {
+			t.Fatalf("parseMode(%q): %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parseMode(%q) = %s, want %s", in, got, want)
+		}
+	}
+	if _, err := parseMode("integrated"); err == nil {
+		t.Fatalf("expected integrated to be rejected without tri-state")
+	}
+	if _, err := parseMode("bogus"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+
+	triStateModes = true
+	if got, err := parseMode("igpu-only"); err != nil || got != modeIntegrated {
+		t.Fatalf("parseMode(igpu-only) = %s, %v", got, err)
+	}
+}
+
+func TestModeFromByte(t *testing.T) {
+	original := triStateModes
+	t.Cleanup(func() { triStateModes = original })
+
+	triStateModes = false
+	if m, ok := modeFromByte(1); !ok || m != modeDiscrete {
+		t.Fatalf("modeFromByte(1) = %s, %v", m, ok)
+	}
+	if _, ok := modeFromByte(2); ok {
+		t.Fatalf("expected 2 to be unknown without tri-state")
+	}
+	triStateModes = true
+	if m, ok := modeFromByte(2); !ok || m != modeIntegrated {
+		t.Fatalf("modeFromByte(2) = %s, %v", m, ok)
+	}
+	if _, ok := modeFromByte(3); ok {
+		t.Fatalf("expected 3 to be unknown")
+	}
+}
@@ -0,0 +1,185 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+type mqttOptions struct {
+	broker          string
+	topic           string
+	username        string
+	passwordFile    string
+	discoveryPrefix string
+}
+
+// mqttBridge publishes the mode to an MQTT broker and accepts switch commands
+// on <topic>/set. Home Assistant picks it up as a select (the pending mode)
+// and a sensor (the active mode) through discovery.
+type mqttBridge struct {
+	ctx    context.Context
+	client mqtt.Client
+	opts   mqttOptions
+	node   string
+
+	mu   sync.Mutex
+	last barState
+	sent bool
+}
+
+var mqttNodeInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func mqttNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return mqttNodeInvalid.ReplaceAllString(host, "_")
+}
+
+func startMQTT(ctx context.Context, opts mqttOptions) (*mqttBridge, func(), error) {
+	b := &mqttBridge{ctx: ctx, opts: opts, node: mqttNodeID()}
+	if b.opts.topic == "" {
+		b.opts.topic = "msi-gpu-switcher/" + b.node
+	}
+	b.opts.topic = strings.TrimSuffix(b.opts.topic, "/")
+
+	co := mqtt.NewClientOptions().
+		AddBroker(opts.broker).
+		SetClientID("msi-gpu-switcher-"+b.node).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(b.opts.topic+"/availability", "offline", 1, true).
+		SetOnConnectHandler(b.onConnect)
+	if opts.username != "" {
+		co.SetUsername(opts.username)
+	}
+	if opts.passwordFile != "" {
+		password, err := readTokenFile(opts.passwordFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		co.SetPassword(password)
+	}
+	b.client = mqtt.NewClient(co)
+	// With SetConnectRetry the token only completes once connected, so do
+	// not wait for it; onConnect publishes everything.
+	b.client.Connect()
+	log.Info().Msgf("publishing to MQTT broker %s under %s", opts.broker, b.opts.topic)
+	return b, func() {
+		b.publish("availability", "offline")
+		b.client.Disconnect(250)
+	}, nil
+}
+
+func (b *mqttBridge) onConnect(c mqtt.Client) {
+	for topic, payload := range mqttDiscovery(b.node, b.opts.topic, b.opts.discoveryPrefix, modeNames()) {
+		c.Publish(topic, 1, true, payload)
+	}
+	b.publish("availability", "online")
+	c.Subscribe(b.opts.topic+"/set", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		go b.handleSet(strings.TrimSpace(string(msg.Payload())))
+	})
+	b.mu.Lock()
+	b.sent = false
+	b.mu.Unlock()
+	b.refresh()
+}
+
+func (b *mqttBridge) handleSet(name string) {
+	mode, err := parseMode(name)
+	if err == nil {
+		err = checkModeSupported(mode)
+	}
+	if err == nil {
+		log.Info().Msgf("switch to %s requested over MQTT", mode)
+		err = switchGPU(b.ctx, mode)
+		recordAudit(auditActor{via: "mqtt", uid: -1}, []string{"switch", mode.String()}, err)
+	}
+	if err != nil {
+		log.Error().Msgf("mqtt switch to %q: %v", name, err)
+	}
+	b.refresh()
+}
+
+// refresh publishes the active and pending mode when they changed.
+func (b *mqttBridge) refresh() {
+	s := readBarState(b.ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sent && s == b.last {
+		return
+	}
+	b.publish("state", orUnknown(s.pending))
+	b.publish("active", orUnknown(s.active))
+	b.last, b.sent = s, true
+}
+
+func (b *mqttBridge) publish(sub, payload string) {
+	if !b.client.IsConnected() {
+		return
+	}
+	t := b.client.Publish(b.opts.topic+"/"+sub, 1, true, payload)
+	if !t.WaitTimeout(5 * time.Second) {
+		log.Warn().Msgf("mqtt publish %s timed out", sub)
+	} else if err := t.Error(); err != nil {
+		log.Warn().Msgf("mqtt publish %s: %v", sub, err)
+	}
+}
+
+// mqttDiscovery returns the retained Home Assistant discovery messages, keyed
+// by topic. An empty discoveryPrefix disables discovery.
+func mqttDiscovery(node, topic, discoveryPrefix string, modes []string) map[string][]byte {
+	if discoveryPrefix == "" {
+		return nil
+	}
+	device := map[string]any{
+		"identifiers":  []string{"msi-gpu-switcher-" + node},
+		"name":         node,
+		"manufacturer": "MSI",
+		"model":        currentQuirkName(),
+	}
+	availability := topic + "/availability"
+	configs := map[string]map[string]any{
+		fmt.Sprintf("%s/select/%s/gpu_mode/config", discoveryPrefix, node): {
+			"name":               "GPU mode (next boot)",
+			"unique_id":          node + "_gpu_mode",
+			"state_topic":        topic + "/state",
+			"command_topic":      topic + "/set",
+			"options":            modes,
+			"availability_topic": availability,
+			"icon":               "mdi:expansion-card",
+			"device":             device,
+		},
+		fmt.Sprintf("%s/sensor/%s/gpu_active_mode/config", discoveryPrefix, node): {
+			"name":               "GPU mode (active)",
+			"unique_id":          node + "_gpu_active_mode",
+			"state_topic":        topic + "/active",
+			"availability_topic": availability,
+			"icon":               "mdi:expansion-card",
+			"device":             device,
+		},
+	}
+	out := make(map[string][]byte, len(configs))
+	for t, c := range configs {
+		payload, _ := json.Marshal(c)
+		out[t] = payload
+	}
+	return out
+}
+
+func currentQuirkName() string {
+	q, _ := detectQuirk()
+	return q.name
+}
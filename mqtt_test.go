@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMqttDiscovery(t *testing.T) {
+	if got := mqttDiscovery("laptop", "msi-gpu-switcher/laptop", "", []string{"hybrid"}); got != nil {
+		t.Fatalf("expected no discovery without a prefix, got %v", got)
+	}
+
+	msgs := mqttDiscovery("laptop", "msi-gpu-switcher/laptop", "homeassistant", []string{"hybrid", "discrete"})
+	raw, ok := msgs["homeassistant/select/laptop/gpu_mode/config"]
+	if !ok {
+		t.Fatalf("missing select config in %v", msgs)
+	}
+	var cfg struct {
+		CommandTopic string   `json:"command_topic"`
+		StateTopic   string   `json:"state_topic"`
+		Options      []string `json:"options"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if cfg.CommandTopic != "msi-gpu-switcher/laptop/set" || cfg.StateTopic != "msi-gpu-switcher/laptop/state" || len(cfg.Options) != 2 {
+		t.Fatalf("unexpected select config: %+v", cfg)
+	}
+	if _, ok := msgs["homeassistant/sensor/laptop/gpu_active_mode/config"]; !ok {
+		t.Fatalf("missing sensor config")
+	}
+}
+
+func TestMqttNodeID(t *testing.T) {
+	if id := mqttNodeInvalid.ReplaceAllString("my.laptop local", "_"); id != "my_laptop_local" {
+		t.Fatalf("unexpected node id %q", id)
+	}
+}
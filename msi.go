@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// Where MSI firmware keeps the GPU mode, the same whichever OS writes it.
+
+// EC registers: the MUX bit routes the panel, the switch register makes the
+// EC pick up a new UEFI mode.
+const (
+	ecMuxOffset    = 0x2e
+	ecMuxMask      = 0x40
+	ecSwitchOffset = 0xd1
+	ecSwitchMask0  = 0x01
+	ecSwitchMask1  = 0x02
+)
+
+const msiVendorGuid = "DD96BAAF-145E-4F56-B1CF-193256298E99"
+
+// The variable holding the GPU mode. Firmwares that use a different variable
+// override these via flags or config. The mode byte's offset comes from the
+// variable's layout unless uefiModeByte forces one.
+var (
+	uefiVarName  = "MsiDCVarData"
+	uefiVarGuid  = msiVendorGuid
+	uefiModeByte = -1
+)
+
+// muxValue is the EC MUX register with the panel routed as discrete says.
+func muxValue(value byte, discrete bool) byte {
+	if discrete {
+		return value | ecMuxMask
+	}
+	return value &^ ecMuxMask
+}
+
+// switchValue is the EC switch register set to apply the UEFI mode.
+func switchValue(value byte) byte {
+	return value&^(ecSwitchMask0|ecSwitchMask1) | ecSwitchMask0
+}
+
+func isGuid(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package main
+
+import "fmt"
+
+// uefiField is one byte of MsiDCVarData with a known meaning.
+type uefiField struct {
+	offset int
+	name   string
+	values map[byte]string
+}
+
+// msiDCSchema lists the fields of MsiDCVarData whose meaning is known. Only
+// the GPU mode byte has been confirmed so far; every other byte is reported
+// as unknown rather than guessed at. The mode byte is where data's layout
+// puts it, or where the first layout does when data matches none.
+func msiDCSchema(data []byte) []uefiField {
+	offset := uefiLayouts[0].mode
+	if v, err := parseMsiDC(data); err == nil {
+		offset = v.offset
+	} else if uefiModeByte >= 0 {
+		offset = uefiModeByte
+	}
+	return []uefiField{
+		{offset: offset, name: "gpu_mode", values: modeValues()},
+	}
+}
+
+func modeValues() map[byte]string {
+	values := map[byte]string{}
+	for _, m := range supportedModes() {
+		values[byte(m)] = m.String()
+	}
+	return values
+}
+
+type decodedField struct {
+	offset  int
+	value   byte
+	name    string
+	meaning string
+}
+
+// decodeMsiDC decodes every byte of data against msiDCSchema and returns
+// warnings for anything that does not look like a structure we would write.
+func decodeMsiDC(attrs uint32, data []byte) ([]decodedField, []string) {
+	schema := msiDCSchema(data)
+	known := map[int]uefiField{}
+	for _, f := range schema {
+		known[f.offset] = f
+	}
+
+	var warnings []string
+	if attrs&uefiDefaultAttrs != uefiDefaultAttrs {
+		warnings = append(warnings, fmt.Sprintf("attributes %s lack NV|BS|RT", efiAttrString(attrs)))
+	}
+	if len(data) != uefiDefaultSize {
+		warnings = append(warnings, fmt.Sprintf("size %d bytes differs from the %d bytes seen on tested models", len(data), uefiDefaultSize))
+	}
+
+	fields := make([]decodedField, 0, len(data))
+	for i, v := range data {
+		d := decodedField{offset: i, value: v, name: "unknown"}
+		if f, ok := known[i]; ok {
+			d.name = f.name
+			if meaning, ok := f.values[v]; ok {
+				d.meaning = meaning
+			} else {
+				d.meaning = "unrecognized"
+				warnings = append(warnings, fmt.Sprintf("%s byte[%d]=0x%02x is not a known value", f.name, i, v))
+			}
+		}
+		fields = append(fields, d)
+	}
+	for _, f := range schema {
+		if f.offset >= len(data) {
+			warnings = append(warnings, fmt.Sprintf("%s byte[%d] is outside the %d byte variable", f.name, f.offset, len(data)))
+		}
+	}
+	return fields, warnings
+}
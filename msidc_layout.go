@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// uefiDefaultSize is the data size of MsiDCVarData as seen on tested models.
+const uefiDefaultSize = 4
+
+// uefiLayout is one known shape of the GPU mode variable. fix recomputes any
+// derived fields (length, checksum) after the mode byte was changed.
+type uefiLayout struct {
+	name string
+	// version numbers the layouts in the order they were added; status
+	// --json reports it so bug reports say which parser read the variable.
+	version int
+	// mode is the offset of the GPU mode byte.
+	mode int
+	// span is the range [lo, hi) of offsets the mode byte may be moved to
+	// with --uefi-mode-byte in an n byte variable; the rest is structure.
+	span   func(n int) (lo, hi int)
+	detect func(data []byte) bool
+	fix    func(data []byte)
+}
+
+// uefiLayouts are tried in order; the first whose detect matches wins.
+var uefiLayouts = []uefiLayout{
+	{
+		// Plain 4-byte variable without derived fields, as on the tested models.
+		name:    "plain",
+		version: 1,
+		mode:    1,
+		span:    func(n int) (int, int) { return 0, n },
+		detect:  func(data []byte) bool { return len(data) == uefiDefaultSize },
+	},
+	{
+		// Length-prefixed variant: byte 0 holds the data length and the last
+		// byte is an 8-bit checksum making all bytes sum to zero.
+		name:    "sum8",
+		version: 2,
+		mode:    1,
+		span:    func(n int) (int, int) { return 1, n - 1 },
+		detect: func(data []byte) bool {
+			return len(data) >= 3 && int(data[0]) == len(data) && sum8(data) == 0
+		},
+		fix: func(data []byte) {
+			data[len(data)-1] = 0
+			data[len(data)-1] = -sum8(data)
+		},
+	},
+}
+
+// uefiLayoutName forces a layout by name instead of detecting it.
+var uefiLayoutName string
+
+func sum8(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+func layoutNames() string {
+	names := make([]string, 0, len(uefiLayouts))
+	for _, l := range uefiLayouts {
+		names = append(names, l.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// detectUefiLayout identifies data's layout, or fails so callers refuse to
+// write a structure the firmware might discard or reset.
+func detectUefiLayout(data []byte) (uefiLayout, error) {
+	if uefiLayoutName != "" {
+		for _, l := range uefiLayouts {
+			if l.name == uefiLayoutName {
+				return l, nil
+			}
+		}
+		return uefiLayout{}, fmt.Errorf("unknown uefi layout %q (known: %s)", uefiLayoutName, layoutNames())
+	}
+	for _, l := range uefiLayouts {
+		if l.detect(data) {
+			return l, nil
+		}
+	}
+	return uefiLayout{}, fmt.Errorf("unknown %s layout (%d bytes); refusing to use it (force one of %s with --uefi-layout)",
+		uefiVarName, len(data), layoutNames())
+}
+
+// msiDCVar is the GPU mode variable parsed against its layout.
+type msiDCVar struct {
+	layout uefiLayout
+	// offset is where the mode byte is: the layout's, or --uefi-mode-byte.
+	offset int
+	data   []byte
+}
+
+// parseMsiDC finds data's layout and checks that the mode byte lies inside
+// the variable and outside the layout's derived fields.
+func parseMsiDC(data []byte) (msiDCVar, error) {
+	layout, err := detectUefiLayout(data)
+	if err != nil {
+		return msiDCVar{}, err
+	}
+	offset := layout.mode
+	if uefiModeByte >= 0 {
+		offset = uefiModeByte
+	}
+	if lo, hi := layout.span(len(data)); offset < lo || offset >= hi {
+		return msiDCVar{}, fmt.Errorf("%s layout %s: mode byte %d is outside bytes %d-%d of the %d byte variable",
+			uefiVarName, layout.name, offset, lo, hi-1, len(data))
+	}
+	return msiDCVar{layout: layout, offset: offset, data: data}, nil
+}
+
+func (v msiDCVar) modeByte() byte {
+	return v.data[v.offset]
+}
+
+// mode returns the mode the variable requests for the next boot.
+func (v msiDCVar) mode() (gpuMode, error) {
+	mode, ok := modeFromByte(v.modeByte())
+	if !ok {
+		return 0, fmt.Errorf("uefi mode byte has unknown value 0x%02x", v.modeByte())
+	}
+	return mode, nil
+}
+
+// setModeByte changes the mode byte and recomputes the derived fields.
+func (v msiDCVar) setModeByte(value byte) {
+	v.data[v.offset] = value
+	if v.layout.fix != nil {
+		v.layout.fix(v.data)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// msiDCDumps returns the variable data of testdata/msidc, which are efivarfs
+// dumps: 4 bytes of attributes, then the data.
+func msiDCDumps(t testing.TB) map[string][]byte {
+	paths, err := filepath.Glob(filepath.Join("testdata", "msidc", "*.bin"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no dumps: %v", err)
+	}
+	dumps := map[string][]byte{}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		dumps[filepath.Base(path)] = raw[4:]
+	}
+	return dumps
+}
+
+func TestParseMsiDCDumps(t *testing.T) {
+	for name, want := range map[string]struct {
+		layout string
+		mode   gpuMode
+	}{
+		"plain-discrete.bin": {"plain", modeDiscrete},
+		"plain-hybrid.bin":   {"plain", modeHybrid},
+		"sum8-hybrid.bin":    {"sum8", modeHybrid},
+		"unknown-6.bin":      {},
+	} {
+		data := msiDCDumps(t)[name]
+		v, err := parseMsiDC(data)
+		if want.layout == "" {
+			if err == nil {
+				t.Fatalf("%s: expected unknown layout, got %s", name, v.layout.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if mode, err := v.mode(); v.layout.name != want.layout || err != nil || mode != want.mode {
+			t.Fatalf("%s: got %s %v %v", name, v.layout.name, mode, err)
+		}
+	}
+}
+
+func TestDetectUefiLayout(t *testing.T) {
+	originalName := uefiLayoutName
+	t.Cleanup(func() { uefiLayoutName = originalName })
+
+	if l, err := detectUefiLayout([]byte{0x01, 0x01, 0x00, 0x00}); err != nil || l.name != "plain" {
+		t.Fatalf("plain: %v %v", l.name, err)
+	}
+
+	data := []byte{0x06, 0x00, 0x10, 0x20, 0x30, 0x00}
+	data[5] = -sum8(data)
+	l, err := detectUefiLayout(data)
+	if err != nil || l.name != "sum8" {
+		t.Fatalf("sum8: %v %v", l.name, err)
+	}
+	data[l.mode] = 1
+	l.fix(data)
+	if sum8(data) != 0 {
+		t.Fatalf("checksum not recomputed: % x", data)
+	}
+
+	if _, err := detectUefiLayout([]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}); err == nil {
+		t.Fatalf("expected unknown layout error")
+	}
+
+	uefiLayoutName = "plain"
+	if l, err := detectUefiLayout([]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}); err != nil || l.name != "plain" {
+		t.Fatalf("forced: %v %v", l.name, err)
+	}
+	uefiLayoutName = "bogus"
+	if _, err := detectUefiLayout(nil); err == nil {
+		t.Fatalf("expected error for unknown forced layout")
+	}
+}
+
+func TestParseMsiDCBounds(t *testing.T) {
+	originalName, originalByte := uefiLayoutName, uefiModeByte
+	t.Cleanup(func() { uefiLayoutName, uefiModeByte = originalName, originalByte })
+
+	sum := []byte{0x06, 0x00, 0x10, 0x20, 0x30, 0x00}
+	sum[5] = -sum8(sum)
+	for _, offset := range []int{0, 5, 6} {
+		uefiModeByte = offset
+		if _, err := parseMsiDC(sum); err == nil {
+			t.Fatalf("sum8: expected offset %d to be refused", offset)
+		}
+	}
+	uefiModeByte = 4
+	if v, err := parseMsiDC(sum); err != nil || v.offset != 4 {
+		t.Fatalf("sum8 offset 4: %+v %v", v, err)
+	}
+
+	// A forced layout still has to fit the data.
+	uefiModeByte, uefiLayoutName = -1, "plain"
+	if _, err := parseMsiDC([]byte{0x01}); err == nil {
+		t.Fatalf("expected a 1 byte variable to be refused")
+	}
+}
+
+func FuzzParseMsiDC(f *testing.F) {
+	for _, data := range msiDCDumps(f) {
+		f.Add(data, byte(modeHybrid))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, value byte) {
+		v, err := parseMsiDC(data)
+		if err != nil {
+			return
+		}
+		if v.offset < 0 || v.offset >= len(data) {
+			t.Fatalf("offset %d outside %d bytes", v.offset, len(data))
+		}
+		before := bytes.Clone(data)
+		v.setModeByte(value)
+		again, err := parseMsiDC(data)
+		if err != nil {
+			t.Fatalf("% x no longer parses after setting 0x%02x (was % x): %v", data, value, before, err)
+		}
+		if again.layout.name != v.layout.name || again.modeByte() != value {
+			t.Fatalf("% x parsed as %s byte 0x%02x, want %s 0x%02x", data, again.layout.name, again.modeByte(), v.layout.name, value)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestDecodeMsiDC(t *testing.T) {
+	fields, warnings := decodeMsiDC(uefiDefaultAttrs, []byte{0x01, 0x01, 0x00, 0x00})
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(fields))
+	}
+	if f := fields[1]; f.name != "gpu_mode" || f.meaning != "discrete" {
+		t.Fatalf("unexpected mode field: %+v", f)
+	}
+	if fields[0].name != "unknown" {
+		t.Fatalf("expected byte 0 unknown, got %+v", fields[0])
+	}
+}
+
+func TestDecodeMsiDCWarnings(t *testing.T) {
+	_, warnings := decodeMsiDC(efiAttrBootService, []byte{0x00, 0x07})
+	if len(warnings) != 3 {
+		t.Fatalf("expected attrs, size and value warnings, got %v", warnings)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// desktopNotify shows a notification through org.freedesktop.Notifications
+// on the session bus.
+func desktopNotify(ctx context.Context, summary, body string) error {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("connect session bus failed: %w", err)
+	}
+	defer conn.Close()
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.CallWithContext(ctx, "org.freedesktop.Notifications.Notify", 0,
+		"msi-gpu-switcher", uint32(0), "video-display", summary, body,
+		[]string{}, map[string]dbus.Variant{}, int32(-1))
+	if call.Err != nil {
+		return fmt.Errorf("notify failed: %w", call.Err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// nvidiaDetails is what the NVIDIA driver reports about a GPU through
+// /proc/driver/nvidia. It needs neither nvidia-smi nor libnvidia-ml, and
+// reading it does not wake a runtime-suspended GPU. Utilization is only
+// available through NVML and is left out for that reason.
+type nvidiaDetails struct {
+	DriverVersion string `json:"driver_version,omitempty"`
+	Model         string `json:"model,omitempty"`
+	UUID          string `json:"uuid,omitempty"`
+	VideoBIOS     string `json:"video_bios,omitempty"`
+	RuntimeD3     string `json:"runtime_d3,omitempty"`
+	VideoMemory   string `json:"video_memory,omitempty"`
+	D3ColdAllowed bool   `json:"d3cold_allowed"`
+	Persistence   bool   `json:"persistence"`
+}
+
+func nvidiaProcDir() string {
+	return filepath.Join(procRoot, "driver", "nvidia")
+}
+
+// readNvidiaDetails returns nil when the proprietary or open NVIDIA kernel
+// module is not driving addr.
+func readNvidiaDetails(addr string) *nvidiaDetails {
+	gpuDir := filepath.Join(nvidiaProcDir(), "gpus", addr)
+	info := readKeyValues(filepath.Join(gpuDir, "information"))
+	if info == nil {
+		return nil
+	}
+	power := readKeyValues(filepath.Join(gpuDir, "power"))
+	return &nvidiaDetails{
+		DriverVersion: nvidiaDriverVersion(),
+		Model:         info["Model"],
+		UUID:          info["GPU UUID"],
+		VideoBIOS:     info["Video BIOS"],
+		RuntimeD3:     power["Runtime D3 status"],
+		VideoMemory:   power["Video Memory"],
+		D3ColdAllowed: readFirstLine(filepath.Join(pciRoot, addr, "d3cold_allowed")) == "1",
+		Persistence:   processRunning("nvidia-persistenced"),
+	}
+}
+
+// nvidiaDriverVersion extracts the version from a line such as
+// "NVRM version: NVIDIA UNIX Open Kernel Module for x86_64  570.124.04  Release Build ...".
+func nvidiaDriverVersion() string {
+	line := readFirstLine(filepath.Join(nvidiaProcDir(), "version"))
+	_, rest, ok := strings.Cut(line, "Module")
+	if !ok {
+		return ""
+	}
+	for _, field := range strings.Fields(rest) {
+		if unicode.IsDigit(rune(field[0])) && strings.Contains(field, ".") {
+			return field
+		}
+	}
+	return ""
+}
+
+// readKeyValues parses "Key: value" lines, ignoring indentation and lines
+// without a value. It returns nil if the file cannot be read.
+func readKeyValues(path string) map[string]string {
+	f, err := hostfs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			values[strings.TrimSpace(key)] = value
+		}
+	}
+	return values
+}
+
+// processRunning reports whether a process with the given comm exists.
+func processRunning(comm string) bool {
+	entries, err := hostfs.ReadDir(procRoot)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.IsDir() && readFirstLine(filepath.Join(procRoot, e.Name(), "comm")) == comm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var modprobeDir = "/etc/modprobe.d"
+
+const nvidiaPMFile = "msi-gpu-switcher-nvidia.conf"
+
+// managePersistenced enables nvidia-persistenced for discrete mode and
+// disables it for the others, where it would keep the dGPU awake.
+var managePersistenced bool
+
+// nvidiaPMConfig is the modprobe.d snippet that lets the NVIDIA driver put an
+// idle dGPU into D3cold (fine-grained dynamic power management). Turing and
+// newer default to it on notebooks only with recent drivers.
+const nvidiaPMConfig = `# Generated by msi-gpu-switcher.
+# Let the NVIDIA driver power the dGPU off when idle in hybrid mode.
+options nvidia NVreg_DynamicPowerManagement=0x02
+`
+
+// systemctl runs systemctl; tests replace it.
+var systemctl = func(ctx context.Context, args ...string) error {
+	out, err := runCommand(ctx, "systemctl", args...)
+	if err != nil {
+		return fmt.Errorf("systemctl %v: %w: %s", args, err, out)
+	}
+	return nil
+}
+
+// applyPersistenced sets nvidia-persistenced up for the mode the machine
+// boots into next. Failures are logged, as the switch itself succeeded.
+func applyPersistenced(ctx context.Context, mode gpuMode) {
+	if !managePersistenced {
+		return
+	}
+	action := "disable"
+	if mode == modeDiscrete {
+		action = "enable"
+	}
+	if err := systemctl(ctx, action, "nvidia-persistenced.service"); err != nil {
+		log.Warn().Msgf("%s nvidia-persistenced failed: %v", action, err)
+		return
+	}
+	log.Info().Msgf("nvidia-persistenced %sd for %s mode", action, mode)
+}
+
+// nvidiaDynamicPM returns the driver's DynamicPowerManagement setting from
+// /proc/driver/nvidia/params, or "" if the driver is not loaded.
+func nvidiaDynamicPM() string {
+	return readKeyValues(filepath.Join(nvidiaProcDir(), "params"))["DynamicPowerManagement"]
+}
+
+func nvidiaPMCmd() *cobra.Command {
+	var write bool
+	cmd := &cobra.Command{
+		Use:   "nvidia-pm",
+		Short: "Print or install the modprobe.d settings for NVIDIA dynamic power management",
+		Long: "Without --write, print the modprobe.d snippet enabling NVreg_DynamicPowerManagement=0x02.\n" +
+			"With --write, install it to " + filepath.Join(modprobeDir, nvidiaPMFile) + "; rebuild the\n" +
+			"initramfs if it loads nvidia, then reboot.",
+		Args: cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if pm := nvidiaDynamicPM(); pm != "" {
+				log.Info().Msgf("current DynamicPowerManagement: %s", pm)
+			}
+			if !write {
+				fmt.Print(nvidiaPMConfig)
+				return nil
+			}
+			requireRoot()
+			path := filepath.Join(modprobeDir, nvidiaPMFile)
+			if err := os.MkdirAll(modprobeDir, 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(nvidiaPMConfig), 0o644); err != nil {
+				return err
+			}
+			log.Info().Msgf("wrote %s; takes effect when the nvidia module is next loaded", path)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&write, "write", false, "install the snippet to "+modprobeDir)
+	return cmd
+}
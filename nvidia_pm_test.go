@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestApplyPersistenced(t *testing.T) {
+	originalSystemctl, originalManage := systemctl, managePersistenced
+	t.Cleanup(func() { systemctl, managePersistenced = originalSystemctl, originalManage })
+
+	var calls [][]string
+	systemctl = func(_ context.Context, args ...string) error {
+		calls = append(calls, args)
+		return nil
+	}
+
+	managePersistenced = false
+	applyPersistenced(context.Background(), modeDiscrete)
+	if len(calls) != 0 {
+		t.Fatalf("systemctl called while disabled: %v", calls)
+	}
+
+	managePersistenced = true
+	applyPersistenced(context.Background(), modeDiscrete)
+	applyPersistenced(context.Background(), modeHybrid)
+	want := [][]string{
+		{"enable", "nvidia-persistenced.service"},
+		{"disable", "nvidia-persistenced.service"},
+	}
+	if !slices.EqualFunc(calls, want, slices.Equal[[]string]) {
+		t.Fatalf("systemctl calls = %v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadNvidiaDetails(t *testing.T) {
+	originalProc, originalPCI := procRoot, pciRoot
+	procRoot, pciRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { procRoot, pciRoot = originalProc, originalPCI })
+
+	const addr = "0000:01:00.0"
+	for name, value := range map[string]string{
+		filepath.Join(procRoot, "driver", "nvidia", "version"):                   "NVRM version: NVIDIA UNIX Open Kernel Module for x86_64  570.124.04  Release Build  (nixbld@localhost)  Tue Mar  4 2025",
+		filepath.Join(procRoot, "driver", "nvidia", "gpus", addr, "information"): "Model: \t\t NVIDIA GeForce RTX 4070 Laptop GPU\nIRQ:   \t\t 185\nGPU UUID: \t GPU-0c1f\nVideo BIOS: \t 95.06.1f.00.8a\n",
+		filepath.Join(procRoot, "driver", "nvidia", "gpus", addr, "power"):       "Runtime D3 status:          Enabled (fine-grained)\nVideo Memory:               Off\n\nGPU Hardware Support:\n Video Memory Self Refresh: Supported\n",
+		filepath.Join(procRoot, "812", "comm"):                                   "nvidia-persistenced",
+		filepath.Join(pciRoot, addr, "d3cold_allowed"):                           "1",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(name, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	n := readNvidiaDetails(addr)
+	want := nvidiaDetails{
+		DriverVersion: "570.124.04",
+		Model:         "NVIDIA GeForce RTX 4070 Laptop GPU",
+		UUID:          "GPU-0c1f",
+		VideoBIOS:     "95.06.1f.00.8a",
+		RuntimeD3:     "Enabled (fine-grained)",
+		VideoMemory:   "Off",
+		D3ColdAllowed: true,
+		Persistence:   true,
+	}
+	if n == nil || *n != want {
+		t.Fatalf("readNvidiaDetails = %+v, want %+v", n, want)
+	}
+	if readNvidiaDetails("0000:06:00.0") != nil {
+		t.Fatal("expected nil for a GPU without NVIDIA procfs entries")
+	}
+}
@@ -0,0 +1,145 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+const onceFile = "once.json"
+
+// onceSwitch is a switch made with --once: Staged is booted once, then the
+// boot service puts Revert back. BootID tells the boot the switch was made
+// in from the one it was made for.
+type onceSwitch struct {
+	Staged string `json:"staged"`
+	Revert string `json:"revert"`
+	BootID string `json:"boot_id"`
+}
+
+func oncePath() string {
+	return filepath.Join(stateDir, onceFile)
+}
+
+func bootID() string {
+	return readFirstLine(filepath.Join(procRoot, "sys", "kernel", "random", "boot_id"))
+}
+
+func loadOnce() (onceSwitch, bool, error) {
+	var o onceSwitch
+	raw, err := readFile(oncePath())
+	// An empty file is a revert cleared after dropping privileges.
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(raw) == 0 {
+		return o, false, nil
+	}
+	if err != nil {
+		return o, false, err
+	}
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return o, false, fmt.Errorf("parse %s: %w", oncePath(), err)
+	}
+	return o, true, nil
+}
+
+func saveOnce(o onceSwitch) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(oncePath(), append(raw, '\n'), 0o644)
+}
+
+// clearOnce drops a pending revert; any switch made after a --once one
+// replaces it.
+func clearOnce() {
+	if err := removeFile(oncePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Msgf("clear one-time switch failed: %v", err)
+	}
+}
+
+// switchOnce stages mode for the next boot only. The mode to go back to is
+// the one stored before, i.e. what the machine would otherwise boot into.
+func switchOnce(ctx context.Context, mode gpuMode) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if err := confirmUnknownModel(); err != nil {
+		return err
+	}
+	if err := confirmFirmware(ctx); err != nil {
+		return err
+	}
+	revert, err := readUefiGpuMode(ctx)
+	if err != nil {
+		if revert, err = activeMode(); err != nil {
+			return fmt.Errorf("cannot tell which mode to revert to: %w", err)
+		}
+	}
+	if revert == mode {
+		return fmt.Errorf("%s is already the mode for the next boot", mode)
+	}
+	id := bootID()
+	if id == "" {
+		return errors.New("cannot read the boot ID to tell this boot from the next")
+	}
+	if err := switchGPU(ctx, mode); err != nil {
+		return err
+	}
+	o := onceSwitch{Staged: mode.String(), Revert: revert.String(), BootID: id}
+	if err := saveOnce(o); err != nil {
+		return fmt.Errorf("record one-time switch failed: %w", err)
+	}
+	log.Info().Msgf("%s is staged for the next boot only; the boot after returns to %s", mode, revert)
+	return nil
+}
+
+// revertOnce switches back after the boot a one-time switch was made for.
+// It does nothing in the boot the switch was made in.
+func revertOnce(ctx context.Context) error {
+	o, found, err := loadOnce()
+	if err != nil || !found {
+		return err
+	}
+	if o.BootID == bootID() {
+		log.Debug().Msgf("one-time %s switch waits for a reboot", o.Staged)
+		return nil
+	}
+	mode, err := parseMode(o.Revert)
+	if err != nil {
+		clearOnce()
+		return fmt.Errorf("one-time switch: %w", err)
+	}
+	log.Info().Msgf("booted the one-time %s mode; reverting to %s for the next boot", o.Staged, mode)
+	// Nobody is there to confirm anything at boot, and whatever already
+	// renders on the dGPU keeps running until the next reboot anyway.
+	return switchGPUWith(ctx, mode, switchOptions{allowBusy: true, revert: true})
+}
+
+// printOnce tells status about a pending one-time switch.
+func printOnce() {
+	o, found, err := loadOnce()
+	if err != nil {
+		log.Debug().Msgf("load one-time switch failed: %v", err)
+		return
+	}
+	if !found {
+		return
+	}
+	outln("")
+	outln("One-time switch:")
+	if o.BootID == bootID() {
+		outf("  %s for the next boot only, then back to %s", o.Staged, o.Revert)
+	} else {
+		outf("  booted %s; %s is restored when the boot service runs", o.Staged, o.Revert)
+	}
+}
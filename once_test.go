@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRevertOnceWaitsForReboot(t *testing.T) {
+	dir := t.TempDir()
+	originalState, originalProc, originalLock, originalEC := stateDir, procRoot, lockPath, ecIOPath
+	stateDir, procRoot, lockPath, ecIOPath = filepath.Join(dir, "state"), filepath.Join(dir, "proc"), filepath.Join(dir, "test.lock"), filepath.Join(dir, "missing-ec")
+	t.Cleanup(func() { stateDir, procRoot, lockPath, ecIOPath = originalState, originalProc, originalLock, originalEC })
+
+	idPath := filepath.Join(procRoot, "sys", "kernel", "random", "boot_id")
+	if err := os.MkdirAll(filepath.Dir(idPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	setBoot := func(id string) {
+		if err := os.WriteFile(idPath, []byte(id+"\n"), 0o644); err != nil {
+			t.Fatalf("write boot_id: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	setBoot("first")
+	if err := revertOnce(ctx); err != nil {
+		t.Fatalf("revertOnce without state: %v", err)
+	}
+	if err := saveOnce(onceSwitch{Staged: "discrete", Revert: "hybrid", BootID: "first"}); err != nil {
+		t.Fatalf("saveOnce: %v", err)
+	}
+	if err := revertOnce(ctx); err != nil {
+		t.Fatalf("expected no revert in the boot the switch was made in, got %v", err)
+	}
+
+	// The next boot tries to switch back; without an EC that fails and the
+	// revert stays pending for the boot after.
+	setBoot("second")
+	if err := revertOnce(ctx); err == nil {
+		t.Fatal("expected the revert to try a switch and fail without an EC")
+	}
+	if _, found, err := loadOnce(); err != nil || !found {
+		t.Fatalf("expected the revert to stay pending, found=%v err=%v", found, err)
+	}
+
+	clearOnce()
+	if _, found, _ := loadOnce(); found {
+		t.Fatal("expected clearOnce to drop the pending revert")
+	}
+}
+
+func TestRevertOnceWithBusyDgpu(t *testing.T) {
+	root := switchFixture(t)
+	busyDgpu(t, root)
+	ctx := context.Background()
+	if err := writeUefiVar(ctx, uefiDefaultAttrs, []byte{0, byte(modeDiscrete), 0, 0}); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	idPath := filepath.Join(root, procRoot, "sys", "kernel", "random", "boot_id")
+	if err := os.MkdirAll(filepath.Dir(idPath), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(idPath, []byte("second\n"), 0o644); err != nil {
+		t.Fatalf("write boot_id: %v", err)
+	}
+	if err := saveOnce(onceSwitch{Staged: "discrete", Revert: "hybrid", BootID: "first"}); err != nil {
+		t.Fatalf("saveOnce: %v", err)
+	}
+
+	if err := switchGPU(ctx, modeHybrid); err == nil {
+		t.Fatal("expected a plain switch off the busy dGPU to be refused")
+	}
+	if err := revertOnce(ctx); err != nil {
+		t.Fatalf("revertOnce: %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("readUefiGpuMode = %v %v, want hybrid", mode, err)
+	}
+	if _, found, _ := loadOnce(); found {
+		t.Fatal("expected the revert to be done")
+	}
+}
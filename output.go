@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdout receives what a command reports, as opposed to the log, which goes
+// to stderr. Status and the listing commands write here so their output can
+// be piped; tests swap it to capture that output.
+var stdout io.Writer = os.Stdout
+
+func outf(format string, args ...any) {
+	fmt.Fprintf(stdout, format+"\n", args...)
+}
+
+func outln(s string) {
+	fmt.Fprintln(stdout, s)
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// captureOutput swaps stdout and the logger for buffers.
+func captureOutput(t *testing.T) (out, logged *bytes.Buffer) {
+	t.Helper()
+	originalOut, originalLog := stdout, log.Logger
+	t.Cleanup(func() { stdout, log.Logger = originalOut, originalLog })
+	out, logged = &bytes.Buffer{}, &bytes.Buffer{}
+	stdout = out
+	log.Logger = zerolog.New(logged)
+	return out, logged
+}
+
+func TestHistoryGoesToStdout(t *testing.T) {
+	original := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = original })
+	out, logged := captureOutput(t)
+
+	if err := showHistory(0); err != nil {
+		t.Fatalf("showHistory: %v", err)
+	}
+	if out.Len() != 0 || !strings.Contains(logged.String(), "no switches recorded") {
+		t.Fatalf("expected the empty note in the log only, stdout %q, log %q", out, logged)
+	}
+	logged.Reset()
+
+	if err := appendHistory(historyEntry{Time: time.Now(), Mode: "discrete", Backend: "uefi+ec", Result: "ok"}); err != nil {
+		t.Fatalf("appendHistory: %v", err)
+	}
+	if err := showHistory(0); err != nil {
+		t.Fatalf("showHistory: %v", err)
+	}
+	if !strings.Contains(out.String(), "discrete") || !strings.HasSuffix(out.String(), "ok\n") {
+		t.Fatalf("stdout = %q", out)
+	}
+	if logged.Len() != 0 {
+		t.Fatalf("expected nothing in the log, got %q", logged)
+	}
+}
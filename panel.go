@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// panelOwner is the GPU currently driving the internal panel, i.e. the MUX
+// position the OS actually sees, as opposed to what the EC or UEFI variable
+// request for the next boot.
+type panelOwner struct {
+	GPU       string `json:"gpu"`
+	Discrete  bool   `json:"discrete"`
+	Connector string `json:"connector,omitempty"`
+	// Source is "drm" for a connected internal connector, or "boot_vga"
+	// when no GPU exposes one (e.g. NVIDIA without nvidia-drm.modeset=1).
+	Source string `json:"source"`
+}
+
+func internalConnector(name string) bool {
+	for _, prefix := range []string{"eDP-", "LVDS-", "DSI-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectPanelOwner() (panelOwner, error) {
+	gpus, err := listGPUs()
+	if err != nil {
+		return panelOwner{}, err
+	}
+	discrete := map[string]bool{}
+	for _, g := range gpus {
+		discrete[g.addr] = g.discrete
+	}
+	if connectors, err := drmConnectors(); err == nil {
+		for _, c := range connectors {
+			if internalConnector(c.Name) && c.connected() && c.GPU != "" {
+				return panelOwner{GPU: c.GPU, Discrete: discrete[c.GPU], Connector: c.Name, Source: "drm"}, nil
+			}
+		}
+	}
+	for _, g := range gpus {
+		if g.bootVGA {
+			return panelOwner{GPU: g.addr, Discrete: g.discrete, Source: "boot_vga"}, nil
+		}
+	}
+	return panelOwner{}, errors.New("no GPU drives the internal panel")
+}
+
+func printPanelOwner() {
+	outln("")
+	outln("Internal panel:")
+	owner, err := detectPanelOwner()
+	if err != nil {
+		outf("  unknown (%v)", err)
+		return
+	}
+	via := owner.Source
+	if owner.Connector != "" {
+		via = owner.Connector + " via " + via
+	}
+	outf("  %s (%s, %s)", owner.GPU, gpuRole(owner.Discrete, false), via)
+}
+
+// warnPanelMismatch points out an EC MUX request the panel does not reflect
+// yet; the MUX only moves the panel on the next boot.
+func warnPanelMismatch(muxDiscrete bool) {
+	owner, err := detectPanelOwner()
+	if err != nil || owner.Discrete == muxDiscrete {
+		return
+	}
+	log.Warn().Msgf("  the panel is still driven by the %s GPU; the MUX takes effect after a reboot", gpuRole(owner.Discrete, false))
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectPanelOwner(t *testing.T) {
+	originalPCI, originalDRM := pciRoot, drmRoot
+	pciRoot, drmRoot = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { pciRoot, drmRoot = originalPCI, originalDRM })
+
+	write := func(path, value string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	const dgpu, igpu = "0000:01:00.0", "0000:06:00.0"
+	for addr, vendor := range map[string]string{dgpu: nvidiaVendor, igpu: "0x8086"} {
+		write(filepath.Join(pciRoot, addr, "class"), "0x030000")
+		write(filepath.Join(pciRoot, addr, "vendor"), vendor)
+	}
+	write(filepath.Join(pciRoot, igpu, "boot_vga"), "1")
+
+	owner, err := detectPanelOwner()
+	if err != nil || owner.GPU != igpu || owner.Discrete || owner.Source != "boot_vga" {
+		t.Fatalf("boot_vga fallback = %+v, %v", owner, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(drmRoot, "card0"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(pciRoot, dgpu), filepath.Join(drmRoot, "card0", "device")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	write(filepath.Join(drmRoot, "card0-eDP-1", "status"), "connected")
+
+	owner, err = detectPanelOwner()
+	if err != nil || owner.GPU != dgpu || !owner.Discrete || owner.Connector != "eDP-1" || owner.Source != "drm" {
+		t.Fatalf("drm owner = %+v, %v", owner, err)
+	}
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// pciIDsPaths are the usual locations of the pci.ids database shipped by
+// hwdata or pciutils.
+var pciIDsPaths = []string{
+	"/usr/share/hwdata/pci.ids",
+	"/usr/share/misc/pci.ids",
+	"/usr/share/pci.ids",
+	"/run/current-system/sw/share/hwdata/pci.ids",
+}
+
+// pciVendorFallback names the vendors found in MSI laptops when no pci.ids
+// is installed.
+var pciVendorFallback = map[string]string{
+	"10de": "NVIDIA Corporation",
+	"1002": "Advanced Micro Devices, Inc. [AMD/ATI]",
+	"8086": "Intel Corporation",
+}
+
+var (
+	pciNamesMu sync.Mutex
+	pciNames   = map[string]string{}
+)
+
+// pciName returns "Vendor Device" for the sysfs ids (e.g. "0x10de",
+// "0x28e0"), falling back to the hex ids for anything unknown.
+func pciName(vendor, device string) string {
+	vendor = strings.TrimPrefix(strings.ToLower(vendor), "0x")
+	device = strings.TrimPrefix(strings.ToLower(device), "0x")
+	key := vendor + ":" + device
+
+	pciNamesMu.Lock()
+	defer pciNamesMu.Unlock()
+	if name, ok := pciNames[key]; ok {
+		return name
+	}
+	vendorName, deviceName := lookupPCIIDs(vendor, device)
+	if vendorName == "" {
+		vendorName = pciVendorFallback[vendor]
+	}
+	var name string
+	switch {
+	case vendorName == "":
+		name = key
+	case deviceName == "":
+		name = vendorName + " [" + key + "]"
+	default:
+		name = vendorName + " " + deviceName
+	}
+	pciNames[key] = name
+	return name
+}
+
+// lookupPCIIDs scans the first readable pci.ids for vendor and device.
+func lookupPCIIDs(vendor, device string) (string, string) {
+	for _, path := range pciIDsPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return scanPCIIDs(bufio.NewScanner(f), vendor, device)
+	}
+	return "", ""
+}
+
+// scanPCIIDs parses the pci.ids format: vendors start at column 0, their
+// devices are indented by one tab, subsystems by two.
+func scanPCIIDs(scanner *bufio.Scanner, vendor, device string) (vendorName, deviceName string) {
+	inVendor := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] != '\t' {
+			if inVendor || strings.HasPrefix(line, "C ") {
+				// Next vendor or the device class section: done.
+				return vendorName, deviceName
+			}
+			if id, name, ok := splitPCIIDLine(line); ok && id == vendor {
+				vendorName, inVendor = name, true
+			}
+			continue
+		}
+		if !inVendor || strings.HasPrefix(line, "\t\t") {
+			continue
+		}
+		if id, name, ok := splitPCIIDLine(line[1:]); ok && id == device {
+			return vendorName, name
+		}
+	}
+	return vendorName, deviceName
+}
+
+func splitPCIIDLine(line string) (string, string, bool) {
+	id, name, ok := strings.Cut(line, "  ")
+	if !ok || len(id) != 4 {
+		return "", "", false
+	}
+	return strings.ToLower(id), strings.TrimSpace(name), true
+}
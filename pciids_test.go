@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPCIIDs = `# pci.ids test excerpt
+1002  Advanced Micro Devices, Inc. [AMD/ATI]
+	15bf  Phoenix1
+		1462 1234  Some MSI board
+10de  NVIDIA Corporation
+	2820  AD106M [GeForce RTX 4070 Max-Q / Mobile]
+	2860  AD106M [GeForce RTX 4070 Max-Q / Mobile]
+10df  Emulex Corporation
+	2860  Not a GPU
+C 03  Display controller
+`
+
+func TestPciName(t *testing.T) {
+	original, originalNames := pciIDsPaths, pciNames
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	if err := os.WriteFile(path, []byte(testPCIIDs), 0o644); err != nil {
+		t.Fatalf("write pci.ids: %v", err)
+	}
+	pciIDsPaths = []string{filepath.Join(t.TempDir(), "missing"), path}
+	pciNames = map[string]string{}
+	t.Cleanup(func() { pciIDsPaths, pciNames = original, originalNames })
+
+	for _, tc := range []struct{ vendor, device, want string }{
+		{"0x10de", "0x2860", "NVIDIA Corporation AD106M [GeForce RTX 4070 Max-Q / Mobile]"},
+		{"0x1002", "0x15bf", "Advanced Micro Devices, Inc. [AMD/ATI] Phoenix1"},
+		{"0x10de", "0xffff", "NVIDIA Corporation [10de:ffff]"},
+		{"0x1234", "0x5678", "1234:5678"},
+	} {
+		if got := pciName(tc.vendor, tc.device); got != tc.want {
+			t.Fatalf("pciName(%s, %s) = %q, want %q", tc.vendor, tc.device, got, tc.want)
+		}
+	}
+
+	pciIDsPaths = nil
+	pciNames = map[string]string{}
+	if got := pciName("0x8086", "0xa7a0"); got != "Intel Corporation [8086:a7a0]" {
+		t.Fatalf("expected the embedded vendor name, got %q", got)
+	}
+}
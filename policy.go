@@ -0,0 +1,422 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	powerSupplyRoot = "/sys/class/power_supply"
+	lidRoot         = "/proc/acpi/button/lid"
+)
+
+const recommendationFile = "recommendation.json"
+
+// powerState is what the automatic policies look at. battery is the lowest
+// battery capacity in percent, or -1 without a battery.
+type powerState struct {
+	onAC    bool
+	battery int
+}
+
+func readPowerState() powerState {
+	state := powerState{battery: -1}
+	entries, err := hostfs.ReadDir(powerSupplyRoot)
+	if err != nil {
+		return state
+	}
+	for _, e := range entries {
+		dir := filepath.Join(powerSupplyRoot, e.Name())
+		switch readFirstLine(filepath.Join(dir, "type")) {
+		case "Mains", "USB":
+			if readFirstLine(filepath.Join(dir, "online")) == "1" {
+				state.onAC = true
+			}
+		case "Battery":
+			// Peripherals such as mice report scope "Device".
+			if readFirstLine(filepath.Join(dir, "scope")) == "Device" {
+				continue
+			}
+			capacity, err := strconv.Atoi(readFirstLine(filepath.Join(dir, "capacity")))
+			if err == nil && (state.battery < 0 || capacity < state.battery) {
+				state.battery = capacity
+			}
+		}
+	}
+	return state
+}
+
+// policyInput is the machine state the policies decide on.
+type policyInput struct {
+	power     powerState
+	lidClosed bool
+	// docked means an external display is connected, directly or through a
+	// USB-C/Thunderbolt dock.
+	docked bool
+	// dgpuDisplay is an external display on a connector wired to the dGPU.
+	dgpuDisplay string
+}
+
+func readPolicyInput() policyInput {
+	in := policyInput{power: readPowerState(), lidClosed: lidClosed()}
+	connectors, err := drmConnectors()
+	if err != nil {
+		return in
+	}
+	for _, c := range connectors {
+		if !c.connected() || internalConnector(c.Name) {
+			continue
+		}
+		in.docked = true
+		if in.dgpuDisplay == "" && isDiscreteGPU(filepath.Join(pciRoot, c.GPU)) {
+			in.dgpuDisplay = c.Name
+		}
+	}
+	return in
+}
+
+// lidClosed reads the ACPI lid button, whose state file holds a line like
+// "state:      closed".
+func lidClosed() bool {
+	paths, _ := hostfs.Glob(filepath.Join(lidRoot, "*", "state"))
+	for _, path := range paths {
+		if strings.HasSuffix(readFirstLine(path), "closed") {
+			return true
+		}
+	}
+	return false
+}
+
+// batteryPolicy recommends discrete on AC and hybrid on battery below
+// threshold. Once low, the battery has to climb hysteresis points above the
+// threshold before it counts as charged again, so readings hovering around
+// the threshold do not flip the recommendation.
+type batteryPolicy struct {
+	threshold  int
+	hysteresis int
+	low        bool
+}
+
+func (p *batteryPolicy) decide(state powerState) (gpuMode, string, bool) {
+	if state.battery >= 0 {
+		switch {
+		case state.battery < p.threshold:
+			p.low = true
+		case state.battery >= p.threshold+p.hysteresis:
+			p.low = false
+		}
+	}
+	if state.onAC {
+		return modeDiscrete, "on AC power", true
+	}
+	if p.low {
+		return modeHybrid, fmt.Sprintf("on battery at %d%%", state.battery), true
+	}
+	return 0, "", false
+}
+
+// recommendation is a mode change proposed by a policy. Every switch needs a
+// reboot, so the daemon never applies one itself; the user accepts it with
+// "policy accept" or from the tray.
+type recommendation struct {
+	Mode   string    `json:"mode"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+func recommendationPath() string {
+	return filepath.Join(stateDir, recommendationFile)
+}
+
+func loadRecommendation() (recommendation, bool, error) {
+	var rec recommendation
+	raw, err := os.ReadFile(recommendationPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rec, false, nil
+		}
+		return rec, false, err
+	}
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return rec, false, fmt.Errorf("parse %s: %w", recommendationFile, err)
+	}
+	return rec, true, nil
+}
+
+func saveRecommendation(rec recommendation) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recommendationPath(), append(raw, '\n'), 0o644)
+}
+
+func clearRecommendation() error {
+	err := os.Remove(recommendationPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type policyOptions struct {
+	dock       bool
+	display    bool
+	battery    bool
+	threshold  int
+	hysteresis int
+	debounce   time.Duration
+	// minInterval is the least time between two recommendations.
+	minInterval time.Duration
+}
+
+// applyConfig fills in the policy settings from the config file for flags
+// not given on the command line, and checks them; the daemon runs it again on
+// every reload.
+func (o *policyOptions) applyConfig(cmd *cobra.Command, cfg config) error {
+	changed := cmd.Flags().Changed
+	for _, d := range []struct {
+		flag, value string
+		dst         *time.Duration
+	}{
+		{"policy-debounce", cfg.PolicyDebounce, &o.debounce},
+		{"policy-min-interval", cfg.PolicyMinInterval, &o.minInterval},
+	} {
+		if d.value == "" || changed(d.flag) {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", d.flag, err)
+		}
+		*d.dst = v
+	}
+	if cfg.DockPolicy && !changed("dock-policy") {
+		o.dock = true
+	}
+	if cfg.DisplayPolicy && !changed("display-policy") {
+		o.display = true
+	}
+	if cfg.BatteryPolicy && !changed("battery-policy") {
+		o.battery = true
+	}
+	if cfg.BatteryThreshold != nil && !changed("battery-threshold") {
+		o.threshold = *cfg.BatteryThreshold
+	}
+	if cfg.BatteryHysteresis != nil && !changed("battery-hysteresis") {
+		o.hysteresis = *cfg.BatteryHysteresis
+	}
+	if o.debounce < 0 || o.minInterval < 0 {
+		return errors.New("--policy-debounce and --policy-min-interval must not be negative")
+	}
+	return nil
+}
+
+func (o *policyOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.dock, "dock-policy", false, "recommend discrete when docked with the lid closed and hybrid otherwise")
+	cmd.Flags().BoolVar(&o.display, "display-policy", false, "recommend discrete while an external display is connected to the dGPU")
+	cmd.Flags().BoolVar(&o.battery, "battery-policy", false, "recommend discrete on AC and hybrid on low battery")
+	cmd.Flags().IntVar(&o.threshold, "battery-threshold", 30, "battery percentage below which hybrid is recommended")
+	cmd.Flags().IntVar(&o.hysteresis, "battery-hysteresis", 5, "percentage points above the threshold before the battery counts as charged")
+	cmd.Flags().DurationVar(&o.debounce, "policy-debounce", 30*time.Second, "how long a policy decision has to hold before it is recommended")
+	cmd.Flags().DurationVar(&o.minInterval, "policy-min-interval", 10*time.Minute, "least time between two recommendations")
+}
+
+// policyRunner evaluates the enabled policies on every daemon poll and keeps
+// the stored recommendation in sync. Policies are checked from the most
+// specific (dock) to the least (battery); when the dock or display policy is
+// enabled and nothing decides, hybrid is recommended.
+type policyRunner struct {
+	dock    bool
+	display bool
+	battery *batteryPolicy
+	// last is the previous decision. A recommendation is only written when
+	// the decision changes, so a dismissed one stays dismissed.
+	last     gpuMode
+	lastSeen bool
+
+	// A decision becomes a recommendation once it has held for debounce,
+	// and at most once per minInterval, so a flaky dock or a cable being
+	// wiggled cannot cause a storm of recommendations and notifications.
+	debounce       time.Duration
+	minInterval    time.Duration
+	candidate      gpuMode
+	candidateSince time.Time
+	recommendedAt  time.Time
+	now            func() time.Time
+}
+
+func newPolicyRunner(opts policyOptions) *policyRunner {
+	if !opts.dock && !opts.display && !opts.battery {
+		return nil
+	}
+	r := &policyRunner{dock: opts.dock, display: opts.display, debounce: opts.debounce, minInterval: opts.minInterval, now: time.Now}
+	if opts.battery {
+		r.battery = &batteryPolicy{threshold: opts.threshold, hysteresis: opts.hysteresis}
+	}
+	return r
+}
+
+// reconfigured returns a runner for opts that carries over r's state, so a
+// config reload neither repeats nor revives a recommendation.
+func (r *policyRunner) reconfigured(opts policyOptions) *policyRunner {
+	next := newPolicyRunner(opts)
+	if next == nil || r == nil {
+		return next
+	}
+	next.last, next.lastSeen = r.last, r.lastSeen
+	next.candidate, next.candidateSince, next.recommendedAt = r.candidate, r.candidateSince, r.recommendedAt
+	next.now = r.now
+	if next.battery != nil && r.battery != nil {
+		next.battery.low = r.battery.low
+	}
+	return next
+}
+
+func (r *policyRunner) decide(in policyInput) (gpuMode, string, bool) {
+	if r.dock && in.docked && in.lidClosed {
+		return modeDiscrete, "docked with the lid closed", true
+	}
+	// Some models only drive HDMI from the dGPU, which goes dark in
+	// integrated mode, so the display policy needs no lid state.
+	if r.display && in.dgpuDisplay != "" {
+		return modeDiscrete, "external display on " + in.dgpuDisplay, true
+	}
+	if r.battery != nil {
+		if mode, reason, ok := r.battery.decide(in.power); ok {
+			return mode, reason, true
+		}
+	}
+	if r.dock {
+		return modeHybrid, "not docked with the lid closed", true
+	}
+	if r.display {
+		return modeHybrid, "no external display on the dGPU", true
+	}
+	return 0, "", false
+}
+
+func (r *policyRunner) evaluate(ctx context.Context) {
+	mode, reason, ok := r.decide(readPolicyInput())
+	if !ok || checkModeSupported(mode) != nil {
+		return
+	}
+	now := r.now()
+	if r.candidateSince.IsZero() || r.candidate != mode {
+		r.candidate, r.candidateSince = mode, now
+	}
+	if now.Sub(r.candidateSince) < r.debounce {
+		log.Debug().Msgf("policy: %s (%s) has not held for %s yet", mode, reason, r.debounce)
+		return
+	}
+	changed := !r.lastSeen || r.last != mode
+	pending, err := readUefiGpuMode(ctx)
+	if err != nil {
+		log.Debug().Msgf("policy: %v", err)
+		return
+	}
+	if pending == mode {
+		r.last, r.lastSeen = mode, true
+		if err := clearRecommendation(); err != nil {
+			log.Warn().Msgf("clear recommendation failed: %v", err)
+		}
+		return
+	}
+	if !changed {
+		return
+	}
+	// Leave last alone while rate limited so the decision is retried.
+	if !r.recommendedAt.IsZero() && now.Sub(r.recommendedAt) < r.minInterval {
+		log.Debug().Msgf("policy: %s rate limited until %s", mode, r.recommendedAt.Add(r.minInterval).Format(time.TimeOnly))
+		return
+	}
+	r.last, r.lastSeen, r.recommendedAt = mode, true, now
+	log.Info().Msgf("recommending %s mode (%s); accept with \"msi-gpu-switcher policy accept\"", mode, reason)
+	if err := saveRecommendation(recommendation{Mode: mode.String(), Reason: reason, Time: now}); err != nil {
+		log.Warn().Msgf("save recommendation failed: %v", err)
+	}
+}
+
+func printRecommendation() {
+	rec, found, err := loadRecommendation()
+	if err != nil || !found {
+		return
+	}
+	outln("")
+	outln("Recommended:")
+	outf("  %s (%s, %s); run \"policy accept\" to switch", rec.Mode, rec.Reason, rec.Time.Format(time.DateTime))
+}
+
+func policyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Show, accept or dismiss the daemon's mode recommendation",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			rec, found, err := loadRecommendation()
+			if err != nil {
+				return err
+			}
+			if !found {
+				log.Info().Msg("no recommendation")
+				return nil
+			}
+			outf("%s (%s, %s)", rec.Mode, rec.Reason, rec.Time.Format(time.DateTime))
+			return nil
+		},
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "accept",
+			Short: "Switch to the recommended mode",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				rec, found, err := loadRecommendation()
+				if err != nil {
+					return err
+				}
+				if !found {
+					return errors.New("no recommendation to accept")
+				}
+				mode, err := parseMode(rec.Mode)
+				if err != nil {
+					return err
+				}
+				if err := switchMode(cmd.Context(), mode); err != nil {
+					return err
+				}
+				// Through the helper this runs unprivileged; the daemon
+				// clears the file itself once it sees the new mode.
+				if err := clearRecommendation(); err != nil {
+					log.Debug().Msgf("clear recommendation failed: %v", err)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "dismiss",
+			Short: "Forget the recommendation until the policy decides differently",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				requireRoot()
+				return clearRecommendation()
+			},
+		},
+	)
+	return cmd
+}
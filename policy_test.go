@@ -0,0 +1,243 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadPowerState(t *testing.T) {
+	original := powerSupplyRoot
+	powerSupplyRoot = t.TempDir()
+	t.Cleanup(func() { powerSupplyRoot = original })
+
+	for path, value := range map[string]string{
+		"ADP1/type":                          "Mains",
+		"ADP1/online":                        "0",
+		"BAT1/type":                          "Battery",
+		"BAT1/capacity":                      "42",
+		"hidpp_battery_0/type":               "Battery",
+		"hidpp_battery_0/scope":              "Device",
+		"hidpp_battery_0/capacity":           "5",
+		"ucsi-source-psy-USBC000:001/type":   "USB",
+		"ucsi-source-psy-USBC000:001/online": "0",
+	} {
+		path = filepath.Join(powerSupplyRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	if got := readPowerState(); got.onAC || got.battery != 42 {
+		t.Fatalf("readPowerState = %+v", got)
+	}
+}
+
+func TestBatteryPolicyHysteresis(t *testing.T) {
+	p := &batteryPolicy{threshold: 30, hysteresis: 5}
+	steps := []struct {
+		state powerState
+		mode  gpuMode
+		ok    bool
+	}{
+		{powerState{battery: 50}, 0, false},
+		{powerState{battery: 29}, modeHybrid, true},
+		{powerState{battery: 32}, modeHybrid, true},
+		{powerState{battery: 36}, 0, false},
+		{powerState{onAC: true, battery: 20}, modeDiscrete, true},
+	}
+	for i, step := range steps {
+		mode, _, ok := p.decide(step.state)
+		if ok != step.ok || (ok && mode != step.mode) {
+			t.Fatalf("step %d: decide(%+v) = %s %v, want %s %v", i, step.state, mode, ok, step.mode, step.ok)
+		}
+	}
+}
+
+func TestPolicyRunnerRecommends(t *testing.T) {
+	dir := t.TempDir()
+	originalState, originalVar, originalPower := stateDir, uefiVarPath, powerSupplyRoot
+	stateDir, uefiVarPath, powerSupplyRoot = dir, filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid), filepath.Join(dir, "power_supply")
+	t.Cleanup(func() { stateDir, uefiVarPath, powerSupplyRoot = originalState, originalVar, originalPower })
+
+	if err := os.MkdirAll(filepath.Join(powerSupplyRoot, "ADP1"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, value := range map[string]string{"type": "Mains", "online": "1"} {
+		if err := os.WriteFile(filepath.Join(powerSupplyRoot, "ADP1", name), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+
+	ctx := context.Background()
+	r := newPolicyRunner(policyOptions{battery: true, threshold: 30, hysteresis: 5})
+	r.evaluate(ctx)
+	rec, found, err := loadRecommendation()
+	if err != nil || !found || rec.Mode != "discrete" {
+		t.Fatalf("recommendation = %+v %v %v", rec, found, err)
+	}
+
+	// A dismissed recommendation is not written again for the same decision.
+	if err := clearRecommendation(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	r.evaluate(ctx)
+	if _, found, _ := loadRecommendation(); found {
+		t.Fatal("dismissed recommendation came back")
+	}
+}
+
+func TestDockPolicy(t *testing.T) {
+	r := newPolicyRunner(policyOptions{dock: true, battery: true, threshold: 30, hysteresis: 5})
+	for _, tc := range []struct {
+		in   policyInput
+		mode gpuMode
+	}{
+		{policyInput{power: powerState{onAC: true, battery: 80}, docked: true, lidClosed: true}, modeDiscrete},
+		{policyInput{power: powerState{battery: 20}, docked: true, lidClosed: false}, modeHybrid},
+		{policyInput{power: powerState{battery: 80}}, modeHybrid},
+	} {
+		mode, reason, ok := r.decide(tc.in)
+		if !ok || mode != tc.mode {
+			t.Fatalf("decide(%+v) = %s (%s) %v, want %s", tc.in, mode, reason, ok, tc.mode)
+		}
+	}
+}
+
+func TestLidClosed(t *testing.T) {
+	original := lidRoot
+	lidRoot = t.TempDir()
+	t.Cleanup(func() { lidRoot = original })
+
+	if err := os.MkdirAll(filepath.Join(lidRoot, "LID0"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(lidRoot, "LID0", "state")
+	for state, want := range map[string]bool{"state:      open": false, "state:      closed": true} {
+		if err := os.WriteFile(path, []byte(state+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if got := lidClosed(); got != want {
+			t.Fatalf("lidClosed with %q = %v", state, got)
+		}
+	}
+}
+
+func TestDisplayPolicy(t *testing.T) {
+	r := newPolicyRunner(policyOptions{display: true})
+	if mode, reason, ok := r.decide(policyInput{docked: true, dgpuDisplay: "HDMI-A-1"}); !ok || mode != modeDiscrete || reason != "external display on HDMI-A-1" {
+		t.Fatalf("with a dGPU display: %s (%s) %v", mode, reason, ok)
+	}
+	// A display on the iGPU's USB-C port does not need the dGPU.
+	if mode, _, ok := r.decide(policyInput{docked: true}); !ok || mode != modeHybrid {
+		t.Fatalf("with an iGPU display: %s %v", mode, ok)
+	}
+}
+
+func TestPolicyRunnerDebounceAndRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	originalState, originalVar, originalPower := stateDir, uefiVarPath, powerSupplyRoot
+	stateDir, uefiVarPath, powerSupplyRoot = dir, filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid), filepath.Join(dir, "power_supply")
+	t.Cleanup(func() { stateDir, uefiVarPath, powerSupplyRoot = originalState, originalVar, originalPower })
+
+	online := filepath.Join(powerSupplyRoot, "ADP1", "online")
+	if err := os.MkdirAll(filepath.Dir(online), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for path, value := range map[string]string{"ADP1/type": "Mains", "BAT1/type": "Battery", "BAT1/capacity": "10"} {
+		path = filepath.Join(powerSupplyRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	setAC := func(on bool) {
+		value := "0\n"
+		if on {
+			value = "1\n"
+		}
+		if err := os.WriteFile(online, []byte(value), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// UEFI says hybrid, so only a discrete decision is a change.
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := newPolicyRunner(policyOptions{battery: true, threshold: 30, debounce: time.Minute, minInterval: time.Hour})
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+	recommended := func() bool {
+		_, found, err := loadRecommendation()
+		if err != nil {
+			t.Fatalf("loadRecommendation: %v", err)
+		}
+		return found
+	}
+
+	setAC(true)
+	r.evaluate(ctx)
+	now = now.Add(30 * time.Second)
+	r.evaluate(ctx)
+	if recommended() {
+		t.Fatal("recommended before the debounce window passed")
+	}
+	now = now.Add(31 * time.Second)
+	r.evaluate(ctx)
+	if !recommended() {
+		t.Fatal("expected a recommendation after the debounce window")
+	}
+
+	// Unplug on a low battery and replug: the hybrid decision matches the
+	// UEFI mode and clears the recommendation, and the next discrete one is
+	// held back by the rate limit.
+	setAC(false)
+	r.evaluate(ctx)
+	now = now.Add(2 * time.Minute)
+	r.evaluate(ctx)
+	if recommended() {
+		t.Fatal("hybrid decision did not clear the recommendation")
+	}
+	setAC(true)
+	r.evaluate(ctx)
+	now = now.Add(2 * time.Minute)
+	r.evaluate(ctx)
+	if recommended() {
+		t.Fatal("rate limit did not hold back the second recommendation")
+	}
+	now = now.Add(time.Hour)
+	r.evaluate(ctx)
+	if !recommended() {
+		t.Fatal("expected the recommendation once the rate limit expired")
+	}
+}
+
+func TestPolicyRunnerReconfigured(t *testing.T) {
+	var none *policyRunner
+	if r := none.reconfigured(policyOptions{}); r != nil {
+		t.Fatalf("expected no runner without policies, got %+v", r)
+	}
+	r := newPolicyRunner(policyOptions{battery: true, threshold: 30, hysteresis: 5})
+	r.last, r.lastSeen = modeHybrid, true
+	r.battery.low = true
+	next := r.reconfigured(policyOptions{battery: true, dock: true, threshold: 20, hysteresis: 5})
+	if !next.dock || next.battery.threshold != 20 {
+		t.Fatalf("new settings not applied: %+v", next)
+	}
+	if next.last != modeHybrid || !next.lastSeen || !next.battery.low {
+		t.Fatalf("state not carried over: %+v", next)
+	}
+}
@@ -0,0 +1,265 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// dropUser is the user a switch drops to once the descriptors it writes
+// through are open (--drop-privileges); empty keeps root.
+var dropUser string
+
+// privilegesDropped is set once dropPrivileges switched users.
+var privilegesDropped bool
+
+// heldFiles are the descriptors opened before dropping privileges, by path.
+// Opening one of these paths afterwards reuses its descriptor.
+var heldFiles map[string]*os.File
+
+// heldFile returns a duplicate of the descriptor held for path, rewound to
+// the start, or nil when none is held. Duplicates share the file offset, so
+// only one may be in use at a time.
+func heldFile(path string) (*os.File, error) {
+	held, ok := heldFiles[path]
+	if !ok {
+		return nil, nil
+	}
+	fd, err := unix.FcntlInt(held.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "dup", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "seek", Path: path, Err: err}
+	}
+	return f, nil
+}
+
+// openFile is os.OpenFile, except that a held path reuses its descriptor
+// whatever flag asks for.
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if f, err := heldFile(name); f != nil || err != nil {
+		return f, err
+	}
+	return os.OpenFile(name, flag, perm)
+}
+
+func readFile(name string) ([]byte, error) {
+	f, err := heldFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return os.ReadFile(name)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeFile writes a held path in a single write, which is all efivarfs
+// needs; the descriptor is not truncated.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	f, err := heldFile(name)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return os.WriteFile(name, data, perm)
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// replaceFile writes a small state file whole. A held path is truncated and
+// rewritten in place, since it cannot be created again without root.
+func replaceFile(name string, data []byte, perm os.FileMode) error {
+	f, err := heldFile(name)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return os.WriteFile(name, data, perm)
+	}
+	defer f.Close()
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// removeFile removes a state file. A held path cannot be unlinked without
+// root and is emptied instead, which readers take for a missing file.
+func removeFile(name string) error {
+	f, err := heldFile(name)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return os.Remove(name)
+	}
+	defer f.Close()
+	return f.Truncate(0)
+}
+
+// holdFile opens path and keeps the descriptor. A path that does not exist
+// is skipped unless flag creates it.
+func holdFile(path string, flag int, perm os.FileMode) error {
+	f, err := os.OpenFile(path, flag, perm)
+	if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if heldFiles == nil {
+		heldFiles = map[string]*os.File{}
+	}
+	heldFiles[path] = f
+	return nil
+}
+
+// dropPrivileges opens everything a switch writes, the EC node, the GPU
+// mode variable, the lock, history, audit and state files, and then switches to
+// dropUser for the rest of the process: the switch itself, hooks and
+// output. When the variable is immutable, CAP_LINUX_IMMUTABLE and
+// CAP_FOWNER are kept to unlock and lock it again; no other capability is,
+// and no_new_privs keeps hooks from regaining any through setuid programs.
+func dropPrivileges(ctx context.Context) error {
+	if dropUser == "" || os.Geteuid() != 0 {
+		return nil
+	}
+	uid, gid, err := lookupDropUser(dropUser)
+	if err != nil {
+		return err
+	}
+	if createUefiVar && !exists(uefiVarPath) {
+		return fmt.Errorf("cannot create %s after dropping privileges; switch once without --drop-privileges", uefiVarName)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("cannot drop privileges in a cgo build, which cannot change every thread")
+		}
+		return fmt.Errorf("keep capabilities failed: %w", errno)
+	}
+
+	// An immutable variable cannot be opened for writing; it is locked
+	// again right away and unlocked around the write as usual.
+	restore, err := makeVarMutable(ctx, uefiVarPath)
+	if err != nil {
+		return fmt.Errorf("prepare uefi var failed: %w", err)
+	}
+	relock := restore != nil && !keepUnlocked
+	err = holdFile(hostPath(uefiVarPath), os.O_RDWR, 0)
+	if relock {
+		restore()
+	}
+	if err == nil {
+		// Without ec_sys write_support the node is read-only, as in openEC,
+		// and without the ec backend it is only read.
+		flag := os.O_RDWR
+		if !slices.Contains(activeBackends(), "ec") {
+			flag = os.O_RDONLY
+		}
+		err = holdFile(hostPath(ecIOPath), flag, 0)
+		if flag != os.O_RDONLY && errors.Is(err, os.ErrPermission) {
+			err = holdFile(hostPath(ecIOPath), os.O_RDONLY, 0)
+		}
+	}
+	for _, dir := range []string{stateDir, filepath.Dir(auditPath)} {
+		if err == nil {
+			err = os.MkdirAll(dir, 0o755)
+		}
+	}
+	// History and audit entries are appended, so their descriptors are too.
+	for _, f := range []struct {
+		path string
+		flag int
+		perm os.FileMode
+	}{
+		{lockPath, os.O_RDWR | os.O_CREATE, 0o600},
+		{historyPath(), os.O_RDWR | os.O_APPEND | os.O_CREATE, 0o644},
+		{auditPath, os.O_RDWR | os.O_APPEND | os.O_CREATE, 0o600},
+		// The BIOS is recorded when it changed, and a pending revert is
+		// cleared by the switch.
+		{firmwarePath(), os.O_RDWR | os.O_CREATE, 0o644},
+		{oncePath(), os.O_RDWR, 0},
+	} {
+		if err == nil {
+			err = holdFile(f.path, f.flag, f.perm)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("open files before dropping privileges failed: %w", err)
+	}
+
+	// syscall's setters change every thread, with or without cgo.
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("drop supplementary groups failed: %w", err)
+	}
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("change group to %d failed: %w", gid, err)
+	}
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("change user to %d failed: %w", uid, err)
+	}
+	var caps uint32
+	if relock {
+		caps = 1<<unix.CAP_LINUX_IMMUTABLE | 1<<unix.CAP_FOWNER
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: caps, Permitted: caps}}
+	_, _, errno := syscall.AllThreadsSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	runtime.KeepAlive(&header)
+	runtime.KeepAlive(&data)
+	if errno != 0 {
+		return fmt.Errorf("drop capabilities failed: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		return fmt.Errorf("set no_new_privs failed: %w", errno)
+	}
+	privilegesDropped = true
+	log.Debug().Msgf("dropped privileges to %s (%d:%d), %d files held", dropUser, uid, gid, len(heldFiles))
+	return nil
+}
+
+// lookupDropUser resolves a user name or numeric uid to the uid and its
+// primary group. Root is refused, since dropping to it drops nothing.
+func lookupDropUser(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if _, numeric := strconv.Atoi(name); err != nil && numeric == nil {
+		u, err = user.LookupId(name)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("drop privileges: %w", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("drop privileges: uid %q: %w", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("drop privileges: gid %q: %w", u.Gid, err)
+	}
+	if uid == 0 {
+		return 0, 0, fmt.Errorf("drop privileges: %s is root", name)
+	}
+	return uid, gid, nil
+}
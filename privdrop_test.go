@@ -0,0 +1,241 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHeldFile(t *testing.T) {
+	t.Cleanup(func() {
+		for _, f := range heldFiles {
+			f.Close()
+		}
+		heldFiles = nil
+	})
+	path := filepath.Join(t.TempDir(), "var")
+	if err := os.WriteFile(path, []byte("abcd"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := holdFile(path, os.O_RDWR, 0); err != nil {
+		t.Fatalf("holdFile: %v", err)
+	}
+	if err := holdFile(filepath.Join(t.TempDir(), "missing"), os.O_RDWR, 0); err != nil || len(heldFiles) != 1 {
+		t.Fatalf("expected a missing path to be skipped, got %v", err)
+	}
+	// Removing the path shows that the held descriptor is used.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		data, err := readFile(path)
+		if err != nil || string(data) != "abcd" {
+			t.Fatalf("readFile = %q %v", data, err)
+		}
+	}
+	if err := writeFile(path, []byte("xy"), 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	f, err := openFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("openFile: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != "xycd" {
+		t.Fatalf("read %q %v", buf, err)
+	}
+	f.Close()
+	if _, err := readFile(path); err != nil {
+		t.Fatalf("closing a duplicate closed the held descriptor: %v", err)
+	}
+
+	if err := replaceFile(path, []byte("z"), 0o644); err != nil {
+		t.Fatalf("replaceFile: %v", err)
+	}
+	if data, err := readFile(path); err != nil || string(data) != "z" {
+		t.Fatalf("after replaceFile: %q %v", data, err)
+	}
+	if err := removeFile(path); err != nil {
+		t.Fatalf("removeFile: %v", err)
+	}
+	if data, err := readFile(path); err != nil || len(data) != 0 {
+		t.Fatalf("expected removeFile to empty a held file, got %q %v", data, err)
+	}
+}
+
+func TestLookupDropUser(t *testing.T) {
+	uid, _, err := lookupDropUser("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	if uid == 0 {
+		t.Fatalf("nobody resolved to root")
+	}
+	if byID, _, err := lookupDropUser(strconv.Itoa(uid)); err != nil || byID != uid {
+		t.Fatalf("lookupDropUser(%d) = %d %v", uid, byID, err)
+	}
+	for _, name := range []string{"root", "0", "no-such-user"} {
+		if _, _, err := lookupDropUser(name); err == nil {
+			t.Fatalf("expected lookupDropUser(%q) to fail", name)
+		}
+	}
+}
+
+// TestDropPrivileges runs TestDropPrivilegesChild in a child process, since
+// root cannot be regained.
+func TestDropPrivileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("privileges are only dropped by root")
+	}
+	// The child's temp dirs end up in this one, which nobody can enter.
+	tmp, err := os.MkdirTemp("", "privdrop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivilegesChild$", "-test.v")
+	cmd.Env = append(os.Environ(), "MSI_GPU_SWITCHER_PRIVDROP_CHILD=1", "TMPDIR="+tmp)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("child skipped:\n%s", out)
+	}
+}
+
+func TestDropPrivilegesChild(t *testing.T) {
+	if os.Getenv("MSI_GPU_SWITCHER_PRIVDROP_CHILD") == "" {
+		t.Skip("run by TestDropPrivileges")
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_GETPID, 0, 0, 0); errno == syscall.ENOTSUP {
+		t.Skip("privileges are not dropped in cgo builds")
+	}
+	if _, _, err := lookupDropUser("nobody"); err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	laptopFixture(t)
+	dir := t.TempDir()
+	// The fixture is read through the held descriptors, the rest of the
+	// tree has to be readable as nobody.
+	for p := dir; strings.HasPrefix(p, os.TempDir()); p = filepath.Dir(p) {
+		os.Chmod(p, 0o755)
+	}
+	stateDir, auditPath, lockPath = filepath.Join(dir, "state"), filepath.Join(dir, "log", "audit.jsonl"), filepath.Join(dir, "test.lock")
+	hooksDir = filepath.Join(dir, "hooks")
+	dropUser = "nobody"
+	// The switch below records a BIOS update and clears a pending revert,
+	// both in the state dir nobody cannot write to.
+	if err := saveFirmware(firmwareInfo{BoardName: "MS-17KK", BiosVersion: "E17KKIMS.100"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveOnce(onceSwitch{Staged: "hybrid", Revert: "discrete", BootID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	forceWrites = true
+
+	ctx := context.Background()
+	if err := dropPrivileges(ctx); err != nil {
+		t.Fatalf("dropPrivileges: %v", err)
+	}
+	// The test's cleanups would fail without root, so the child exits
+	// before them.
+	defer func() {
+		if t.Failed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+	if os.Geteuid() == 0 || os.Getegid() == 0 {
+		t.Fatalf("still root: %d:%d", os.Geteuid(), os.Getegid())
+	}
+
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	if err := ec.writeByte(ctx, ecMuxOffset, 0x01); err != nil {
+		t.Fatalf("EC write: %v", err)
+	}
+	ec.Close()
+	if err := writeUefiVar(ctx, uefiDefaultAttrs, []byte{0, byte(modeDiscrete), 0, 0}); err != nil {
+		t.Fatalf("UEFI write: %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v", mode, err)
+	}
+	for range 2 {
+		if err := appendHistory(historyEntry{Time: time.Now(), Mode: "discrete", Result: "ok"}); err != nil {
+			t.Fatalf("history: %v", err)
+		}
+		if err := appendAudit(auditEntry{Time: time.Now(), Args: []string{"dgpu"}, Result: "ok"}); err != nil {
+			t.Fatalf("audit: %v", err)
+		}
+	}
+	if entries, err := readHistory(); err != nil || len(entries) != 2 {
+		t.Fatalf("readHistory = %v %v", entries, err)
+	}
+	unlock, err := acquireLock(ctx)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	unlock()
+
+	if err := switchGPU(ctx, modeHybrid); err != nil {
+		t.Fatalf("switchGPU after a BIOS change: %v", err)
+	}
+	if info, found, err := loadFirmware(); err != nil || !found || info != currentFirmware() {
+		t.Fatalf("expected the new BIOS to be recorded, got %+v %v %v", info, found, err)
+	}
+	if _, found, err := loadOnce(); err != nil || found {
+		t.Fatalf("expected the switch to clear the pending revert, found=%v err=%v", found, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, "new"), nil, 0o644); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected creating a file in the state dir to be refused, got %v", err)
+	}
+	if out, err := exec.Command("id", "-u").Output(); err != nil || strings.TrimSpace(string(out)) == "0" {
+		t.Fatalf("hooks would run as %q: %v", out, err)
+	}
+}
+
+func TestSwitchModeChecksDgpuBeforeDropping(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("privileges are only dropped by root")
+	}
+	root := switchFixture(t)
+	busyDgpu(t, root)
+	originalUser := dropUser
+	t.Cleanup(func() { dropUser = originalUser })
+	dropUser = "nobody"
+	ctx := context.Background()
+	if err := writeUefiVar(ctx, uefiDefaultAttrs, []byte{0, byte(modeDiscrete), 0, 0}); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+
+	// As nobody the busy process could not be seen; it is found while root
+	// and the switch stops before dropping anything.
+	if err := switchMode(ctx, modeHybrid); err == nil || !strings.Contains(err.Error(), "using the dGPU") {
+		t.Fatalf("expected the busy dGPU to be refused, got %v", err)
+	}
+	if privilegesDropped || os.Geteuid() != 0 {
+		t.Fatal("privileges were dropped for a refused switch")
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v, want discrete", mode, err)
+	}
+}
@@ -0,0 +1,225 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	profileModprobeFile = "msi-gpu-switcher-profile.conf"
+	activeProfileFile   = "profile"
+)
+
+// profile bundles a GPU mode with the settings that go with it, so a whole
+// setup (say "gaming" or "battery") is switched with one command. Profiles
+// live under "profiles" in the config file.
+type profile struct {
+	Mode string `json:"mode"`
+	// Modprobe holds modprobe.d lines, e.g. "options nvidia
+	// NVreg_DynamicPowerManagement=0x02"; they take effect with the reboot
+	// the switch needs anyway.
+	Modprobe []string `json:"modprobe,omitempty"`
+	// Persistenced enables or disables nvidia-persistenced when set.
+	Persistenced *bool `json:"persistenced,omitempty"`
+	// PowerProfile is set through power-profiles-daemon, e.g. "performance".
+	PowerProfile string `json:"power_profile,omitempty"`
+}
+
+// setPowerProfile runs powerprofilesctl; tests replace it.
+var setPowerProfile = func(ctx context.Context, name string) error {
+	out, err := runCommand(ctx, "powerprofilesctl", "set", name)
+	if err != nil {
+		return fmt.Errorf("powerprofilesctl set %s: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+func lookupProfile(name string) (profile, gpuMode, error) {
+	p, ok := loadedConfig.Profiles[name]
+	if !ok {
+		return p, 0, fmt.Errorf("no profile %q in %s", name, configPath)
+	}
+	mode, err := parseMode(p.Mode)
+	if err != nil {
+		return p, 0, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return p, mode, nil
+}
+
+// profileHooksDir holds the hooks run after name is applied.
+func profileHooksDir(name string) string {
+	return filepath.Join(hooksDir, "profiles", name+".d")
+}
+
+// applyProfile switches to the profile's mode and then applies the rest of
+// it. Nothing but the switch is changed when the switch fails.
+func applyProfile(ctx context.Context, name string) error {
+	p, mode, err := lookupProfile(name)
+	if err != nil {
+		return err
+	}
+	if err := checkModeSupported(mode); err != nil {
+		return err
+	}
+	if err := switchGPU(ctx, mode); err != nil {
+		return err
+	}
+	var errs []error
+	if err := writeProfileModprobe(p.Modprobe); err != nil {
+		errs = append(errs, fmt.Errorf("write modprobe options failed: %w", err))
+	}
+	if p.Persistenced != nil {
+		action := "disable"
+		if *p.Persistenced {
+			action = "enable"
+		}
+		if err := systemctl(ctx, action, "nvidia-persistenced.service"); err != nil {
+			errs = append(errs, fmt.Errorf("%s nvidia-persistenced failed: %w", action, err))
+		}
+	}
+	if p.PowerProfile != "" {
+		if err := setPowerProfile(ctx, p.PowerProfile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	env := append(hookEvent{newMode: mode}.env("profile"), "MSI_GPU_SWITCHER_PROFILE="+name)
+	if err := runHookDir(ctx, "profile", profileHooksDir(name), env, false); err != nil {
+		errs = append(errs, err)
+	}
+	if err := saveActiveProfile(name); err != nil {
+		errs = append(errs, fmt.Errorf("record profile failed: %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("profile %s: switched to %s, but %w", name, mode, err)
+	}
+	log.Info().Msgf("applied profile %s (%s)", name, mode)
+	return nil
+}
+
+// writeProfileModprobe replaces the profile's modprobe.d file with lines, or
+// removes it when there are none.
+func writeProfileModprobe(lines []string) error {
+	path := filepath.Join(modprobeDir, profileModprobeFile)
+	if len(lines) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(modprobeDir, 0o755); err != nil {
+		return err
+	}
+	data := "# Generated by msi-gpu-switcher from the active profile.\n" + strings.Join(lines, "\n") + "\n"
+	return os.WriteFile(path, []byte(data), 0o644)
+}
+
+func saveActiveProfile(name string) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stateDir, activeProfileFile), []byte(name+"\n"), 0o644)
+}
+
+// activeProfile returns the profile applied last, or "" if none was.
+func activeProfile() string {
+	raw, err := os.ReadFile(filepath.Join(stateDir, activeProfileFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func profileNames() []string {
+	names := make([]string, 0, len(loadedConfig.Profiles))
+	for name := range loadedConfig.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeProfiles completes the first argument with the configured
+// profiles. Completion runs no PersistentPreRunE, so the config is read here.
+func completeProfiles(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if len(loadedConfig.Profiles) == 0 {
+		if cfg, err := loadConfig(configPath); err == nil {
+			loadedConfig = cfg
+		}
+	}
+	return profileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func profileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "List or apply named profiles of mode and driver settings",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			names := profileNames()
+			if len(names) == 0 {
+				log.Info().Msgf("no profiles in %s", configPath)
+				return nil
+			}
+			active := activeProfile()
+			for _, name := range names {
+				p := loadedConfig.Profiles[name]
+				mark := " "
+				if name == active {
+					mark = "*"
+				}
+				outf("%s %-12s %s", mark, name, p.summary())
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:               "apply <name>",
+		Short:             "Switch to a profile's mode and apply its settings",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, _, err := lookupProfile(args[0]); err != nil {
+				return err
+			}
+			if err := checkWritable(); err != nil {
+				return err
+			}
+			requireRoot()
+			if err := confirmUnknownModel(); err != nil {
+				return err
+			}
+			if err := confirmFirmware(cmd.Context()); err != nil {
+				return err
+			}
+			return applyProfile(cmd.Context(), args[0])
+		},
+	})
+	return cmd
+}
+
+func (p profile) summary() string {
+	parts := []string{p.Mode}
+	if len(p.Modprobe) > 0 {
+		parts = append(parts, fmt.Sprintf("%d modprobe line(s)", len(p.Modprobe)))
+	}
+	if p.Persistenced != nil {
+		parts = append(parts, fmt.Sprintf("persistenced %v", *p.Persistenced))
+	}
+	if p.PowerProfile != "" {
+		parts = append(parts, "power "+p.PowerProfile)
+	}
+	return strings.Join(parts, ", ")
+}
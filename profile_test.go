@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupProfile(t *testing.T) {
+	original := loadedConfig
+	loadedConfig = config{Profiles: map[string]profile{
+		"gaming": {Mode: "discrete"},
+		"broken": {Mode: "turbo"},
+	}}
+	t.Cleanup(func() { loadedConfig = original })
+
+	if _, mode, err := lookupProfile("gaming"); err != nil || mode != modeDiscrete {
+		t.Fatalf("lookupProfile(gaming) = %v, %v", mode, err)
+	}
+	if _, _, err := lookupProfile("broken"); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+	if _, _, err := lookupProfile("missing"); err == nil {
+		t.Fatal("expected a missing profile to be rejected")
+	}
+	if got := profileNames(); len(got) != 2 || got[0] != "broken" || got[1] != "gaming" {
+		t.Fatalf("profileNames() = %v", got)
+	}
+}
+
+func TestWriteProfileModprobe(t *testing.T) {
+	original := modprobeDir
+	modprobeDir = t.TempDir()
+	t.Cleanup(func() { modprobeDir = original })
+
+	path := filepath.Join(modprobeDir, profileModprobeFile)
+	if err := writeProfileModprobe([]string{"options nvidia NVreg_DynamicPowerManagement=0x02"}); err != nil {
+		t.Fatalf("writeProfileModprobe: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := "# Generated by msi-gpu-switcher from the active profile.\noptions nvidia NVreg_DynamicPowerManagement=0x02\n"
+	if string(raw) != want {
+		t.Fatalf("modprobe file = %q, want %q", raw, want)
+	}
+	if err := writeProfileModprobe(nil); err != nil {
+		t.Fatalf("writeProfileModprobe(nil): %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, stat: %v", err)
+	}
+	if err := writeProfileModprobe(nil); err != nil {
+		t.Fatalf("expected removing a missing file to succeed, got %v", err)
+	}
+}
+
+func TestActiveProfile(t *testing.T) {
+	original := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = original })
+
+	if got := activeProfile(); got != "" {
+		t.Fatalf("activeProfile() = %q without state", got)
+	}
+	if err := saveActiveProfile("gaming"); err != nil {
+		t.Fatalf("saveActiveProfile: %v", err)
+	}
+	if got := activeProfile(); got != "gaming" {
+		t.Fatalf("activeProfile() = %q, want gaming", got)
+	}
+}
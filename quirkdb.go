@@ -0,0 +1,238 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// quirkDBURL is the community quirk database. It is attached to a rolling
+// "quirks" release, next to its detached signature quirks.json.sig, so new
+// models can be added without a binary release.
+var quirkDBURL = "https://github.com/ElXreno/msi-gpu-switcher/releases/download/quirks/quirks.json"
+
+const (
+	quirkDBFile   = "quirks.json"
+	quirkDBSchema = 1
+)
+
+// installedQuirks is the quirk database installed by quirks update, read by
+// applyQuirk. Its entries take precedence over quirkTable.
+var installedQuirks []quirk
+
+// quirkDB is the downloadable quirk database. Serial increases with every
+// published revision, so an older file cannot be installed over a newer one.
+type quirkDB struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Serial        int64        `json:"serial"`
+	Quirks        []quirkEntry `json:"quirks"`
+}
+
+type quirkEntry struct {
+	Name       string   `json:"name"`
+	BoardName  string   `json:"board_name"`
+	EcIndex    int      `json:"ec_index"`
+	TestedBios []string `json:"tested_bios"`
+}
+
+func quirkDBPath() string {
+	return filepath.Join(stateDir, quirkDBFile)
+}
+
+func parseQuirkDB(raw []byte) (quirkDB, error) {
+	var db quirkDB
+	if err := json.Unmarshal(raw, &db); err != nil {
+		return db, fmt.Errorf("parse %s: %w", quirkDBFile, err)
+	}
+	if db.SchemaVersion != quirkDBSchema {
+		return db, fmt.Errorf("%s has schema version %d, this build reads %d", quirkDBFile, db.SchemaVersion, quirkDBSchema)
+	}
+	boards := map[string]bool{}
+	for i, e := range db.Quirks {
+		switch {
+		case e.Name == "" || e.BoardName == "":
+			return db, fmt.Errorf("%s entry %d lacks a name or board name", quirkDBFile, i)
+		case boards[e.BoardName]:
+			return db, fmt.Errorf("%s lists board %q twice", quirkDBFile, e.BoardName)
+		case e.EcIndex < 0:
+			return db, fmt.Errorf("%s entry %q has EC index %d", quirkDBFile, e.BoardName, e.EcIndex)
+		case len(e.TestedBios) == 0:
+			// An entry without one would skip checkTestedBios on its board.
+			return db, fmt.Errorf("%s entry %q lists no tested BIOS", quirkDBFile, e.BoardName)
+		}
+		for _, pattern := range e.TestedBios {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return db, fmt.Errorf("%s entry %q: BIOS pattern %q: %w", quirkDBFile, e.BoardName, pattern, err)
+			}
+		}
+		boards[e.BoardName] = true
+	}
+	return db, nil
+}
+
+func (db quirkDB) table() []quirk {
+	table := make([]quirk, len(db.Quirks))
+	for i, e := range db.Quirks {
+		table[i] = quirk{name: e.Name, boardName: e.BoardName, ecIndex: e.EcIndex, testedBios: e.TestedBios}
+	}
+	return table
+}
+
+// loadQuirkDB reads the installed database; found is false when there is
+// none.
+func loadQuirkDB() (db quirkDB, found bool, err error) {
+	raw, err := os.ReadFile(quirkDBPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return db, false, nil
+		}
+		return db, false, err
+	}
+	db, err = parseQuirkDB(raw)
+	return db, err == nil, err
+}
+
+// loadInstalledQuirks sets installedQuirks from the installed database. A
+// broken file is reported and the built-in table used alone.
+func loadInstalledQuirks() {
+	db, found, err := loadQuirkDB()
+	if err != nil {
+		log.Warn().Msgf("ignoring quirk database %s: %v", quirkDBPath(), err)
+	}
+	installedQuirks = nil
+	if found {
+		installedQuirks = db.table()
+	}
+}
+
+func quirksCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "quirks",
+		Short: "Show or update the model quirk database",
+	}
+	cmd.AddCommand(quirksListCmd(), quirksUpdateCmd())
+	return cmd
+}
+
+func quirksListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the known models",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			db, found, err := loadQuirkDB()
+			if err != nil {
+				log.Warn().Msgf("ignoring quirk database %s: %v", quirkDBPath(), err)
+			}
+			if found {
+				log.Info().Msgf("quirk database serial %d installed in %s", db.Serial, quirkDBPath())
+			}
+			seen := map[string]bool{}
+			show := func(q quirk, source string) {
+				if seen[q.boardName] {
+					return
+				}
+				seen[q.boardName] = true
+				outf("%-8s ec%d  %-9s %s", q.boardName, q.ecIndex, source, q.name)
+			}
+			for _, q := range db.table() {
+				show(q, "database")
+			}
+			for _, q := range quirkTable {
+				show(q, "built-in")
+			}
+			return nil
+		},
+	}
+}
+
+func quirksUpdateCmd() *cobra.Command {
+	var url string
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Download and install the community quirk database",
+		Long: "Download the community quirk database and its detached signature, verify it\n" +
+			"against the release signing key built into this binary and install it in the\n" +
+			"state directory. Its entries take precedence over the built-in table; reload\n" +
+			"the daemon to pick it up.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			return updateQuirkDB(cmd.Context(), url, force)
+		},
+	}
+	cmd.Flags().StringVar(&url, "url", quirkDBURL, "database URL; the signature is read from <url>.sig")
+	cmd.Flags().BoolVar(&force, "force", false, "install even if the database is older than the installed one")
+	return cmd
+}
+
+func updateQuirkDB(ctx context.Context, url string, force bool) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	if releasePublicKey == "" {
+		return errors.New("this build has no release signing key to verify the quirk database with")
+	}
+	raw, err := download(ctx, url)
+	if err != nil {
+		return fmt.Errorf("download %s failed: %w", quirkDBFile, err)
+	}
+	sig, err := download(ctx, url+".sig")
+	if err != nil {
+		return fmt.Errorf("download %s.sig failed: %w", quirkDBFile, err)
+	}
+	if err := verifyReleaseSignature(quirkDBFile, raw, sig); err != nil {
+		return err
+	}
+	db, err := parseQuirkDB(raw)
+	if err != nil {
+		return err
+	}
+	installed, found, err := loadQuirkDB()
+	if err != nil {
+		log.Warn().Msgf("replacing unreadable quirk database: %v", err)
+	}
+	if found && db.Serial <= installed.Serial && !force {
+		if db.Serial == installed.Serial {
+			log.Info().Msgf("quirk database serial %d is up to date", db.Serial)
+			return nil
+		}
+		return fmt.Errorf("downloaded quirk database serial %d is older than the installed %d; refusing to downgrade without --force", db.Serial, installed.Serial)
+	}
+
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(stateDir, ".quirks-*.json")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, quirkDBPath()); err != nil {
+		return err
+	}
+	log.Info().Msgf("installed quirk database serial %d with %d models in %s", db.Serial, len(db.Quirks), quirkDBPath())
+	return nil
+}
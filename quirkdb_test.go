@@ -0,0 +1,108 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveQuirkDB serves db, signed with a fresh release key, at the returned
+// URL. sig, if set, replaces the signature.
+func serveQuirkDB(t *testing.T, db string, sig []byte) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if sig == nil {
+		sig = ed25519.Sign(priv, []byte(db))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/quirks.json":
+			w.Write([]byte(db))
+		case "/quirks.json.sig":
+			w.Write(sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	original := releasePublicKey
+	releasePublicKey = base64.StdEncoding.EncodeToString(pub)
+	t.Cleanup(func() { releasePublicKey = original })
+	return srv.URL + "/quirks.json"
+}
+
+func TestUpdateQuirkDB(t *testing.T) {
+	laptopFixture(t)
+	originalState, originalInstalled, originalPath := stateDir, installedQuirks, ecIOPath
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir, installedQuirks, ecIOPath = originalState, originalInstalled, originalPath })
+	ctx := context.Background()
+
+	db := `{"schemaVersion": 1, "serial": 3, "quirks": [{"name": "MSI Alpha 17 C7VG rev2", "board_name": "MS-17KK", "ec_index": 1, "tested_bios": ["E17KKIMS.1*"]}]}`
+	if err := updateQuirkDB(ctx, serveQuirkDB(t, db, nil), false); err != nil {
+		t.Fatalf("updateQuirkDB: %v", err)
+	}
+	applyQuirk(-1)
+	if q, known := detectQuirk(); !known || q.name != "MSI Alpha 17 C7VG rev2" || ecIOPath != ecPath(1) {
+		t.Fatalf("installed quirk not used: %+v %s", q, ecIOPath)
+	}
+
+	older := strings.Replace(db, `"serial": 3`, `"serial": 2`, 1)
+	if err := updateQuirkDB(ctx, serveQuirkDB(t, older, nil), false); err == nil || !strings.Contains(err.Error(), "downgrade") {
+		t.Fatalf("expected a downgrade to be refused, got %v", err)
+	}
+	if err := updateQuirkDB(ctx, serveQuirkDB(t, older, nil), true); err != nil {
+		t.Fatalf("forced downgrade: %v", err)
+	}
+
+	newer := strings.Replace(db, `"serial": 3`, `"serial": 4`, 1)
+	if err := updateQuirkDB(ctx, serveQuirkDB(t, newer, make([]byte, ed25519.SignatureSize)), false); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("expected a bad signature to be refused, got %v", err)
+	}
+	if installed, _, err := loadQuirkDB(); err != nil || installed.Serial != 2 {
+		t.Fatalf("installed database changed by a refused update: serial %d, %v", installed.Serial, err)
+	}
+}
+
+func TestParseQuirkDB(t *testing.T) {
+	for name, db := range map[string]string{
+		"schema":     `{"schemaVersion": 2, "quirks": []}`,
+		"no board":   `{"schemaVersion": 1, "quirks": [{"name": "x"}]}`,
+		"duplicate":  `{"schemaVersion": 1, "quirks": [{"name": "x", "board_name": "MS-1", "tested_bios": ["*"]}, {"name": "y", "board_name": "MS-1", "tested_bios": ["*"]}]}`,
+		"ec index":   `{"schemaVersion": 1, "quirks": [{"name": "x", "board_name": "MS-1", "ec_index": -1, "tested_bios": ["*"]}]}`,
+		"no bios":    `{"schemaVersion": 1, "quirks": [{"name": "x", "board_name": "MS-1"}]}`,
+		"empty bios": `{"schemaVersion": 1, "quirks": [{"name": "x", "board_name": "MS-1", "tested_bios": []}]}`,
+		"bad bios":   `{"schemaVersion": 1, "quirks": [{"name": "x", "board_name": "MS-1", "tested_bios": ["["]}]}`,
+		"not json":   `quirks`,
+		"wrong type": `{"schemaVersion": 1, "quirks": {}}`,
+	} {
+		if _, err := parseQuirkDB([]byte(db)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBrokenQuirkDBFallsBack(t *testing.T) {
+	laptopFixture(t)
+	originalState, originalInstalled := stateDir, installedQuirks
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir, installedQuirks = originalState, originalInstalled })
+	if err := os.WriteFile(filepath.Join(stateDir, quirkDBFile), []byte("{"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	loadInstalledQuirks()
+	if q, known := detectQuirk(); !known || q.name != "MSI Alpha 17 C7VG" {
+		t.Fatalf("expected the built-in quirk, got %+v", q)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+var dmiRoot = "/sys/class/dmi/id"
+
+func readDMI(field string) string {
+	return readFirstLine(filepath.Join(dmiRoot, field))
+}
+
+// detectQuirk matches the DMI board name against the installed quirk
+// database, then quirkTable.
+func detectQuirk() (quirk, bool) {
+	board := readDMI("board_name")
+	for _, table := range [][]quirk{installedQuirks, quirkTable} {
+		for _, q := range table {
+			if q.boardName != "" && q.boardName == board {
+				return q, true
+			}
+		}
+	}
+	return defaultQuirk, false
+}
+
+// applyQuirk points the EC path at the EC index for this model, unless the
+// user selected one explicitly.
+func applyQuirk(ecIndex int) {
+	loadInstalledQuirks()
+	q, known := detectQuirk()
+	if known {
+		log.Debug().Msgf("model quirk: %s (ec%d)", q.name, q.ecIndex)
+	} else {
+		log.Debug().Msgf("no model quirk for board %q, using defaults", readDMI("board_name"))
+	}
+	if ecIndex < 0 {
+		ecIndex = q.ecIndex
+	}
+	ecIOPath = ecPath(ecIndex)
+}
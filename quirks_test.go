@@ -0,0 +1,116 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyQuirkSelectsEC(t *testing.T) {
+	originalDMI, originalPath := dmiRoot, ecIOPath
+	dmiRoot = t.TempDir()
+	t.Cleanup(func() { dmiRoot, ecIOPath = originalDMI, originalPath })
+
+	originalTable := quirkTable
+	quirkTable = []quirk{{name: "test", boardName: "MS-TEST", ecIndex: 1}}
+	t.Cleanup(func() { quirkTable = originalTable })
+
+	if err := os.WriteFile(filepath.Join(dmiRoot, "board_name"), []byte("MS-TEST\n"), 0o644); err != nil {
+		t.Fatalf("write dmi: %v", err)
+	}
+
+	applyQuirk(-1)
+	if ecIOPath != ecPath(1) {
+		t.Fatalf("expected quirk ec1, got %s", ecIOPath)
+	}
+	applyQuirk(2)
+	if ecIOPath != ecPath(2) {
+		t.Fatalf("expected explicit ec2, got %s", ecIOPath)
+	}
+}
+
+func TestListECs(t *testing.T) {
+	originalRoot := ecRoot
+	ecRoot = t.TempDir()
+	t.Cleanup(func() { ecRoot = originalRoot })
+
+	for _, name := range []string{"ec1", "ec0", "ec10"} {
+		dir := filepath.Join(ecRoot, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "io"), nil, 0o600); err != nil {
+			t.Fatalf("write io: %v", err)
+		}
+	}
+
+	indexes, err := listECs()
+	if err != nil {
+		t.Fatalf("listECs: %v", err)
+	}
+	if len(indexes) != 3 || indexes[0] != 0 || indexes[1] != 1 || indexes[2] != 10 {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+}
+
+func TestCheckTestedBios(t *testing.T) {
+	originalDMI, originalTable, originalForce := dmiRoot, quirkTable, forceWrites
+	dmiRoot = t.TempDir()
+	t.Cleanup(func() { dmiRoot, quirkTable, forceWrites = originalDMI, originalTable, originalForce })
+
+	quirkTable = []quirk{{name: "test", boardName: "MS-TEST", testedBios: []string{"E1TESTIMS.11*"}}}
+	write := func(field, value string) {
+		if err := os.WriteFile(filepath.Join(dmiRoot, field), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write dmi: %v", err)
+		}
+	}
+	write("board_name", "MS-TEST")
+
+	forceWrites = false
+	write("bios_version", "E1TESTIMS.114")
+	if err := checkTestedBios(); err != nil {
+		t.Fatalf("tested BIOS: %v", err)
+	}
+	write("bios_version", "E1TESTIMS.120")
+	if err := checkTestedBios(); err == nil {
+		t.Fatalf("expected untested BIOS to be refused")
+	}
+	forceWrites = true
+	if err := checkTestedBios(); err != nil {
+		t.Fatalf("forced: %v", err)
+	}
+}
+
+func TestQuirkTableRefusesUntestedBios(t *testing.T) {
+	for _, q := range quirkTable {
+		if len(q.testedBios) == 0 {
+			t.Fatalf("%s has no tested BIOS; record the one it was verified with", q.name)
+		}
+	}
+	originalDMI, originalForce := dmiRoot, forceWrites
+	dmiRoot = t.TempDir()
+	t.Cleanup(func() { dmiRoot, forceWrites = originalDMI, originalForce })
+	write := func(field, value string) {
+		if err := os.WriteFile(filepath.Join(dmiRoot, field), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write dmi: %v", err)
+		}
+	}
+
+	write("board_name", "MS-17KK")
+	write("bios_version", "E17KKIMS.114")
+	forceWrites = false
+	if err := checkTestedBios(); err != nil {
+		t.Fatalf("tested BIOS: %v", err)
+	}
+	write("bios_version", "E17KKIMS.120")
+	if err := checkTestedBios(); !errors.Is(err, ErrUnsupportedModel) {
+		t.Fatalf("expected an untested BIOS on a known board to be refused, got %v", err)
+	}
+	forceWrites = true
+	if err := checkTestedBios(); err != nil {
+		t.Fatalf("forced: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The quirk table and the checks made against it are shared by every build;
+// each OS provides detectQuirk and readDMI, which read the board and BIOS
+// from SMBIOS.
+
+// quirk describes where a model keeps its GPU switch state.
+type quirk struct {
+	name      string
+	boardName string
+	ecIndex   int
+	// testedBios lists path.Match patterns of DMI bios_version values the
+	// quirk was verified against. Every quirkTable and quirk database entry
+	// has one; only defaultQuirk, which is never checked, is empty.
+	testedBios []string
+}
+
+// defaultQuirk is used for models not present in quirkTable.
+var defaultQuirk = quirk{name: "generic MSI", ecIndex: 0}
+
+var quirkTable = []quirk{
+	// Tested Hardware in the README lists the BIOS each entry was verified
+	// with; a model is only added once it has been switched on hardware.
+	{name: "MSI Alpha 17 C7VG", boardName: "MS-17KK", ecIndex: 0, testedBios: []string{"E17KKIMS.114"}},
+}
+
+// biosTested reports whether version matches one of q's tested BIOS patterns.
+func (q quirk) biosTested(version string) bool {
+	for _, pattern := range q.testedBios {
+		if ok, _ := path.Match(pattern, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownModelConfirmed is set once the user agreed to write the default EC
+// offsets on a board missing from the quirk table.
+var unknownModelConfirmed bool
+
+// checkKnownModel refuses, unless forced or confirmed, to write the default
+// EC offsets on a board missing from the quirk table. It never asks, so the
+// daemon, the helper and the other services fail with ErrUnsupportedModel.
+func checkKnownModel() error {
+	if _, known := detectQuirk(); known || forceWrites || unknownModelConfirmed {
+		return nil
+	}
+	return fmt.Errorf("%w: board %q; switch from a terminal to confirm the default offsets, or pass --force", ErrUnsupportedModel, readDMI("board_name"))
+}
+
+// confirmUnknownModel is the CLI front end of checkKnownModel: it asks, and
+// a yes lets the switch through.
+func confirmUnknownModel() error {
+	if checkKnownModel() == nil {
+		return nil
+	}
+	board := readDMI("board_name")
+	ok, err := confirm(fmt.Sprintf("Board %q is not a known model; write the default EC offsets anyway?", board))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: board %q; re-run with --yes to write the default offsets", ErrUnsupportedModel, board)
+	}
+	unknownModelConfirmed = true
+	return nil
+}
+
+// checkTestedBios refuses, unless forced, to write on a known model whose
+// BIOS is outside the versions its quirk was tested with.
+func checkTestedBios() error {
+	q, known := detectQuirk()
+	if !known || len(q.testedBios) == 0 {
+		return nil
+	}
+	version := readDMI("bios_version")
+	if q.biosTested(version) {
+		return nil
+	}
+	log.Warn().Msgf("BIOS %s is outside the versions tested on %s (%v)", version, q.name, q.testedBios)
+	if forceWrites {
+		return nil
+	}
+	return fmt.Errorf("%w: untested BIOS %s for %s; re-run with --force to write anyway", ErrUnsupportedModel, version, q.name)
+}
@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rendererTimeout bounds glxinfo and vulkaninfo, which can hang on a wedged
+// driver or a dGPU waking from D3cold.
+const rendererTimeout = 5 * time.Second
+
+// probeTool runs a diagnostic tool from the user's session and returns its
+// output, or "" if it is not installed or fails.
+func probeTool(ctx context.Context, name string, args ...string) string {
+	if _, err := exec.LookPath(name); err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, rendererTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		log.Debug().Msgf("%s failed: %v", name, err)
+		return ""
+	}
+	return string(out)
+}
+
+// parseGLRenderer extracts the renderer from `glxinfo -B` output.
+func parseGLRenderer(out string) string {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		if _, renderer, ok := strings.Cut(scanner.Text(), "OpenGL renderer string:"); ok {
+			return strings.TrimSpace(renderer)
+		}
+	}
+	return ""
+}
+
+// parseVulkanDevice returns the deviceName of the first device in
+// `vulkaninfo --summary` output. Mesa's device-select layer sorts the
+// default device first.
+func parseVulkanDevice(out string) string {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(key) == "deviceName" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// printRenderer shows which GPU the current session renders on, which is
+// what users usually mean by "the mode" and can disagree with the firmware.
+// It needs the session's DISPLAY or WAYLAND_DISPLAY, so it is mostly useful
+// when status runs as the desktop user.
+func printRenderer(ctx context.Context) {
+	outln("")
+	outln("Current session renders on:")
+	gl := parseGLRenderer(probeTool(ctx, "glxinfo", "-B"))
+	// vulkaninfo initialises every GPU, which would wake a sleeping dGPU.
+	vk := "skipped, the dGPU is asleep"
+	if !dgpuAsleep() {
+		vk = orUnknown(parseVulkanDevice(probeTool(ctx, "vulkaninfo", "--summary")))
+	}
+	if gl == "" && vk == "unknown" {
+		outln("  unknown (needs glxinfo or vulkaninfo and a graphical session)")
+		return
+	}
+	outf("  OpenGL: %s", orUnknown(gl))
+	outf("  Vulkan: %s", vk)
+}
+
+func dgpuAsleep() bool {
+	gpus, err := selectedDGPUs()
+	if err != nil {
+		return false
+	}
+	for _, g := range gpus {
+		if readFirstLine(filepath.Join(pciRoot, g.addr, "power", "runtime_status")) == "suspended" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestParseRenderers(t *testing.T) {
+	glxinfo := `name of display: :0
+display: :0  screen: 0
+direct rendering: Yes
+Extended renderer info (GLX_MESA_query_renderer):
+    Vendor: AMD (0x1002)
+OpenGL vendor string: AMD
+OpenGL renderer string: AMD Radeon 780M (radeonsi, gfx1103_r1, LLVM 18.1.8, DRM 3.59)
+OpenGL core profile version string: 4.6 (Core Profile) Mesa 24.2.3
+`
+	if got := parseGLRenderer(glxinfo); got != "AMD Radeon 780M (radeonsi, gfx1103_r1, LLVM 18.1.8, DRM 3.59)" {
+		t.Fatalf("parseGLRenderer = %q", got)
+	}
+
+	vulkaninfo := `Devices:
+========
+GPU0:
+	apiVersion         = 1.3.289
+	deviceType         = PHYSICAL_DEVICE_TYPE_DISCRETE_GPU
+	deviceName         = NVIDIA GeForce RTX 4070 Laptop GPU
+GPU1:
+	deviceType         = PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU
+	deviceName         = AMD Radeon 780M (RADV GFX1103_R1)
+`
+	if got := parseVulkanDevice(vulkaninfo); got != "NVIDIA GeForce RTX 4070 Laptop GPU" {
+		t.Fatalf("parseVulkanDevice = %q", got)
+	}
+	if parseGLRenderer("") != "" || parseVulkanDevice("") != "" {
+		t.Fatal("expected empty results for empty output")
+	}
+}
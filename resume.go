@@ -0,0 +1,123 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// desiredMode is the mode the user last asked for: the mode in the UEFI
+// variable, or without one the last successful switch in the history.
+func desiredMode(ctx context.Context) (gpuMode, error) {
+	if exists(uefiVarPath) {
+		return readUefiGpuMode(ctx)
+	}
+	if mode, ok := lastRequestedMode(); ok {
+		return mode, nil
+	}
+	return 0, errors.New("no UEFI variable and no switch history to tell the desired mode")
+}
+
+// restoreMux re-applies the EC MUX bit if it no longer matches the desired
+// mode. Some EC firmwares reset PXCT coming out of S3, which would flip the
+// panel to the other GPU on the next reboot. It reports whether it wrote.
+func restoreMux(ctx context.Context) (bool, error) {
+	want, err := desiredMode(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !exists(ecIOPath) {
+		return false, fmt.Errorf("%w (ec_sys/debugfs)", ErrECUnavailable)
+	}
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	ec, err := openEC()
+	if err != nil {
+		return false, err
+	}
+	defer ec.Close()
+	state, err := ec.readMuxState(ctx)
+	if err != nil {
+		return false, err
+	}
+	if state == want.muxDiscrete() {
+		log.Debug().Msgf("EC MUX still matches %s", want)
+		return false, nil
+	}
+	log.Warn().Msgf("EC MUX was reset while asleep (want %s); re-applying", want)
+	err = writeVerified(ctx, "EC MUX bit",
+		func() error { return ec.setMux(ctx, want.muxDiscrete()) },
+		func() (bool, error) {
+			state, err := ec.readMuxState(ctx)
+			return state == want.muxDiscrete(), err
+		})
+	if err != nil {
+		return false, fmt.Errorf("restore EC MUX failed: %w", err)
+	}
+	return true, nil
+}
+
+// verifyAfterHibernate compares the UEFI variable with the last switch in
+// the history. Resuming from hibernation goes through POST, and a firmware
+// that restored its defaults leaves the variable at another mode while the
+// resumed OS still assumes the old one. With reconcile the requested mode is
+// written again, otherwise the mismatch is only reported.
+func verifyAfterHibernate(ctx context.Context, reconcile bool) error {
+	want, ok := lastRequestedMode()
+	if !ok || !exists(uefiVarPath) {
+		return nil
+	}
+	got, err := readUefiGpuMode(ctx)
+	if err != nil {
+		return err
+	}
+	if got == want {
+		return nil
+	}
+	log.Warn().Msgf("UEFI GPU mode is %s after hibernation, but %s was last requested; the firmware may have restored its defaults", got, want)
+	if !reconcile {
+		log.Warn().Msgf("run \"msi-gpu-switcher switch %s\" to request it again", want)
+		return nil
+	}
+	log.Info().Msgf("re-applying %s", want)
+	return switchGPU(ctx, want)
+}
+
+func resumeCmd() *cobra.Command {
+	var reconcile bool
+	cmd := &cobra.Command{
+		Use:   "resume [suspend|hibernate|hybrid-sleep|suspend-then-hibernate]",
+		Short: "Re-check the EC MUX and UEFI mode after resume (run by the systemd sleep hook)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			requireRoot()
+			enterSandbox()
+			kind := "suspend"
+			if len(args) == 1 {
+				kind = args[0]
+			}
+			log.Debug().Msgf("resumed from %s", kind)
+			if loadedConfig.ReconcileOnResume && !cmd.Flags().Changed("reconcile") {
+				reconcile = true
+			}
+			switch kind {
+			case "hibernate", "hybrid-sleep", "suspend-then-hibernate":
+				if err := verifyAfterHibernate(cmd.Context(), reconcile); err != nil {
+					return err
+				}
+			}
+			_, err := restoreMux(cmd.Context())
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&reconcile, "reconcile", false, "re-apply the last requested mode when hibernation left the UEFI variable at another one")
+	return cmd
+}
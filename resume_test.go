@@ -0,0 +1,63 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreMux(t *testing.T) {
+	dir := t.TempDir()
+	originalEC, originalVar, originalLock := ecIOPath, uefiVarPath, lockPath
+	ecIOPath = filepath.Join(dir, "io")
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	lockPath = filepath.Join(dir, "lock")
+	t.Cleanup(func() { ecIOPath, uefiVarPath, lockPath = originalEC, originalVar, originalLock })
+
+	if err := os.WriteFile(ecIOPath, make([]byte, ecSize), 0o600); err != nil {
+		t.Fatalf("write ec: %v", err)
+	}
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 1, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+
+	ctx := context.Background()
+	wrote, err := restoreMux(ctx)
+	if err != nil || !wrote {
+		t.Fatalf("restoreMux = %v, %v", wrote, err)
+	}
+	raw, err := os.ReadFile(ecIOPath)
+	if err != nil {
+		t.Fatalf("read ec: %v", err)
+	}
+	if raw[ecMuxOffset]&ecMuxMask == 0 {
+		t.Fatalf("MUX bit not restored: 0x%02x", raw[ecMuxOffset])
+	}
+	if wrote, err := restoreMux(ctx); err != nil || wrote {
+		t.Fatalf("second restoreMux = %v, %v; want no write", wrote, err)
+	}
+}
+
+func TestVerifyAfterHibernateReportsReset(t *testing.T) {
+	dir := t.TempDir()
+	originalVar, originalState := uefiVarPath, stateDir
+	uefiVarPath = filepath.Join(dir, "MsiDCVarData-"+msiVendorGuid)
+	stateDir = dir
+	t.Cleanup(func() { uefiVarPath, stateDir = originalVar, originalState })
+
+	recordSwitch(modeDiscrete, []string{"uefi", "ec"}, nil)
+	// The firmware restored hybrid while the machine was hibernated.
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write var: %v", err)
+	}
+	if err := verifyAfterHibernate(context.Background(), false); err != nil {
+		t.Fatalf("verifyAfterHibernate: %v", err)
+	}
+	// Without reconcile nothing is written.
+	if mode, err := readUefiGpuMode(context.Background()); err != nil || mode != modeHybrid {
+		t.Fatalf("UEFI mode = %s, %v", mode, err)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// primeEnv returns the variables that make a program render on the dGPU in
+// hybrid mode. In discrete mode the dGPU already renders everything, so none
+// are needed.
+func primeEnv(mode gpuMode, dgpu gpuInfo) ([]string, error) {
+	switch mode {
+	case modeDiscrete:
+		return nil, nil
+	case modeIntegrated:
+		return nil, errors.New("the dGPU is disabled in integrated mode")
+	}
+	switch dgpu.vendor {
+	case nvidiaVendor:
+		return []string{
+			"__NV_PRIME_RENDER_OFFLOAD=1",
+			"__GLX_VENDOR_LIBRARY_NAME=nvidia",
+			"__VK_LAYER_NV_optimus=NVIDIA_only",
+		}, nil
+	case amdVendor:
+		// Mesa takes the PCI tag with every separator turned into '_'.
+		tag := strings.NewReplacer(":", "_", ".", "_").Replace(dgpu.addr)
+		return []string{"DRI_PRIME=pci-" + tag}, nil
+	}
+	return nil, fmt.Errorf("no PRIME offload support for vendor %s", dgpu.vendor)
+}
+
+func runCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run -- <command> [args...]",
+		Short: "Run a command on the discrete GPU (PRIME render offload)",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			mode, err := activeMode()
+			if err != nil {
+				return err
+			}
+			var dgpu gpuInfo
+			if mode == modeHybrid {
+				if dgpu, err = selectDGPU(); err != nil {
+					return err
+				}
+			}
+			env, err := primeEnv(mode, dgpu)
+			if err != nil {
+				return err
+			}
+			path, err := exec.LookPath(args[0])
+			if err != nil {
+				return err
+			}
+			log.Debug().Msgf("running %s in %s mode with %v", path, mode, env)
+			return syscall.Exec(path, args, append(os.Environ(), env...))
+		},
+	}
+	// Flags after the command name belong to the command.
+	cmd.Flags().SetInterspersed(false)
+	return cmd
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPrimeEnv(t *testing.T) {
+	env, err := primeEnv(modeHybrid, gpuInfo{addr: "0000:01:00.0", vendor: nvidiaVendor})
+	if err != nil || !slices.Contains(env, "__NV_PRIME_RENDER_OFFLOAD=1") || !slices.Contains(env, "__GLX_VENDOR_LIBRARY_NAME=nvidia") {
+		t.Fatalf("nvidia env = %v, %v", env, err)
+	}
+	env, err = primeEnv(modeHybrid, gpuInfo{addr: "0000:03:00.0", vendor: amdVendor})
+	if err != nil || !slices.Equal(env, []string{"DRI_PRIME=pci-0000_03_00_0"}) {
+		t.Fatalf("amd env = %v, %v", env, err)
+	}
+	if env, err := primeEnv(modeDiscrete, gpuInfo{}); err != nil || env != nil {
+		t.Fatalf("discrete env = %v, %v", env, err)
+	}
+	if _, err := primeEnv(modeIntegrated, gpuInfo{}); err == nil {
+		t.Fatal("expected an error in integrated mode")
+	}
+}
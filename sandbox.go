@@ -0,0 +1,234 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// useSandbox turns enterSandbox on (--sandbox).
+var useSandbox bool
+
+// sandboxed is set once enterSandbox has restricted the process.
+var sandboxed bool
+
+// Landlock access rights, as sets.
+const (
+	landlockRead  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockExec  = landlockRead | unix.LANDLOCK_ACCESS_FS_EXECUTE
+	landlockWrite = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR
+	// landlockFile are the rights that apply to a file rather than a
+	// directory.
+	landlockFile = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// landlockHandled are the rights each Landlock ABI version restricts, by
+// version: 1 has the first 13, 2 adds REFER, 3 TRUNCATE, 5 IOCTL_DEV.
+var landlockHandled = []uint64{
+	0,
+	0x1fff,
+	0x1fff | unix.LANDLOCK_ACCESS_FS_REFER,
+	0x1fff | unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE,
+}
+
+type landlockRule struct {
+	path   string
+	access uint64
+}
+
+// sandboxExecDirs hold the programs hooks and external tools like systemctl
+// run, and their libraries.
+var sandboxExecDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/nix", "/opt"}
+
+// sandboxRules are the paths a switch may touch: everything it reads, and
+// for writing only the files in debugfs (for the EC node), the GPU mode
+// variable and our own state, audit, log and lock files. A replay's hardware
+// paths are the capture's.
+func sandboxRules() []landlockRule {
+	rules := []landlockRule{
+		{hostPath("/sys"), landlockRead},
+		{hostPath("/proc"), landlockRead},
+		{"/dev", landlockRead},
+		{"/etc", landlockRead},
+		{"/run", landlockRead},
+		{filepath.Dir(configPath), landlockRead},
+		{hooksDir, landlockExec},
+		{"/dev/null", landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE},
+		// ec_sys creates its directories when it is loaded and removes them
+		// when it is unloaded, taking rules on them along, so the EC is
+		// granted through debugfs itself: a daemon keeps reaching it after
+		// ec_sys is loaded or reloaded, or a reload picks another EC.
+		{hostPath(filepath.Dir(ecRoot)), landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE},
+		{hostPath(uefiVarPath), landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE},
+		{stateDir, landlockWrite},
+		{filepath.Dir(auditPath), landlockWrite},
+		{lockPath, landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE},
+	}
+	for _, dir := range sandboxExecDirs {
+		rules = append(rules, landlockRule{dir, landlockExec})
+	}
+	if createUefiVar {
+		rules = append(rules, landlockRule{hostPath(filepath.Dir(uefiVarPath)), unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE})
+	}
+	if logFile != nil {
+		// Rotation renames and creates files next to it.
+		rules = append(rules, landlockRule{filepath.Dir(logFile.path), landlockWrite})
+	}
+	return rules
+}
+
+// enterSandbox restricts a root process that is about to write firmware
+// state, with --sandbox: Landlock limits it to sandboxRules, and seccomp
+// denies the system calls that would let it reach further than that, such as
+// loading modules, mounting or tracing other processes. Hooks and the tools a
+// switch runs inherit both, which is why it is off by default: hooks that
+// write outside those paths, load modules or talk to the NVIDIA driver fail
+// inside it. Either is skipped, with a log line, where the kernel or the build
+// lacks it.
+func enterSandbox() {
+	if !useSandbox || sandboxed {
+		return
+	}
+	// Files a switch opens later have to exist now for their rules.
+	for _, dir := range []string{stateDir, filepath.Dir(auditPath)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Debug().Msgf("sandbox: %v", err)
+		}
+	}
+	if f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600); err == nil {
+		f.Close()
+	}
+	if err := landlockRestrict(sandboxRules()); err != nil {
+		log.Warn().Msgf("not sandboxed with Landlock: %v", err)
+	}
+	if err := seccompRestrict(); err != nil {
+		log.Warn().Msgf("not sandboxed with seccomp: %v", err)
+	}
+	sandboxed = true
+}
+
+func landlockRestrict(rules []landlockRule) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("Landlock unavailable: %w", errno)
+	}
+	handled := landlockHandled[min(int(abi), len(landlockHandled)-1)]
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	// Only the filesystem rights are handled, so the attribute is passed
+	// without the fields for network rights and scopes newer ABIs added.
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(unix.LandlockRulesetAttr{}.Access_fs), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset failed: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, rule := range rules {
+		if err := landlockAddRule(int(fd), rule, handled); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+	}
+	// landlock_restrict_self only restricts the calling thread; the runtime
+	// repeats it on every thread, which it cannot do in a cgo build.
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("this is a cgo build, which cannot restrict every thread")
+		}
+		return fmt.Errorf("restrict failed: %w", errno)
+	}
+	log.Debug().Msgf("sandbox: Landlock ABI %d, %d rules", abi, len(rules))
+	return nil
+}
+
+func landlockAddRule(ruleset int, rule landlockRule, handled uint64) error {
+	fd, err := unix.Open(rule.path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: rule.path, Err: err}
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: rule.path, Err: err}
+	}
+	access := rule.access & handled
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFile
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add Landlock rule for %s failed: %w", rule.path, errno)
+	}
+	return nil
+}
+
+// seccompDenied are the system calls no switch needs, in any architecture.
+// They fail with EPERM.
+var seccompDenied = []uintptr{
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_REBOOT,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_FSOPEN, unix.SYS_FSMOUNT, unix.SYS_FSCONFIG, unix.SYS_FSPICK,
+	unix.SYS_MOVE_MOUNT, unix.SYS_OPEN_TREE, unix.SYS_MOUNT_SETATTR,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_QUOTACTL, unix.SYS_ACCT,
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_CLOCK_ADJTIME, unix.SYS_ADJTIMEX,
+	unix.SYS_SETHOSTNAME, unix.SYS_SETDOMAINNAME, unix.SYS_SYSLOG, unix.SYS_VHANGUP,
+	unix.SYS_OPEN_BY_HANDLE_AT, unix.SYS_LOOKUP_DCOOKIE, unix.SYS_NFSSERVCTL,
+}
+
+// seccompFilter returns the BPF program: system calls of another
+// architecture kill the process, the denied ones return EPERM and the rest
+// are allowed.
+func seccompFilter(arch, minDenied uint32, denied []uintptr) []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter { return unix.SockFilter{Code: code, K: k} }
+	jeq := func(k uint32, jt uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: jt, K: k}
+	}
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4), // seccomp_data.arch
+		jeq(arch, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0), // seccomp_data.nr
+	}
+	if minDenied != 0 {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: uint8(len(denied) + 1), K: minDenied})
+	}
+	for i, nr := range denied {
+		prog = append(prog, jeq(uint32(nr), uint8(len(denied)-i)))
+	}
+	return append(prog,
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)))
+}
+
+func seccompRestrict() error {
+	if seccompArch == 0 {
+		return errors.New("no filter for this architecture")
+	}
+	prog := seccompFilter(seccompArch, seccompMinDenied, append(seccompDenied, seccompArchDenied...))
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	// TSYNC applies the filter to every thread of the process.
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("install filter failed: %w", errno)
+	}
+	log.Debug().Msgf("sandbox: seccomp denies %d system calls", len(seccompDenied)+len(seccompArchDenied))
+	return nil
+}
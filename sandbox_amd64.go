@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompArch = unix.AUDIT_ARCH_X86_64
+	// seccompMinDenied denies the x32 system calls, which share the arch.
+	seccompMinDenied = 0x40000000
+)
+
+var seccompArchDenied = []uintptr{
+	unix.SYS_IOPL, unix.SYS_IOPERM, unix.SYS_CREATE_MODULE, unix.SYS_USELIB, unix.SYS__SYSCTL,
+}
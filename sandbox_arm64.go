@@ -0,0 +1,12 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const (
+	seccompArch      = unix.AUDIT_ARCH_AARCH64
+	seccompMinDenied = 0
+)
+
+var seccompArchDenied []uintptr
@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// Other architectures have no seccomp filter; Landlock still applies.
+const (
+	seccompArch      = 0
+	seccompMinDenied = 0
+)
+
+var seccompArchDenied []uintptr
@@ -0,0 +1,144 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestSandbox runs TestSandboxChild in a child process, since a sandbox
+// cannot be left again.
+func TestSandbox(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the sandbox is only entered as root")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSandboxChild$", "-test.v")
+	// The child's temp dirs end up in ours, so they are cleaned up here.
+	cmd.Env = append(os.Environ(), "MSI_GPU_SWITCHER_SANDBOX_CHILD=1", "TMPDIR="+t.TempDir())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("child skipped:\n%s", out)
+	}
+}
+
+func TestSandboxChild(t *testing.T) {
+	if os.Getenv("MSI_GPU_SWITCHER_SANDBOX_CHILD") == "" {
+		t.Skip("run by TestSandbox")
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION); errno != 0 {
+		t.Skipf("Landlock unavailable: %v", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_GETPID, 0, 0, 0); errno == syscall.ENOTSUP {
+		t.Skip("Landlock is skipped in cgo builds")
+	}
+	laptopFixture(t)
+	dir := t.TempDir()
+	stateDir, auditPath, lockPath = filepath.Join(dir, "state"), filepath.Join(dir, "log", "audit.jsonl"), filepath.Join(dir, "test.lock")
+	outside := filepath.Join(dir, "outside")
+
+	// Started before the sandbox, this stands in for ec_sys being reloaded
+	// while we are sandboxed: the EC directory goes away and comes back.
+	fifo := filepath.Join(stateDir, "reload")
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(fifo, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ecDir := hostPath(ecRoot)
+	reload := exec.Command("sh", "-c", `read _ < "$1" && rm -rf "$2" && mkdir -p "$2/ec0" && head -c 256 /dev/zero > "$2/ec0/io"`, "sh", fifo, ecDir)
+	if err := reload.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	useSandbox = true
+	enterSandbox()
+	// The test's cleanups would fail inside the sandbox, so the child exits
+	// before them.
+	defer func() {
+		if t.Failed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+	ctx := context.Background()
+
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	if err := ec.writeByte(ctx, 0xd1, 0x01); err != nil {
+		t.Fatalf("EC write: %v", err)
+	}
+	ec.Close()
+	data := []byte{0, byte(modeDiscrete), 0, 0}
+	if err := writeUefiVar(ctx, uefiDefaultAttrs, data); err != nil {
+		t.Fatalf("UEFI write: %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v", mode, err)
+	}
+	if err := appendHistory(historyEntry{Mode: "discrete", Result: "ok"}); err != nil {
+		t.Fatalf("state write: %v", err)
+	}
+	unlock, err := acquireLock(ctx)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	unlock()
+	if err := os.WriteFile(fifo, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("signal reload: %v", err)
+	}
+	if err := reload.Wait(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if ec, err = openEC(); err != nil {
+		t.Fatalf("openEC after an ec_sys reload: %v", err)
+	}
+	if err := ec.writeByte(ctx, 0xd1, 0x01); err != nil {
+		t.Fatalf("EC write after an ec_sys reload: %v", err)
+	}
+	ec.Close()
+	// Hooks and systemctl run inside the sandbox.
+	if out, err := exec.Command("sh", "-c", "echo ok").Output(); err != nil || string(out) != "ok\n" {
+		t.Fatalf("running a command: %q %v", out, err)
+	}
+
+	if err := os.WriteFile(outside, nil, 0o644); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected a write outside the rules to be refused, got %v", err)
+	}
+	// An unknown keyctl operation fails with EOPNOTSUPP unless it is denied.
+	if _, _, errno := unix.Syscall(unix.SYS_KEYCTL, 0xffff, 0, 0); errno != unix.EPERM {
+		t.Fatalf("expected keyctl to be denied, got %v", errno)
+	}
+}
+
+func TestSeccompFilter(t *testing.T) {
+	prog := seccompFilter(seccompArch, 0x40000000, []uintptr{10, 20})
+	// Arch check, kill, load nr, JGE, two JEQs, allow, errno.
+	if len(prog) != 9 {
+		t.Fatalf("unexpected program length %d", len(prog))
+	}
+	errnoAt := len(prog) - 1
+	for i, ins := range prog[4:7] {
+		pc := 4 + i
+		if pc+1+int(ins.Jt) != errnoAt {
+			t.Fatalf("instruction %d jumps to %d, not the EPERM return", pc, pc+1+int(ins.Jt))
+		}
+	}
+	if prog[errnoAt].K != unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM) || prog[errnoAt-1].K != unix.SECCOMP_RET_ALLOW {
+		t.Fatalf("unexpected returns %+v", prog[errnoAt-1:])
+	}
+}
@@ -0,0 +1,139 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// scheduleUnitPrefix names the transient timers made by schedule, so they
+// can be listed and cancelled together.
+const scheduleUnitPrefix = "msi-gpu-switcher-schedule-"
+
+func systemdRun(ctx context.Context, args ...string) error {
+	out, err := runCommand(ctx, "systemd-run", args...)
+	if err != nil {
+		return fmt.Errorf("systemd-run: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// scheduleArgs builds the systemd-run call for a transient timer firing at
+// the OnCalendar= time at. With reboot the machine restarts into mode right
+// after a successful switch. The switch gets --yes, since it was confirmed
+// when it was scheduled and has no terminal to ask on.
+func scheduleArgs(bin string, mode gpuMode, at, unit string, reboot bool) []string {
+	args := []string{
+		"--unit=" + unit,
+		"--on-calendar=" + at,
+		"--timer-property=AccuracySec=1s",
+		"--description=Scheduled switch to " + mode.String() + " GPU mode",
+	}
+	switchCmd := []string{bin, "--config", configPath, "--no-elevate", "--yes", "switch", mode.String()}
+	if !reboot {
+		return append(args, switchCmd...)
+	}
+	return append(args, "/bin/sh", "-c", shellJoin(switchCmd)+" && systemctl reboot")
+}
+
+// shellJoin quotes args for sh -c.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func scheduleCmd() *cobra.Command {
+	var (
+		at     string
+		reboot bool
+	)
+	cmd := &cobra.Command{
+		Use:   "schedule <mode>",
+		Short: "Stage a switch at a later time with a transient systemd timer",
+		Long: "Create a transient systemd timer that switches to mode at --at, given in\n" +
+			"systemd.time(7) calendar syntax (e.g. \"22:00\" or \"2025-06-01 03:00\").\n" +
+			"Transient timers do not survive a reboot.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeModes,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mode, err := parseMode(args[0])
+			if err != nil {
+				return err
+			}
+			if err := checkModeSupported(mode); err != nil {
+				return err
+			}
+			if err := checkWritable(); err != nil {
+				return err
+			}
+			requireRoot()
+			if err := confirmUnknownModel(); err != nil {
+				return err
+			}
+			// The timer switches unattended, so a new BIOS is accepted now.
+			if err := confirmFirmware(cmd.Context()); err != nil {
+				return err
+			}
+			if reboot {
+				ok, err := confirm(fmt.Sprintf("Reboot right after switching to %s at %q?", mode, at))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errors.New("not scheduling a reboot; re-run with --yes to confirm")
+				}
+			}
+			unit := fmt.Sprintf("%s%s-%d", scheduleUnitPrefix, mode, time.Now().Unix())
+			if err := systemdRun(cmd.Context(), scheduleArgs(installBinary(), mode, at, unit, reboot)...); err != nil {
+				return err
+			}
+			then := ""
+			if reboot {
+				then = " and reboot"
+			}
+			log.Info().Msgf("will switch to %s%s at %q (%s.timer)", mode, then, at, unit)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&at, "at", "", "when to switch, as a systemd OnCalendar= time")
+	cmd.Flags().BoolVar(&reboot, "reboot", false, "reboot right after the switch")
+	_ = cmd.MarkFlagRequired("at")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List scheduled switches",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out, err := runCommand(cmd.Context(), "systemctl", "list-timers", "--all", scheduleUnitPrefix+"*")
+			fmt.Print(string(out))
+			return err
+		},
+	}, &cobra.Command{
+		Use:   "cancel <unit>",
+		Short: "Cancel a scheduled switch",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			unit := strings.TrimSuffix(args[0], ".timer")
+			if !strings.HasPrefix(unit, scheduleUnitPrefix) {
+				return fmt.Errorf("%s is not a scheduled switch", args[0])
+			}
+			requireRoot()
+			if err := systemctl(cmd.Context(), "stop", unit+".timer"); err != nil {
+				return err
+			}
+			log.Info().Msgf("cancelled %s", unit)
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScheduleArgs(t *testing.T) {
+	original := configPath
+	configPath = "/etc/msi-gpu-switcher/config.json"
+	t.Cleanup(func() { configPath = original })
+
+	args := scheduleArgs("/usr/bin/msi-gpu-switcher", modeDiscrete, "22:00", "msi-gpu-switcher-schedule-discrete-1", false)
+	want := []string{
+		"--unit=msi-gpu-switcher-schedule-discrete-1",
+		"--on-calendar=22:00",
+		"--timer-property=AccuracySec=1s",
+		"--description=Scheduled switch to discrete GPU mode",
+		"/usr/bin/msi-gpu-switcher", "--config", "/etc/msi-gpu-switcher/config.json", "--no-elevate", "--yes", "switch", "discrete",
+	}
+	if !slices.Equal(args, want) {
+		t.Fatalf("scheduleArgs = %q, want %q", args, want)
+	}
+
+	args = scheduleArgs("/opt/it's/msi-gpu-switcher", modeHybrid, "03:00", "u", true)
+	script := `'/opt/it'\''s/msi-gpu-switcher' '--config' '/etc/msi-gpu-switcher/config.json' '--no-elevate' '--yes' 'switch' 'hybrid' && systemctl reboot`
+	if tail := args[len(args)-3:]; !slices.Equal(tail, []string{"/bin/sh", "-c", script}) {
+		t.Fatalf("reboot command = %q", tail)
+	}
+}
@@ -0,0 +1,236 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// schemaVersion is the version of every JSON document the CLI prints. It is
+// bumped when a field is removed or changes meaning; new optional fields do
+// not bump it.
+const schemaVersion = 1
+
+// statusReport is status --json.
+type statusReport struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Model         string `json:"model"`
+	BiosVersion   string `json:"bios_version"`
+	BiosTested    *bool  `json:"bios_tested,omitempty"`
+	// Active is the mode this boot runs in and ActiveSource what it was
+	// read from (drm, boot_vga or pci); Pending is what the UEFI variable
+	// requests for the next boot.
+	Active         string          `json:"active,omitempty"`
+	ActiveSource   string          `json:"active_source,omitempty"`
+	Pending        string          `json:"pending,omitempty"`
+	EcMux          string          `json:"ec_mux,omitempty"`
+	Panel          *panelOwner     `json:"panel,omitempty"`
+	GPUs           []gpuDetails    `json:"gpus"`
+	Uefi           *uefiStatus     `json:"uefi,omitempty"`
+	Once           *onceSwitch     `json:"once,omitempty"`
+	Recommendation *recommendation `json:"recommendation,omitempty"`
+	// Errors lists the parts that could not be read.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type uefiStatus struct {
+	Name      string `json:"name"`
+	Attrs     uint32 `json:"attrs"`
+	Size      int    `json:"size"`
+	Immutable *bool  `json:"immutable,omitempty"`
+	Layout    string `json:"layout,omitempty"`
+	// LayoutVersion is the version of the layout that parsed the variable.
+	LayoutVersion int `json:"layout_version,omitempty"`
+}
+
+// doctorReport is doctor --json.
+type doctorReport struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Checks        []doctorResult `json:"checks"`
+	Failed        int            `json:"failed"`
+}
+
+type doctorResult struct {
+	Name string `json:"name"`
+	// Status is "ok", "warn" or "fail".
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	// Hints say what to do about a warning or failure, e.g. policy rules.
+	Hints []string `json:"hints,omitempty"`
+}
+
+// gpusReport is gpus --json.
+type gpusReport struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	GPUs          []gpuDetails `json:"gpus"`
+}
+
+// schemaDocs are the documents `schema` describes, by name.
+var schemaDocs = map[string]any{
+	"status": statusReport{},
+	"doctor": doctorReport{},
+	"gpus":   gpusReport{},
+}
+
+func schemaNames() []string {
+	names := make([]string, 0, len(schemaDocs))
+	for name := range schemaDocs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func collectStatus(ctx context.Context) statusReport {
+	r := statusReport{SchemaVersion: schemaVersion, GPUs: []gpuDetails{}}
+	r.Model, r.BiosVersion, r.BiosTested = modelInfo()
+	failed := func(what string, err error) {
+		r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	if mode, source, err := activeModeSource(); err == nil {
+		r.Active, r.ActiveSource = mode.String(), source
+	}
+	if gpus, err := gpuDetailsList(); err != nil {
+		failed("gpus", err)
+	} else {
+		r.GPUs = gpus
+	}
+	if owner, err := detectPanelOwner(); err == nil {
+		r.Panel = &owner
+	}
+
+	switch {
+	case exists(ecIOPath):
+		ec, err := openEC()
+		if err == nil {
+			var discrete bool
+			discrete, err = ec.readMuxState(ctx)
+			ec.Close()
+			if err == nil {
+				r.EcMux = muxLabel(discrete)
+			}
+		}
+		if err != nil {
+			failed("ec", err)
+		}
+	case useHelper():
+		if state, err := helperCall(ctx, "mux"); err != nil {
+			failed("ec", err)
+		} else {
+			r.EcMux = state
+		}
+	}
+
+	if exists(uefiVarPath) {
+		if attrs, data, err := readUefiVar(ctx); err != nil {
+			failed("uefi", err)
+		} else {
+			u := &uefiStatus{Name: uefiVarName, Attrs: attrs, Size: len(data)}
+			if immutable, err := isImmutable(uefiVarPath); err == nil {
+				u.Immutable = &immutable
+			}
+			if v, err := parseMsiDC(data); err == nil {
+				u.Layout, u.LayoutVersion = v.layout.name, v.layout.version
+				if mode, err := v.mode(); err == nil {
+					r.Pending = mode.String()
+				}
+			}
+			r.Uefi = u
+		}
+	}
+
+	if o, found, err := loadOnce(); err == nil && found {
+		r.Once = &o
+	}
+	if rec, found, err := loadRecommendation(); err == nil && found {
+		r.Recommendation = &rec
+	}
+	return r
+}
+
+func muxLabel(discrete bool) string {
+	if discrete {
+		return modeDiscrete.String()
+	}
+	return modeHybrid.String()
+}
+
+// jsonSchema describes t, following its json tags: fields without omitempty
+// are required.
+func jsonSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		required := []string{}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "required": required}
+	}
+	return map[string]any{}
+}
+
+// documentSchema is the JSON Schema of the document called name.
+func documentSchema(name string) map[string]any {
+	s := jsonSchema(reflect.TypeOf(schemaDocs[name]))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "msi-gpu-switcher " + name + " --json"
+	s["properties"].(map[string]any)["schemaVersion"] = map[string]any{"const": schemaVersion}
+	return s
+}
+
+func schemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "schema <" + strings.Join(schemaNames(), "|") + ">",
+		Short:     "Print the JSON Schema of a command's --json output",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: schemaNames(),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return printJSON(documentSchema(args[0]))
+		},
+	}
+}
@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// checkSchema is just enough of a validator for the schemas jsonSchema
+// builds: types, required fields and no unknown ones.
+func checkSchema(t *testing.T, path string, schema map[string]any, v any) {
+	t.Helper()
+	if want, ok := schema["const"]; ok {
+		if v != float64(want.(int)) {
+			t.Fatalf("%s = %v, want %v", path, v, want)
+		}
+		return
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			t.Fatalf("%s is %T, want an object", path, v)
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range schema["required"].([]string) {
+			if _, ok := obj[name]; !ok {
+				t.Fatalf("%s lacks required %q", path, name)
+			}
+		}
+		for name, value := range obj {
+			sub, ok := props[name].(map[string]any)
+			if !ok {
+				t.Fatalf("%s has %q, which the schema does not describe", path, name)
+			}
+			checkSchema(t, path+"."+name, sub, value)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			t.Fatalf("%s is %T, want an array", path, v)
+		}
+		for _, item := range items {
+			checkSchema(t, path+"[]", schema["items"].(map[string]any), item)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			t.Fatalf("%s is %T, want a string", path, v)
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			t.Fatalf("%s is %T, want a number", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Fatalf("%s is %T, want a boolean", path, v)
+		}
+	}
+}
+
+func TestDocumentsMatchSchema(t *testing.T) {
+	tested := true
+	docs := map[string]any{
+		"status": statusReport{
+			SchemaVersion:  schemaVersion,
+			Model:          "GP66 Leopard",
+			BiosTested:     &tested,
+			Active:         "hybrid",
+			Panel:          &panelOwner{GPU: "0000:00:02.0", Source: "drm"},
+			GPUs:           []gpuDetails{{Address: "0000:01:00.0", Link: &pcieLink{Speed: "16 GT/s"}, NVIDIA: &nvidiaDetails{Persistence: true}}},
+			Uefi:           &uefiStatus{Name: "MsiDCVarData", Attrs: 7, Size: 4},
+			Recommendation: &recommendation{Mode: "hybrid", Reason: "on battery"},
+		},
+		"doctor": doctorReport{SchemaVersion: schemaVersion, Checks: []doctorResult{{Name: "root", Status: "warn"}}, Failed: 0},
+		"gpus":   gpusReport{SchemaVersion: schemaVersion, GPUs: []gpuDetails{}},
+	}
+	for _, name := range schemaNames() {
+		doc, ok := docs[name]
+		if !ok {
+			t.Fatalf("no sample document for schema %s", name)
+		}
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			t.Fatal(err)
+		}
+		checkSchema(t, name, documentSchema(name), v)
+	}
+}
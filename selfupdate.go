@@ -0,0 +1,327 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+var releasesURL = "https://api.github.com/repos/ElXreno/msi-gpu-switcher/releases/latest"
+
+// releasePublicKey is the base64 Ed25519 public key that signs the release
+// checksums.txt, set at build time like version:
+//
+//	-X main.releasePublicKey=<key>
+var releasePublicKey = ""
+
+// executablePath is replaced in tests.
+var executablePath = os.Executable
+
+const (
+	releaseChecksums = "checksums.txt"
+	// releaseMaxDownload bounds every download; archives are a few MiB.
+	releaseMaxDownload = 64 << 20
+)
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r githubRelease) assetURL(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+func selfUpdateCmd() *cobra.Command {
+	var check, force, checksumOnly bool
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with the latest GitHub release",
+		Long: "Download the latest release for this platform, verify it against the release's\n" +
+			"signed checksums.txt and atomically replace the running binary. Meant for the\n" +
+			"static binary; installs managed by Nix or a package manager are left alone\n" +
+			"unless --force is given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !check {
+				if err := checkNotReplaying("self-update"); err != nil {
+					return err
+				}
+			}
+			return selfUpdate(cmd.Context(), check, force, checksumOnly)
+		},
+	}
+	cmd.Flags().BoolVar(&check, "check", false, "only report whether a newer release exists")
+	cmd.Flags().BoolVar(&force, "force", false, "install even when up to date or managed by a package manager")
+	cmd.Flags().BoolVar(&checksumOnly, "checksum-only", false, "accept an unsigned checksum, for builds without a release key")
+	return cmd
+}
+
+func selfUpdate(ctx context.Context, check, force, checksumOnly bool) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	exe, err := executablePath()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	raw, err := download(ctx, releasesURL)
+	if err != nil {
+		return fmt.Errorf("check for updates failed: %w", err)
+	}
+	var release githubRelease
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return fmt.Errorf("parse release: %w", err)
+	}
+	latest := strings.TrimPrefix(release.TagName, "v")
+	if !newerVersion(latest, version) && !force {
+		log.Info().Msgf("msi-gpu-switcher %s is up to date", version)
+		return nil
+	}
+	if check {
+		outf("msi-gpu-switcher %s is available (running %s)", latest, version)
+		return nil
+	}
+	if manager := packageManager(exe); manager != "" && !force {
+		return fmt.Errorf("%s is managed by %s; update it through that, or pass --force", exe, manager)
+	}
+	if releasePublicKey == "" && !checksumOnly {
+		return errors.New("this build has no release signing key; pass --checksum-only to trust the checksum alone")
+	}
+	if unix.Access(filepath.Dir(exe), unix.W_OK) != nil {
+		requireRoot()
+	}
+
+	sums, err := downloadAsset(ctx, release, releaseChecksums)
+	if err != nil {
+		return err
+	}
+	if releasePublicKey != "" {
+		sig, err := downloadAsset(ctx, release, releaseChecksums+".sig")
+		if err != nil {
+			return err
+		}
+		if err := verifyReleaseSignature(releaseChecksums, sums, sig); err != nil {
+			return err
+		}
+	} else {
+		log.Warn().Msg("no release signing key in this build; trusting the checksum alone")
+	}
+
+	name := fmt.Sprintf("msi-gpu-switcher_%s_%s_%s.tar.gz", latest, runtime.GOOS, runtime.GOARCH)
+	archive, err := downloadAsset(ctx, release, name)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(sums, name, archive); err != nil {
+		return err
+	}
+	binary, err := extractReleaseBinary(archive)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return fmt.Errorf("replace %s failed: %w", exe, err)
+	}
+	log.Info().Msgf("updated %s from %s to %s", exe, version, latest)
+	return nil
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, releaseMaxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > releaseMaxDownload {
+		return nil, fmt.Errorf("GET %s: larger than %d bytes", url, releaseMaxDownload)
+	}
+	return data, nil
+}
+
+func downloadAsset(ctx context.Context, release githubRelease, name string) ([]byte, error) {
+	url, err := release.assetURL(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := download(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("download %s failed: %w", name, err)
+	}
+	return data, nil
+}
+
+// newerVersion reports whether latest is a higher x.y.z than current. A
+// current version that is not one, such as "dev", is always older.
+func newerVersion(latest, current string) bool {
+	parse := func(v string) ([]int, bool) {
+		parts := strings.Split(v, ".")
+		nums := make([]int, len(parts))
+		for i, p := range parts {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, false
+			}
+			nums[i] = n
+		}
+		return nums, true
+	}
+	l, ok := parse(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parse(current)
+	if !ok {
+		return true
+	}
+	for i := 0; i < max(len(l), len(c)); i++ {
+		var a, b int
+		if i < len(l) {
+			a = l[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
+
+// packageManager names what installed exe, or "" for a standalone copy.
+func packageManager(exe string) string {
+	switch {
+	case strings.HasPrefix(exe, "/nix/store/"):
+		return "Nix"
+	case strings.HasPrefix(exe, "/usr/bin/"), strings.HasPrefix(exe, "/bin/"):
+		return "the system package manager"
+	}
+	return ""
+}
+
+// verifyReleaseSignature checks sig, the detached signature of the file name,
+// against releasePublicKey.
+func verifyReleaseSignature(name string, data, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release signing key %q", releasePublicKey)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return fmt.Errorf("%s signature does not verify; refusing to update", name)
+	}
+	return nil
+}
+
+// verifyChecksum checks data against name's line in a sha256sum-style sums.
+func verifyChecksum(sums []byte, name string, data []byte) error {
+	for _, line := range strings.Split(string(sums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != name {
+			continue
+		}
+		got := sha256.Sum256(data)
+		if hex.EncodeToString(got[:]) != strings.ToLower(fields[0]) {
+			return fmt.Errorf("%s checksum mismatch; refusing to update", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s lists no checksum for %s", releaseChecksums, name)
+}
+
+// extractReleaseBinary returns the msi-gpu-switcher binary in a release
+// archive.
+func extractReleaseBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("no msi-gpu-switcher binary in the archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == "msi-gpu-switcher" {
+			return io.ReadAll(io.LimitReader(tr, releaseMaxDownload))
+		}
+	}
+}
+
+// replaceExecutable writes data next to exe and renames it over exe, so a
+// failure leaves the old binary in place and running copies keep theirs.
+func replaceExecutable(exe string, data []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(exe), ".msi-gpu-switcher-update-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, exe)
+}
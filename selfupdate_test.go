@@ -0,0 +1,165 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeRelease serves a 0.9.0 release with the given archive contents and
+// points self-update at it and at a temp executable, which it returns.
+// tamper, if set, replaces the checksums and their signature; sign makes a
+// valid one.
+func fakeRelease(t *testing.T, binary []byte, tamper func(sums []byte, sign func([]byte) []byte) ([]byte, []byte)) string {
+	t.Helper()
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "msi-gpu-switcher", Mode: 0o755, Size: int64(len(binary))})
+	tw.Write(binary)
+	tw.Close()
+	gz.Close()
+
+	name := fmt.Sprintf("msi-gpu-switcher_0.9.0_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	sum := sha256.Sum256(archive.Bytes())
+	sums := []byte(fmt.Sprintf("%x  %s\n", sum, name))
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	sign := func(data []byte) []byte { return ed25519.Sign(priv, data) }
+	sig := sign(sums)
+	if tamper != nil {
+		sums, sig = tamper(sums, sign)
+	}
+
+	files := map[string][]byte{name: archive.Bytes(), releaseChecksums: sums, releaseChecksums + ".sig": sig}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" {
+			release := map[string]any{"tag_name": "v0.9.0"}
+			var assets []map[string]string
+			for n := range files {
+				assets = append(assets, map[string]string{"name": n, "browser_download_url": "http://" + r.Host + "/" + n})
+			}
+			release["assets"] = assets
+			json.NewEncoder(w).Encode(release)
+			return
+		}
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	exe := filepath.Join(t.TempDir(), "msi-gpu-switcher")
+	if err := os.WriteFile(exe, []byte("old"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	originalURL, originalKey, originalExe, originalVersion := releasesURL, releasePublicKey, executablePath, version
+	releasesURL, releasePublicKey, version = srv.URL+"/latest", base64.StdEncoding.EncodeToString(pub), "0.8.2"
+	executablePath = func() (string, error) { return exe, nil }
+	t.Cleanup(func() {
+		releasesURL, releasePublicKey, executablePath, version = originalURL, originalKey, originalExe, originalVersion
+	})
+	return exe
+}
+
+func TestSelfUpdate(t *testing.T) {
+	exe := fakeRelease(t, []byte("new"), nil)
+	if err := selfUpdate(context.Background(), true, false, false); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "old" {
+		t.Fatalf("--check replaced the binary")
+	}
+	if err := selfUpdate(context.Background(), false, false, false); err != nil {
+		t.Fatalf("selfUpdate: %v", err)
+	}
+	got, _ := os.ReadFile(exe)
+	info, _ := os.Stat(exe)
+	if string(got) != "new" || info.Mode().Perm() != 0o755 {
+		t.Fatalf("binary not replaced: %q %v", got, info.Mode())
+	}
+
+	version = "0.9.0"
+	if err := selfUpdate(context.Background(), false, false, false); err != nil {
+		t.Fatalf("up to date: %v", err)
+	}
+}
+
+func TestSelfUpdateRefusesBadDownloads(t *testing.T) {
+	for name, tamper := range map[string]func([]byte, func([]byte) []byte) ([]byte, []byte){
+		"signature": func(sums []byte, _ func([]byte) []byte) ([]byte, []byte) {
+			return sums, make([]byte, ed25519.SignatureSize)
+		},
+		"checksum": func(sums []byte, sign func([]byte) []byte) ([]byte, []byte) {
+			// A validly signed list whose checksum does not match.
+			sums = append([]byte("00000000"), sums[8:]...)
+			return sums, sign(sums)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			exe := fakeRelease(t, []byte("new"), tamper)
+			if err := selfUpdate(context.Background(), false, false, false); err == nil || !strings.Contains(err.Error(), "refusing") {
+				t.Fatalf("expected the update to be refused, got %v", err)
+			}
+			if got, _ := os.ReadFile(exe); string(got) != "old" {
+				t.Fatalf("binary replaced despite a bad %s", name)
+			}
+		})
+	}
+}
+
+func TestSelfUpdateNeedsKeyOrChecksumOnly(t *testing.T) {
+	exe := fakeRelease(t, []byte("new"), nil)
+	releasePublicKey = ""
+	if err := selfUpdate(context.Background(), false, false, false); err == nil {
+		t.Fatalf("expected an error without a release key")
+	}
+	if err := selfUpdate(context.Background(), false, false, true); err != nil {
+		t.Fatalf("--checksum-only: %v", err)
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "new" {
+		t.Fatalf("binary not replaced with --checksum-only")
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	for _, c := range []struct {
+		latest, current string
+		want            bool
+	}{
+		{"0.9.0", "0.8.2", true},
+		{"0.10.0", "0.9.1", true},
+		{"0.9.0", "0.9.0", false},
+		{"0.9", "0.9.0", false},
+		{"0.8.9", "0.9.0", false},
+		{"0.9.0", "dev", true},
+		{"garbage", "0.9.0", false},
+	} {
+		if got := newerVersion(c.latest, c.current); got != c.want {
+			t.Fatalf("newerVersion(%q, %q) = %v", c.latest, c.current, got)
+		}
+	}
+	if packageManager("/nix/store/abc-msi-gpu-switcher/bin/msi-gpu-switcher") != "Nix" || packageManager("/usr/local/bin/msi-gpu-switcher") != "" {
+		t.Fatalf("unexpected packageManager results")
+	}
+}
@@ -0,0 +1,325 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// shellCommands is the shell's help, in the order it is printed.
+var shellCommands = []struct{ usage, help string }{
+	{"rd <offset> [count]", "read one EC register, or count registers as a hexdump"},
+	{"wr <offset> <value>", "write an EC register and read it back"},
+	{"dump [start:end]", "hexdump the EC register space"},
+	{"snap", "remember the EC register space for diff"},
+	{"diff", "show registers changed since the last snap"},
+	{"uefi list", "list variables under the MSI vendor GUIDs"},
+	{"uefi hex [var]", "hexdump a variable, by default the GPU mode variable"},
+	{"uefi decode", "decode the GPU mode variable"},
+	{"log", "show the operations of this session"},
+	{"help", "show this help"},
+	{"quit", "leave the shell (also exit or Ctrl-D)"},
+}
+
+func shellCmd() *cobra.Command {
+	var logPath string
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Interactive EC and UEFI exploration shell",
+		Long: "Read and write EC registers and inspect UEFI variables from a prompt, through one\n" +
+			"EC session, for working out the registers of a new model. Commands are read from\n" +
+			"stdin, so a script can be piped in. Writes take effect immediately, without asking.\n" +
+			"Type help for the commands.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			s := &shell{ctx: cmd.Context()}
+			if logPath != "" {
+				f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				s.logw = f
+			}
+			ec, err := openEC()
+			if err != nil {
+				log.Warn().Msgf("EC commands unavailable: %v", err)
+			} else {
+				defer ec.Close()
+				s.ec = ec
+			}
+			return s.run(os.Stdin, stdinIsTerminal())
+		},
+	}
+	cmd.Flags().StringVarP(&logPath, "log", "o", "", "also append the operation log to this file")
+	return cmd
+}
+
+// shell is one session of the shell command.
+type shell struct {
+	ctx context.Context
+	// ec is nil when the EC could not be opened; its methods then fail.
+	ec *ecSession
+	// ops is the operation log: every EC access and UEFI read, timestamped.
+	ops  []string
+	logw io.Writer
+	snap []byte
+}
+
+var errShellQuit = errors.New("quit")
+
+// run executes the commands in in until it ends or quit. A failing command
+// is reported and the shell goes on.
+func (s *shell) run(in io.Reader, prompt bool) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprint(os.Stderr, "msi> ")
+		}
+		if !scanner.Scan() {
+			if prompt {
+				fmt.Fprintln(os.Stderr)
+			}
+			return scanner.Err()
+		}
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		err := s.exec(args)
+		if errors.Is(err, errShellQuit) {
+			return nil
+		}
+		if err != nil {
+			log.Error().Msgf("%s: %v", args[0], err)
+		}
+		if s.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// record adds an operation to the log.
+func (s *shell) record(format string, a ...any) {
+	entry := time.Now().Format("15:04:05.000") + " " + fmt.Sprintf(format, a...)
+	s.ops = append(s.ops, entry)
+	if s.logw != nil {
+		if _, err := fmt.Fprintln(s.logw, entry); err != nil {
+			log.Warn().Msgf("write operation log: %v", err)
+			s.logw = nil
+		}
+	}
+}
+
+func (s *shell) exec(args []string) error {
+	want := func(min, max int) error {
+		if n := len(args) - 1; n < min || n > max {
+			for _, c := range shellCommands {
+				if strings.HasPrefix(c.usage, args[0]+" ") || c.usage == args[0] {
+					return fmt.Errorf("usage: %s", c.usage)
+				}
+			}
+			return fmt.Errorf("%d arguments", n)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "rd":
+		if err := want(1, 2); err != nil {
+			return err
+		}
+		offset, err := parseByteArg("offset", args[1])
+		if err != nil {
+			return err
+		}
+		if len(args) == 3 {
+			count, err := parseByteArg("count", args[2])
+			if err != nil {
+				return err
+			}
+			return s.dump(int(offset), int(offset)+int(count)-1)
+		}
+		value, err := s.ec.readByte(s.ctx, int(offset))
+		if err != nil {
+			return err
+		}
+		s.record("rd 0x%02x = 0x%02x", offset, value)
+		outf("[0x%02x] = 0x%02x (%d, 0b%08b)", offset, value, value, value)
+	case "wr":
+		if err := want(2, 2); err != nil {
+			return err
+		}
+		offset, err := parseByteArg("offset", args[1])
+		if err != nil {
+			return err
+		}
+		value, err := parseByteArg("value", args[2])
+		if err != nil {
+			return err
+		}
+		// Each write takes the lock, so it does not land in the middle of a
+		// switch; holding it for the whole session would block the daemon.
+		release, err := acquireLock(s.ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+		before, err := s.ec.readByte(s.ctx, int(offset))
+		if err != nil {
+			return err
+		}
+		if err := s.ec.writeByte(s.ctx, int(offset), value); err != nil {
+			s.record("wr 0x%02x 0x%02x failed: %v", offset, value, err)
+			return err
+		}
+		after, err := s.ec.readByte(s.ctx, int(offset))
+		if err != nil {
+			return err
+		}
+		s.record("wr 0x%02x 0x%02x: 0x%02x -> 0x%02x", offset, value, before, after)
+		outf("[0x%02x] 0x%02x -> 0x%02x", offset, before, after)
+		if after != value {
+			log.Warn().Msgf("read back 0x%02x instead of 0x%02x; the EC changed or ignored the write", after, value)
+		}
+	case "dump":
+		if err := want(0, 1); err != nil {
+			return err
+		}
+		start, end := 0, ecSize-1
+		if len(args) == 2 {
+			var err error
+			if start, end, err = parseRange(args[1]); err != nil {
+				return err
+			}
+		}
+		return s.dump(start, end)
+	case "snap":
+		if err := want(0, 0); err != nil {
+			return err
+		}
+		snap := make([]byte, ecSize)
+		if err := s.ec.readRange(s.ctx, 0, snap); err != nil {
+			return err
+		}
+		s.snap = snap
+		s.record("snap")
+		log.Info().Msg("snapshot taken")
+	case "diff":
+		if err := want(0, 0); err != nil {
+			return err
+		}
+		if s.snap == nil {
+			return errors.New("no snapshot; run snap first")
+		}
+		cur := make([]byte, ecSize)
+		if err := s.ec.readRange(s.ctx, 0, cur); err != nil {
+			return err
+		}
+		changes := diffSnapshots(s.snap, cur)
+		s.record("diff: %d changed", len(changes))
+		if len(changes) == 0 {
+			log.Info().Msg("no differences")
+		}
+		for _, c := range changes {
+			s.record("  [0x%02x] 0x%02x -> 0x%02x", c.offset, c.before, c.after)
+			outf("[0x%02x] 0x%02x -> 0x%02x (changed bits 0b%08b)", c.offset, c.before, c.after, c.before^c.after)
+		}
+	case "uefi":
+		return s.uefi(args[1:])
+	case "log":
+		if err := want(0, 0); err != nil {
+			return err
+		}
+		for _, op := range s.ops {
+			outln(op)
+		}
+	case "help", "?":
+		for _, c := range shellCommands {
+			outf("  %-22s %s", c.usage, c.help)
+		}
+	case "quit", "exit":
+		return errShellQuit
+	default:
+		return errors.New("unknown command; type help")
+	}
+	return nil
+}
+
+func (s *shell) dump(start, end int) error {
+	if end < start || end >= ecSize {
+		return fmt.Errorf("range 0x%02x:0x%02x outside register space", start, end)
+	}
+	buf := make([]byte, end-start+1)
+	if err := s.ec.readRange(s.ctx, start, buf); err != nil {
+		return err
+	}
+	s.record("dump 0x%02x:0x%02x % x", start, end, buf)
+	for _, line := range hexdump(start, buf) {
+		outln(line)
+	}
+	return nil
+}
+
+func (s *shell) uefi(args []string) error {
+	usage := errors.New("usage: uefi list | uefi hex [var] | uefi decode")
+	if len(args) == 0 {
+		return usage
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		vars, err := listEfiVars(s.ctx, msiVendorGuids)
+		if err != nil {
+			return err
+		}
+		s.record("uefi list: %d variables", len(vars))
+		for _, v := range vars {
+			outf("%s-%s  %5d bytes  0x%08x (%s)", v.name, v.guid, v.size, v.attrs, efiAttrString(v.attrs))
+		}
+	case args[0] == "hex" && len(args) <= 2:
+		path, err := uefiArgPath(args[1:])
+		if err != nil {
+			return err
+		}
+		attrs, data, err := readEfiVar(s.ctx, path)
+		if err != nil {
+			return err
+		}
+		s.record("uefi hex %s: attrs 0x%08x % x", filepath.Base(path), attrs, data)
+		for _, line := range hexdump(0, data) {
+			outln(line)
+		}
+	case args[0] == "decode" && len(args) == 1:
+		attrs, data, err := readUefiVar(s.ctx)
+		if err != nil {
+			return err
+		}
+		s.record("uefi decode %s: % x", uefiVarName, data)
+		fields, warnings := decodeMsiDC(attrs, data)
+		for _, f := range fields {
+			line := fmt.Sprintf("  [%2d] 0x%02x  %s", f.offset, f.value, f.name)
+			if f.meaning != "" {
+				line += " = " + f.meaning
+			}
+			outln(line)
+		}
+		for _, w := range warnings {
+			log.Warn().Msg(w)
+		}
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellSession(t *testing.T) {
+	switchFixture(t)
+	out, logged := captureOutput(t)
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+
+	var opLog bytes.Buffer
+	s := &shell{ctx: context.Background(), ec: ec, logw: &opLog}
+	script := strings.Join([]string{
+		"snap",
+		"wr 0xd1 0x01  # set a bit",
+		"rd 0xd1",
+		"diff",
+		"rd 0x100",
+		"bogus",
+		"uefi hex",
+		"quit",
+		"rd 0xd1",
+	}, "\n")
+	if err := s.run(strings.NewReader(script), false); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, want := range []string{"[0xd1] 0x00 -> 0x01", "[0xd1] = 0x01", "00: 00 00 00 00"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(logged.String(), "invalid offset") || !strings.Contains(logged.String(), "unknown command") {
+		t.Fatalf("expected errors to be reported and the shell to go on, log %q", logged)
+	}
+	// snap, wr, rd, diff and its change, uefi hex; nothing after quit.
+	if len(s.ops) != 6 || !strings.Contains(s.ops[1], "wr 0xd1 0x01: 0x00 -> 0x01") {
+		t.Fatalf("unexpected operation log: %q", s.ops)
+	}
+	if got := strings.Count(opLog.String(), "\n"); got != len(s.ops) {
+		t.Fatalf("log file has %d lines, want %d", got, len(s.ops))
+	}
+}
+
+func TestShellWithoutEC(t *testing.T) {
+	_, logged := captureOutput(t)
+	s := &shell{ctx: context.Background()}
+	if err := s.run(strings.NewReader("rd 0x2e\n"), false); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(logged.String(), "ec session not open") {
+		t.Fatalf("expected the EC to be reported missing, log %q", logged)
+	}
+}
+
+func TestShellWriteTakesLock(t *testing.T) {
+	root := switchFixture(t)
+	_, logged := captureOutput(t)
+	ec, err := openEC()
+	if err != nil {
+		t.Fatalf("openEC: %v", err)
+	}
+	defer ec.Close()
+	release, err := acquireLock(context.Background())
+	if err != nil {
+		t.Fatalf("acquireLock: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	s := &shell{ctx: ctx, ec: ec}
+	if err := s.run(strings.NewReader("wr 0xd1 0x01\n"), false); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(logged.String(), "deadline exceeded") {
+		t.Fatalf("expected the write to wait for the lock, log %q", logged)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, ecIOPath)); data[0xd1] != 0 {
+		t.Fatalf("EC written while the lock was held: 0x%02x", data[0xd1])
+	}
+}
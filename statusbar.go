@@ -0,0 +1,164 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var statusbarFormats = []string{"waybar", "polybar", "i3blocks"}
+
+// barState is what status bars show: the mode in use and the one stored for
+// the next boot, either of which may be unknown.
+type barState struct {
+	active, pending string
+}
+
+func readBarState(ctx context.Context) barState {
+	var s barState
+	if mode, err := activeMode(); err == nil {
+		s.active = mode.String()
+	}
+	if mode, err := readUefiGpuMode(ctx); err == nil {
+		s.pending = mode.String()
+	}
+	return s
+}
+
+func (s barState) text() string {
+	switch {
+	case s.active == "" && s.pending == "":
+		return "unknown"
+	case s.active == "":
+		return s.pending
+	case s.pending == "" || s.pending == s.active:
+		return s.active
+	default:
+		return s.active + " → " + s.pending
+	}
+}
+
+// summary answers "which GPU is in use and will that change" in one line.
+func (s barState) summary() string {
+	current := "Current: unknown"
+	if m, ok := modeNamed(s.active); ok {
+		current = fmt.Sprintf("Current: %s (%s)", m, m.primary())
+	}
+	switch {
+	case s.pending == "":
+		return current
+	case s.active == "":
+		return current + " — Pending: " + s.pending
+	case s.pending == s.active:
+		return current + " — no switch pending"
+	}
+	return current + " — Pending: " + s.pending + ", reboot required"
+}
+
+func (s barState) tooltip() string {
+	tip := "GPU mode: " + orUnknown(s.active)
+	if s.pending != s.active {
+		tip += "\nAfter reboot: " + orUnknown(s.pending)
+	}
+	return tip
+}
+
+// class is the CSS class for waybar: the mode, or "pending" while a switch
+// waits for a reboot.
+func (s barState) class() string {
+	if s.pending != "" && s.active != "" && s.pending != s.active {
+		return "pending"
+	}
+	return orUnknown(s.active)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+func writeBarState(w io.Writer, format string, s barState) error {
+	switch format {
+	case "waybar":
+		line, err := json.Marshal(struct {
+			Text    string `json:"text"`
+			Alt     string `json:"alt"`
+			Tooltip string `json:"tooltip"`
+			Class   string `json:"class"`
+		}{s.text(), orUnknown(s.active), s.tooltip(), s.class()})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		return err
+	case "polybar":
+		_, err := fmt.Fprintln(w, s.text())
+		return err
+	case "i3blocks":
+		// full_text, short_text, color
+		color := ""
+		if s.class() == "pending" {
+			color = "#e0a000"
+		}
+		_, err := fmt.Fprintf(w, "%s\n%s\n%s\n", s.text(), orUnknown(s.active), color)
+		return err
+	}
+	return fmt.Errorf("unknown status bar format %q (use %v)", format, statusbarFormats)
+}
+
+func statusbarCmd() *cobra.Command {
+	var (
+		format   string
+		follow   bool
+		interval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "statusbar",
+		Short: "Print the GPU mode for waybar, polybar or i3blocks",
+		Long: "Print the GPU mode for a status bar.\n\n" +
+			"waybar:   \"custom/gpu\": {\"exec\": \"msi-gpu-switcher statusbar --follow\", \"return-type\": \"json\"}\n" +
+			"polybar:  exec = msi-gpu-switcher statusbar --format polybar --follow, tail = true\n" +
+			"i3blocks: command=msi-gpu-switcher statusbar --format i3blocks",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if follow && interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			return runStatusbar(cmd.Context(), os.Stdout, format, follow, interval)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "waybar", "output format (waybar, polybar, i3blocks)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep running and print again whenever the mode changes")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "polling interval with --follow")
+	_ = cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(statusbarFormats, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
+
+func runStatusbar(ctx context.Context, w io.Writer, format string, follow bool, interval time.Duration) error {
+	last := barState{}
+	for first := true; ; first = false {
+		s := readBarState(ctx)
+		if first || s != last {
+			if err := writeBarState(w, format, s); err != nil {
+				return err
+			}
+			last = s
+		}
+		if !follow {
+			return nil
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteBarState(t *testing.T) {
+	pending := barState{active: "hybrid", pending: "discrete"}
+	for _, tc := range []struct {
+		format string
+		state  barState
+		want   string
+	}{
+		{"waybar", barState{active: "hybrid", pending: "hybrid"},
+			`{"text":"hybrid","alt":"hybrid","tooltip":"GPU mode: hybrid","class":"hybrid"}` + "\n"},
+		{"waybar", pending,
+			`{"text":"hybrid → discrete","alt":"hybrid","tooltip":"GPU mode: hybrid\nAfter reboot: discrete","class":"pending"}` + "\n"},
+		{"polybar", pending, "hybrid → discrete\n"},
+		{"i3blocks", pending, "hybrid → discrete\nhybrid\n#e0a000\n"},
+		{"i3blocks", barState{}, "unknown\nunknown\n\n"},
+	} {
+		var buf bytes.Buffer
+		if err := writeBarState(&buf, tc.format, tc.state); err != nil {
+			t.Fatalf("%s: %v", tc.format, err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Fatalf("%s %+v: got %q, want %q", tc.format, tc.state, got, tc.want)
+		}
+	}
+	if err := writeBarState(&bytes.Buffer{}, "xmobar", pending); err == nil {
+		t.Fatalf("expected unknown format to fail")
+	}
+}
+
+func TestBarStateSummary(t *testing.T) {
+	for s, want := range map[barState]string{
+		{active: "hybrid", pending: "discrete"}:   "Current: hybrid (iGPU primary) — Pending: discrete, reboot required",
+		{active: "discrete", pending: "discrete"}: "Current: discrete (dGPU primary) — no switch pending",
+		{active: "integrated"}:                    "Current: integrated (dGPU off)",
+		{pending: "hybrid"}:                       "Current: unknown — Pending: hybrid",
+	} {
+		if got := s.summary(); got != want {
+			t.Fatalf("%+v.summary() = %q, want %q", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// switchStep is one half of a mode switch. apply returns an undo func that
+// puts back whatever apply changed, so a failing later step can roll it back.
+type switchStep struct {
+	name     string
+	backend  string
+	optional bool
+	apply    func(ctx context.Context) (undo func(ctx context.Context) error, err error)
+}
+
+// verifyRetries is how many times a write is repeated when reading it back
+// shows the value did not stick.
+var verifyRetries = 3
+
+const verifyDelay = 100 * time.Millisecond
+
+// noEC and noUefi restrict a switch to one mechanism, for debugging models
+// where the other misbehaves: --no-ec writes only the UEFI variable and
+// --no-uefi only the EC MUX.
+var noEC, noUefi bool
+
+// backendNames are the mechanisms a switch can write through.
+var backendNames = []string{"uefi", "ec"}
+
+// switchBackends is the order a switch writes in, from --backends or
+// "backends" in the config. Leaving one out never touches it.
+var switchBackends = backendNames
+
+func parseBackends(list string) ([]string, error) {
+	var backends []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(backendNames, name) {
+			return nil, fmt.Errorf("unknown backend %q (want %s)", name, strings.Join(backendNames, ", "))
+		}
+		if slices.Contains(backends, name) {
+			return nil, fmt.Errorf("backend %s listed twice", name)
+		}
+		backends = append(backends, name)
+	}
+	return backends, nil
+}
+
+// activeBackends is switchBackends without the one --no-ec or --no-uefi
+// turns off.
+func activeBackends() []string {
+	return slices.DeleteFunc(slices.Clone(switchBackends), func(name string) bool {
+		return name == "ec" && noEC || name == "uefi" && noUefi
+	})
+}
+
+// switchOptions relax a switch for callers that cannot ask the user.
+type switchOptions struct {
+	// allowBusy skips checkDgpuIdle, for switches the user asked for
+	// without a way to pass --force or --kill, or made at boot.
+	allowBusy bool
+	// revert puts back the mode a one-time switch replaced. When the UEFI
+	// variable is there only it is written, the mode the firmware boots
+	// next, and the BIOS checks are skipped: the machine goes back to a
+	// mode it already ran in.
+	revert bool
+	// enforce is the daemon putting back the last requested mode, which
+	// --enforce is the answer to warnDualBoot's warning; it is not repeated.
+	enforce bool
+}
+
+func switchGPU(ctx context.Context, mode gpuMode) error {
+	return switchGPUWith(ctx, mode, switchOptions{})
+}
+
+func switchGPUWith(ctx context.Context, mode gpuMode, opts switchOptions) error {
+	// Checked before the lock and the pre hooks, which would otherwise run
+	// for a switch that cannot happen.
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	release, err := acquireLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ev := hookEvent{oldMode: "unknown", newMode: mode}
+	if old, err := readUefiGpuMode(ctx); err == nil {
+		ev.oldMode = old.String()
+	}
+	// A failing pre hook cancels the switch, e.g. when it could not close
+	// an application that holds the dGPU.
+	if err := runHooks(ctx, "pre", ev, true); err != nil {
+		recordSwitch(mode, nil, err)
+		return err
+	}
+	backends, err := applySwitch(ctx, mode, opts)
+	recordSwitch(mode, backends, err)
+	ev.backends, ev.err = backends, err
+	if err := runHooks(ctx, "post", ev, false); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	if err == nil {
+		clearOnce()
+		applyPersistenced(ctx, mode)
+		if !opts.enforce {
+			warnDualBoot(ctx)
+		}
+	}
+	return err
+}
+
+func applySwitch(ctx context.Context, mode gpuMode, opts switchOptions) ([]string, error) {
+	backends := activeBackends()
+	nextBootOnly := opts.revert && slices.Contains(backends, "uefi") && exists(uefiVarPath)
+	if nextBootOnly {
+		backends = []string{"uefi"}
+	}
+	var ec *ecSession
+	var err error
+	switch {
+	case slices.Contains(backends, "ec"):
+		if !exists(ecIOPath) {
+			return nil, fmt.Errorf("%w; cannot switch without ec_sys/debugfs", ErrECUnavailable)
+		}
+		ec, err = openEC()
+	case !nextBootOnly && exists(ecIOPath):
+		// Without the ec backend the EC is only read, for the firmware
+		// checks.
+		ec, err = openECReadOnly()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ec != nil {
+		defer ec.Close()
+	}
+
+	if !nextBootOnly {
+		if err := checkFirmware(ctx, ec); err != nil {
+			return nil, err
+		}
+		if err := checkKnownModel(); err != nil {
+			return nil, err
+		}
+		if err := checkTestedBios(); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.allowBusy {
+		if err := checkDgpuIdle(mode); err != nil {
+			return nil, err
+		}
+	}
+
+	steps, err := switchSteps(ec, mode, backends)
+	if err != nil {
+		return nil, err
+	}
+	return runSteps(ctx, steps)
+}
+
+// switchSteps lays out a switch to mode through backends, in their order.
+func switchSteps(ec *ecSession, mode gpuMode, backends []string) ([]switchStep, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no backend left to switch with")
+	}
+	useEC := slices.Contains(backends, "ec")
+	var steps []switchStep
+	for _, backend := range backends {
+		switch backend {
+		case "uefi":
+			switch {
+			case exists(uefiVarPath):
+				steps = append(steps, uefiStep(mode))
+			case createUefiVar:
+				steps = append(steps, uefiCreateStep(mode))
+			case !useEC:
+				return nil, fmt.Errorf("%w; no other backend to switch with", ErrUefiVarMissing)
+			default:
+				continue
+			}
+			// The trigger makes the EC pick up the new variable.
+			if useEC {
+				steps = append(steps, ecSwitchStep(ec))
+			}
+		case "ec":
+			steps = append(steps, ecMuxStep(ec, mode))
+		}
+	}
+	return steps, nil
+}
+
+// runSteps applies steps in order. When a required step fails, every step
+// applied so far is undone in reverse order.
+func runSteps(ctx context.Context, steps []switchStep) ([]string, error) {
+	var backends []string
+	var undos []func(ctx context.Context) error
+	var names []string
+
+	for _, step := range steps {
+		undo, err := step.apply(ctx)
+		if err != nil {
+			if step.optional {
+				log.Warn().Msgf("%s failed: %v", step.name, err)
+				continue
+			}
+			err = fmt.Errorf("%s failed: %w", step.name, err)
+			if rbErr := rollback(ctx, names, undos); rbErr != nil {
+				return backends, errors.Join(err, rbErr)
+			}
+			return backends, fmt.Errorf("%w (rolled back)", err)
+		}
+		undos = append(undos, undo)
+		names = append(names, step.name)
+		if len(backends) == 0 || backends[len(backends)-1] != step.backend {
+			backends = append(backends, step.backend)
+		}
+	}
+	return backends, nil
+}
+
+// rollback runs even when ctx was cancelled, since the applied steps must be
+// undone regardless.
+func rollback(ctx context.Context, names []string, undos []func(ctx context.Context) error) error {
+	ctx = context.WithoutCancel(ctx)
+	var errs []error
+	for i := len(undos) - 1; i >= 0; i-- {
+		log.Warn().Msgf("rolling back %s", names[i])
+		if err := undos[i](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("rollback %s failed: %w", names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func uefiStep(mode gpuMode) switchStep {
+	return switchStep{
+		name:    "UEFI var write",
+		backend: "uefi",
+		apply: func(ctx context.Context) (func(ctx context.Context) error, error) {
+			before, err := readUefiModeByte(ctx)
+			if err != nil {
+				return nil, err
+			}
+			err = writeVerified(ctx, "UEFI mode byte",
+				func() error { return setUefiGpuMode(ctx, mode) },
+				func() (bool, error) {
+					got, err := readUefiGpuMode(ctx)
+					return got == mode, err
+				})
+			if err != nil {
+				return nil, err
+			}
+			log.Info().Str("mode", mode.String()).Str("backend", "uefi").Msgf("UEFI target set: %s", mode.label())
+			return func(ctx context.Context) error { return writeUefiModeByte(ctx, before) }, nil
+		},
+	}
+}
+
+func uefiCreateStep(mode gpuMode) switchStep {
+	return switchStep{
+		name:    "UEFI var create",
+		backend: "uefi",
+		apply: func(ctx context.Context) (func(ctx context.Context) error, error) {
+			if err := createUefiGpuModeVar(ctx, mode); err != nil {
+				return nil, err
+			}
+			log.Info().Str("mode", mode.String()).Str("backend", "uefi").Msgf("UEFI var %s created, target set: %s", uefiVarName, mode.label())
+			return removeCreatedUefiVar, nil
+		},
+	}
+}
+
+// removeCreatedUefiVar undoes uefiCreateStep. efivarfs refuses to unlink an
+// immutable variable, and something may have locked the new one meanwhile.
+func removeCreatedUefiVar(ctx context.Context) error {
+	restore, err := makeVarMutable(ctx, uefiVarPath)
+	if err == nil {
+		if err = os.Remove(hostPath(uefiVarPath)); err != nil && restore != nil {
+			restore()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s is left with the new mode: %w", uefiVarName, err)
+	}
+	return nil
+}
+
+func ecSwitchStep(ec *ecSession) switchStep {
+	return switchStep{
+		name:     "EC switch trigger",
+		backend:  "ec",
+		optional: true,
+		apply: func(ctx context.Context) (func(ctx context.Context) error, error) {
+			before, err := ec.readByte(ctx, ecSwitchOffset)
+			if err != nil {
+				return nil, err
+			}
+			if err := ec.triggerSwitch(ctx); err != nil {
+				return nil, err
+			}
+			return func(ctx context.Context) error { return ec.writeByte(ctx, ecSwitchOffset, before) }, nil
+		},
+	}
+}
+
+func ecMuxStep(ec *ecSession, mode gpuMode) switchStep {
+	return switchStep{
+		name:    "EC MUX write",
+		backend: "ec",
+		apply: func(ctx context.Context) (func(ctx context.Context) error, error) {
+			before, err := ec.readByte(ctx, ecMuxOffset)
+			if err != nil {
+				return nil, err
+			}
+			err = writeVerified(ctx, "EC MUX bit",
+				func() error { return ec.setMux(ctx, mode.muxDiscrete()) },
+				func() (bool, error) {
+					state, err := ec.readMuxState(ctx)
+					return state == mode.muxDiscrete(), err
+				})
+			if err != nil {
+				return nil, err
+			}
+			log.Info().Str("mode", mode.String()).Str("backend", "ec").Int("offset", ecMuxOffset).Msgf("Requested primary GPU: %s (EC MUX)", mode.label())
+			return func(ctx context.Context) error { return ec.writeByte(ctx, ecMuxOffset, before) }, nil
+		},
+	}
+}
+
+// writeVerified performs write and reads the result back with check,
+// repeating the write up to verifyRetries times if the value did not stick.
+func writeVerified(ctx context.Context, what string, write func() error, check func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		if err := write(); err != nil {
+			return err
+		}
+		ok, err := check()
+		if err != nil {
+			return fmt.Errorf("read back %s failed: %w", what, err)
+		}
+		if ok {
+			return nil
+		}
+		if attempt > verifyRetries {
+			return fmt.Errorf("%w: %s did not stick after %d attempts", ErrWriteRejected, what, attempt)
+		}
+		log.Warn().Msgf("%s read-back mismatch, retrying (%d/%d)", what, attempt, verifyRetries)
+		if err := sleepContext(ctx, verifyDelay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,238 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunStepsRollsBackOnFailure(t *testing.T) {
+	var undone []string
+	step := func(name string, fail bool) switchStep {
+		return switchStep{
+			name:    name,
+			backend: name,
+			apply: func(context.Context) (func(context.Context) error, error) {
+				if fail {
+					return nil, errors.New("write rejected")
+				}
+				return func(context.Context) error {
+					undone = append(undone, name)
+					return nil
+				}, nil
+			},
+		}
+	}
+
+	_, err := runSteps(context.Background(), []switchStep{step("a", false), step("b", false), step("c", true)})
+	if err == nil {
+		t.Fatalf("expected error from failing step")
+	}
+	if len(undone) != 2 || undone[0] != "b" || undone[1] != "a" {
+		t.Fatalf("expected rollback of b then a, got %v", undone)
+	}
+}
+
+func TestRunStepsSkipsOptionalFailure(t *testing.T) {
+	steps := []switchStep{
+		{name: "uefi", backend: "uefi", apply: func(context.Context) (func(context.Context) error, error) {
+			return func(context.Context) error { return nil }, nil
+		}},
+		{name: "trigger", backend: "ec", optional: true, apply: func(context.Context) (func(context.Context) error, error) { return nil, errors.New("nak") }},
+		{name: "mux", backend: "ec", apply: func(context.Context) (func(context.Context) error, error) {
+			return func(context.Context) error { return nil }, nil
+		}},
+	}
+
+	backends, err := runSteps(context.Background(), steps)
+	if err != nil {
+		t.Fatalf("runSteps: %v", err)
+	}
+	if len(backends) != 2 || backends[0] != "uefi" || backends[1] != "ec" {
+		t.Fatalf("unexpected backends: %v", backends)
+	}
+}
+
+func TestWriteVerifiedRetriesUntilStuck(t *testing.T) {
+	originalRetries := verifyRetries
+	verifyRetries = 3
+	t.Cleanup(func() { verifyRetries = originalRetries })
+
+	writes := 0
+	err := writeVerified(context.Background(), "test",
+		func() error { writes++; return nil },
+		func() (bool, error) { return writes >= 2, nil })
+	if err != nil {
+		t.Fatalf("writeVerified: %v", err)
+	}
+	if writes != 2 {
+		t.Fatalf("expected 2 writes, got %d", writes)
+	}
+}
+
+func TestWriteVerifiedFailsWhenValueNeverSticks(t *testing.T) {
+	originalRetries := verifyRetries
+	verifyRetries = 1
+	t.Cleanup(func() { verifyRetries = originalRetries })
+
+	writes := 0
+	err := writeVerified(context.Background(), "test",
+		func() error { writes++; return nil },
+		func() (bool, error) { return false, nil })
+	if err == nil {
+		t.Fatalf("expected error when value never sticks")
+	}
+	if writes != 2 {
+		t.Fatalf("expected 2 writes, got %d", writes)
+	}
+}
+
+func TestSwitchStepsFollowBackends(t *testing.T) {
+	originalVar, originalCreate, originalNoEC, originalNoUefi, originalBackends := uefiVarPath, createUefiVar, noEC, noUefi, switchBackends
+	t.Cleanup(func() {
+		uefiVarPath, createUefiVar, noEC, noUefi, switchBackends = originalVar, originalCreate, originalNoEC, originalNoUefi, originalBackends
+	})
+	uefiVarPath = filepath.Join(t.TempDir(), "MsiDCVarData-"+msiVendorGuid)
+	if err := os.WriteFile(uefiVarPath, []byte{7, 0, 0, 0, 1}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	createUefiVar = false
+
+	names := func() []string {
+		t.Helper()
+		steps, err := switchSteps(nil, modeDiscrete, activeBackends())
+		if err != nil {
+			t.Fatalf("switchSteps: %v", err)
+		}
+		var names []string
+		for _, s := range steps {
+			names = append(names, s.name)
+		}
+		return names
+	}
+	for _, tc := range []struct {
+		backends     string
+		noEC, noUefi bool
+		want         string
+	}{
+		{"uefi,ec", false, false, "UEFI var write,EC switch trigger,EC MUX write"},
+		{"uefi,ec", true, false, "UEFI var write"},
+		{"uefi,ec", false, true, "EC MUX write"},
+		{"ec,uefi", false, false, "EC MUX write,UEFI var write,EC switch trigger"},
+		{"uefi", false, false, "UEFI var write"},
+	} {
+		var err error
+		if switchBackends, err = parseBackends(tc.backends); err != nil {
+			t.Fatalf("parseBackends(%s): %v", tc.backends, err)
+		}
+		noEC, noUefi = tc.noEC, tc.noUefi
+		if got := strings.Join(names(), ","); got != tc.want {
+			t.Fatalf("%s noEC=%v noUefi=%v: steps %s, want %s", tc.backends, tc.noEC, tc.noUefi, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"debugfs", "ec,ec", ""} {
+		if _, err := parseBackends(bad); err == nil {
+			t.Fatalf("parseBackends(%q) accepted", bad)
+		}
+	}
+	if _, err := switchSteps(nil, modeDiscrete, nil); err == nil {
+		t.Fatal("expected an error without backends")
+	}
+
+	switchBackends = backendNames
+	noEC, noUefi = true, false
+	uefiVarPath += ".missing"
+	if _, err := switchSteps(nil, modeDiscrete, activeBackends()); !errors.Is(err, ErrUefiVarMissing) {
+		t.Fatalf("--no-ec without the variable = %v, want ErrUefiVarMissing", err)
+	}
+}
+
+func TestSwitchWithoutECBackendOnlyReadsEC(t *testing.T) {
+	root := switchFixture(t)
+	originalNoEC := noEC
+	t.Cleanup(func() { noEC = originalNoEC })
+	noEC = true
+	// A directory opens for reading but never for writing, so the switch
+	// fails if the EC is opened read-write.
+	ecFile := filepath.Join(root, ecIOPath)
+	if err := os.Remove(ecFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(ecFile, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := switchGPU(ctx, modeDiscrete); err != nil {
+		t.Fatalf("switch with --no-ec: %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeDiscrete {
+		t.Fatalf("readUefiGpuMode = %v %v, want discrete", mode, err)
+	}
+}
+
+func TestUefiCreateRollbackClearsImmutable(t *testing.T) {
+	root := switchFixture(t)
+	varFile := filepath.Join(root, uefiVarPath)
+	if err := os.Remove(varFile); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	create := func() func(context.Context) error {
+		t.Helper()
+		undo, err := uefiCreateStep(modeDiscrete).apply(ctx)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		return undo
+	}
+
+	undo := create()
+	flags := fakeInodeFlags(t, fsImmutableFl, 0)
+	if err := undo(ctx); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if *flags&fsImmutableFl != 0 {
+		t.Fatal("expected the immutable flag to be cleared before removing the variable")
+	}
+	if _, err := os.Stat(varFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the variable to be removed, got %v", err)
+	}
+
+	undo = create()
+	fakeInodeFlags(t, fsImmutableFl, inodeFlagsRetries)
+	if err := undo(ctx); err == nil || !strings.Contains(err.Error(), "left with the new mode") {
+		t.Fatalf("expected a failed rollback to be reported, got %v", err)
+	}
+	if _, err := os.Stat(varFile); err != nil {
+		t.Fatalf("expected the variable to stay: %v", err)
+	}
+}
+
+func TestSwitchRefusesUnknownBoard(t *testing.T) {
+	root := switchFixture(t)
+	originalForce := forceWrites
+	t.Cleanup(func() { forceWrites = originalForce })
+	forceWrites = false
+	if err := os.WriteFile(filepath.Join(root, dmiRoot, "board_name"), []byte("MS-0000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon, D-Bus, the API, MQTT and the helper cannot ask.
+	ctx := context.Background()
+	if err := switchGPU(ctx, modeDiscrete); !errors.Is(err, ErrUnsupportedModel) {
+		t.Fatalf("expected an unknown board to be refused, got %v", err)
+	}
+	if mode, err := readUefiGpuMode(ctx); err != nil || mode != modeHybrid {
+		t.Fatalf("readUefiGpuMode = %v %v, want the fixture's hybrid", mode, err)
+	}
+	forceWrites = true
+	if err := switchGPU(ctx, modeDiscrete); err != nil {
+		t.Fatalf("switch with --force: %v", err)
+	}
+}
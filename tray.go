@@ -0,0 +1,188 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"time"
+
+	"fyne.io/systray"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func trayCmd() *cobra.Command {
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "tray",
+		Short: "Show the GPU mode in the system tray (switches via daemon --dbus)",
+		Long: "Show the GPU mode as a StatusNotifierItem with switch actions in its menu.\n\n" +
+			"The tray runs as the desktop user; switches are delegated to\n" +
+			"\"msi-gpu-switcher daemon --dbus\" running as root.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			runTray(cmd.Context(), interval)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "mode polling interval")
+	return cmd
+}
+
+func runTray(ctx context.Context, interval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	systray.Run(func() { trayReady(ctx, interval) }, cancel)
+}
+
+func trayReady(ctx context.Context, interval time.Duration) {
+	systray.SetTitle("GPU")
+	items := map[gpuMode]*systray.MenuItem{}
+	for _, m := range supportedModes() {
+		items[m] = systray.AddMenuItemCheckbox(m.label(), "Switch to "+m.String()+" mode", false)
+	}
+	systray.AddSeparator()
+	suggestion := systray.AddMenuItem("", "Accept the daemon's recommendation")
+	suggestion.Hide()
+	quit := systray.AddMenuItem("Quit", "Close the tray icon")
+	var suggested gpuMode
+	var notified time.Time
+
+	refresh := func() {
+		if rec, found, err := loadRecommendation(); err == nil && found {
+			if mode, err := parseMode(rec.Mode); err == nil {
+				suggested = mode
+				suggestion.SetTitle(fmt.Sprintf("Switch to %s (%s)", mode, rec.Reason))
+				suggestion.Show()
+				if !rec.Time.Equal(notified) {
+					notified = rec.Time
+					body := fmt.Sprintf("%s mode is recommended: %s. Switch from the tray menu; it applies after a reboot.", mode, rec.Reason)
+					if err := desktopNotify(ctx, "GPU mode change recommended", body); err != nil {
+						log.Debug().Msgf("notify: %v", err)
+					}
+				}
+			}
+		} else {
+			suggestion.Hide()
+		}
+		// The icon shows the mode in use, the check mark the one requested
+		// for the next boot.
+		s := readBarState(ctx)
+		systray.SetTitle(s.text())
+		systray.SetTooltip(s.tooltip())
+		if active, ok := modeNamed(s.active); ok {
+			systray.SetIcon(trayIcon(trayColor(active)))
+		} else {
+			systray.SetIcon(trayIcon(color.RGBA{0x80, 0x80, 0x80, 0xff}))
+		}
+		requested, ok := modeNamed(s.pending)
+		if !ok {
+			requested, ok = modeNamed(s.active)
+		}
+		for m, item := range items {
+			if ok && m == requested {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+	}
+
+	for m, item := range items {
+		go func() {
+			for range item.ClickedCh {
+				if err := dbusSwitch(ctx, m); err != nil {
+					log.Error().Msgf("switch to %s: %v", m, err)
+					systray.SetTooltip(err.Error())
+					continue
+				}
+				refresh()
+			}
+		}()
+	}
+	go func() {
+		switches, err := dbusHotkeySwitches(ctx)
+		if err != nil {
+			log.Debug().Msgf("not watching the GPU key: %v", err)
+			return
+		}
+		for sw := range switches {
+			refresh()
+			summary, body := "GPU mode switched", fmt.Sprintf("Switched to %s mode with the GPU key; it applies after a reboot.", sw.mode)
+			switch {
+			case sw.mode == "":
+				summary, body = "GPU mode switch failed", fmt.Sprintf("The GPU key did not switch: %s", sw.err)
+			case sw.err != "":
+				summary, body = "GPU mode switch failed", fmt.Sprintf("Switching to %s with the GPU key failed: %s", sw.mode, sw.err)
+			}
+			if err := desktopNotify(ctx, summary, body); err != nil {
+				log.Debug().Msgf("notify: %v", err)
+			}
+		}
+	}()
+	go func() {
+		for range suggestion.ClickedCh {
+			if err := dbusSwitch(ctx, suggested); err != nil {
+				log.Error().Msgf("switch to %s: %v", suggested, err)
+				systray.SetTooltip(err.Error())
+				continue
+			}
+			refresh()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			refresh()
+			select {
+			case <-ctx.Done():
+				systray.Quit()
+				return
+			case <-quit.ClickedCh:
+				systray.Quit()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func trayColor(mode gpuMode) color.RGBA {
+	switch mode {
+	case modeDiscrete:
+		return color.RGBA{0x76, 0xb9, 0x00, 0xff}
+	case modeIntegrated:
+		return color.RGBA{0x00, 0x71, 0xc5, 0xff}
+	default:
+		return color.RGBA{0xe0, 0xa0, 0x00, 0xff}
+	}
+}
+
+// trayIcon renders a filled circle, which is enough to tell the modes apart
+// without shipping image assets.
+func trayIcon(c color.RGBA) []byte {
+	const size = 22
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	r := size/2 - 1
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := x-size/2, y-size/2
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
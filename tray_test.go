@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestTrayIconIsPNG(t *testing.T) {
+	c := trayColor(modeDiscrete)
+	img, err := png.Decode(bytes.NewReader(trayIcon(c)))
+	if err != nil {
+		t.Fatalf("decode icon: %v", err)
+	}
+	r, g, b, _ := img.At(11, 11).RGBA()
+	if byte(r>>8) != c.R || byte(g>>8) != c.G || byte(b>>8) != c.B {
+		t.Fatalf("unexpected centre colour %v", img.At(11, 11))
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("expected transparent corner")
+	}
+}
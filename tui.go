@@ -0,0 +1,192 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+type tuiView int
+
+const (
+	viewStatus tuiView = iota
+	viewHistory
+	viewDoctor
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\x1b[H\x1b[2J"
+
+const tuiHelp = "[h]ybrid [d]iscrete [i]ntegrated  [s]tatus [l]og [c]hecks  [q]uit"
+
+// tui redraws one view on every refresh; logs go to stderr, so the whole
+// screen is rendered through the regular status, history and doctor output.
+type tui struct {
+	fd      int
+	saved   *unix.Termios
+	view    tuiView
+	message string
+}
+
+func tuiCmd() *cobra.Command {
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Interactive view of GPU/EC/UEFI state with switch keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if interval <= 0 {
+				return errors.New("--interval must be positive")
+			}
+			return runTUI(cmd.Context(), interval)
+		},
+	}
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "refresh interval")
+	return cmd
+}
+
+func runTUI(ctx context.Context, interval time.Duration) error {
+	t := &tui{fd: int(os.Stdin.Fd())}
+	if err := t.cbreak(); err != nil {
+		return fmt.Errorf("tui needs a terminal: %w", err)
+	}
+	defer registerCleanup(t.restore)()
+
+	for {
+		t.render(ctx)
+		key, err := t.readKey(interval)
+		if err != nil {
+			return err
+		}
+		if key != 0 && t.handleKey(ctx, key) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// cbreak turns off line buffering and echo but keeps signals and output
+// processing, so Ctrl-C and the log writer behave as usual.
+func (t *tui) cbreak() error {
+	saved, err := unix.IoctlGetTermios(t.fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(t.fd, unix.TCSETS, &raw); err != nil {
+		return err
+	}
+	t.saved = saved
+	return nil
+}
+
+func (t *tui) restore() {
+	if t.saved != nil {
+		_ = unix.IoctlSetTermios(t.fd, unix.TCSETS, t.saved)
+		t.saved = nil
+	}
+}
+
+// readKey waits up to timeout (forever if negative) for a key press and
+// returns 0 if none came.
+func (t *tui) readKey(timeout time.Duration) (byte, error) {
+	ms := -1
+	if timeout >= 0 {
+		ms = int(timeout.Milliseconds())
+	}
+	fds := []unix.PollFd{{Fd: int32(t.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, ms)
+	if err != nil {
+		if errors.Is(err, unix.EINTR) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	var buf [1]byte
+	if _, err := os.Stdin.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (t *tui) render(ctx context.Context) {
+	fmt.Fprint(stdout, clearScreen)
+	outf("msi-gpu-switcher  %s  %s", time.Now().Format("15:04:05"), tuiHelp)
+	if t.message != "" {
+		log.Warn().Msg(t.message)
+	}
+	outln("")
+	switch t.view {
+	case viewHistory:
+		if err := showHistory(20); err != nil {
+			log.Error().Msgf("history: %v", err)
+		}
+	case viewDoctor:
+		_ = runDoctor(ctx, false)
+	default:
+		_ = showStatus(ctx, false)
+	}
+}
+
+// handleKey acts on key and reports whether the TUI should exit.
+func (t *tui) handleKey(ctx context.Context, key byte) bool {
+	t.message = ""
+	switch key {
+	case 'q', 'Q', 0x1b:
+		return true
+	case 's':
+		t.view = viewStatus
+	case 'l':
+		t.view = viewHistory
+	case 'c':
+		t.view = viewDoctor
+	case 'h':
+		t.switchTo(ctx, modeHybrid)
+	case 'd':
+		t.switchTo(ctx, modeDiscrete)
+	case 'i':
+		t.switchTo(ctx, modeIntegrated)
+	}
+	return false
+}
+
+// switchTo leaves cbreak mode for the duration of the switch so that
+// confirmation prompts read a normal line from the terminal.
+func (t *tui) switchTo(ctx context.Context, mode gpuMode) {
+	if err := checkModeSupported(mode); err != nil {
+		t.message = err.Error()
+		return
+	}
+	if os.Geteuid() != 0 && !useHelper() {
+		t.message = "switching requires root or a running helper"
+		return
+	}
+	t.restore()
+	fmt.Fprint(stdout, "\x1b[H\x1b[2J")
+	if err := switchMode(ctx, mode); err != nil {
+		log.Error().Msgf("error: %v", err)
+	}
+	log.Info().Msg("press any key to continue")
+	if err := t.cbreak(); err != nil {
+		t.message = fmt.Sprintf("restore terminal failed: %v", err)
+		return
+	}
+	_, _ = t.readKey(-1)
+	t.view = viewStatus
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTuiHandleKey(t *testing.T) {
+	original := triStateModes
+	triStateModes = false
+	t.Cleanup(func() { triStateModes = original })
+
+	ui := &tui{}
+	ctx := context.Background()
+	if ui.handleKey(ctx, 'l'); ui.view != viewHistory {
+		t.Fatalf("expected history view, got %d", ui.view)
+	}
+	if ui.handleKey(ctx, 'c'); ui.view != viewDoctor {
+		t.Fatalf("expected doctor view, got %d", ui.view)
+	}
+	if ui.handleKey(ctx, 'i'); ui.message == "" {
+		t.Fatalf("expected unsupported integrated mode to be reported")
+	}
+	if !ui.handleKey(ctx, 'q') {
+		t.Fatalf("expected q to quit")
+	}
+}
@@ -0,0 +1,174 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	udevRulesFile = "90-msi-gpu-switcher.rules"
+	policyFile    = "policy.json"
+)
+
+var udevRulesDir = "/etc/udev/rules.d"
+
+// udevadm runs udevadm; tests replace it.
+var udevadm = func(ctx context.Context, args ...string) error {
+	out, err := runCommand(ctx, "udevadm", args...)
+	if err != nil {
+		return fmt.Errorf("udevadm %v: %w: %s", args, err, out)
+	}
+	return nil
+}
+
+// policyState is what a one-shot handle-event run needs to remember between
+// events to behave like the daemon's long-lived policyRunner.
+type policyState struct {
+	BatteryLow    bool      `json:"battery_low"`
+	Last          string    `json:"last,omitempty"`
+	RecommendedAt time.Time `json:"recommended_at,omitempty"`
+}
+
+func policyStatePath() string {
+	return filepath.Join(stateDir, policyFile)
+}
+
+func (r *policyRunner) loadState() {
+	raw, err := os.ReadFile(policyStatePath())
+	if err != nil {
+		return
+	}
+	var st policyState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		log.Debug().Msgf("parse %s: %v", policyFile, err)
+		return
+	}
+	if r.battery != nil {
+		r.battery.low = st.BatteryLow
+	}
+	if mode, err := parseMode(st.Last); err == nil {
+		r.last, r.lastSeen = mode, true
+	}
+	r.recommendedAt = st.RecommendedAt
+}
+
+func (r *policyRunner) saveState() error {
+	st := policyState{RecommendedAt: r.recommendedAt}
+	if r.battery != nil {
+		st.BatteryLow = r.battery.low
+	}
+	if r.lastSeen {
+		st.Last = r.last.String()
+	}
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(policyStatePath(), append(raw, '\n'), 0o644)
+}
+
+func handleEventCmd() *cobra.Command {
+	var (
+		opts policyOptions
+		boot bool
+	)
+	cmd := &cobra.Command{
+		Use:   "handle-event",
+		Short: "Evaluate the automatic policies for a udev event (run by the udev rules)",
+		Long: "Evaluate the automatic policies once. The udev rules installed by\n" +
+			"\"udev install\" run this on AC plug/unplug and GPU bind/unbind, with\n" +
+			"the event in ACTION, SUBSYSTEM and DEVPATH. Policy settings come from the\n" +
+			"config file unless given as flags. With --boot, a switch made with\n" +
+			"\"switch --once\" is reverted first.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := opts.applyConfig(cmd, loadedConfig); err != nil {
+				return err
+			}
+			if boot {
+				if err := revertOnce(cmd.Context()); err != nil {
+					log.Error().Msgf("revert one-time switch failed: %v", err)
+				}
+			}
+			if action := os.Getenv("ACTION"); action != "" {
+				log.Info().Msgf("udev event: %s %s %s", action, os.Getenv("SUBSYSTEM"), os.Getenv("DEVPATH"))
+			}
+			r := newPolicyRunner(opts)
+			if r == nil {
+				log.Debug().Msg("no automatic policy enabled")
+				return nil
+			}
+			// A one-shot run cannot wait for a decision to settle; the rate
+			// limit still applies through the saved state.
+			r.debounce = 0
+			r.loadState()
+			r.evaluate(cmd.Context())
+			return r.saveState()
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().BoolVar(&boot, "boot", false, "also revert a one-time switch (run by the boot service)")
+	return cmd
+}
+
+func udevCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "udev",
+		Short: "Install or remove the udev rules that run handle-event",
+	}
+	var bin string
+	install := &cobra.Command{
+		Use:   "install",
+		Short: "Install " + udevRulesFile + " and reload udev",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			if bin == "" {
+				bin = installBinary()
+			}
+			data, err := renderAsset(udevRulesFile, bin)
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(udevRulesDir, udevRulesFile)
+			if err := os.MkdirAll(udevRulesDir, 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return fmt.Errorf("install %s failed: %w", path, err)
+			}
+			log.Info().Msgf("installed %s", path)
+			return udevadm(cmd.Context(), "control", "--reload")
+		},
+	}
+	install.Flags().StringVar(&bin, "bin", "", "binary path used in the rules (default: this executable)")
+	uninstall := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove " + udevRulesFile + " and reload udev",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			requireRoot()
+			path := filepath.Join(udevRulesDir, udevRulesFile)
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			log.Info().Msgf("removed %s", path)
+			return udevadm(cmd.Context(), "control", "--reload")
+		},
+	}
+	cmd.AddCommand(install, uninstall)
+	return cmd
+}
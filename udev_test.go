@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUdevRulesRender(t *testing.T) {
+	data, err := renderAsset(udevRulesFile, "/usr/bin/msi-gpu-switcher")
+	if err != nil {
+		t.Fatalf("renderAsset: %v", err)
+	}
+	rules := string(data)
+	for _, want := range []string{`SUBSYSTEM=="power_supply"`, `ACTION=="bind|unbind"`, `RUN+="/usr/bin/msi-gpu-switcher handle-event"`} {
+		if !strings.Contains(rules, want) {
+			t.Fatalf("rules missing %q:\n%s", want, rules)
+		}
+	}
+}
+
+func TestPolicyStateRoundTrip(t *testing.T) {
+	original := stateDir
+	stateDir = t.TempDir()
+	t.Cleanup(func() { stateDir = original })
+
+	r := newPolicyRunner(policyOptions{battery: true, threshold: 30})
+	r.battery.low, r.last, r.lastSeen = true, modeHybrid, true
+	if err := r.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	loaded := newPolicyRunner(policyOptions{battery: true, threshold: 30})
+	loaded.loadState()
+	if !loaded.battery.low || !loaded.lastSeen || loaded.last != modeHybrid {
+		t.Fatalf("loaded state = %+v %+v", loaded, loaded.battery)
+	}
+}
@@ -0,0 +1,422 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// UEFI
+const uefiDataBase = 4
+
+// efivarTimeout bounds one efivarfs read or write (--efivar-timeout).
+var efivarTimeout = 5 * time.Second
+
+// EFI variable attributes (UEFI spec 8.2)
+const (
+	efiAttrNonVolatile   = 0x01
+	efiAttrBootService   = 0x02
+	efiAttrRuntime       = 0x04
+	efiAttrHwErrorRecord = 0x08
+	efiAttrAuthWrite     = 0x10
+	efiAttrTimeAuthWrite = 0x20
+	efiAttrAppendWrite   = 0x40
+)
+
+// msiVendorGuids are vendor GUIDs MSI firmware has been seen storing its own
+// variables under.
+var msiVendorGuids = []string{msiVendorGuid}
+
+var (
+	efivarsDir  = "/sys/firmware/efi/efivars"
+	uefiVarPath = filepath.Join(efivarsDir, uefiVarName+"-"+uefiVarGuid)
+)
+
+// setUefiTarget overrides the GPU mode variable and byte offset.
+func setUefiTarget(name, guid string, modeByte int) error {
+	if name == "" || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid uefi var name %q", name)
+	}
+	if !isGuid(guid) {
+		return fmt.Errorf("invalid uefi var guid %q", guid)
+	}
+	if modeByte < -1 {
+		return fmt.Errorf("invalid uefi mode byte %d", modeByte)
+	}
+	uefiVarName, uefiVarGuid, uefiModeByte = name, strings.ToUpper(guid), modeByte
+	uefiVarPath = filepath.Join(efivarsDir, uefiVarName+"-"+uefiVarGuid)
+	return nil
+}
+
+func readUefiGpuMode(ctx context.Context) (gpuMode, error) {
+	v, err := readMsiDC(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return v.mode()
+}
+
+func setUefiGpuMode(ctx context.Context, mode gpuMode) error {
+	return writeUefiModeByte(ctx, byte(mode))
+}
+
+// readMsiDC reads and parses the GPU mode variable.
+func readMsiDC(ctx context.Context) (msiDCVar, error) {
+	_, data, err := readUefiVar(ctx)
+	if err != nil {
+		return msiDCVar{}, err
+	}
+	return parseMsiDC(data)
+}
+
+func readUefiModeByte(ctx context.Context) (byte, error) {
+	v, err := readMsiDC(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return v.modeByte(), nil
+}
+
+func writeUefiModeByte(ctx context.Context, value byte) error {
+	attrs, data, err := readUefiVar(ctx)
+	if err != nil {
+		return err
+	}
+	v, err := parseMsiDC(data)
+	if err != nil {
+		return err
+	}
+	before := v.modeByte()
+	v.setModeByte(value)
+	log.Debug().Int("offset", v.offset).Uint8("value", value).Msgf("uefi %s[%d] before=0x%02x after=0x%02x layout=%s", uefiVarName, v.offset, before, value, v.layout.name)
+	return writeUefiVar(ctx, attrs, data)
+}
+
+func readUefiVar(ctx context.Context) (uint32, []byte, error) {
+	return readEfiVar(ctx, uefiVarPath)
+}
+
+func writeUefiVar(ctx context.Context, attrs uint32, data []byte) error {
+	return writeEfiVar(ctx, uefiVarPath, attrs, data)
+}
+
+// createUefiVar makes a switch create the GPU mode variable when it is
+// missing, e.g. before MSI Center ever touched it, instead of going EC-only.
+var createUefiVar bool
+
+// keepUnlocked skips re-applying the immutable flag after a write.
+var keepUnlocked bool
+
+// uefiDefaultAttrs are the attributes MSI firmware uses for MsiDCVarData.
+const uefiDefaultAttrs = efiAttrNonVolatile | efiAttrBootService | efiAttrRuntime
+
+// createUefiGpuModeVar creates the GPU mode variable zero-filled apart from
+// the mode byte. It fails if the variable already exists.
+func createUefiGpuModeVar(ctx context.Context, mode gpuMode) error {
+	if exists(uefiVarPath) {
+		return fmt.Errorf("uefi var %s already exists", filepath.Base(uefiVarPath))
+	}
+	offset := uefiLayouts[0].mode
+	if uefiModeByte >= 0 {
+		offset = uefiModeByte
+	}
+	data := make([]byte, max(uefiDefaultSize, offset+1))
+	data[offset] = byte(mode)
+	log.Debug().Msgf("uefi create %s attrs=0x%08x len=%d", uefiVarName, uefiDefaultAttrs, len(data))
+	return writeEfiVar(ctx, uefiVarPath, uefiDefaultAttrs, data)
+}
+
+// efiVarPath resolves a "Name-GUID" efivarfs entry, or a bare name under
+// the MSI vendor GUID. Anything but a name in efivarsDir is refused, since
+// the result is written to as root.
+func efiVarPath(spec string) (string, error) {
+	if strings.ContainsRune(spec, filepath.Separator) || !filepath.IsLocal(spec) {
+		return "", fmt.Errorf("invalid variable name %q", spec)
+	}
+	if strings.Count(spec, "-") < 5 {
+		spec += "-" + msiVendorGuid
+	}
+	return filepath.Join(efivarsDir, spec), nil
+}
+
+func readEfiVar(ctx context.Context, path string) (uint32, []byte, error) {
+	var raw []byte
+	err := runWithTimeout(ctx, efivarTimeout, func() error {
+		var err error
+		raw, err = hostfs.ReadFile(path)
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil, fmt.Errorf("%w: %s", ErrUefiVarMissing, filepath.Base(path))
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(raw) < uefiDataBase {
+		return 0, nil, fmt.Errorf("uefi var too small: %d bytes", len(raw))
+	}
+	attrs := binary.LittleEndian.Uint32(raw[:uefiDataBase])
+	data := make([]byte, len(raw)-uefiDataBase)
+	copy(data, raw[uefiDataBase:])
+	log.Debug().Msgf("uefi %s attrs=0x%08x len=%d", filepath.Base(path), attrs, len(data))
+	return attrs, data, nil
+}
+
+func writeEfiVar(ctx context.Context, path string, attrs uint32, data []byte) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if attrs&(efiAttrAuthWrite|efiAttrTimeAuthWrite) != 0 {
+		return fmt.Errorf("%w: refusing to write authenticated variable %s (attrs=0x%08x)", ErrWriteRejected, filepath.Base(path), attrs)
+	}
+
+	payload := make([]byte, uefiDataBase+len(data))
+	binary.LittleEndian.PutUint32(payload[:uefiDataBase], attrs)
+	copy(payload[uefiDataBase:], data)
+
+	restore, err := makeVarMutable(ctx, path)
+	if err != nil {
+		return fmt.Errorf("prepare uefi var failed: %w", err)
+	}
+	if restore != nil {
+		if keepUnlocked {
+			log.Debug().Msgf("leaving %s unlocked", filepath.Base(path))
+		} else {
+			defer registerCleanup(restore)()
+		}
+	}
+
+	err = runWithTimeout(ctx, efivarTimeout, func() error {
+		return hostfs.WriteFile(path, payload, 0o644)
+	})
+	switch {
+	case errors.Is(err, os.ErrPermission) && os.Geteuid() != 0:
+		return fmt.Errorf("write uefi var failed: %w: %w", ErrNeedsRoot, err)
+	case err != nil && ctx.Err() == nil:
+		return fmt.Errorf("write uefi var failed: %w: %w", ErrWriteRejected, err)
+	case err != nil:
+		return fmt.Errorf("write uefi var failed: %w", err)
+	}
+	return nil
+}
+
+type efiVarEntry struct {
+	name, guid string
+	size       int
+	attrs      uint32
+	err        error
+}
+
+// listEfiVars returns efivarfs entries whose vendor GUID is one of guids,
+// sorted by name. An empty guids matches every entry.
+func listEfiVars(ctx context.Context, guids []string) ([]efiVarEntry, error) {
+	dirEntries, err := hostfs.ReadDir(efivarsDir)
+	if err != nil {
+		return nil, err
+	}
+	var vars []efiVarEntry
+	for _, de := range dirEntries {
+		name, guid, ok := splitEfiVarName(de.Name())
+		if !ok || (len(guids) > 0 && !containsFold(guids, guid)) {
+			continue
+		}
+		entry := efiVarEntry{name: name, guid: guid}
+		entry.attrs, entry.size, entry.err = readEfiVarHeader(ctx, filepath.Join(efivarsDir, de.Name()))
+		vars = append(vars, entry)
+	}
+	return vars, nil
+}
+
+// splitEfiVarName splits an efivarfs file name into variable name and GUID.
+func splitEfiVarName(file string) (string, string, bool) {
+	const guidLen = 36
+	if len(file) < guidLen+2 || file[len(file)-guidLen-1] != '-' {
+		return "", "", false
+	}
+	return file[:len(file)-guidLen-1], file[len(file)-guidLen:], true
+}
+
+func readEfiVarHeader(ctx context.Context, path string) (uint32, int, error) {
+	attrs, data, err := readEfiVar(ctx, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return attrs, len(data), nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// efiAttrBits names the variable attribute bits, short and as in the UEFI
+// specification.
+var efiAttrBits = []struct {
+	bit         uint32
+	short, long string
+}{
+	{efiAttrNonVolatile, "NV", "NON_VOLATILE"},
+	{efiAttrBootService, "BS", "BOOTSERVICE_ACCESS"},
+	{efiAttrRuntime, "RT", "RUNTIME_ACCESS"},
+	{efiAttrHwErrorRecord, "HW", "HARDWARE_ERROR_RECORD"},
+	{efiAttrAuthWrite, "AW", "AUTHENTICATED_WRITE_ACCESS"},
+	{efiAttrTimeAuthWrite, "TAW", "TIME_BASED_AUTHENTICATED_WRITE_ACCESS"},
+	{efiAttrAppendWrite, "AP", "APPEND_WRITE"},
+}
+
+// efiAttrString renders attrs the way efivar(1) does, e.g. "NV|BS|RT".
+func efiAttrString(attrs uint32) string {
+	var parts []string
+	for _, n := range efiAttrBits {
+		if attrs&n.bit != 0 {
+			parts = append(parts, n.short)
+			attrs &^= n.bit
+		}
+	}
+	if attrs != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", attrs))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "|")
+}
+
+// efiAttrNames spells out each bit set in attrs, for reports.
+func efiAttrNames(attrs uint32) []string {
+	var parts []string
+	for _, n := range efiAttrBits {
+		if attrs&n.bit != 0 {
+			parts = append(parts, fmt.Sprintf("%s (0x%x)", n.long, n.bit))
+			attrs &^= n.bit
+		}
+	}
+	if attrs != 0 {
+		parts = append(parts, fmt.Sprintf("unknown (0x%x)", attrs))
+	}
+	if len(parts) == 0 {
+		return []string{"none"}
+	}
+	return parts
+}
+
+// fsImmutableFl is FS_IMMUTABLE_FL from linux/fs.h.
+const fsImmutableFl = 0x00000010
+
+const (
+	inodeFlagsRetries = 3
+	inodeFlagsBackoff = 20 * time.Millisecond
+)
+
+// FS_IOC_GETFLAGS/SETFLAGS both take a pointer to the flags word. Swapped
+// out in tests, since tmpfs does not support inode flags.
+var (
+	getInodeFlags = func(fd int) (int, error) {
+		return unix.IoctlGetInt(fd, unix.FS_IOC_GETFLAGS)
+	}
+	setInodeFlags = func(fd int, flags int) error {
+		return unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, flags)
+	}
+)
+
+// isImmutable reports whether path carries the immutable inode flag.
+func isImmutable(path string) (bool, error) {
+	f, err := hostfs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	flags, err := getInodeFlags(int(f.Fd()))
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) {
+		// No inode flag support on this filesystem, so nothing can be immutable.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get inode flags of %s: %w", filepath.Base(path), err)
+	}
+	return flags&fsImmutableFl != 0, nil
+}
+
+// setImmutable sets or clears the immutable flag via FS_IOC_SETFLAGS,
+// retrying transient failures.
+func setImmutable(ctx context.Context, path string, on bool) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	f, err := hostfs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			flags, err := getInodeFlags(fd)
+			if err != nil {
+				return err
+			}
+			if on {
+				flags |= fsImmutableFl
+			} else {
+				flags &^= fsImmutableFl
+			}
+			return setInodeFlags(fd, flags)
+		}()
+		if err == nil {
+			log.Debug().Msgf("set immutable=%v on %s", on, filepath.Base(path))
+			return nil
+		}
+		if attempt >= inodeFlagsRetries || !isTransient(err) {
+			return fmt.Errorf("set immutable=%v on %s: %w", on, filepath.Base(path), err)
+		}
+		if err := sleepContext(ctx, inodeFlagsBackoff); err != nil {
+			return err
+		}
+	}
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EBUSY)
+}
+
+// restoreImmutable runs during cleanup, so it deliberately ignores
+// cancellation of ctx and only applies its own timeout.
+func restoreImmutable(ctx context.Context, path string) {
+	if err := setImmutable(context.WithoutCancel(ctx), path, true); err != nil {
+		log.Warn().Msgf("restore immutable flag failed: %v", err)
+	}
+}
+
+// makeVarMutable clears the immutable flag on path if set, returning a func
+// that sets it again.
+func makeVarMutable(ctx context.Context, path string) (func(), error) {
+	immutable, err := isImmutable(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !immutable {
+		return nil, nil
+	}
+	if err := setImmutable(ctx, path, false); err != nil {
+		return nil, err
+	}
+	return func() { restoreImmutable(ctx, path) }, nil
+}
@@ -0,0 +1,237 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func uefiCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uefi",
+		Short: "Low-level UEFI variable access",
+		Long: "Inspect and modify efivarfs variables. <var> is either Name-GUID or a\n" +
+			"bare name, which implies the MSI vendor GUID " + msiVendorGuid + ".",
+	}
+	cmd.AddCommand(uefiListCmd(), uefiReadCmd(), uefiHexdumpCmd(), uefiDecodeCmd(), uefiWriteCmd())
+	return cmd
+}
+
+func uefiListCmd() *cobra.Command {
+	var (
+		guids []string
+		all   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List variables stored under MSI vendor GUIDs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			match := append(append([]string{}, msiVendorGuids...), guids...)
+			if all {
+				match = nil
+			}
+			vars, err := listEfiVars(cmd.Context(), match)
+			if err != nil {
+				return err
+			}
+			if len(vars) == 0 {
+				log.Info().Msg("(none found)")
+				return nil
+			}
+			for _, v := range vars {
+				if v.err != nil {
+					outf("%s-%s  error: %v", v.name, v.guid, v.err)
+					continue
+				}
+				outf("%s-%s  %5d bytes  0x%08x (%s)", v.name, v.guid, v.size, v.attrs, efiAttrString(v.attrs))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&guids, "guid", nil, "additional vendor GUID to match (repeatable)")
+	cmd.Flags().BoolVar(&all, "all", false, "list every variable regardless of vendor GUID")
+	return cmd
+}
+
+func uefiReadCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "read [var]",
+		Short:             "Print a variable's attributes and contents",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeMsiVars,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := uefiArgPath(args)
+			if err != nil {
+				return err
+			}
+			attrs, data, err := readEfiVar(cmd.Context(), path)
+			if err != nil {
+				return err
+			}
+			outf("%s", filepath.Base(path))
+			outf("  attrs: 0x%08x (%s)", attrs, efiAttrString(attrs))
+			outf("  size:  %d bytes", len(data))
+			outf("  data:  % x", data)
+			return nil
+		},
+	}
+}
+
+func uefiHexdumpCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "hexdump [var]",
+		Short:             "Hexdump a variable's contents",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeMsiVars,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := uefiArgPath(args)
+			if err != nil {
+				return err
+			}
+			_, data, err := readEfiVar(cmd.Context(), path)
+			if err != nil {
+				return err
+			}
+			for _, line := range hexdump(0, data) {
+				outln(line)
+			}
+			return nil
+		},
+	}
+}
+
+func uefiDecodeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "decode",
+		Short: "Decode the fields of the GPU mode variable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			attrs, data, err := readUefiVar(cmd.Context())
+			if err != nil {
+				return err
+			}
+			fields, warnings := decodeMsiDC(attrs, data)
+			outf("%s: %d bytes, attrs 0x%08x (%s)", filepath.Base(uefiVarPath), len(data), attrs, efiAttrString(attrs))
+			for _, f := range fields {
+				line := fmt.Sprintf("  [%2d] 0x%02x  %s", f.offset, f.value, f.name)
+				if f.meaning != "" {
+					line += " = " + f.meaning
+				}
+				outln(line)
+			}
+			if v, err := parseMsiDC(data); err != nil {
+				log.Warn().Msg(err.Error())
+			} else {
+				outf("layout: %s (v%d)", v.layout.name, v.layout.version)
+			}
+			for _, w := range warnings {
+				log.Warn().Msg(w)
+			}
+			return nil
+		},
+	}
+}
+
+func uefiWriteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "write <var> <offset> <value>",
+		Short: "Write one byte of a variable, keeping its attributes (dangerous)",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWritable(); err != nil {
+				return err
+			}
+			requireRoot()
+			path, err := efiVarPath(args[0])
+			if err != nil {
+				return err
+			}
+			offset, err := strconv.ParseUint(args[1], 0, 16)
+			if err != nil {
+				return fmt.Errorf("invalid offset %q", args[1])
+			}
+			value, err := parseByteArg("value", args[2])
+			if err != nil {
+				return err
+			}
+			// Held across the prompt, so the value shown is the one replaced.
+			release, err := acquireLock(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer release()
+			attrs, data, err := readEfiVar(cmd.Context(), path)
+			if err != nil {
+				return err
+			}
+			if int(offset) >= len(data) {
+				return fmt.Errorf("offset %d outside variable (%d bytes)", offset, len(data))
+			}
+			before := data[offset]
+			ok, err := confirm(fmt.Sprintf("Write 0x%02x to %s[%d] (currently 0x%02x)?", value, filepath.Base(path), offset, before))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Info().Msg("aborted")
+				return nil
+			}
+			data[offset] = value
+			if err := writeEfiVar(cmd.Context(), path, attrs, data); err != nil {
+				return err
+			}
+			log.Info().Msgf("%s[%d] 0x%02x -> 0x%02x", filepath.Base(path), offset, before, value)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func uefiArgPath(args []string) (string, error) {
+	if len(args) == 0 {
+		return uefiVarPath, nil
+	}
+	return efiVarPath(args[0])
+}
+
+func lockCmd(lock bool) *cobra.Command {
+	use, short := "unlock", "Clear the immutable flag on the GPU mode variable"
+	if lock {
+		use, short = "lock", "Set the immutable flag on the GPU mode variable"
+	}
+	return &cobra.Command{
+		Use:   use + " [var]",
+		Short: short,
+		Long: short + ".\n\nA locked variable cannot be changed by other tools. Switches still\n" +
+			"unlock it temporarily and lock it again afterwards unless --keep-unlocked\n" +
+			"is given.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeMsiVars,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWritable(); err != nil {
+				return err
+			}
+			requireRoot()
+			path, err := uefiArgPath(args)
+			if err != nil {
+				return err
+			}
+			release, err := acquireLock(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer release()
+			if err := setImmutable(cmd.Context(), path, lock); err != nil {
+				return err
+			}
+			log.Info().Msgf("%sed %s", use, filepath.Base(path))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,192 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEfiVarPath(t *testing.T) {
+	originalDir := efivarsDir
+	efivarsDir = "/efivars"
+	t.Cleanup(func() { efivarsDir = originalDir })
+
+	if got, err := efiVarPath("MsiDCVarData"); err != nil || got != filepath.Join("/efivars", "MsiDCVarData-"+msiVendorGuid) {
+		t.Fatalf("bare name: got %s %v", got, err)
+	}
+	full := "Boot0000-8BE4DF61-93CA-11D2-AA0D-00E098032B8C"
+	if got, err := efiVarPath(full); err != nil || got != filepath.Join("/efivars", full) {
+		t.Fatalf("full name: got %s %v", got, err)
+	}
+	for _, bad := range []string{"../../etc/shadow", "../x-8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "a/b", "..", "", "/etc/passwd"} {
+		if got, err := efiVarPath(bad); err == nil {
+			t.Fatalf("efiVarPath(%q) = %s, want an error", bad, got)
+		}
+	}
+}
+
+func TestEfiAttrString(t *testing.T) {
+	if got := efiAttrString(0x07); got != "NV|BS|RT" {
+		t.Fatalf("got %q", got)
+	}
+	if got := efiAttrString(0x80); got != "0x80" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWriteEfiVarRefusesAuthenticated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Auth-test")
+	if err := writeEfiVar(context.Background(), path, efiAttrNonVolatile|efiAttrTimeAuthWrite, []byte{0}); err == nil {
+		t.Fatalf("expected refusal for authenticated variable")
+	}
+}
+
+func TestListEfiVarsFiltersByGuid(t *testing.T) {
+	originalDir := efivarsDir
+	efivarsDir = t.TempDir()
+	t.Cleanup(func() { efivarsDir = originalDir })
+
+	write := func(file string, data []byte) {
+		payload := append([]byte{0x07, 0x00, 0x00, 0x00}, data...)
+		if err := os.WriteFile(filepath.Join(efivarsDir, file), payload, 0o644); err != nil {
+			t.Fatalf("write %s: %v", file, err)
+		}
+	}
+	write("MsiDCVarData-"+msiVendorGuid, []byte{0x01, 0x00})
+	write("MsiOther-"+strings.ToLower(msiVendorGuid), []byte{0x01})
+	write("Boot0000-8BE4DF61-93CA-11D2-AA0D-00E098032B8C", []byte{0x00, 0x00, 0x00})
+
+	vars, err := listEfiVars(context.Background(), msiVendorGuids)
+	if err != nil {
+		t.Fatalf("listEfiVars: %v", err)
+	}
+	if len(vars) != 2 {
+		t.Fatalf("expected 2 MSI vars, got %+v", vars)
+	}
+	if vars[0].name != "MsiDCVarData" || vars[0].size != 2 || vars[0].attrs != 0x07 {
+		t.Fatalf("unexpected entry: %+v", vars[0])
+	}
+
+	all, err := listEfiVars(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("listEfiVars: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 vars, got %d", len(all))
+	}
+}
+
+func TestSetUefiTarget(t *testing.T) {
+	originalName, originalGuid, originalByte, originalPath := uefiVarName, uefiVarGuid, uefiModeByte, uefiVarPath
+	t.Cleanup(func() {
+		uefiVarName, uefiVarGuid, uefiModeByte, uefiVarPath = originalName, originalGuid, originalByte, originalPath
+	})
+
+	guid := "12345678-9abc-def0-1234-56789abcdef0"
+	if err := setUefiTarget("MsiGpuMode", guid, 3); err != nil {
+		t.Fatalf("setUefiTarget: %v", err)
+	}
+	if want := filepath.Join(efivarsDir, "MsiGpuMode-"+strings.ToUpper(guid)); uefiVarPath != want {
+		t.Fatalf("got path %s want %s", uefiVarPath, want)
+	}
+	if uefiModeByte != 3 {
+		t.Fatalf("got mode byte %d", uefiModeByte)
+	}
+
+	for _, bad := range []struct {
+		name, guid string
+		modeByte   int
+	}{
+		{"", guid, 1},
+		{"../evil", guid, 1},
+		{"Name", "not-a-guid", 1},
+		{"Name", guid, -2},
+	} {
+		if err := setUefiTarget(bad.name, bad.guid, bad.modeByte); err == nil {
+			t.Fatalf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestCreateUefiGpuModeVar(t *testing.T) {
+	originalPath := uefiVarPath
+	uefiVarPath = filepath.Join(t.TempDir(), "MsiDCVarData-create")
+	t.Cleanup(func() { uefiVarPath = originalPath })
+
+	ctx := context.Background()
+	if err := createUefiGpuModeVar(ctx, modeDiscrete); err != nil {
+		t.Fatalf("createUefiGpuModeVar: %v", err)
+	}
+	attrs, data, err := readUefiVar(ctx)
+	if err != nil {
+		t.Fatalf("readUefiVar: %v", err)
+	}
+	if attrs != uefiDefaultAttrs || len(data) != uefiDefaultSize || data[1] != 1 {
+		t.Fatalf("unexpected var: attrs=0x%08x data=% x", attrs, data)
+	}
+	if err := createUefiGpuModeVar(ctx, modeHybrid); err == nil {
+		t.Fatalf("expected error creating an existing var")
+	}
+}
+
+func fakeInodeFlags(t *testing.T, initial int, failures int) *int {
+	t.Helper()
+	flags := initial
+	originalGet, originalSet := getInodeFlags, setInodeFlags
+	t.Cleanup(func() { getInodeFlags, setInodeFlags = originalGet, originalSet })
+	getInodeFlags = func(int) (int, error) { return flags, nil }
+	setInodeFlags = func(_ int, v int) error {
+		if failures > 0 {
+			failures--
+			return unix.EINTR
+		}
+		flags = v
+		return nil
+	}
+	return &flags
+}
+
+func TestSetImmutableRetriesTransientErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "var")
+	if err := os.WriteFile(path, []byte{0}, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	flags := fakeInodeFlags(t, 0x80000, 2)
+
+	if err := setImmutable(context.Background(), path, true); err != nil {
+		t.Fatalf("setImmutable: %v", err)
+	}
+	if *flags != 0x80000|fsImmutableFl {
+		t.Fatalf("unexpected flags 0x%x", *flags)
+	}
+	immutable, err := isImmutable(path)
+	if err != nil || !immutable {
+		t.Fatalf("isImmutable = %v, %v", immutable, err)
+	}
+}
+
+func TestMakeVarMutableRestoresFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "var")
+	if err := os.WriteFile(path, []byte{0}, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	flags := fakeInodeFlags(t, fsImmutableFl, 0)
+
+	restore, err := makeVarMutable(context.Background(), path)
+	if err != nil {
+		t.Fatalf("makeVarMutable: %v", err)
+	}
+	if restore == nil || *flags&fsImmutableFl != 0 {
+		t.Fatalf("expected flag cleared with restore func, flags=0x%x", *flags)
+	}
+	restore()
+	if *flags&fsImmutableFl == 0 {
+		t.Fatalf("expected flag restored")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows"
+)
+
+var platformHints = map[error]string{
+	ErrNeedsRoot:        "run it from an elevated (Run as administrator) prompt",
+	ErrECUnavailable:    "install MSI Center or the MSI system driver, which register the MSI_ACPI WMI class",
+	ErrUnsupportedModel: "see Tested Hardware in the README",
+}
+
+// Windows has no efivarfs; firmware variables are read and written through
+// kernel32, by a process holding SeSystemEnvironmentPrivilege.
+var (
+	kernel32                           = windows.NewLazySystemDLL("kernel32.dll")
+	procGetFirmwareEnvironmentVariable = kernel32.NewProc("GetFirmwareEnvironmentVariableExW")
+	procSetFirmwareEnvironmentVariable = kernel32.NewProc("SetFirmwareEnvironmentVariableExW")
+)
+
+const efivarMaxSize = 64 << 10
+
+// requirePrivilege turns on SeSystemEnvironmentPrivilege, which an elevated
+// Administrator token holds but does not enable by default.
+func requirePrivilege() error {
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		return ErrNeedsRoot
+	}
+	var token windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &token); err != nil {
+		return fmt.Errorf("open process token failed: %w", err)
+	}
+	defer token.Close()
+	name, err := windows.UTF16PtrFromString("SeSystemEnvironmentPrivilege")
+	if err != nil {
+		return err
+	}
+	var luid windows.LUID
+	if err := windows.LookupPrivilegeValue(nil, name, &luid); err != nil {
+		return fmt.Errorf("look up SeSystemEnvironmentPrivilege failed: %w", err)
+	}
+	privs := windows.Tokenprivileges{PrivilegeCount: 1}
+	privs.Privileges[0] = windows.LUIDAndAttributes{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED}
+	if err := windows.AdjustTokenPrivileges(token, false, &privs, 0, nil, nil); err != nil {
+		return fmt.Errorf("enable SeSystemEnvironmentPrivilege failed: %w", err)
+	}
+	return nil
+}
+
+func firmwareVarArgs() (*uint16, *uint16, error) {
+	name, err := windows.UTF16PtrFromString(uefiVarName)
+	if err != nil {
+		return nil, nil, err
+	}
+	guid, err := windows.UTF16PtrFromString("{" + uefiVarGuid + "}")
+	if err != nil {
+		return nil, nil, err
+	}
+	return name, guid, nil
+}
+
+// firmwareVarError maps what kernel32 reports to the error kinds the Linux
+// build returns for the same failures.
+func firmwareVarError(op string, err error) error {
+	switch {
+	case errors.Is(err, windows.ERROR_ENVVAR_NOT_FOUND):
+		return fmt.Errorf("%w: %s-%s", ErrUefiVarMissing, uefiVarName, uefiVarGuid)
+	case errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD):
+		return fmt.Errorf("%w: %w", ErrNeedsRoot, err)
+	case errors.Is(err, windows.ERROR_INVALID_FUNCTION):
+		return fmt.Errorf("%s uefi var failed: the system did not boot through UEFI: %w", op, err)
+	}
+	return fmt.Errorf("%s uefi var failed: %w", op, err)
+}
+
+func readUefiVar() (uint32, []byte, error) {
+	if err := requirePrivilege(); err != nil {
+		return 0, nil, err
+	}
+	name, guid, err := firmwareVarArgs()
+	if err != nil {
+		return 0, nil, err
+	}
+	for size := 1024; ; size *= 2 {
+		buf := make([]byte, size)
+		var attrs uint32
+		n, _, err := procGetFirmwareEnvironmentVariable.Call(
+			uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(guid)),
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), uintptr(unsafe.Pointer(&attrs)))
+		if n != 0 {
+			log.Debug().Msgf("uefi %s attrs=0x%08x len=%d", uefiVarName, attrs, n)
+			return attrs, buf[:n], nil
+		}
+		if !errors.Is(err, windows.ERROR_INSUFFICIENT_BUFFER) || size >= efivarMaxSize {
+			return 0, nil, firmwareVarError("read", err)
+		}
+	}
+}
+
+func writeUefiVar(attrs uint32, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("refusing to write an empty uefi var, which would delete it")
+	}
+	name, guid, err := firmwareVarArgs()
+	if err != nil {
+		return err
+	}
+	ok, _, err := procSetFirmwareEnvironmentVariable.Call(
+		uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(guid)),
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(attrs))
+	if ok == 0 {
+		return firmwareVarError("write", err)
+	}
+	return nil
+}
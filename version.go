@@ -0,0 +1,67 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=0.1.5 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type buildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+// currentBuild returns the ldflags values, falling back to the VCS stamp the
+// go tool embeds when building from a checkout.
+func currentBuild() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		vcs := map[string]string{}
+		for _, s := range bi.Settings {
+			vcs[s.Key] = s.Value
+		}
+		if info.Commit == "" && vcs["vcs.revision"] != "" {
+			info.Commit = vcs["vcs.revision"]
+			if vcs["vcs.modified"] == "true" {
+				info.Commit += "-dirty"
+			}
+		}
+		if info.BuildDate == "" {
+			info.BuildDate = vcs["vcs.time"]
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+func versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Show version and build information",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			info := currentBuild()
+			outf("msi-gpu-switcher %s", info.Version)
+			outf("  commit:     %s", info.Commit)
+			outf("  built:      %s", info.BuildDate)
+			outf("  go version: %s", info.GoVersion)
+		},
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestCurrentBuildPrefersLdflags(t *testing.T) {
+	originalVersion, originalCommit, originalDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = originalVersion, originalCommit, originalDate })
+
+	version, commit, buildDate = "1.2.3", "abc123", "2024-01-02T03:04:05Z"
+	info := currentBuild()
+	if info.Version != "1.2.3" || info.Commit != "abc123" || info.BuildDate != "2024-01-02T03:04:05Z" {
+		t.Fatalf("unexpected build info: %+v", info)
+	}
+	if info.GoVersion == "" {
+		t.Fatalf("expected go version")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Windows has no ec_sys. The EC is reached through the MSI_ACPI class MSI
+// registers in root\WMI, the interface MSI's own software uses: Get_Data and
+// Set_Data take a 32-byte Package_32 whose byte 0 is the EC register and,
+// for Set_Data, byte 1 the value; the reply's byte 1 is the register value.
+// It is driven from PowerShell, which every Windows install has.
+
+// wmiTimeout bounds one WMI call; PowerShell alone takes a while to start.
+const wmiTimeout = 30 * time.Second
+
+const wmiPackageSize = 32
+
+// wmiScript is the PowerShell that calls method with a package starting
+// with args, and prints the reply's bytes separated by spaces.
+func wmiScript(method string, args ...byte) string {
+	values := make([]string, len(args))
+	for i, b := range args {
+		values[i] = fmt.Sprintf("0x%02x", b)
+	}
+	return strings.Join([]string{
+		"$ErrorActionPreference = 'Stop'",
+		"$acpi = Get-WmiObject -Namespace root\\WMI -Class MSI_ACPI",
+		"$in = ([wmiclass]'root\\WMI:Package_32').CreateInstance()",
+		fmt.Sprintf("$bytes = New-Object byte[] %d", wmiPackageSize),
+		fmt.Sprintf("$request = @(%s)", strings.Join(values, ", ")),
+		"for ($i = 0; $i -lt $request.Count; $i++) { $bytes[$i] = $request[$i] }",
+		"$in.Bytes = $bytes",
+		fmt.Sprintf("$out = $acpi.%s($in)", method),
+		"$out.Data.Bytes -join ' '",
+	}, "; ")
+}
+
+// parseWmiReply reads the bytes wmiScript prints.
+func parseWmiReply(out string) ([]byte, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected MSI_ACPI reply %q", strings.TrimSpace(out))
+	}
+	reply := make([]byte, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected MSI_ACPI reply %q", strings.TrimSpace(out))
+		}
+		reply[i] = byte(v)
+	}
+	return reply, nil
+}
+
+func wmiCall(ctx context.Context, method string, args ...byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, wmiTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", wmiScript(method, args...)).CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("MSI_ACPI %s timed out after %s", method, wmiTimeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if strings.Contains(msg, "Invalid class") || strings.Contains(msg, "Not found") {
+			return nil, fmt.Errorf("%w: MSI_ACPI WMI class not found", ErrECUnavailable)
+		}
+		return nil, fmt.Errorf("MSI_ACPI %s failed: %w: %s", method, err, msg)
+	}
+	return parseWmiReply(string(out))
+}
+
+func readEC(ctx context.Context, offset byte) (byte, error) {
+	reply, err := wmiCall(ctx, "Get_Data", offset)
+	if err != nil {
+		return 0, err
+	}
+	log.Trace().Msgf("ec read [0x%02x]=0x%02x", offset, reply[1])
+	return reply[1], nil
+}
+
+func writeEC(ctx context.Context, offset, value byte) error {
+	log.Trace().Msgf("ec write [0x%02x]=0x%02x", offset, value)
+	_, err := wmiCall(ctx, "Set_Data", offset, value)
+	return err
+}
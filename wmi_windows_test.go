@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWmiScript(t *testing.T) {
+	script := wmiScript("Set_Data", ecMuxOffset, 0x40)
+	for _, want := range []string{"-Class MSI_ACPI", "$request = @(0x2e, 0x40)", "$acpi.Set_Data($in)", "New-Object byte[] 32"} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("script lacks %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestParseWmiReply(t *testing.T) {
+	reply, err := parseWmiReply("1 64 0 0\r\n")
+	if err != nil {
+		t.Fatalf("parseWmiReply: %v", err)
+	}
+	if len(reply) != 4 || reply[1] != 0x40 {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	for _, bad := range []string{"", "1", "1 256", "Get-WmiObject : Invalid class"} {
+		if _, err := parseWmiReply(bad); err == nil {
+			t.Fatalf("parseWmiReply(%q) accepted", bad)
+		}
+	}
+}